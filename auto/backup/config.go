@@ -16,6 +16,7 @@ type Config struct {
 	NoCompress bool             `json:"no_compress,omitempty"`
 	Timestamp  bool             `json:"timestamp"`
 	Vacuum     bool             `json:"vacuum,omitempty"`
+	Checksum   bool             `json:"checksum,omitempty"`
 	Interval   auto.Duration    `json:"interval"`
 	Sub        json.RawMessage  `json:"sub"`
 }
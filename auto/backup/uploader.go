@@ -2,6 +2,7 @@ package backup
 
 import (
 	"context"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
@@ -29,6 +30,26 @@ type StorageClient interface {
 	fmt.Stringer
 }
 
+// ChecksumStorageClient is a StorageClient which can store a checksum of the
+// data with it, so that the data can be verified when it is downloaded. The
+// checksum must be replaced, or removed, by every later upload of data.
+type ChecksumStorageClient interface {
+	StorageClient
+
+	// UploadWithChecksum is like Upload, but also stores checksum, the
+	// hex-encoded SHA256 checksum of the data.
+	UploadWithChecksum(ctx context.Context, reader io.Reader, id, checksum string) error
+}
+
+// NamedStorageClient is a StorageClient which can store data under a name,
+// alongside the data uploaded with Upload, rather than in its place.
+type NamedStorageClient interface {
+	StorageClient
+
+	// UploadNamed is like Upload, but stores the data under name.
+	UploadNamed(ctx context.Context, reader io.Reader, id, name string) error
+}
+
 // DataProvider is an interface for providing data to be uploaded. The Uploader
 // service will call Provide() to have the data-for-upload to be written to the
 // to the file specified by path.
@@ -41,6 +62,76 @@ type DataProvider interface {
 	Provide(w io.Writer) error
 }
 
+// ContextDataProvider is a DataProvider which can be cancelled while it
+// provides data. If the DataProvider passed to the Uploader implements it,
+// an upload in progress when the Uploader is stopped is abandoned promptly.
+type ContextDataProvider interface {
+	DataProvider
+
+	// ProvideWithContext is like Provide, but returns once ctx is done.
+	ProvideWithContext(ctx context.Context, w io.Writer) error
+}
+
+// GatedDataProvider is a DataProvider which decides for itself whether new
+// data is worth uploading, for example to limit how often uploads happen. If
+// the DataProvider passed to the Uploader implements it, the Uploader only
+// uploads when ShouldProvide returns true, in addition to when the last index
+// has advanced.
+type GatedDataProvider interface {
+	DataProvider
+
+	// ShouldProvide returns whether the data should be provided now. idx is
+	// the index just returned by LastIndex.
+	ShouldProvide(idx uint64) (bool, error)
+}
+
+// ChecksumDataProvider is a DataProvider which checksums the data it
+// provides. If the DataProvider passed to the Uploader implements it, and the
+// StorageClient implements ChecksumStorageClient, the checksum is uploaded
+// with the data.
+type ChecksumDataProvider interface {
+	DataProvider
+
+	// Checksum returns the hex-encoded SHA256 checksum of the data written by
+	// the most recent Provide. If there is none, ok is false.
+	Checksum() (sum string, ok bool)
+}
+
+// NamedDataProvider is a DataProvider whose data must each be stored under a
+// distinct name, such as a chain of deltas which are all needed to restore.
+// If the DataProvider passed to the Uploader implements it, the StorageClient
+// must implement NamedStorageClient.
+type NamedDataProvider interface {
+	DataProvider
+
+	// Name returns the name to store the data written by the most recent
+	// Provide under.
+	Name() string
+}
+
+// CommittingDataProvider is a DataProvider which must be told once the data
+// it provided has been uploaded. If the DataProvider passed to the Uploader
+// implements it, Commit is called after each successful upload, but not if
+// the upload fails or is skipped.
+type CommittingDataProvider interface {
+	DataProvider
+
+	// Commit records that the data written by the most recent Provide has
+	// been uploaded.
+	Commit()
+}
+
+// SkipError is implemented by errors which a DataProvider returns from Provide
+// when it deliberately provided nothing to upload, such as while it is paused.
+// The Uploader skips the upload, rather than treating it as failed.
+type SkipError interface {
+	error
+
+	// DryRun returns whether the data was produced, but deliberately not
+	// written, rather than not produced at all.
+	DryRun() bool
+}
+
 // stats captures stats for the Uploader service.
 var stats *expvar.Map
 
@@ -49,6 +140,8 @@ const (
 	numUploadsFail      = "num_uploads_fail"
 	numUploadsSkipped   = "num_uploads_skipped"
 	numUploadsSkippedID = "num_uploads_skipped_id"
+	numUploadsGated     = "num_uploads_gated"
+	numUploadsDryRun    = "num_uploads_dry_run"
 	numSumGetFail       = "num_sum_get_fail"
 	totalUploadBytes    = "total_upload_bytes"
 	lastUploadBytes     = "last_upload_bytes"
@@ -66,6 +159,8 @@ func ResetStats() {
 	stats.Add(numUploadsFail, 0)
 	stats.Add(numUploadsSkipped, 0)
 	stats.Add(numUploadsSkippedID, 0)
+	stats.Add(numUploadsGated, 0)
+	stats.Add(numUploadsDryRun, 0)
 	stats.Add(numSumGetFail, 0)
 	stats.Add(totalUploadBytes, 0)
 	stats.Add(lastUploadBytes, 0)
@@ -148,6 +243,16 @@ func (u *Uploader) upload(ctx context.Context) error {
 		stats.Add(numUploadsSkipped, 1)
 		return nil
 	}
+	if gp, ok := u.dataProvider.(GatedDataProvider); ok {
+		should, err := gp.ShouldProvide(li)
+		if err != nil {
+			return err
+		}
+		if !should {
+			stats.Add(numUploadsGated, 1)
+			return nil
+		}
+	}
 
 	// Create a temporary file for the data to be uploaded
 	fd, err := tempFD()
@@ -157,11 +262,29 @@ func (u *Uploader) upload(ctx context.Context) error {
 	defer os.Remove(fd.Name())
 	defer fd.Close()
 
-	if err := u.dataProvider.Provide(fd); err != nil {
+	if cp, ok := u.dataProvider.(ContextDataProvider); ok {
+		err = cp.ProvideWithContext(ctx, fd)
+	} else {
+		err = u.dataProvider.Provide(fd)
+	}
+	var se SkipError
+	if errors.As(err, &se) {
+		if se.DryRun() {
+			// The data was produced but not written, so there is nothing to
+			// upload. This is not a failure.
+			stats.Add(numUploadsDryRun, 1)
+		} else {
+			// Nothing was provided, just as if the data had not changed.
+			stats.Add(numUploadsSkipped, 1)
+		}
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 
-	if u.lastIndex == 0 {
+	_, named := u.dataProvider.(NamedDataProvider)
+	if u.lastIndex == 0 && !named {
 		// No last index, so this must be the first upload since this
 		// uploader started. Double-check that we really need to upload.
 		cloudID, err := u.storageClient.CurrentID(ctx)
@@ -179,13 +302,16 @@ func (u *Uploader) upload(ctx context.Context) error {
 	}
 	cr := progress.NewCountingReader(fd)
 	startTime := time.Now()
-	err = u.storageClient.Upload(ctx, cr, strconv.FormatUint(li, 10))
+	err = u.uploadData(ctx, cr, strconv.FormatUint(li, 10))
 	if err != nil {
 		stats.Add(numUploadsFail, 1)
 		return err
 	}
 
 	// Successful upload!
+	if cp, ok := u.dataProvider.(CommittingDataProvider); ok {
+		cp.Commit()
+	}
 	u.lastIndex = li
 	stats.Add(numUploadsOK, 1)
 	stats.Add(totalUploadBytes, cr.Count())
@@ -198,6 +324,27 @@ func (u *Uploader) upload(ctx context.Context) error {
 	return nil
 }
 
+// uploadData uploads the data read from r, under the name given by the
+// DataProvider if it names its data, and otherwise along with its checksum if
+// both the DataProvider and the StorageClient support checksums.
+func (u *Uploader) uploadData(ctx context.Context, r io.Reader, id string) error {
+	if np, ok := u.dataProvider.(NamedDataProvider); ok {
+		nsc, ok := u.storageClient.(NamedStorageClient)
+		if !ok {
+			return fmt.Errorf("%s does not support named uploads", u.storageClient)
+		}
+		return nsc.UploadNamed(ctx, r, id, np.Name())
+	}
+	cp, cpOK := u.dataProvider.(ChecksumDataProvider)
+	csc, cscOK := u.storageClient.(ChecksumStorageClient)
+	if cpOK && cscOK {
+		if sum, ok := cp.Checksum(); ok {
+			return csc.UploadWithChecksum(ctx, r, id, sum)
+		}
+	}
+	return u.storageClient.Upload(ctx, r, id)
+}
+
 func tempFD() (*os.File, error) {
 	return os.CreateTemp("", "rqlite-upload")
 }
@@ -9,6 +9,20 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/rqlite/rqlite/v8/aws"
+	"github.com/rqlite/rqlite/v8/store"
+)
+
+// The store's providers, and the S3 client, must implement the optional
+// interfaces the Uploader looks for.
+var (
+	_ ChecksumDataProvider   = (*store.Provider)(nil)
+	_ GatedDataProvider      = (*store.DeltaProvider)(nil)
+	_ NamedDataProvider      = (*store.DeltaProvider)(nil)
+	_ CommittingDataProvider = (*store.DeltaProvider)(nil)
+	_ ChecksumStorageClient  = (*aws.S3Client)(nil)
+	_ NamedStorageClient     = (*aws.S3Client)(nil)
 )
 
 func Test_NewUploader(t *testing.T) {
@@ -242,6 +256,222 @@ func Test_UploaderContextCancellation(t *testing.T) {
 	}
 }
 
+func Test_UploaderContextDataProvider(t *testing.T) {
+	ResetStats()
+	var uploadCount int32
+
+	sc := &mockStorageClient{
+		uploadFn: func(ctx context.Context, reader io.Reader, id string) error {
+			atomic.AddInt32(&uploadCount, 1)
+			return nil
+		},
+	}
+	providing := make(chan struct{})
+	dp := &mockContextDataProvider{
+		provideFn: func(ctx context.Context, w io.Writer) error {
+			close(providing)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	uploader := NewUploader(sc, dp, 100*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := uploader.Start(ctx, nil)
+	<-providing
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("uploader did not stop while providing data")
+	}
+	if exp, got := int32(0), atomic.LoadInt32(&uploadCount); exp != got {
+		t.Errorf("expected uploadCount to be %d, got %d", exp, got)
+	}
+}
+
+func Test_UploaderGatedDataProvider(t *testing.T) {
+	ResetStats()
+	var uploadCount int32
+	sc := &mockStorageClient{
+		uploadFn: func(ctx context.Context, reader io.Reader, id string) error {
+			atomic.AddInt32(&uploadCount, 1)
+			return nil
+		},
+	}
+	should := false
+	dp := &mockGatedDataProvider{
+		mockDataProvider: mockDataProvider{data: "my upload data"},
+		shouldFn:         func() (bool, error) { return should, nil },
+	}
+	uploader := NewUploader(sc, dp, time.Hour)
+
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err.Error())
+	}
+	if exp, got := int32(0), atomic.LoadInt32(&uploadCount); exp != got {
+		t.Fatalf("expected uploadCount to be %d, got %d", exp, got)
+	}
+	if exp, got := int64(1), stats.Get(numUploadsGated).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected numUploadsGated to be %d, got %d", exp, got)
+	}
+	if exp, got := uint64(1), dp.shouldIdx; exp != got {
+		t.Fatalf("expected ShouldProvide to be passed index %d, got %d", exp, got)
+	}
+
+	should = true
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err.Error())
+	}
+	if exp, got := int32(1), atomic.LoadInt32(&uploadCount); exp != got {
+		t.Fatalf("expected uploadCount to be %d, got %d", exp, got)
+	}
+
+	dp.shouldFn = func() (bool, error) { return false, fmt.Errorf("check failed") }
+	dp.lastIndexFn = func() (uint64, error) { return 2, nil }
+	if err := uploader.upload(context.Background()); err == nil {
+		t.Fatalf("expected error when ShouldProvide fails")
+	}
+}
+
+func Test_UploaderDryRun(t *testing.T) {
+	ResetStats()
+	var uploadCount int32
+	sc := &mockStorageClient{
+		uploadFn: func(ctx context.Context, reader io.Reader, id string) error {
+			atomic.AddInt32(&uploadCount, 1)
+			return nil
+		},
+	}
+	dp := &mockDataProvider{err: store.ErrDryRun}
+	uploader := NewUploader(sc, dp, time.Hour)
+
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("expected dry run to be reported as success, got %s", err.Error())
+	}
+	if exp, got := int32(0), atomic.LoadInt32(&uploadCount); exp != got {
+		t.Fatalf("expected uploadCount to be %d, got %d", exp, got)
+	}
+	if exp, got := int64(1), stats.Get(numUploadsDryRun).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected numUploadsDryRun to be %d, got %d", exp, got)
+	}
+	if exp, got := int64(0), stats.Get(numUploadsFail).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected numUploadsFail to be %d, got %d", exp, got)
+	}
+}
+
+func Test_UploaderPaused(t *testing.T) {
+	ResetStats()
+	var uploadCount int32
+	sc := &mockStorageClient{
+		uploadFn: func(ctx context.Context, reader io.Reader, id string) error {
+			atomic.AddInt32(&uploadCount, 1)
+			return nil
+		},
+	}
+	dp := &mockDataProvider{err: store.ErrPaused}
+	uploader := NewUploader(sc, dp, time.Hour)
+
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("expected paused provide to be skipped, got %s", err.Error())
+	}
+	if exp, got := int32(0), atomic.LoadInt32(&uploadCount); exp != got {
+		t.Fatalf("expected uploadCount to be %d, got %d", exp, got)
+	}
+	if exp, got := int64(1), stats.Get(numUploadsSkipped).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("expected numUploadsSkipped to be %d, got %d", exp, got)
+	}
+
+	// Once resumed, the data is uploaded.
+	dp.err = nil
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err.Error())
+	}
+	if exp, got := int32(1), atomic.LoadInt32(&uploadCount); exp != got {
+		t.Fatalf("expected uploadCount to be %d, got %d", exp, got)
+	}
+}
+
+func Test_UploaderChecksum(t *testing.T) {
+	ResetStats()
+	var uploadedSum string
+	var uploadedData []byte
+	sc := &mockChecksumStorageClient{
+		uploadWithChecksumFn: func(ctx context.Context, reader io.Reader, id, checksum string) error {
+			uploadedSum = checksum
+			var err error
+			uploadedData, err = io.ReadAll(reader)
+			return err
+		},
+	}
+	dp := &mockChecksumDataProvider{
+		mockDataProvider: mockDataProvider{data: "my upload data"},
+		sum:              "abc123",
+	}
+	uploader := NewUploader(sc, dp, time.Hour)
+
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err.Error())
+	}
+	if exp, got := "my upload data", string(uploadedData); exp != got {
+		t.Fatalf("expected uploadedData to be %s, got %s", exp, got)
+	}
+	if exp, got := "abc123", uploadedSum; exp != got {
+		t.Fatalf("expected uploaded checksum to be %s, got %s", exp, got)
+	}
+}
+
+func Test_UploaderNamedCommit(t *testing.T) {
+	ResetStats()
+	var uploadedName string
+	var uploadErr error
+	sc := &mockNamedStorageClient{
+		uploadNamedFn: func(ctx context.Context, reader io.Reader, id, name string) error {
+			uploadedName = name
+			return uploadErr
+		},
+	}
+	li := uint64(1)
+	dp := &mockNamedDataProvider{
+		mockDataProvider: mockDataProvider{
+			data:        "my upload data",
+			lastIndexFn: func() (uint64, error) { return li, nil },
+		},
+		name: "delta-1",
+	}
+	uploader := NewUploader(sc, dp, time.Hour)
+
+	// A failed upload is not committed.
+	uploadErr = fmt.Errorf("failed to upload")
+	if err := uploader.upload(context.Background()); err == nil {
+		t.Fatalf("expected upload to fail")
+	}
+	if exp, got := 0, dp.commits; exp != got {
+		t.Fatalf("expected %d commits, got %d", exp, got)
+	}
+
+	uploadErr = nil
+	if err := uploader.upload(context.Background()); err != nil {
+		t.Fatalf("failed to upload: %s", err.Error())
+	}
+	if exp, got := "delta-1", uploadedName; exp != got {
+		t.Fatalf("expected upload to be named %s, got %s", exp, got)
+	}
+	if exp, got := 1, dp.commits; exp != got {
+		t.Fatalf("expected %d commits, got %d", exp, got)
+	}
+
+	// A StorageClient which cannot name uploads is an error.
+	li = 2
+	uploader = NewUploader(&mockStorageClient{}, dp, time.Hour)
+	if err := uploader.upload(context.Background()); err == nil {
+		t.Fatalf("expected error uploading named data to unnamed storage")
+	}
+	if exp, got := 1, dp.commits; exp != got {
+		t.Fatalf("expected %d commits, got %d", exp, got)
+	}
+}
+
 func Test_UploaderEnabledFalse(t *testing.T) {
 	ResetStats()
 	sc := &mockStorageClient{}
@@ -358,3 +588,64 @@ func (mp *mockDataProvider) Provide(w io.Writer) error {
 	}
 	return nil
 }
+
+type mockContextDataProvider struct {
+	mockDataProvider
+	provideFn func(ctx context.Context, w io.Writer) error
+}
+
+func (mp *mockContextDataProvider) ProvideWithContext(ctx context.Context, w io.Writer) error {
+	return mp.provideFn(ctx, w)
+}
+
+type mockGatedDataProvider struct {
+	mockDataProvider
+	shouldFn  func() (bool, error)
+	shouldIdx uint64
+}
+
+func (mp *mockGatedDataProvider) ShouldProvide(idx uint64) (bool, error) {
+	mp.shouldIdx = idx
+	return mp.shouldFn()
+}
+
+type mockChecksumStorageClient struct {
+	mockStorageClient
+	uploadWithChecksumFn func(ctx context.Context, reader io.Reader, id, checksum string) error
+}
+
+func (mc *mockChecksumStorageClient) UploadWithChecksum(ctx context.Context, reader io.Reader, id, checksum string) error {
+	return mc.uploadWithChecksumFn(ctx, reader, id, checksum)
+}
+
+type mockChecksumDataProvider struct {
+	mockDataProvider
+	sum string
+}
+
+func (mp *mockChecksumDataProvider) Checksum() (string, bool) {
+	return mp.sum, mp.sum != ""
+}
+
+type mockNamedStorageClient struct {
+	mockStorageClient
+	uploadNamedFn func(ctx context.Context, reader io.Reader, id, name string) error
+}
+
+func (mc *mockNamedStorageClient) UploadNamed(ctx context.Context, reader io.Reader, id, name string) error {
+	return mc.uploadNamedFn(ctx, reader, id, name)
+}
+
+type mockNamedDataProvider struct {
+	mockDataProvider
+	name    string
+	commits int
+}
+
+func (mp *mockNamedDataProvider) Name() string {
+	return mp.name
+}
+
+func (mp *mockNamedDataProvider) Commit() {
+	mp.commits++
+}
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
@@ -21,6 +24,10 @@ var (
 	gzipMagic = []byte{0x1f, 0x8b, 0x08}
 )
 
+// ErrChecksumMismatch is returned when downloaded data does not match the
+// checksum stored with it.
+var ErrChecksumMismatch = errors.New("download checksum mismatch")
+
 const (
 	numDownloadsOK   = "num_downloads_ok"
 	numDownloadsFail = "num_downloads_fail"
@@ -93,6 +100,17 @@ type StorageClient interface {
 	fmt.Stringer
 }
 
+// ChecksumStorageClient is a StorageClient which can download the checksum
+// stored with the data. If the StorageClient passed to the Downloader
+// implements it, downloaded data is verified against the checksum, if any.
+type ChecksumStorageClient interface {
+	StorageClient
+
+	// DownloadWithChecksum downloads the data, and returns the hex-encoded
+	// SHA256 checksum stored with it. If there is no checksum, ok is false.
+	DownloadWithChecksum(ctx context.Context, writer io.WriterAt) (checksum string, ok bool, err error)
+}
+
 type Downloader struct {
 	storageClient StorageClient
 	logger        *log.Logger
@@ -130,9 +148,21 @@ func (d *Downloader) Do(ctx context.Context, w io.Writer, timeout time.Duration)
 	defer cancel()
 
 	cw = &countingWriterAt{writerAt: f}
-	err = d.storageClient.Download(ctx, cw)
-	if err != nil {
-		return err
+	if csc, ok := d.storageClient.(ChecksumStorageClient); ok {
+		exp, ok, err := csc.DownloadWithChecksum(ctx, cw)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := verify(f, exp); err != nil {
+				return err
+			}
+		}
+	} else {
+		err = d.storageClient.Download(ctx, cw)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Check if the download data is gzip compressed.
@@ -161,6 +191,22 @@ func (d *Downloader) Do(ctx context.Context, w io.Writer, timeout time.Duration)
 	return nil
 }
 
+// verify checks the downloaded data in f against exp, the hex-encoded SHA256
+// checksum stored with it.
+func verify(f io.ReadSeeker, exp string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != exp {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, exp, got)
+	}
+	return nil
+}
+
 type countingWriterAt struct {
 	writerAt io.WriterAt
 	count    int64
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 	"time"
 )
@@ -67,6 +70,42 @@ func TestDownloader_Do(t *testing.T) {
 	}
 }
 
+func TestDownloader_DoChecksum(t *testing.T) {
+	data := []byte("test data")
+	mockClient := &mockChecksumStorageClient{
+		mockStorageClient: mockStorageClient{data: data},
+	}
+	mockClient.Compress()
+
+	// The checksum is of the data as stored, before decompression.
+	sum := sha256.Sum256(mockClient.data)
+	mockClient.checksum = hex.EncodeToString(sum[:])
+	downloader := NewDownloader(mockClient)
+
+	f := new(bytes.Buffer)
+	if err := downloader.Do(context.Background(), f, 5*time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, f.Bytes()) {
+		t.Fatalf("Expected output data %v, but got %v", data, f.Bytes())
+	}
+
+	mockClient.checksum = strings.Repeat("0", 64)
+	f.Reset()
+	if err := downloader.Do(context.Background(), f, 5*time.Second); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if f.Len() != 0 {
+		t.Fatalf("Expected no data written on checksum mismatch, got %d bytes", f.Len())
+	}
+
+	// With no checksum stored, the data is not verified.
+	mockClient.checksum = ""
+	if err := downloader.Do(context.Background(), f, 5*time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
 type mockStorageClient struct {
 	data  []byte
 	error error
@@ -104,3 +143,15 @@ func (m *mockStorageClient) Compress() error {
 func (m *mockStorageClient) String() string {
 	return "mockStorageClient"
 }
+
+type mockChecksumStorageClient struct {
+	mockStorageClient
+	checksum string
+}
+
+func (m *mockChecksumStorageClient) DownloadWithChecksum(ctx context.Context, writer io.WriterAt) (string, bool, error) {
+	if err := m.Download(ctx, writer); err != nil {
+		return "", false, err
+	}
+	return m.checksum, m.checksum != "", nil
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -17,6 +18,10 @@ import (
 
 var (
 	AWSS3IDKey = http.CanonicalHeaderKey("x-rqlite-auto-backup-id")
+
+	// AWSS3ChecksumKey is the metadata key under which the hex-encoded SHA256
+	// checksum of uploaded data is stored, on the object holding the data.
+	AWSS3ChecksumKey = http.CanonicalHeaderKey("x-rqlite-auto-backup-sha256")
 )
 
 // S3Config is the subconfig for the S3 storage type
@@ -47,6 +52,7 @@ type S3Client struct {
 	// These fields are used for testing via dependency injection.
 	uploader   uploader
 	downloader downloader
+	header     header
 	now        func() time.Time
 }
 
@@ -91,6 +97,7 @@ func NewS3Client(endpoint, region, accessKey, secretKey, bucket, key string, opt
 
 		uploader:   s3manager.NewUploaderWithClient(s3),
 		downloader: s3manager.NewDownloaderWithClient(s3),
+		header:     s3,
 	}
 	if opts != nil {
 		client.forcePathStyle = opts.ForcePathStyle
@@ -113,25 +120,53 @@ func (s *S3Client) String() string {
 	}
 }
 
-// Upload uploads data to S3.
+// Upload uploads data to S3. Any checksum stored with the data previously
+// uploaded to the same key is replaced along with it.
 func (s *S3Client) Upload(ctx context.Context, reader io.Reader, id string) error {
-	key := s.key
-	if s.timestamp {
-		if s.now == nil {
-			s.now = time.Now().UTC
-		}
-		key = TimestampedPath(key, s.now())
+	return s.upload(ctx, s.uploadKey(), reader, id, "")
+}
+
+// UploadWithChecksum uploads data to S3, like Upload, storing checksum, the
+// hex-encoded SHA256 checksum of the data, in the metadata of the object
+// holding the data. The data and its checksum are written by the same
+// request, so one is never stored without the other.
+func (s *S3Client) UploadWithChecksum(ctx context.Context, reader io.Reader, id, checksum string) error {
+	return s.upload(ctx, s.uploadKey(), reader, id, checksum)
+}
+
+// UploadNamed uploads data to S3 under name, at the client's key with "."
+// and name appended, so that it is stored alongside the data uploaded with
+// Upload rather than replacing it.
+func (s *S3Client) UploadNamed(ctx context.Context, reader io.Reader, id, name string) error {
+	return s.upload(ctx, s.key+"."+name, reader, id, "")
+}
+
+// uploadKey returns the key to upload data to.
+func (s *S3Client) uploadKey() string {
+	if !s.timestamp {
+		return s.key
 	}
+	if s.now == nil {
+		s.now = time.Now().UTC
+	}
+	return TimestampedPath(s.key, s.now())
+}
+
+func (s *S3Client) upload(ctx context.Context, key string, reader io.Reader, id, checksum string) error {
 	input := &s3manager.UploadInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 		Body:   reader,
 	}
 
+	if id != "" || checksum != "" {
+		input.Metadata = make(map[string]*string)
+	}
 	if id != "" {
-		input.Metadata = map[string]*string{
-			AWSS3IDKey: aws.String(id),
-		}
+		input.Metadata[AWSS3IDKey] = aws.String(id)
+	}
+	if checksum != "" {
+		input.Metadata[AWSS3ChecksumKey] = aws.String(checksum)
 	}
 	_, err := s.uploader.UploadWithContext(ctx, input)
 	if err != nil {
@@ -173,6 +208,34 @@ func (s *S3Client) Download(ctx context.Context, writer io.WriterAt) error {
 	return nil
 }
 
+// DownloadWithChecksum downloads data from S3, like Download, and returns
+// the checksum stored with the data by UploadWithChecksum. If no checksum is
+// stored with the data, ok is false. The checksum is read first, and the data
+// is then only downloaded if it is the same object, so that the two always
+// match.
+func (s *S3Client) DownloadWithChecksum(ctx context.Context, writer io.WriterAt) (checksum string, ok bool, err error) {
+	head, err := s.header.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get object head for %v: %w", s, err)
+	}
+	if sum, found := head.Metadata[AWSS3ChecksumKey]; found && sum != nil {
+		checksum, ok = strings.ToLower(*sum), true
+	}
+
+	_, err = s.downloader.DownloadWithContext(ctx, writer, &s3.GetObjectInput{
+		Bucket:  aws.String(s.bucket),
+		Key:     aws.String(s.key),
+		IfMatch: head.ETag,
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to download from %v: %w", s, err)
+	}
+	return checksum, ok, nil
+}
+
 // TimestampedPath returns a new path with the given timestamp prepended.
 // If path contains /, the timestamp is prepended to the last segment.
 func TimestampedPath(path string, t time.Time) string {
@@ -185,6 +248,10 @@ type uploader interface {
 	UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
 }
 
+type header interface {
+	HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error)
+}
+
 type downloader interface {
 	DownloadWithContext(ctx aws.Context, w io.WriterAt, input *s3.GetObjectInput, opts ...func(*s3manager.Downloader)) (n int64, err error)
 }
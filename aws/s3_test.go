@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
@@ -192,6 +194,110 @@ func Test_S3ClientUploadOK_Timestamped(t *testing.T) {
 	}
 }
 
+func Test_S3ClientUploadWithChecksum(t *testing.T) {
+	key := "your/key/path"
+	timestampedKey := "your/key/20210701150405_path"
+	store := newMockObjectStore()
+
+	client := &S3Client{
+		region:    "us-west-2",
+		bucket:    "your-bucket",
+		key:       key,
+		timestamp: true,
+		uploader:  store,
+		now: func() time.Time {
+			return time.Date(2021, time.July, 1, 15, 4, 5, 0, time.UTC) // Controls timestampedKey
+		},
+	}
+
+	err := client.UploadWithChecksum(context.Background(), strings.NewReader("test data"), "some-id", "abc123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp, got := 1, len(store.objects); exp != got {
+		t.Fatalf("expected %d objects, got %d", exp, got)
+	}
+	obj, ok := store.objects[timestampedKey]
+	if !ok {
+		t.Fatalf("expected object at %q", timestampedKey)
+	}
+	if exp, got := "test data", string(obj.data); exp != got {
+		t.Errorf("expected uploaded data to be %q, got %q", exp, got)
+	}
+	if exp, got := "abc123", aws.StringValue(obj.metadata[AWSS3ChecksumKey]); exp != got {
+		t.Errorf("expected uploaded checksum to be %q, got %q", exp, got)
+	}
+	if exp, got := "some-id", aws.StringValue(obj.metadata[AWSS3IDKey]); exp != got {
+		t.Errorf("expected uploaded ID to be %q, got %q", exp, got)
+	}
+}
+
+// Test_S3ClientUploadChecksumDisabled tests that data uploaded without a
+// checksum, after data was uploaded with one, is not stored with the earlier
+// checksum.
+func Test_S3ClientUploadChecksumDisabled(t *testing.T) {
+	store := newMockObjectStore()
+	client := &S3Client{
+		bucket:     "your-bucket",
+		key:        "your/key/path",
+		uploader:   store,
+		downloader: store,
+		header:     store,
+	}
+
+	err := client.UploadWithChecksum(context.Background(), strings.NewReader("old data"), "id1", "abc123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	writer := aws.NewWriteAtBuffer(nil)
+	sum, ok, err := client.DownloadWithChecksum(context.Background(), writer)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !ok || sum != "abc123" {
+		t.Fatalf("expected checksum %q, got %q (ok %v)", "abc123", sum, ok)
+	}
+
+	if err := client.Upload(context.Background(), strings.NewReader("new data"), "id2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	writer = aws.NewWriteAtBuffer(nil)
+	sum, ok, err = client.DownloadWithChecksum(context.Background(), writer)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatalf("expected no checksum, got %q", sum)
+	}
+	if exp, got := "new data", string(writer.Bytes()); exp != got {
+		t.Fatalf("expected downloaded data to be %q, got %q", exp, got)
+	}
+}
+
+func Test_S3ClientUploadNamed(t *testing.T) {
+	key := "your/key/path"
+	var uploadedKey string
+	mockUploader := &mockUploader{
+		uploadFn: func(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+			uploadedKey = *input.Key
+			return &s3manager.UploadOutput{}, nil
+		},
+	}
+	client := &S3Client{
+		bucket:   "your-bucket",
+		key:      key,
+		uploader: mockUploader,
+	}
+
+	err := client.UploadNamed(context.Background(), strings.NewReader("test data"), "some-id", "delta-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp, got := key+".delta-1", uploadedKey; exp != got {
+		t.Fatalf("expected key to be %q, got %q", exp, got)
+	}
+}
+
 func Test_S3ClientUploadFail(t *testing.T) {
 	region := "us-west-2"
 	accessKey := "your-access-key"
@@ -301,6 +407,39 @@ func Test_S3ClientDownloadFail(t *testing.T) {
 	}
 }
 
+func Test_S3ClientDownloadWithChecksum(t *testing.T) {
+	key := "your/key/path"
+	store := newMockObjectStore()
+	client := &S3Client{
+		bucket:     "your-bucket",
+		key:        key,
+		downloader: store,
+		header:     store,
+	}
+
+	store.put(key, []byte("test data"), map[string]*string{AWSS3ChecksumKey: aws.String("ABC123")})
+	writer := aws.NewWriteAtBuffer(nil)
+	sum, ok, err := client.DownloadWithChecksum(context.Background(), writer)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !ok || sum != "abc123" {
+		t.Fatalf("expected checksum %q, got %q (ok %v)", "abc123", sum, ok)
+	}
+	if exp, got := "test data", string(writer.Bytes()); exp != got {
+		t.Fatalf("expected downloaded data to be %q, got %q", exp, got)
+	}
+
+	// Data replaced between reading the checksum and downloading the data
+	// must not be returned with the old checksum.
+	store.headFn = func() {
+		store.put(key, []byte("new data"), nil)
+	}
+	if _, _, err := client.DownloadWithChecksum(context.Background(), aws.NewWriteAtBuffer(nil)); err == nil {
+		t.Fatalf("expected error downloading replaced data")
+	}
+}
+
 func Test_TimestampedPath(t *testing.T) {
 	ts, err := time.Parse(time.RFC3339, "2021-07-01T15:04:05Z")
 	if err != nil {
@@ -343,6 +482,72 @@ func (m *mockUploader) UploadWithContext(ctx aws.Context, input *s3manager.Uploa
 	return &s3manager.UploadOutput{}, nil
 }
 
+type mockObject struct {
+	data     []byte
+	metadata map[string]*string
+	etag     string
+}
+
+// mockObjectStore is an in-memory store of objects, which acts as the
+// uploader, downloader and header of an S3Client.
+type mockObjectStore struct {
+	objects map[string]*mockObject
+	nPuts   int
+
+	// headFn, if set, is called after each head of an object.
+	headFn func()
+}
+
+func newMockObjectStore() *mockObjectStore {
+	return &mockObjectStore{
+		objects: make(map[string]*mockObject),
+	}
+}
+
+func (m *mockObjectStore) put(key string, data []byte, metadata map[string]*string) {
+	m.nPuts++
+	m.objects[key] = &mockObject{
+		data:     data,
+		metadata: metadata,
+		etag:     fmt.Sprintf(`"%d"`, m.nPuts),
+	}
+}
+
+func (m *mockObjectStore) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	b, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.put(*input.Key, b, input.Metadata)
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (m *mockObjectStore) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	obj, ok := m.objects[*input.Key]
+	if !ok {
+		return nil, awserr.New("NotFound", "not found", nil)
+	}
+	if m.headFn != nil {
+		m.headFn()
+	}
+	return &s3.HeadObjectOutput{
+		ETag:     aws.String(obj.etag),
+		Metadata: obj.metadata,
+	}, nil
+}
+
+func (m *mockObjectStore) DownloadWithContext(ctx aws.Context, w io.WriterAt, input *s3.GetObjectInput, opts ...func(*s3manager.Downloader)) (int64, error) {
+	obj, ok := m.objects[*input.Key]
+	if !ok {
+		return 0, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+	if input.IfMatch != nil && *input.IfMatch != obj.etag {
+		return 0, awserr.New("PreconditionFailed", "precondition failed", nil)
+	}
+	n, err := w.WriteAt(obj.data, 0)
+	return int64(n), err
+}
+
 func forcePathStyleOptions() *S3ClientOpts {
 	return &S3ClientOpts{
 		ForcePathStyle: true,
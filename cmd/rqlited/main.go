@@ -250,7 +250,7 @@ func startAutoBackups(ctx context.Context, cfg *Config, str *store.Store) (*back
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse auto-backup file: %s", err.Error())
 	}
-	provider := store.NewProvider(str, uCfg.Vacuum, !uCfg.NoCompress)
+	provider := store.NewProvider(str, uCfg.Vacuum, !uCfg.NoCompress, uCfg.Checksum)
 	s3ClientOps := &aws.S3ClientOpts{
 		ForcePathStyle: s3cfg.ForcePathStyle,
 		Timestamp:      uCfg.Timestamp,
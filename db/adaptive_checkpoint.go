@@ -0,0 +1,175 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// adaptiveCheckpointTimeout is the busy timeout for checkpoints run by
+	// the adaptive checkpointer.
+	adaptiveCheckpointTimeout = 100 * time.Millisecond
+
+	// defaultAdaptiveCheckpointTargetSize is the WAL size the adaptive
+	// checkpointer aims to checkpoint at, if no target is configured.
+	defaultAdaptiveCheckpointTargetSize = 4 * 1024 * 1024
+)
+
+// ErrInvalidCheckpointInterval is returned by OpenWithConfig when the bounds
+// of the adaptive checkpoint interval are not valid.
+var ErrInvalidCheckpointInterval = errors.New("invalid adaptive checkpoint interval")
+
+// adaptiveCheckpointer periodically checkpoints the WAL in TRUNCATE mode,
+// adjusting the interval between checkpoints to the rate at which the WAL
+// grows. The interval is chosen so that, at the recently observed rate, the
+// WAL reaches the target size between checkpoints. To avoid overreacting to a
+// single measurement, the interval changes by at most a factor of two each
+// time, and it always stays within the configured bounds. While the database
+// is idle the interval doubles until it reaches the maximum.
+//
+// If a checkpoint fails, typically because readers prevent the WAL from being
+// truncated, the interval is doubled, so repeated failures back off towards
+// the maximum interval instead of contending with the readers.
+type adaptiveCheckpointer struct {
+	minInterval time.Duration
+	maxInterval time.Duration
+	targetSize  int64
+	walSize     func() (int64, error)
+	checkpoint  func() error
+	logger      *log.Logger
+
+	mu        sync.Mutex
+	interval  time.Duration
+	lastSize  int64
+	nFailures int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newAdaptiveCheckpointer returns an adaptiveCheckpointer configured by cfg,
+// or nil if adaptive checkpointing is disabled.
+func newAdaptiveCheckpointer(cfg *Config, walSize func() (int64, error), checkpoint func() error,
+	logger *log.Logger) (*adaptiveCheckpointer, error) {
+	if !cfg.WAL || cfg.AdaptiveCheckpointMaxInterval <= 0 {
+		return nil, nil
+	}
+	if cfg.AdaptiveCheckpointMinInterval <= 0 || cfg.AdaptiveCheckpointMinInterval > cfg.AdaptiveCheckpointMaxInterval {
+		return nil, ErrInvalidCheckpointInterval
+	}
+	target := cfg.AdaptiveCheckpointTargetSize
+	if target <= 0 {
+		target = defaultAdaptiveCheckpointTargetSize
+	}
+	return &adaptiveCheckpointer{
+		minInterval: cfg.AdaptiveCheckpointMinInterval,
+		maxInterval: cfg.AdaptiveCheckpointMaxInterval,
+		targetSize:  target,
+		walSize:     walSize,
+		checkpoint:  checkpoint,
+		logger:      logger,
+		interval:    cfg.AdaptiveCheckpointMaxInterval,
+	}, nil
+}
+
+// Interval returns the current checkpoint interval.
+func (a *adaptiveCheckpointer) Interval() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.interval
+}
+
+// Start starts checkpointing in the background.
+func (a *adaptiveCheckpointer) Start() {
+	a.done = make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		last := time.Now()
+		for {
+			timer := time.NewTimer(a.Interval())
+			select {
+			case <-a.done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			now := time.Now()
+			a.step(now.Sub(last))
+			last = now
+		}
+	}()
+}
+
+// Stop stops checkpointing, waiting for any checkpoint in progress to
+// complete. It is safe to call Stop on a nil adaptiveCheckpointer.
+func (a *adaptiveCheckpointer) Stop() {
+	if a == nil || a.done == nil {
+		return
+	}
+	close(a.done)
+	a.wg.Wait()
+	a.done = nil
+}
+
+// step checkpoints the WAL, if it is not empty, and adjusts the interval
+// given that elapsed has passed since the previous step.
+func (a *adaptiveCheckpointer) step(elapsed time.Duration) {
+	sz, err := a.walSize()
+	if err != nil {
+		a.logger.Printf("adaptive checkpointer failed to get WAL size: %s", err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	growth := sz - a.lastSize
+	if growth < 0 {
+		// The WAL was checkpointed by someone else.
+		growth = sz
+	}
+	if sz == 0 {
+		a.lastSize = 0
+		a.nFailures = 0
+		a.setInterval(2 * a.interval)
+		return
+	}
+
+	stats.Add(numAdaptiveCheckpoints, 1)
+	if err := a.checkpoint(); err != nil {
+		stats.Add(numAdaptiveFailures, 1)
+		a.nFailures++
+		a.lastSize = sz
+		a.setInterval(2 * a.interval)
+		a.logger.Printf("adaptive checkpoint failed (%d consecutive failures), next attempt in %s: %s",
+			a.nFailures, a.interval, err.Error())
+		return
+	}
+	a.nFailures = 0
+	a.lastSize = 0
+
+	if growth == 0 {
+		a.setInterval(2 * a.interval)
+		return
+	}
+	ideal := time.Duration(float64(elapsed) * float64(a.targetSize) / float64(growth))
+	if ideal < a.interval/2 {
+		ideal = a.interval / 2
+	} else if ideal > 2*a.interval {
+		ideal = 2 * a.interval
+	}
+	a.setInterval(ideal)
+}
+
+// setInterval sets the interval, clamped to the configured bounds. It must
+// be called with mu held.
+func (a *adaptiveCheckpointer) setInterval(d time.Duration) {
+	if d < a.minInterval {
+		d = a.minInterval
+	} else if d > a.maxInterval {
+		d = a.maxInterval
+	}
+	a.interval = d
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_AdaptiveCheckpointer_Bursty(t *testing.T) {
+	var walSize int64
+	var checkpointErr error
+	nCheckpoints := 0
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.AdaptiveCheckpointMinInterval = 100 * time.Millisecond
+	cfg.AdaptiveCheckpointMaxInterval = 10 * time.Second
+	cfg.AdaptiveCheckpointTargetSize = 1000
+	a, err := newAdaptiveCheckpointer(cfg, func() (int64, error) {
+		return walSize, nil
+	}, func() error {
+		nCheckpoints++
+		if checkpointErr != nil {
+			return checkpointErr
+		}
+		walSize = 0
+		return nil
+	}, log.New(os.Stderr, "", 0))
+	if err != nil {
+		t.Fatalf("failed to create adaptive checkpointer: %s", err.Error())
+	}
+	if exp, got := 10*time.Second, a.Interval(); exp != got {
+		t.Fatalf("wrong initial interval, exp %s, got %s", exp, got)
+	}
+
+	// A burst of writes, much faster than the target rate, shortens the
+	// interval by half each step until it reaches the minimum.
+	for _, exp := range []time.Duration{5 * time.Second, 2500 * time.Millisecond} {
+		walSize += 100000
+		a.step(a.Interval())
+		if got := a.Interval(); exp != got {
+			t.Fatalf("wrong interval during burst, exp %s, got %s", exp, got)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		walSize += 100000
+		a.step(a.Interval())
+	}
+	if exp, got := 100*time.Millisecond, a.Interval(); exp != got {
+		t.Fatalf("wrong interval after sustained burst, exp %s, got %s", exp, got)
+	}
+
+	// Writes at the target rate hold the interval steady.
+	a.mu.Lock()
+	a.interval = time.Second
+	a.mu.Unlock()
+	walSize += 1000
+	a.step(time.Second)
+	if exp, got := time.Second, a.Interval(); exp != got {
+		t.Fatalf("wrong interval at target rate, exp %s, got %s", exp, got)
+	}
+
+	// Writes at a quarter of the target rate lengthen the interval, but by
+	// no more than a factor of two.
+	walSize += 250
+	a.step(time.Second)
+	if exp, got := 2*time.Second, a.Interval(); exp != got {
+		t.Fatalf("wrong interval at low rate, exp %s, got %s", exp, got)
+	}
+
+	// Once idle, the interval grows to the maximum without checkpointing.
+	n := nCheckpoints
+	for i := 0; i < 5; i++ {
+		a.step(a.Interval())
+	}
+	if exp, got := 10*time.Second, a.Interval(); exp != got {
+		t.Fatalf("wrong interval when idle, exp %s, got %s", exp, got)
+	}
+	if n != nCheckpoints {
+		t.Fatalf("checkpointed empty WAL")
+	}
+
+	// Failing checkpoints back off, even while writes are heavy.
+	a.mu.Lock()
+	a.interval = 100 * time.Millisecond
+	a.mu.Unlock()
+	checkpointErr = errors.New("database is locked")
+	for _, exp := range []time.Duration{200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond} {
+		walSize += 100000
+		a.step(a.Interval())
+		if got := a.Interval(); exp != got {
+			t.Fatalf("wrong interval after failed checkpoint, exp %s, got %s", exp, got)
+		}
+	}
+
+	// Once checkpoints succeed the interval adapts to the write rate again,
+	// counting only growth since the last attempt.
+	checkpointErr = nil
+	walSize += 100000
+	a.step(a.Interval())
+	if exp, got := 400*time.Millisecond, a.Interval(); exp != got {
+		t.Fatalf("wrong interval after recovery, exp %s, got %s", exp, got)
+	}
+	if walSize != 0 {
+		t.Fatalf("WAL not checkpointed after recovery")
+	}
+}
+
+func Test_AdaptiveCheckpointer_Config(t *testing.T) {
+	cfg := NewConfig()
+	cfg.WAL = true
+	if a, err := newAdaptiveCheckpointer(cfg, nil, nil, nil); err != nil || a != nil {
+		t.Fatalf("adaptive checkpointer enabled by default")
+	}
+	cfg.AdaptiveCheckpointMaxInterval = time.Second
+	if _, err := newAdaptiveCheckpointer(cfg, nil, nil, nil); err != ErrInvalidCheckpointInterval {
+		t.Fatalf("expected ErrInvalidCheckpointInterval for zero minimum, got %v", err)
+	}
+	cfg.AdaptiveCheckpointMinInterval = 2 * time.Second
+	if _, err := newAdaptiveCheckpointer(cfg, nil, nil, nil); err != ErrInvalidCheckpointInterval {
+		t.Fatalf("expected ErrInvalidCheckpointInterval for minimum over maximum, got %v", err)
+	}
+	cfg.WAL = false
+	if a, err := newAdaptiveCheckpointer(cfg, nil, nil, nil); err != nil || a != nil {
+		t.Fatalf("adaptive checkpointer enabled without WAL")
+	}
+}
+
+func Test_WALDatabaseCheckpoint_Adaptive(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.AdaptiveCheckpointMinInterval = 10 * time.Millisecond
+	cfg.AdaptiveCheckpointMaxInterval = 200 * time.Millisecond
+	cfg.AdaptiveCheckpointTargetSize = 4096
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	if exp, got := 200*time.Millisecond, db.CheckpointInterval(); exp != got {
+		t.Fatalf("wrong initial interval, exp %s, got %s", exp, got)
+	}
+
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	minInterval := db.CheckpointInterval()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+		if i := db.CheckpointInterval(); i < minInterval {
+			minInterval = i
+		}
+	}
+	if minInterval >= 200*time.Millisecond {
+		t.Fatalf("interval did not shorten during burst of writes")
+	}
+
+	// Once idle the WAL is truncated, and the interval returns to the maximum.
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		sz, err := db.WALSize()
+		if err != nil {
+			t.Fatalf("failed to get WAL size: %s", err.Error())
+		}
+		if sz == 0 && db.CheckpointInterval() == 200*time.Millisecond {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("WAL not truncated or interval not at maximum when idle, WAL size %d, interval %s",
+				sz, db.CheckpointInterval())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
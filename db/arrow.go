@@ -0,0 +1,279 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+const (
+	arrowBatchSize = 1024
+)
+
+// QueryStringStmtArrow executes a single query that returns rows, and writes
+// those rows to w as an Apache Arrow IPC stream. Rows are written in record
+// batches as they are read from the database, so the full result set is never
+// held in memory.
+//
+// Arrow column types are derived from the declared SQLite column types, using
+// SQLite's type affinity rules. INTEGER columns become int64, REAL columns
+// float64, TEXT columns utf8, BLOB columns binary, and BOOLEAN columns bool.
+// Columns with no declared type, such as expressions, take their type from the
+// first non-NULL value in the first batch, defaulting to utf8. NULL values are
+// written as Arrow nulls.
+func (db *DB) QueryStringStmtArrow(query string, w io.Writer) (retErr error) {
+	if err := db.ops.enter(); err != nil {
+		return err
+	}
+	defer db.ops.exit()
+	stats.Add(numQueries, 1)
+	defer func() {
+		if retErr != nil {
+			stats.Add(numQueryErrors, 1)
+		}
+	}()
+
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := db.syncAttached(ctx, conn); err != nil {
+		return err
+	}
+	defer db.readers.remove(db.readers.add(ReaderArrow, query))
+
+	readOnly, err := db.StmtReadOnlyWithConn(query, conn)
+	if err != nil {
+		return err
+	}
+	if !readOnly {
+		return fmt.Errorf("attempt to change database via query operation")
+	}
+
+	rs, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	return writeArrow(rs, w)
+}
+
+// writeArrow writes the given rows to w as an Arrow IPC stream.
+func writeArrow(rs *sql.Rows, w io.Writer) error {
+	columns, err := rs.Columns()
+	if err != nil {
+		return err
+	}
+	types, err := rs.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	xTypes := make([]string, len(types))
+	for i := range types {
+		xTypes[i] = strings.ToLower(types[i].DatabaseTypeName())
+	}
+
+	mem := memory.NewGoAllocator()
+	var schema *arrow.Schema
+	var iw *ipc.Writer
+	defer func() {
+		if iw != nil {
+			iw.Close()
+		}
+	}()
+
+	batch := make([][]*command.Parameter, 0, arrowBatchSize)
+	flush := func() error {
+		if schema == nil {
+			schema = arrowSchema(columns, xTypes, batch)
+			iw = ipc.NewWriter(w, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		rec, err := arrowRecord(mem, schema, batch)
+		if err != nil {
+			return err
+		}
+		defer rec.Release()
+		batch = batch[:0]
+		return iw.Write(rec)
+	}
+
+	dest := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(dest))
+	for i := range ptrs {
+		ptrs[i] = &dest[i]
+	}
+	for rs.Next() {
+		if err := rs.Scan(ptrs...); err != nil {
+			return err
+		}
+		params, err := normalizeRowValues(dest, xTypes)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, params)
+		if len(batch) == arrowBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	err = iw.Close()
+	iw = nil
+	return err
+}
+
+// arrowSchema returns the Arrow schema for the given columns. Columns without
+// a recognised declared type take their type from the first non-NULL value in
+// rows.
+func arrowSchema(columns, xTypes []string, rows [][]*command.Parameter) *arrow.Schema {
+	fields := make([]arrow.Field, len(columns))
+	for i := range columns {
+		dt := arrowTypeFromDecl(xTypes[i])
+		if dt == nil {
+			dt = arrow.BinaryTypes.String
+			for _, row := range rows {
+				if t := arrowTypeFromParam(row[i]); t != nil {
+					dt = t
+					break
+				}
+			}
+		}
+		fields[i] = arrow.Field{Name: columns[i], Type: dt, Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// arrowTypeFromDecl returns the Arrow type for the given lower-cased, declared
+// SQLite column type. It returns nil if the type cannot be determined from the
+// declaration alone.
+func arrowTypeFromDecl(t string) arrow.DataType {
+	switch {
+	case t == "":
+		return nil
+	case t == "bool" || t == "boolean":
+		return arrow.FixedWidthTypes.Boolean
+	case t == "date" || t == "datetime" || t == "timestamp":
+		return arrow.BinaryTypes.String
+	case strings.Contains(t, "int"):
+		return arrow.PrimitiveTypes.Int64
+	case strings.Contains(t, "char") || strings.Contains(t, "clob") || strings.Contains(t, "text") || t == "json":
+		return arrow.BinaryTypes.String
+	case strings.Contains(t, "blob"):
+		return arrow.BinaryTypes.Binary
+	case strings.Contains(t, "real") || strings.Contains(t, "floa") || strings.Contains(t, "doub"):
+		return arrow.PrimitiveTypes.Float64
+	default:
+		return nil
+	}
+}
+
+// arrowTypeFromParam returns the Arrow type for the given value, or nil if
+// the value is NULL.
+func arrowTypeFromParam(p *command.Parameter) arrow.DataType {
+	switch p.GetValue().(type) {
+	case *command.Parameter_I:
+		return arrow.PrimitiveTypes.Int64
+	case *command.Parameter_D:
+		return arrow.PrimitiveTypes.Float64
+	case *command.Parameter_B:
+		return arrow.FixedWidthTypes.Boolean
+	case *command.Parameter_Y:
+		return arrow.BinaryTypes.Binary
+	case *command.Parameter_S:
+		return arrow.BinaryTypes.String
+	default:
+		return nil
+	}
+}
+
+// arrowRecord builds an Arrow record from the given rows. The caller must
+// release the record.
+func arrowRecord(mem memory.Allocator, schema *arrow.Schema, rows [][]*command.Parameter) (arrow.Record, error) {
+	b := array.NewRecordBuilder(mem, schema)
+	defer b.Release()
+
+	for _, row := range rows {
+		for i, p := range row {
+			if err := appendArrowValue(b.Field(i), p); err != nil {
+				return nil, fmt.Errorf("column %s: %s", schema.Field(i).Name, err.Error())
+			}
+		}
+	}
+	return b.NewRecord(), nil
+}
+
+// appendArrowValue appends the given value to the Arrow array builder. Values
+// are converted to the builder's type where that can be done without loss.
+func appendArrowValue(b array.Builder, p *command.Parameter) error {
+	if p == nil || p.GetValue() == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch ab := b.(type) {
+	case *array.Int64Builder:
+		switch v := p.GetValue().(type) {
+		case *command.Parameter_I:
+			ab.Append(v.I)
+			return nil
+		case *command.Parameter_B:
+			if v.B {
+				ab.Append(1)
+			} else {
+				ab.Append(0)
+			}
+			return nil
+		}
+	case *array.Float64Builder:
+		switch v := p.GetValue().(type) {
+		case *command.Parameter_D:
+			ab.Append(v.D)
+			return nil
+		case *command.Parameter_I:
+			ab.Append(float64(v.I))
+			return nil
+		}
+	case *array.BooleanBuilder:
+		switch v := p.GetValue().(type) {
+		case *command.Parameter_B:
+			ab.Append(v.B)
+			return nil
+		case *command.Parameter_I:
+			ab.Append(v.I != 0)
+			return nil
+		}
+	case *array.BinaryBuilder:
+		switch v := p.GetValue().(type) {
+		case *command.Parameter_Y:
+			ab.Append(v.Y)
+			return nil
+		case *command.Parameter_S:
+			ab.Append([]byte(v.S))
+			return nil
+		}
+	case *array.StringBuilder:
+		ab.Append(csvField(p, ""))
+		return nil
+	}
+	return fmt.Errorf("cannot convert %T to Arrow %s", p.GetValue(), b.Type())
+}
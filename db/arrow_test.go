@@ -0,0 +1,122 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+)
+
+func Test_QueryStringStmtArrow(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, age REAL, data BLOB, active BOOLEAN)`)
+	mustExecute(db, `INSERT INTO foo(id, name, age, data, active) VALUES(1, 'fiona', 20.5, x'010203', true)`)
+	mustExecute(db, `INSERT INTO foo(id, name, age, data, active) VALUES(2, NULL, NULL, NULL, NULL)`)
+	for i := 3; i <= arrowBatchSize+10; i++ {
+		mustExecute(db, `INSERT INTO foo(id, name, age) VALUES(NULL, 'declan', 3)`)
+	}
+
+	var buf bytes.Buffer
+	if err := db.QueryStringStmtArrow("SELECT *, id*2 AS dbl FROM foo ORDER BY id", &buf); err != nil {
+		t.Fatalf("failed to query as Arrow: %s", err.Error())
+	}
+
+	rdr, err := ipc.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to create Arrow reader: %s", err.Error())
+	}
+	defer rdr.Release()
+
+	schema := rdr.Schema()
+	expTypes := []struct {
+		name string
+		typ  arrow.DataType
+	}{
+		{"id", arrow.PrimitiveTypes.Int64},
+		{"name", arrow.BinaryTypes.String},
+		{"age", arrow.PrimitiveTypes.Float64},
+		{"data", arrow.BinaryTypes.Binary},
+		{"active", arrow.FixedWidthTypes.Boolean},
+		{"dbl", arrow.PrimitiveTypes.Int64},
+	}
+	if exp, got := len(expTypes), len(schema.Fields()); exp != got {
+		t.Fatalf("wrong number of fields, exp %d, got %d", exp, got)
+	}
+	for i, e := range expTypes {
+		f := schema.Field(i)
+		if f.Name != e.name || !arrow.TypeEqual(f.Type, e.typ) {
+			t.Fatalf("wrong field %d, exp %s %s, got %s %s", i, e.name, e.typ, f.Name, f.Type)
+		}
+	}
+
+	var nRows, nBatches int
+	for rdr.Next() {
+		rec := rdr.Record()
+		if nBatches == 0 {
+			if exp, got := int64(1), rec.Column(0).(*array.Int64).Value(0); exp != got {
+				t.Fatalf("wrong id, exp %d, got %d", exp, got)
+			}
+			if exp, got := "fiona", rec.Column(1).(*array.String).Value(0); exp != got {
+				t.Fatalf("wrong name, exp %s, got %s", exp, got)
+			}
+			if exp, got := 20.5, rec.Column(2).(*array.Float64).Value(0); exp != got {
+				t.Fatalf("wrong age, exp %f, got %f", exp, got)
+			}
+			if exp, got := []byte{1, 2, 3}, rec.Column(3).(*array.Binary).Value(0); !bytes.Equal(exp, got) {
+				t.Fatalf("wrong data, exp %v, got %v", exp, got)
+			}
+			if !rec.Column(4).(*array.Boolean).Value(0) {
+				t.Fatalf("wrong active, exp true, got false")
+			}
+			for i := 1; i < 5; i++ {
+				if !rec.Column(i).IsNull(1) {
+					t.Fatalf("expected NULL for column %d in second row", i)
+				}
+			}
+		}
+		nRows += int(rec.NumRows())
+		nBatches++
+	}
+	if err := rdr.Err(); err != nil {
+		t.Fatalf("failed to read Arrow stream: %s", err.Error())
+	}
+	if exp, got := arrowBatchSize+10, nRows; exp != got {
+		t.Fatalf("wrong number of rows, exp %d, got %d", exp, got)
+	}
+	if exp, got := 2, nBatches; exp != got {
+		t.Fatalf("wrong number of batches, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_QueryStringStmtArrow_Empty(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+
+	var buf bytes.Buffer
+	if err := db.QueryStringStmtArrow("SELECT * FROM foo", &buf); err != nil {
+		t.Fatalf("failed to query as Arrow: %s", err.Error())
+	}
+	rdr, err := ipc.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to create Arrow reader: %s", err.Error())
+	}
+	defer rdr.Release()
+	if exp, got := 2, len(rdr.Schema().Fields()); exp != got {
+		t.Fatalf("wrong number of fields, exp %d, got %d", exp, got)
+	}
+	if rdr.Next() {
+		t.Fatalf("expected no record batches")
+	}
+
+	if err := db.QueryStringStmtArrow("DELETE FROM foo", &buf); err == nil {
+		t.Fatalf("expected error for write statement")
+	}
+}
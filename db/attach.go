@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+var (
+	// ErrAttachInTransaction is returned by Attach and Detach when a
+	// transaction is open on the database.
+	ErrAttachInTransaction = errors.New("cannot attach or detach while a transaction is open")
+
+	// ErrInvalidAttachAlias is returned by Attach and Detach when the alias
+	// is empty, or is one of the reserved schema names main and temp.
+	ErrInvalidAttachAlias = errors.New("invalid attach alias")
+
+	// ErrNotAttached is returned by Detach when no database is attached
+	// under the alias.
+	ErrNotAttached = errors.New("database not attached")
+)
+
+// attachSet is the set of databases attached with Attach, keyed by alias.
+// Read-only connections are brought in line with the set before each query.
+type attachSet struct {
+	mu   sync.Mutex
+	dbs  map[string]string
+	used atomic.Bool // Whether Attach has ever been called.
+}
+
+// snapshot returns a copy of the set.
+func (a *attachSet) snapshot() map[string]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := make(map[string]string, len(a.dbs))
+	for k, v := range a.dbs {
+		m[k] = v
+	}
+	return m
+}
+
+// Attach attaches the SQLite database at path to the database, under alias,
+// as with ATTACH DATABASE. The attached database's tables can then be
+// referred to as alias.table, including in queries made with Query,
+// QueryStringStmt and QueryStream, and joined with tables in the main
+// database. The database is attached immediately to the connection used for
+// writes, and to each read-only connection before it is next used.
+//
+// ErrAttachInTransaction is returned if a transaction is open, whether begun
+// with Begin or by executing BEGIN. In WAL mode the attached database keeps
+// its own journal mode, and a transaction which writes to both databases is
+// atomic for each database, but not across them. Attached databases are not
+// included in backups of the database, such as those made by Backup,
+// Serialize, or a store Provider, and are not checkpointed by Checkpoint.
+func (db *DB) Attach(alias, path string) error {
+	if err := checkAttachAlias(alias); err != nil {
+		return err
+	}
+	if err := db.withAutoCommitRWConn(func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, fmt.Sprintf("ATTACH DATABASE ? AS %s", quoteIdent(alias)), path)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	db.attached.mu.Lock()
+	defer db.attached.mu.Unlock()
+	if db.attached.dbs == nil {
+		db.attached.dbs = make(map[string]string)
+	}
+	db.attached.dbs[alias] = path
+	db.attached.used.Store(true)
+	return nil
+}
+
+// Detach detaches the database attached under alias by Attach.
+// ErrAttachInTransaction is returned if a transaction is open.
+func (db *DB) Detach(alias string) error {
+	if err := checkAttachAlias(alias); err != nil {
+		return err
+	}
+	db.attached.mu.Lock()
+	_, ok := db.attached.dbs[alias]
+	db.attached.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotAttached, alias)
+	}
+	if err := db.withAutoCommitRWConn(func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, fmt.Sprintf("DETACH DATABASE %s", quoteIdent(alias)))
+		return err
+	}); err != nil {
+		return err
+	}
+
+	db.attached.mu.Lock()
+	defer db.attached.mu.Unlock()
+	delete(db.attached.dbs, alias)
+	return nil
+}
+
+// Attached returns the databases attached with Attach, keyed by alias.
+func (db *DB) Attached() map[string]string {
+	return db.attached.snapshot()
+}
+
+// withAutoCommitRWConn calls fn with the read-write connection, if no
+// transaction is open on it.
+func (db *DB) withAutoCommitRWConn(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	// An open Tx holds the read-write connection, so check before waiting
+	// for it.
+	if db.openTxs.Load() > 0 {
+		return ErrAttachInTransaction
+	}
+	ctx := context.Background()
+	conn, err := db.rwDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var autoCommit bool
+	if err := conn.Raw(func(driverConn interface{}) error {
+		autoCommit = driverConn.(*sqlite3.SQLiteConn).AutoCommit()
+		return nil
+	}); err != nil {
+		return err
+	}
+	if !autoCommit {
+		return ErrAttachInTransaction
+	}
+	return fn(ctx, conn)
+}
+
+// syncAttached attaches to, or detaches from, the given read-only connection
+// so that the databases attached to it match those attached with Attach.
+func (db *DB) syncAttached(ctx context.Context, conn *sql.Conn) error {
+	if !db.attached.used.Load() {
+		return nil
+	}
+	want := db.attached.snapshot()
+
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM pragma_database_list WHERE name NOT IN ('main', 'temp')")
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := want[name]; ok {
+			delete(want, name)
+		} else {
+			stale = append(stale, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range stale {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DETACH DATABASE %s", quoteIdent(name))); err != nil {
+			return fmt.Errorf("detach %s: %s", name, err.Error())
+		}
+	}
+	for alias, path := range want {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("ATTACH DATABASE ? AS %s", quoteIdent(alias)), path); err != nil {
+			return fmt.Errorf("attach %s: %s", alias, err.Error())
+		}
+	}
+	return nil
+}
+
+// checkAttachAlias checks that alias may be used to attach a database.
+func checkAttachAlias(alias string) error {
+	if alias == "" || strings.EqualFold(alias, "main") || strings.EqualFold(alias, "temp") {
+		return fmt.Errorf("%w: %q", ErrInvalidAttachAlias, alias)
+	}
+	return nil
+}
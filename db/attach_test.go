@@ -0,0 +1,112 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_AttachDetach(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	other, otherPath := mustCreateOnDiskDatabaseWAL()
+	defer os.Remove(otherPath)
+	mustExecute(other, "CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, age INTEGER)")
+	mustExecute(other, "INSERT INTO bar(id, age) VALUES(1, 20)")
+	if err := other.Close(); err != nil {
+		t.Fatalf("failed to close other database: %s", err.Error())
+	}
+
+	if err := db.Attach("other", otherPath); err != nil {
+		t.Fatalf("failed to attach database: %s", err.Error())
+	}
+	if exp, got := otherPath, db.Attached()["other"]; exp != got {
+		t.Fatalf("wrong attached path, exp %s, got %s", exp, got)
+	}
+
+	// Query across both databases, more than once so that more than one
+	// read-only connection may be used.
+	for i := 0; i < 3; i++ {
+		rows, err := db.QueryStringStmt("SELECT foo.name, bar.age FROM foo JOIN other.bar ON foo.id = bar.id")
+		if err != nil {
+			t.Fatalf("failed to query across databases: %s", err.Error())
+		}
+		if exp, got := `[{"columns":["name","age"],"types":["text","integer"],"values":[["fiona",20]]}]`, asJSON(rows); exp != got {
+			t.Fatalf("wrong rows across databases, exp %s, got %s", exp, got)
+		}
+	}
+
+	// Writes through the attached alias are possible too.
+	mustExecute(db, "INSERT INTO other.bar(id, age) VALUES(2, 30)")
+	rows, err := db.QueryStringStmt("SELECT COUNT(*) FROM other.bar")
+	if err != nil {
+		t.Fatalf("failed to query attached database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong rows from attached database, exp %s, got %s", exp, got)
+	}
+
+	if err := db.Detach("other"); err != nil {
+		t.Fatalf("failed to detach database: %s", err.Error())
+	}
+	if len(db.Attached()) != 0 {
+		t.Fatalf("expected no attached databases, got %v", db.Attached())
+	}
+	rows, err = db.QueryStringStmt("SELECT COUNT(*) FROM other.bar")
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err.Error())
+	}
+	if len(rows) != 1 || !strings.Contains(rows[0].Error, "no such table") {
+		t.Fatalf("expected no such table error after detach, got %s", asJSON(rows))
+	}
+
+	if err := db.Detach("other"); !errors.Is(err, ErrNotAttached) {
+		t.Fatalf("expected ErrNotAttached, got %v", err)
+	}
+}
+
+func Test_AttachInvalidAlias(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	for _, alias := range []string{"", "main", "TEMP"} {
+		if err := db.Attach(alias, mustTempPath()); !errors.Is(err, ErrInvalidAttachAlias) {
+			t.Fatalf("expected ErrInvalidAttachAlias for %q, got %v", alias, err)
+		}
+	}
+}
+
+func Test_AttachInTransaction(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	otherPath := mustTempPath()
+	defer os.Remove(otherPath)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if err := db.Attach("other", otherPath); err != ErrAttachInTransaction {
+		t.Fatalf("expected ErrAttachInTransaction with open Tx, got %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back transaction: %s", err.Error())
+	}
+
+	mustExecute(db, "BEGIN")
+	if err := db.Attach("other", otherPath); err != ErrAttachInTransaction {
+		t.Fatalf("expected ErrAttachInTransaction after BEGIN, got %v", err)
+	}
+	mustExecute(db, "COMMIT")
+
+	if err := db.Attach("other", otherPath); err != nil {
+		t.Fatalf("failed to attach database: %s", err.Error())
+	}
+}
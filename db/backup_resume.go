@@ -0,0 +1,116 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrNoResumableBackup is returned by BackupAt when a non-zero offset is
+	// given, but there is no backup in progress to resume.
+	ErrNoResumableBackup = errors.New("no resumable backup in progress")
+
+	// ErrInvalidBackupOffset is returned by BackupAt when the offset is
+	// beyond the end of the backup in progress.
+	ErrInvalidBackupOffset = errors.New("invalid backup offset")
+)
+
+// resumableBackup is a binary backup of the database, kept on disk so that
+// it can be read in more than one call to BackupAt.
+type resumableBackup struct {
+	mu   sync.Mutex
+	path string // Path to the backup file, empty if none in progress.
+	size int64
+}
+
+// remove deletes the backup file, if any. It must be called with mu held.
+func (r *resumableBackup) remove() error {
+	if r.path == "" {
+		return nil
+	}
+	err := os.Remove(r.path)
+	r.path, r.size = "", 0
+	return err
+}
+
+// BackupAt writes the binary backup of the database to w, starting at the
+// byte offset offset, and returns the offset reached. An offset of zero
+// takes a new backup, discarding any backup in progress. A non-zero offset
+// resumes reading the backup taken by the most recent BackupAt call with an
+// offset of zero, so offsets are consistent across calls even if the
+// database changes in between. If writing to w fails, the offset reached is
+// returned along with the error, so the caller can resume from that offset.
+//
+// The backup is kept on disk until it has been completely written, a new
+// backup is started, or the database is closed.
+func (db *DB) BackupAt(offset int64, w io.Writer) (int64, error) {
+	r := &db.resumable
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if offset < 0 {
+		return offset, ErrInvalidBackupOffset
+	}
+	if offset == 0 {
+		if err := r.remove(); err != nil {
+			return 0, err
+		}
+		if err := db.startResumableBackup(r); err != nil {
+			return 0, err
+		}
+	}
+	if r.path == "" {
+		return offset, ErrNoResumableBackup
+	}
+	if offset > r.size {
+		return offset, ErrInvalidBackupOffset
+	}
+
+	fd, err := os.Open(r.path)
+	if err != nil {
+		return offset, err
+	}
+	defer fd.Close()
+	if _, err := fd.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	n, err := io.Copy(w, fd)
+	offset += n
+	if err != nil {
+		return offset, err
+	}
+	if offset == r.size {
+		if err := r.remove(); err != nil {
+			return offset, err
+		}
+	}
+	return offset, nil
+}
+
+// startResumableBackup takes a new binary backup of the database, and
+// records it in r. It must be called with r.mu held.
+func (db *DB) startResumableBackup(r *resumableBackup) error {
+	fd, err := os.CreateTemp("", "rqlite-backup-resume")
+	if err != nil {
+		return err
+	}
+	path := fd.Name()
+	if err := fd.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := db.Backup(path, false); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("resumable backup: %s", err.Error())
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	r.path, r.size = path, fi.Size()
+	return nil
+}
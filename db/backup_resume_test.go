@@ -0,0 +1,87 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// failingWriter accepts n bytes, and then fails.
+type failingWriter struct {
+	buf *bytes.Buffer
+	n   int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > f.n {
+		f.buf.Write(p[:f.n])
+		n := f.n
+		f.n = 0
+		return n, errors.New("write failed")
+	}
+	f.n -= len(p)
+	return f.buf.Write(p)
+}
+
+func Test_DBBackupAt(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 100; i++ {
+		mustExecute(db, fmt.Sprintf(`INSERT INTO foo(name) VALUES("name-%d")`, i))
+	}
+
+	if _, err := db.BackupAt(10, &bytes.Buffer{}); err != ErrNoResumableBackup {
+		t.Fatalf("expected ErrNoResumableBackup, got %v", err)
+	}
+
+	// Fail part way through the first read.
+	var buf bytes.Buffer
+	offset, err := db.BackupAt(0, &failingWriter{buf: &buf, n: 5000})
+	if err == nil {
+		t.Fatalf("expected write error")
+	}
+	if exp, got := int64(5000), offset; exp != got {
+		t.Fatalf("wrong offset after failed write, exp %d, got %d", exp, got)
+	}
+
+	// Changes made before resuming must not appear in the backup.
+	mustExecute(db, `INSERT INTO foo(name) VALUES("late")`)
+
+	if _, err := db.BackupAt(offset+1<<30, &bytes.Buffer{}); err != ErrInvalidBackupOffset {
+		t.Fatalf("expected ErrInvalidBackupOffset, got %v", err)
+	}
+	offset, err = db.BackupAt(offset, &buf)
+	if err != nil {
+		t.Fatalf("failed to resume backup: %s", err.Error())
+	}
+	if exp, got := int64(buf.Len()), offset; exp != got {
+		t.Fatalf("wrong final offset, exp %d, got %d", exp, got)
+	}
+
+	// The backup is discarded once completely read.
+	if _, err := db.BackupAt(offset, &bytes.Buffer{}); err != ErrNoResumableBackup {
+		t.Fatalf("expected ErrNoResumableBackup after completion, got %v", err)
+	}
+
+	bPath := mustTempFile()
+	defer os.Remove(bPath)
+	if err := os.WriteFile(bPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write reassembled backup: %s", err.Error())
+	}
+	bDB, err := Open(bPath, false, false)
+	if err != nil {
+		t.Fatalf("failed to open reassembled backup: %s", err.Error())
+	}
+	defer bDB.Close()
+	rows, err := bDB.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query reassembled backup: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[100]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong row count, exp %s, got %s", exp, got)
+	}
+}
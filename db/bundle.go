@@ -0,0 +1,255 @@
+package db
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	bundleManifestName = "manifest.json"
+)
+
+// ErrInvalidBundle is returned when a bundle cannot be restored because it is
+// malformed.
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// BundleManifest describes the databases contained in a bundle.
+type BundleManifest struct {
+	Databases []BundleDatabase `json:"databases"`
+}
+
+// BundleDatabase describes a single database contained in a bundle.
+type BundleDatabase struct {
+	// Name is the schema name of the database, "main" for the main database.
+	Name string `json:"name"`
+
+	// File is the name of the bundle entry holding the database.
+	File string `json:"file"`
+
+	// Path is the path of the database file at the time of export.
+	Path string `json:"path"`
+}
+
+// ExportBundle writes the main database, and every database attached to it,
+// to w as a tar archive. The first entry in the archive is a JSON manifest,
+// describing each database, and it is followed by one SQLite file per
+// database. Each SQLite file is in DELETE mode.
+//
+// Databases are attached only to the read-write connection, so it is those
+// attachments which are exported. Since there is only one read-write
+// connection, no writes can take place via this DB while the export is in
+// progress, and the export is consistent across all databases.
+func (db *DB) ExportBundle(w io.Writer) error {
+	ctx := context.Background()
+	conn, err := db.rwDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, "PRAGMA database_list")
+	if err != nil {
+		return err
+	}
+	var manifest BundleManifest
+	for rows.Next() {
+		var seq int
+		var d BundleDatabase
+		if err := rows.Scan(&seq, &d.Name, &d.Path); err != nil {
+			rows.Close()
+			return err
+		}
+		if d.Name == "temp" {
+			continue
+		}
+		d.File = d.Name + ".db"
+		manifest.Databases = append(manifest.Databases, d)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	tmpDir, err := os.MkdirTemp("", "rqlite-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	for _, d := range manifest.Databases {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("VACUUM %s INTO ?", quoteIdent(d.Name)),
+			filepath.Join(tmpDir, d.File)); err != nil {
+			return fmt.Errorf("export database %s: %s", d.Name, err.Error())
+		}
+	}
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{
+		Name: bundleManifestName,
+		Mode: 0644,
+		Size: int64(len(b)),
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return err
+	}
+	for _, d := range manifest.Databases {
+		if err := addFileToTar(tw, filepath.Join(tmpDir, d.File), d.File); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// RestoreBundle restores a bundle, as written by ExportBundle, read from r.
+// The contents of the main database are replaced with the main database in
+// the bundle. Every other database in the bundle is written to dir, named
+// after its schema, and attached to the database under its original schema
+// name. Any existing file in dir with the same name is overwritten, and any
+// database already attached with the same name is first detached. dir must
+// exist.
+func (db *DB) RestoreBundle(r io.Reader, dir string) error {
+	// Stage in dir, so databases can be moved into place with a rename.
+	tmpDir, err := os.MkdirTemp(dir, "rqlite-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrInvalidBundle, err.Error())
+	}
+	if hdr.Name != bundleManifestName {
+		return fmt.Errorf("%s: first entry is %s, not manifest", ErrInvalidBundle, hdr.Name)
+	}
+	var manifest BundleManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("%s: %s", ErrInvalidBundle, err.Error())
+	}
+	files := make(map[string]bool, len(manifest.Databases))
+	for _, d := range manifest.Databases {
+		if d.File != filepath.Base(d.File) {
+			return fmt.Errorf("%s: illegal file name %s", ErrInvalidBundle, d.File)
+		}
+		files[d.File] = true
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", ErrInvalidBundle, err.Error())
+		}
+		if !files[hdr.Name] {
+			return fmt.Errorf("%s: unexpected entry %s", ErrInvalidBundle, hdr.Name)
+		}
+		if err := writeFileFromReader(filepath.Join(tmpDir, hdr.Name), tr); err != nil {
+			return err
+		}
+	}
+	for _, d := range manifest.Databases {
+		if !IsValidSQLiteFile(filepath.Join(tmpDir, d.File)) {
+			return fmt.Errorf("%s: %s is not a SQLite file", ErrInvalidBundle, d.File)
+		}
+	}
+
+	for _, d := range manifest.Databases {
+		if d.Name == "main" {
+			if err := db.restoreMain(filepath.Join(tmpDir, d.File)); err != nil {
+				return fmt.Errorf("restore main database: %s", err.Error())
+			}
+			continue
+		}
+		if err := db.restoreAttached(d, filepath.Join(tmpDir, d.File), dir); err != nil {
+			return fmt.Errorf("restore database %s: %s", d.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// restoreMain replaces the contents of the main database with the contents
+// of the SQLite file at path.
+func (db *DB) restoreMain(path string) error {
+	srcDB, err := Open(path, false, false)
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+	return srcDB.CloneInto(db)
+}
+
+// restoreAttached moves the SQLite file at path into dir, and attaches it
+// using the schema name in d.
+func (db *DB) restoreAttached(d BundleDatabase, path, dir string) error {
+	ctx := context.Background()
+	conn, err := db.rwDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var n int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_database_list WHERE name = ?",
+		d.Name).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DETACH DATABASE %s", quoteIdent(d.Name))); err != nil {
+			return err
+		}
+	}
+
+	dstPath := filepath.Join(dir, d.File)
+	if err := os.Rename(path, dstPath); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("ATTACH DATABASE ? AS %s", quoteIdent(d.Name)), dstPath)
+	return err
+}
+
+func addFileToTar(tw *tar.Writer, path, name string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: fi.Size(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, fd)
+	return err
+}
+
+func writeFileFromReader(path string, r io.Reader) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fd, r); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
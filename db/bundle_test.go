@@ -0,0 +1,75 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ExportRestoreBundle(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	auxPath := filepath.Join(t.TempDir(), "aux.db")
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	mustExecute(db, `ATTACH DATABASE '`+auxPath+`' AS aux`)
+	mustExecute(db, `CREATE TABLE aux.bar (id INTEGER NOT NULL PRIMARY KEY, age INTEGER)`)
+	mustExecute(db, `INSERT INTO aux.bar(id, age) VALUES(1, 20)`)
+	mustExecute(db, `INSERT INTO aux.bar(id, age) VALUES(2, 30)`)
+
+	var buf bytes.Buffer
+	if err := db.ExportBundle(&buf); err != nil {
+		t.Fatalf("failed to export bundle: %s", err.Error())
+	}
+
+	newDB, newPath := mustCreateOnDiskDatabaseWAL()
+	defer newDB.Close()
+	defer os.Remove(newPath)
+	mustExecute(newDB, `CREATE TABLE qux (id INTEGER NOT NULL PRIMARY KEY)`)
+
+	dir := t.TempDir()
+	if err := newDB.RestoreBundle(&buf, dir); err != nil {
+		t.Fatalf("failed to restore bundle: %s", err.Error())
+	}
+	if !IsValidSQLiteFile(filepath.Join(dir, "aux.db")) {
+		t.Fatalf("attached database not restored to directory")
+	}
+
+	// Attached databases are only visible on the read-write connection.
+	res, err := newDB.RequestStringStmts([]string{
+		`SELECT * FROM foo`,
+		`SELECT * FROM aux.bar ORDER BY id`,
+		`SELECT name FROM sqlite_master`,
+	})
+	if err != nil {
+		t.Fatalf("failed to query restored database: %s", err.Error())
+	}
+	exp := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]},` +
+		`{"columns":["id","age"],"types":["integer","integer"],"values":[[1,20],[2,30]]},` +
+		`{"columns":["name"],"types":["text"],"values":[["foo"]]}]`
+	if got := asJSON(res); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Restoring again should replace the existing attachment.
+	buf.Reset()
+	if err := db.ExportBundle(&buf); err != nil {
+		t.Fatalf("failed to export bundle: %s", err.Error())
+	}
+	if err := newDB.RestoreBundle(&buf, dir); err != nil {
+		t.Fatalf("failed to restore bundle a second time: %s", err.Error())
+	}
+}
+
+func Test_RestoreBundle_Invalid(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	if err := db.RestoreBundle(bytes.NewBufferString("not a bundle"), t.TempDir()); err == nil {
+		t.Fatalf("expected error restoring invalid bundle")
+	}
+}
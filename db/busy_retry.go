@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/rqlite/go-sqlite3"
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+const (
+	// defaultBusyRetryBackoff is the base backoff between retries of a busy
+	// write, if none is configured.
+	defaultBusyRetryBackoff = 10 * time.Millisecond
+
+	// maxBusyRetryBackoff is the maximum backoff between retries of a busy
+	// write.
+	maxBusyRetryBackoff = time.Second
+)
+
+// busyRetrier decides whether, and after how long, a write which failed
+// because the database was busy or locked should be retried.
+type busyRetrier struct {
+	retries int
+	backoff time.Duration
+}
+
+// newBusyRetrier returns a busyRetrier configured by cfg, or nil if busy
+// writes are not to be retried.
+func newBusyRetrier(cfg *Config) *busyRetrier {
+	if cfg.BusyRetries <= 0 {
+		return nil
+	}
+	backoff := cfg.BusyRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultBusyRetryBackoff
+	}
+	return &busyRetrier{
+		retries: cfg.BusyRetries,
+		backoff: backoff,
+	}
+}
+
+// Retry waits before retry number attempt, counting from zero, and returns
+// true if the write should be retried. It returns false, without waiting, if
+// the retries are exhausted, or if ctx is done before the wait is over. The
+// wait doubles with each attempt, up to a maximum, and is jittered so that
+// competing writers do not retry in lockstep. It is safe to call Retry on a
+// nil busyRetrier.
+func (b *busyRetrier) Retry(ctx context.Context, attempt int) bool {
+	if b == nil || attempt >= b.retries {
+		return false
+	}
+	d := b.backoff << uint(attempt)
+	if d <= 0 || d > maxBusyRetryBackoff {
+		d = maxBusyRetryBackoff
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		stats.Add(numBusyRetries, 1)
+		return true
+	}
+}
+
+// isBusyError returns whether err indicates the database was busy or locked.
+func isBusyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var se sqlite3.Error
+	if errors.As(err, &se) {
+		return se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked
+	}
+	return isBusyErrorMsg(err.Error())
+}
+
+// isBusyErrorMsg returns whether the error message msg indicates the database
+// was busy or locked.
+func isBusyErrorMsg(msg string) bool {
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked")
+}
+
+// txFailedBusy returns whether a transaction, executed with results res and
+// error err, was rolled back because the database was busy or locked.
+func txFailedBusy(res []*command.ExecuteQueryResponse, err error) bool {
+	if err != nil {
+		return isBusyError(err)
+	}
+	return len(res) > 0 && isBusyErrorMsg(res[len(res)-1].GetError())
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_BusyRetry(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.BusyRetries = 50
+	cfg.BusyRetryBackoff = 5 * time.Millisecond
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if err := db.SetBusyTimeout(10, -1); err != nil {
+		t.Fatalf("failed to set busy timeout: %s", err.Error())
+	}
+
+	// Hold the write lock from another connection, releasing it after a
+	// period much longer than the busy timeout.
+	other, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open other connection: %s", err.Error())
+	}
+	defer other.Close()
+	lock := func() func() {
+		conn, err := other.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get other connection: %s", err.Error())
+		}
+		if _, err := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); err != nil {
+			t.Fatalf("failed to lock database: %s", err.Error())
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			conn.Close()
+		}
+	}
+
+	ResetStats()
+	for _, tx := range []bool{false, true} {
+		unlock := lock()
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			unlock()
+		}()
+		r, err := db.Execute(&command.Request{
+			Transaction: tx,
+			Statements: []*command.Statement{
+				{Sql: `INSERT INTO foo(name) VALUES("fiona")`},
+			},
+		}, false)
+		if err != nil {
+			t.Fatalf("failed to execute (transaction %t): %s", tx, err.Error())
+		}
+		if r[0].GetError() != "" {
+			t.Fatalf("failed to execute (transaction %t): %s", tx, r[0].GetError())
+		}
+	}
+	if stats.Get(numBusyRetries).String() == "0" {
+		t.Fatalf("expected busy retries")
+	}
+	rows, err := db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Once the retries are exhausted the error is returned.
+	db.busyRetrier.retries = 1
+	unlock := lock()
+	defer unlock()
+	r, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+	if !isBusyErrorMsg(r[0].GetError()) {
+		t.Fatalf("expected busy error, got %q", r[0].GetError())
+	}
+}
+
+func Test_BusyRetrier(t *testing.T) {
+	var b *busyRetrier
+	if b.Retry(context.Background(), 0) {
+		t.Fatalf("nil retrier should not retry")
+	}
+	if newBusyRetrier(NewConfig()) != nil {
+		t.Fatalf("busy retries enabled by default")
+	}
+
+	b = &busyRetrier{retries: 2, backoff: time.Millisecond}
+	if !b.Retry(context.Background(), 0) || !b.Retry(context.Background(), 1) {
+		t.Fatalf("expected retries")
+	}
+	if b.Retry(context.Background(), 2) {
+		t.Fatalf("expected retries to be exhausted")
+	}
+
+	b = &busyRetrier{retries: 1, backoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if b.Retry(ctx, 0) {
+		t.Fatalf("expected no retry once context is done")
+	}
+
+	if !isBusyError(errors.New("database is locked")) || isBusyError(errors.New("no such table")) || isBusyError(nil) {
+		t.Fatalf("wrong busy error detection")
+	}
+}
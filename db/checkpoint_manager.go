@@ -0,0 +1,132 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// checkpointManagerMaxBackoff is the largest multiple of its interval a
+// CheckpointManager waits between checkpoints while backing off.
+const checkpointManagerMaxBackoff = 16
+
+// CheckpointManager checkpoints the WAL of a database in TRUNCATE mode at a
+// fixed interval, in the background. Each checkpoint is given a timeout, so
+// that it does not wait indefinitely on readers. If a checkpoint times out,
+// or cannot complete because snapshot readers are open, the wait before the
+// next checkpoint is doubled, up to 16 times the interval, so the manager
+// backs off instead of contending with the readers. The wait returns to the
+// interval once a checkpoint succeeds.
+//
+// A CheckpointManager is an alternative to the checkpointing configured when
+// the database is opened, and must not be combined with it, as described by
+// Config. It is intended for callers who control when checkpointing starts
+// and stops.
+type CheckpointManager struct {
+	db       *DB
+	interval time.Duration
+	timeout  time.Duration
+
+	mu        sync.Mutex
+	wait      time.Duration
+	nTimeouts int
+	done      chan struct{} // Closed to stop checkpointing, nil if not started.
+	stopped   chan struct{} // Closed once checkpointing has stopped.
+}
+
+// NewCheckpointManager returns a CheckpointManager which checkpoints db every
+// interval, giving each checkpoint timeout to complete. Call Start to start
+// checkpointing.
+func NewCheckpointManager(db *DB, interval, timeout time.Duration) *CheckpointManager {
+	return &CheckpointManager{
+		db:       db,
+		interval: interval,
+		timeout:  timeout,
+		wait:     interval,
+	}
+}
+
+// Wait returns how long the CheckpointManager waits before its next
+// checkpoint, which is longer than the interval while it is backing off.
+func (m *CheckpointManager) Wait() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.wait
+}
+
+// Start starts checkpointing in the background. Calling Start on a
+// CheckpointManager which is already started is a no-op.
+func (m *CheckpointManager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done != nil {
+		return
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	m.done, m.stopped = done, stopped
+	go func() {
+		defer close(stopped)
+		for {
+			timer := time.NewTimer(m.Wait())
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			m.step()
+		}
+	}()
+}
+
+// Stop stops checkpointing, waiting for any checkpoint in progress to
+// complete. It is safe to call Stop on a CheckpointManager which is not
+// started.
+func (m *CheckpointManager) Stop() {
+	m.mu.Lock()
+	done, stopped := m.done, m.stopped
+	m.done, m.stopped = nil, nil
+	m.mu.Unlock()
+	if done == nil {
+		return
+	}
+	// m.mu must not be held while waiting, as the checkpointing goroutine
+	// takes it.
+	close(done)
+	<-stopped
+}
+
+// step runs a single checkpoint, and sets the wait before the next.
+func (m *CheckpointManager) step() {
+	stats.Add(numManagedCheckpoints, 1)
+	var res CheckpointResult
+	err := ErrSnapshotReadersOpen
+	if m.db.NumSnapshotReaders() == 0 {
+		res, err = m.db.checkpoint(CheckpointTruncate, m.timeout)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.nTimeouts = 0
+		m.wait = m.interval
+		if res.LogFrames > 0 {
+			m.db.logger.Printf("checkpoint manager checkpointed %d of %d WAL frames",
+				res.CheckpointedFrames, res.LogFrames)
+		}
+		return
+	}
+
+	stats.Add(numManagedFailures, 1)
+	if !errors.Is(err, ErrCheckpointTimeout) && !errors.Is(err, ErrSnapshotReadersOpen) {
+		m.db.logger.Printf("checkpoint manager failed to checkpoint: %s", err.Error())
+		return
+	}
+	m.nTimeouts++
+	m.wait *= 2
+	if max := checkpointManagerMaxBackoff * m.interval; m.wait > max {
+		m.wait = max
+	}
+	m.db.logger.Printf("checkpoint manager checkpoint blocked by readers (%d consecutive), next attempt in %s: %s",
+		m.nTimeouts, m.wait, err.Error())
+}
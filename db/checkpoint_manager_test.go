@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_CheckpointManager(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	if mustFileSize(db.WALPath()) == 0 {
+		t.Fatalf("WAL is empty after write")
+	}
+
+	m := NewCheckpointManager(db, 10*time.Millisecond, 100*time.Millisecond)
+	m.Start()
+	defer m.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for mustFileSize(db.WALPath()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("WAL not truncated by checkpoint manager")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	m.Stop()
+	if m.done != nil {
+		t.Fatalf("checkpoint manager not stopped")
+	}
+	m.Stop()
+}
+
+func Test_CheckpointManager_Backoff(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	// Hold a read transaction open, so a TRUNCATE checkpoint times out.
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get read-only connection: %s", err.Error())
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		t.Fatalf("failed to begin read transaction: %s", err.Error())
+	}
+	var n int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM foo").Scan(&n); err != nil {
+		t.Fatalf("failed to read in transaction: %s", err.Error())
+	}
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+
+	interval := time.Second
+	m := NewCheckpointManager(db, interval, 10*time.Millisecond)
+	for i, exp := range []time.Duration{2 * interval, 4 * interval, 8 * interval, 16 * interval, 16 * interval} {
+		m.step()
+		if got := m.Wait(); exp != got {
+			t.Fatalf("wrong wait after %d timeouts, exp %s, got %s", i+1, exp, got)
+		}
+	}
+
+	// Once the reader is done, the next checkpoint succeeds and the wait is
+	// reset.
+	if _, err := conn.ExecContext(ctx, "ROLLBACK"); err != nil {
+		t.Fatalf("failed to end read transaction: %s", err.Error())
+	}
+	m.step()
+	if exp, got := interval, m.Wait(); exp != got {
+		t.Fatalf("wrong wait after success, exp %s, got %s", exp, got)
+	}
+	if mustFileSize(db.WALPath()) != 0 {
+		t.Fatalf("WAL not truncated after success")
+	}
+
+	// Snapshot readers also cause the manager to back off.
+	s, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to open snapshot reader: %s", err.Error())
+	}
+	defer s.Close()
+	m.step()
+	if exp, got := 2*interval, m.Wait(); exp != got {
+		t.Fatalf("wrong wait with snapshot reader open, exp %s, got %s", exp, got)
+	}
+}
+
+// Test_CheckpointManager_StartStopConcurrent tests that a CheckpointManager
+// can be started and stopped from many goroutines at once. Run with -race.
+func Test_CheckpointManager_StartStopConcurrent(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	m := NewCheckpointManager(db, time.Millisecond, 100*time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				m.Start()
+				m.Wait()
+				m.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+	m.Stop()
+	if m.done != nil {
+		t.Fatalf("checkpoint manager not stopped")
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// QueryStringStmtCSV executes a single query that returns rows, and writes those
+// rows to w in RFC 4180 CSV format, with CRLF record terminators. Line breaks
+// within values are written unchanged. Rows are streamed to w as they are read
+// from the database, so the full result set is never held in memory. If header is true the column names are written as the
+// first record. BLOB values are base64-encoded and NULL values are written as
+// empty fields.
+func (db *DB) QueryStringStmtCSV(query string, w io.Writer, header bool) error {
+	return db.QueryStringStmtCSVWithNull(query, w, header, "")
+}
+
+// QueryStringStmtCSVWithNull is identical to QueryStringStmtCSV, except that NULL
+// values are written as the given string.
+func (db *DB) QueryStringStmtCSVWithNull(query string, w io.Writer, header bool, null string) (retErr error) {
+	if err := db.ops.enter(); err != nil {
+		return err
+	}
+	defer db.ops.exit()
+	stats.Add(numQueries, 1)
+	defer func() {
+		if retErr != nil {
+			stats.Add(numQueryErrors, 1)
+		}
+	}()
+
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := db.syncAttached(ctx, conn); err != nil {
+		return err
+	}
+	defer db.readers.remove(db.readers.add(ReaderCSV, query))
+
+	readOnly, err := db.StmtReadOnlyWithConn(query, conn)
+	if err != nil {
+		return err
+	}
+	if !readOnly {
+		return fmt.Errorf("attempt to change database via query operation")
+	}
+
+	rs, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	return writeCSV(rs, w, header, null)
+}
+
+// writeCSV writes the given rows to w in CSV format.
+func writeCSV(rs *sql.Rows, w io.Writer, header bool, null string) error {
+	columns, err := rs.Columns()
+	if err != nil {
+		return err
+	}
+	types, err := rs.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	xTypes := make([]string, len(types))
+	for i := range types {
+		xTypes[i] = strings.ToLower(types[i].DatabaseTypeName())
+	}
+
+	// RFC 4180 terminates records with CRLF, but csv.Writer with UseCRLF set
+	// also rewrites any LF inside a quoted field, changing the value. So each
+	// record is encoded with an LF terminator, which is then replaced.
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	writeRecord := func(record []string) error {
+		buf.Reset()
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		b := buf.Bytes()
+		b = append(b[:len(b)-1], '\r', '\n')
+		_, err := w.Write(b)
+		return err
+	}
+	if header {
+		if err := writeRecord(columns); err != nil {
+			return err
+		}
+	}
+
+	record := make([]string, len(columns))
+	dest := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(dest))
+	for i := range ptrs {
+		ptrs[i] = &dest[i]
+	}
+	for rs.Next() {
+		if err := rs.Scan(ptrs...); err != nil {
+			return err
+		}
+		params, err := normalizeRowValues(dest, xTypes)
+		if err != nil {
+			return err
+		}
+		for i := range params {
+			record[i] = csvField(params[i], null)
+		}
+		if err := writeRecord(record); err != nil {
+			return err
+		}
+	}
+	return rs.Err()
+}
+
+// csvField returns the CSV representation of the given parameter.
+func csvField(p *command.Parameter, null string) string {
+	switch v := p.GetValue().(type) {
+	case *command.Parameter_I:
+		return strconv.FormatInt(v.I, 10)
+	case *command.Parameter_D:
+		return strconv.FormatFloat(v.D, 'g', -1, 64)
+	case *command.Parameter_B:
+		return strconv.FormatBool(v.B)
+	case *command.Parameter_Y:
+		return base64.StdEncoding.EncodeToString(v.Y)
+	case *command.Parameter_S:
+		return v.S
+	default:
+		return null
+	}
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func Test_QueryStringStmtCSV(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, age REAL, data BLOB)`)
+	mustExecute(db, `INSERT INTO foo(id, name, age, data) VALUES(1, 'fiona', 20.5, x'010203')`)
+	mustExecute(db, `INSERT INTO foo(id, name, age, data) VALUES(2, 'declan, the "great"', NULL, NULL)`)
+	mustExecute(db, `INSERT INTO foo(id, name, age, data) VALUES(3, 'line1`+"\n"+`line2', 3, NULL)`)
+	mustExecute(db, `INSERT INTO foo(id, name, age, data) VALUES(4, 'line1`+"\r\n"+`line2', 4, NULL)`)
+
+	var buf bytes.Buffer
+	if err := db.QueryStringStmtCSV("SELECT * FROM foo ORDER BY id", &buf, true); err != nil {
+		t.Fatalf("failed to query as CSV: %s", err.Error())
+	}
+	exp := "id,name,age,data\r\n" +
+		"1,fiona,20.5,AQID\r\n" +
+		"2,\"declan, the \"\"great\"\"\",,\r\n" +
+		"3,\"line1\nline2\",3,\r\n" +
+		"4,\"line1\r\nline2\",4,\r\n"
+	// Only record terminators are CRLF. Line breaks within values must be
+	// written byte for byte as stored.
+	if got := buf.String(); exp != got {
+		t.Fatalf("unexpected CSV output\nexp: %q\ngot: %q", exp, got)
+	}
+
+	buf.Reset()
+	if err := db.QueryStringStmtCSVWithNull("SELECT id, age FROM foo WHERE id = 2", &buf, false, "NULL"); err != nil {
+		t.Fatalf("failed to query as CSV: %s", err.Error())
+	}
+	if exp, got := "2,NULL\r\n", buf.String(); exp != got {
+		t.Fatalf("unexpected CSV output\nexp: %q\ngot: %q", exp, got)
+	}
+
+	buf.Reset()
+	if err := db.QueryStringStmtCSV("SELECT * FROM foo WHERE id = 99", &buf, true); err != nil {
+		t.Fatalf("failed to query as CSV: %s", err.Error())
+	}
+	if exp, got := "id,name,age,data\r\n", buf.String(); exp != got {
+		t.Fatalf("unexpected CSV output for empty result\nexp: %q\ngot: %q", exp, got)
+	}
+
+	if err := db.QueryStringStmtCSV("DELETE FROM foo", &buf, true); err == nil {
+		t.Fatalf("expected error for write statement")
+	}
+	if err := db.QueryStringStmtCSV("SELECT * FROM bar", &buf, true); err == nil {
+		t.Fatalf("expected error for nonexistent table")
+	}
+}
@@ -14,23 +14,40 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rqlite/go-sqlite3"
 	command "github.com/rqlite/rqlite/v8/command/proto"
 	"github.com/rqlite/rqlite/v8/db/humanize"
+	"github.com/rqlite/rqlite/v8/db/wal"
 )
 
 const (
 	SQLiteHeaderSize = 32
 	bkDelay          = 250
 	durToOpenLog     = 2 * time.Second
+
+	// walPressureCheckpointTimeout is the busy timeout for checkpoints
+	// triggered because the WAL has reached WALCheckpointThreshold.
+	walPressureCheckpointTimeout = 100 * time.Millisecond
+
+	// closeCheckpointTimeout bounds how long CloseWithCheckpoint waits for
+	// readers and writers to release the database.
+	closeCheckpointTimeout = 2 * time.Second
 )
 
 const (
 	openDuration              = "open_duration_ms"
 	numCheckpoints            = "checkpoints"
 	numCheckpointErrors       = "checkpoint_errors"
+	numCheckpointsSkipped     = "checkpoints_skipped"
+	numCheckpointTimeouts     = "checkpoint_timeouts"
+	numCheckpointTruncate     = "checkpoint_truncate"
+	numCheckpointRestart      = "checkpoint_restart"
+	numCheckpointPassive      = "checkpoint_passive"
+	numCheckpointFull         = "checkpoint_full"
 	numCheckpointedPages      = "checkpointed_pages"
 	numCheckpointedMoves      = "checkpointed_moves"
 	checkpointDuration        = "checkpoint_duration_ms"
@@ -46,6 +63,17 @@ const (
 	numBackupStepErrors       = "backup_step_errors"
 	numBackupStepDones        = "backup_step_dones"
 	numBackupSleeps           = "backup_sleeps"
+	numSlowQueries            = "slow_queries"
+	numWALPressureCheckpoints = "wal_pressure_checkpoints"
+	numWALPressureFailures    = "wal_pressure_checkpoint_failures"
+	numWriteQueueFull         = "write_queue_full"
+	numAdaptiveCheckpoints    = "adaptive_checkpoints"
+	numAdaptiveFailures       = "adaptive_checkpoint_failures"
+	numBusyRetries            = "busy_retries"
+	numWALAutoCheckpoints     = "wal_auto_checkpoints"
+	numWALAutoFailures        = "wal_auto_checkpoint_failures"
+	numManagedCheckpoints     = "managed_checkpoints"
+	numManagedFailures        = "managed_checkpoint_failures"
 )
 
 var (
@@ -58,6 +86,15 @@ var (
 
 	// ErrExecuteTimeout is returned when an execute times out.
 	ErrExecuteTimeout = errors.New("execute timeout")
+
+	// ErrCheckpointTimeout is returned when a checkpoint run with a timeout
+	// does not complete within it, because readers or writers are holding the
+	// database.
+	ErrCheckpointTimeout = errors.New("checkpoint timeout")
+
+	// ErrConflictingCheckpointers is returned by OpenWithConfig when more
+	// than one automatic checkpointer is enabled.
+	ErrConflictingCheckpointers = errors.New("more than one automatic checkpointer enabled")
 )
 
 // CheckpointMode is the mode in which a checkpoint runs.
@@ -68,12 +105,29 @@ const (
 	CheckpointRestart CheckpointMode = iota
 	// CheckpointTruncate instructs the checkpoint to run in truncate mode.
 	CheckpointTruncate
+	// CheckpointPassive instructs the checkpoint to run in passive mode. A
+	// passive checkpoint never blocks writers, but may not checkpoint all
+	// frames in the WAL.
+	CheckpointPassive
+	// CheckpointFull instructs the checkpoint to run in full mode. A full
+	// checkpoint blocks writers until every frame in the WAL has been
+	// checkpointed, but unlike restart mode does not wait for readers to
+	// finish with the WAL, and so does not cause the next writer to reset it.
+	CheckpointFull
 )
 
 var (
 	checkpointPRAGMAs = map[CheckpointMode]string{
 		CheckpointRestart:  "PRAGMA wal_checkpoint(RESTART)",
 		CheckpointTruncate: "PRAGMA wal_checkpoint(TRUNCATE)",
+		CheckpointPassive:  "PRAGMA wal_checkpoint(PASSIVE)",
+		CheckpointFull:     "PRAGMA wal_checkpoint(FULL)",
+	}
+	checkpointModeStats = map[CheckpointMode]string{
+		CheckpointRestart:  numCheckpointRestart,
+		CheckpointTruncate: numCheckpointTruncate,
+		CheckpointPassive:  numCheckpointPassive,
+		CheckpointFull:     numCheckpointFull,
 	}
 )
 
@@ -95,6 +149,12 @@ func ResetStats() {
 	stats.Add(openDuration, 0)
 	stats.Add(numCheckpoints, 0)
 	stats.Add(numCheckpointErrors, 0)
+	stats.Add(numCheckpointsSkipped, 0)
+	stats.Add(numCheckpointTimeouts, 0)
+	stats.Add(numCheckpointTruncate, 0)
+	stats.Add(numCheckpointRestart, 0)
+	stats.Add(numCheckpointPassive, 0)
+	stats.Add(numCheckpointFull, 0)
 	stats.Add(numCheckpointedPages, 0)
 	stats.Add(numCheckpointedMoves, 0)
 	stats.Add(checkpointDuration, 0)
@@ -110,6 +170,225 @@ func ResetStats() {
 	stats.Add(numBackupStepErrors, 0)
 	stats.Add(numBackupStepDones, 0)
 	stats.Add(numBackupSleeps, 0)
+	stats.Add(numSlowQueries, 0)
+	stats.Add(numWALPressureCheckpoints, 0)
+	stats.Add(numWALPressureFailures, 0)
+	stats.Add(numWriteQueueFull, 0)
+	stats.Add(numAdaptiveCheckpoints, 0)
+	stats.Add(numAdaptiveFailures, 0)
+	stats.Add(numBusyRetries, 0)
+	stats.Add(numWALAutoCheckpoints, 0)
+	stats.Add(numWALAutoFailures, 0)
+	stats.Add(numManagedCheckpoints, 0)
+	stats.Add(numManagedFailures, 0)
+}
+
+// Config represents the configuration of a DB.
+//
+// WALCheckpointThreshold, AdaptiveCheckpointMaxInterval and
+// WALAutoCheckpointBytes each enable an automatic checkpointer, which runs
+// TRUNCATE checkpoints outside the control of the caller. At most one may be
+// enabled, and OpenWithConfig returns ErrConflictingCheckpointers otherwise.
+// A CheckpointManager must only be used with a database on which none is
+// enabled. None of these may be used when the caller depends on the WAL
+// containing every change since its own last checkpoint, such as when
+// snapshotting incrementally from the WAL.
+type Config struct {
+	// FKEnabled enables Foreign Key constraints.
+	FKEnabled bool
+
+	// WAL enables WAL mode.
+	WAL bool
+
+	// BackgroundCheckpoint, if true, performs WAL checkpoints using a connection
+	// dedicated to checkpointing, instead of the connection used for writes. This
+	// means a checkpoint does not occupy the write connection, so an Execute call
+	// is not queued behind a checkpoint in progress. A PASSIVE checkpoint never
+	// blocks writes. RESTART and TRUNCATE checkpoints must hold the SQLite writer
+	// lock for their duration, so concurrent writes wait on that lock, subject to
+	// the busy timeout, instead of on the connection. Ignored if WAL is false.
+	//
+	// BenchmarkWALDatabaseCheckpoint_WriteLatency measures a write made during a
+	// PASSIVE checkpoint of a 4MB WAL. On a Linux VM with an SSD, it took about
+	// 1.7ms without BackgroundCheckpoint, and about 0.65ms with it.
+	BackgroundCheckpoint bool
+
+	// SoftHeapLimit, if greater than zero, sets the SQLite soft heap limit in
+	// bytes. The soft limit is advisory: as it is approached SQLite attempts to
+	// reduce memory usage, for example by releasing cached pages, but
+	// allocations do not fail because of it.
+	//
+	// The heap limits are global to the SQLite library, and therefore apply
+	// to all databases opened by this process, not just this one. Opening
+	// another database with a different limit will change it for all.
+	SoftHeapLimit int64
+
+	// HardHeapLimit, if greater than zero, sets the SQLite hard heap limit in
+	// bytes. Allocations which would exceed this limit fail, so any query or
+	// execution which needs more memory returns an "out of memory" error. Like
+	// SoftHeapLimit this setting is process-wide.
+	HardHeapLimit int64
+
+	// SlowQueryThreshold, if greater than zero, enables logging of any query
+	// or execution which takes at least this long. At most one slow statement
+	// is logged per second, and the number of slow statements not logged is
+	// included in the next log line.
+	SlowQueryThreshold time.Duration
+
+	// SlowQueryLogSQL, if true, includes the SQL text and parameter values of
+	// the statement when logging a slow statement.
+	SlowQueryLogSQL bool
+
+	// SlowQueryRedactParams, if true, replaces parameter values with a
+	// placeholder when logging the SQL text of a slow statement, so that
+	// sensitive values are not written to the log.
+	SlowQueryRedactParams bool
+
+	// WALCheckpointThreshold, if greater than zero, is the WAL size in bytes
+	// at which the database checkpoints the WAL after a write. Since the
+	// wal-index held in shared memory grows with the WAL, this bounds memory
+	// use on constrained nodes. The WAL may exceed the threshold by the size
+	// of a single write. The checkpoint runs in TRUNCATE mode, with a short busy
+	// timeout, so a write is never held up for long, and the WAL file is
+	// truncated to zero bytes, so the next checkpoint is not run until the WAL
+	// has again grown to the threshold. If readers prevent the checkpoint from
+	// completing the failure is logged, and the checkpoint is retried after the
+	// next write. The journal size limit is also set to the threshold, so a WAL
+	// reset by any other checkpoint is also trimmed. Ignored if WAL is false.
+	WALCheckpointThreshold int64
+
+	// Defensive, if true, hardens every connection to the database against
+	// malicious SQL, for use when serving untrusted statements. In defensive
+	// mode the following are rejected with an authorization error:
+	//   - ATTACH and DETACH, so no other file can be read or written.
+	//   - The load_extension() SQL function, so no code can be loaded.
+	//   - Setting the writable_schema, schema_version, trusted_schema,
+	//     ignore_check_constraints, legacy_alter_table, locking_mode,
+	//     mmap_size, temp_store_directory, and data_store_directory PRAGMAs,
+	//     and setting journal_mode to OFF or MEMORY. These PRAGMAs can still
+	//     be read.
+	//   - Writes to sqlite_dbpage, if it is available.
+	// Since writable_schema cannot be set, SQLite continues to reject direct
+	// writes to sqlite_schema. In addition trusted_schema is turned off, so functions with side
+	// effects cannot be invoked from triggers or views. The SQLite driver does
+	// not expose SQLITE_DBCONFIG_DEFENSIVE, so the protections it offers
+	// against schema corruption are provided by the restrictions above.
+	//
+	// Operations which rely on ATTACH, such as MergeFrom and RestoreBundle,
+	// are not available in defensive mode.
+	Defensive bool
+
+	// ExtensionsEnabled, if true, allows SQLite extensions to be loaded into
+	// the database with LoadExtension. Loaded extensions have full access to
+	// the process, so only trusted extensions should be loaded. Extensions
+	// cannot be loaded via SQL, even if this is true.
+	ExtensionsEnabled bool
+
+	// FunctionsEnabled, if true, allows Go functions to be registered as SQL
+	// functions with RegisterFunc.
+	FunctionsEnabled bool
+
+	// WriteQueueDepth, if greater than zero, enables an in-process write
+	// queue. Calls to Execute and Request are then admitted one at a time, in
+	// the order they arrive, so a burst of writes is smoothed out instead of
+	// contending for the database. WriteQueueDepth is the maximum number of
+	// calls which may wait in the queue. A call made while the queue is full
+	// fails immediately with ErrWriteQueueFull. Time spent waiting counts
+	// towards the timeout of the request, and a call which times out while
+	// waiting leaves the queue. Calls waiting when the database is closed
+	// leave the queue, and fail with ErrClosed.
+	WriteQueueDepth int
+
+	// StatementPolicy, if not nil, restricts the types of statement which may
+	// be executed against the database, on every connection. See
+	// StatementPolicy for details.
+	StatementPolicy *StatementPolicy
+
+	// BusyRetries, if greater than zero, is the number of times Execute
+	// retries a write which fails because the database is busy or locked.
+	// Each retry is a new attempt, which itself waits for up to the busy
+	// timeout, so this allows writes to survive contention lasting longer
+	// than the busy timeout, such as during a checkpoint. Retries back off
+	// exponentially, starting from BusyRetryBackoff, with jitter. Outside of
+	// a transaction only the failed statement is retried, since earlier
+	// statements have already taken effect. Within a transaction the whole
+	// transaction is retried, since it has been rolled back.
+	BusyRetries int
+
+	// BusyRetryBackoff is the base backoff between retries of a busy write.
+	// If zero, 10ms is used.
+	BusyRetryBackoff time.Duration
+
+	// AdaptiveCheckpointMaxInterval, if greater than zero, enables adaptive
+	// checkpointing. The database then checkpoints the WAL in TRUNCATE mode
+	// in the background, at an interval which adapts to the rate at which
+	// the WAL grows: checkpoints happen more often while writes are heavy,
+	// and less often while the database is idle. The interval is always
+	// between AdaptiveCheckpointMinInterval and AdaptiveCheckpointMaxInterval.
+	// If readers prevent a checkpoint from completing, the interval is
+	// doubled, so that repeated failures back off towards the maximum.
+	// Ignored if WAL is false.
+	AdaptiveCheckpointMaxInterval time.Duration
+
+	// AdaptiveCheckpointMinInterval is the shortest interval between adaptive
+	// checkpoints. It must be greater than zero, and no greater than
+	// AdaptiveCheckpointMaxInterval, if adaptive checkpointing is enabled.
+	AdaptiveCheckpointMinInterval time.Duration
+
+	// AdaptiveCheckpointTargetSize is the WAL size, in bytes, at which the
+	// adaptive checkpointer aims to checkpoint. If zero, 4MB is used.
+	AdaptiveCheckpointTargetSize int64
+
+	// WALAutoCheckpointBytes, if greater than zero, enables WAL
+	// auto-checkpointing. A background goroutine then checks the size of the
+	// WAL every WALAutoCheckpointPollInterval, and checkpoints it in TRUNCATE
+	// mode, with a short busy timeout, once it has reached this many bytes.
+	// Unlike WALCheckpointThreshold the checkpoint is not run after a write,
+	// so the WAL may exceed the threshold by however much is written between
+	// checks. A failed checkpoint is logged, and retried at the next check.
+	// Ignored if WAL is false.
+	WALAutoCheckpointBytes int64
+
+	// WALAutoCheckpointPollInterval is how often the size of the WAL is
+	// checked, if WAL auto-checkpointing is enabled. If zero, 1 second is used.
+	WALAutoCheckpointPollInterval time.Duration
+
+	// BusyTimeout, if greater than zero, sets the busy timeout on every
+	// connection to the database, including each read-only connection in the
+	// pool. A write or checkpoint which finds the database locked then waits
+	// for up to this long for the lock, instead of immediately failing with
+	// SQLITE_BUSY. If zero, the driver default of 5 seconds is used.
+	BusyTimeout time.Duration
+
+	// CacheSizeKB, if greater than zero, sets the size of the page cache of
+	// every connection to the database, in KiB, as with PRAGMA cache_size=-N.
+	// A larger cache lets long read transactions, which block RESTART and
+	// TRUNCATE checkpoints, finish sooner, at the cost of memory for each
+	// connection. If zero, the SQLite default of 2000 KiB is used.
+	CacheSizeKB int
+
+	// Synchronous sets the SQLite synchronous mode of every connection to the
+	// database. The zero value, SynchronousOff, is the default, as rqlite
+	// relies on the Raft log, not SQLite, for durability. See SynchronousMode
+	// for the tradeoffs of each mode.
+	Synchronous SynchronousMode
+
+	// InitPragmas are PRAGMAs, such as "cache_size=-64000" or
+	// "temp_store=MEMORY", executed on every new connection to the database,
+	// after its journal mode and other settings have been applied. Each is
+	// given without the leading PRAGMA keyword. If any fails, opening the
+	// database fails with an error identifying the PRAGMA. PRAGMAs which
+	// change the journal mode or checkpointing behaviour must not be used.
+	InitPragmas []string
+
+	// RecoverWAL, if true, allows CheckWALConsistency to recover from an
+	// inconsistency it finds, instead of only reporting it.
+	RecoverWAL bool
+}
+
+// NewConfig returns a new Config instance, with default settings.
+func NewConfig() *Config {
+	return &Config{}
 }
 
 // DB is the SQL database.
@@ -118,13 +397,52 @@ type DB struct {
 	walPath   string // Path to WAL file.
 	fkEnabled bool   // Foreign key constraints enabled
 	wal       bool
+	memory    bool // In-memory database, see OpenInMemory.
+
+	recoverWAL bool // Recover in CheckWALConsistency, see Config.RecoverWAL.
 
-	rwDB *sql.DB // Database connection for database reads and writes.
-	roDB *sql.DB // Database connection database reads.
+	rwDB  *sql.DB // Database connection for database reads and writes.
+	roDB  *sql.DB // Database connection database reads.
+	rodDB *sql.DB // Dedicated read-only connections, see QueryOptions.
+	chkDB *sql.DB // Database connection for checkpointing, if dedicated.
 
 	rwDSN string // DSN used for read-write connection
 	roDSN string // DSN used for read-only connections
 
+	chkWg sync.WaitGroup // Tracks background checkpoints.
+
+	slowLogger *slowQueryLogger // Logs slow statements, if enabled.
+
+	walCheckpointThreshold int64 // WAL size which triggers a checkpoint, if non-zero.
+
+	exts *extensionSet // Extensions loaded into every connection, if enabled.
+
+	funcs *functionSet // Functions registered with every connection, if enabled.
+
+	writeQueue *writeQueue // Serializes writes, if enabled.
+
+	adaptiveCheckpointer *adaptiveCheckpointer // Checkpoints in the background, if enabled.
+
+	walAutoCheckpointer *walAutoCheckpointer // Checkpoints a large WAL, if enabled.
+
+	busyRetrier *busyRetrier // Retries busy writes, if enabled.
+
+	snapshotReaders atomic.Int64 // Number of open SnapshotTx.
+
+	openTxs atomic.Int64 // Number of open Tx.
+
+	chkStats checkpointStats // Checkpoints of this database.
+
+	attached attachSet // Databases attached with Attach.
+
+	readers readerSet // Readers in progress, for diagnostics.
+
+	ops opGuard // Operations in flight, which Close waits for.
+
+	resumable resumableBackup // Backup being read by BackupAt, if any.
+
+	hooks hookSet // Hooks registered on the read-write connection.
+
 	logger *log.Logger
 }
 
@@ -143,7 +461,19 @@ type PoolStats struct {
 
 // Open opens a file-based database, creating it if it does not exist. After this
 // function returns, an actual SQLite file will always exist.
-func Open(dbPath string, fkEnabled, wal bool) (retDB *DB, retErr error) {
+func Open(dbPath string, fkEnabled, wal bool) (*DB, error) {
+	cfg := NewConfig()
+	cfg.FKEnabled = fkEnabled
+	cfg.WAL = wal
+	return OpenWithConfig(dbPath, cfg)
+}
+
+// OpenWithConfig opens a file-based database using the given configuration,
+// creating it if it does not exist. After this function returns, an actual
+// SQLite file will always exist.
+func OpenWithConfig(dbPath string, cfg *Config) (retDB *DB, retErr error) {
+	fkEnabled := cfg.FKEnabled
+	wal := cfg.WAL
 	logger := log.New(log.Writer(), "[db] ", log.LstdFlags)
 	startTime := time.Now()
 	defer func() {
@@ -156,53 +486,179 @@ func Open(dbPath string, fkEnabled, wal bool) (retDB *DB, retErr error) {
 		stats.Get(openDuration).(*expvar.Int).Set(time.Since(startTime).Milliseconds())
 	}()
 
+	if wal {
+		n := 0
+		for _, enabled := range []bool{
+			cfg.WALCheckpointThreshold > 0,
+			cfg.AdaptiveCheckpointMaxInterval > 0,
+			cfg.WALAutoCheckpointBytes > 0,
+		} {
+			if enabled {
+				n++
+			}
+		}
+		if n > 1 {
+			return nil, ErrConflictingCheckpointers
+		}
+	}
+
+	// Close every connection pool opened so far if the open fails at any
+	// later step.
+	var rwDB, roDB, rodDB, chkDB *sql.DB
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		for _, d := range []*sql.DB{chkDB, rodDB, roDB, rwDB} {
+			if d != nil {
+				d.Close()
+			}
+		}
+	}()
+
 	/////////////////////////////////////////////////////////////////////////
 	// Main RW connection
-	rwDSN := MakeDSN(dbPath, ModeReadWrite, fkEnabled, wal)
-	rwDB, err := sql.Open("sqlite3", rwDSN)
+	rwDSN := withCacheSize(withBusyTimeout(makeDSN(dbPath, ModeReadWrite, fkEnabled, wal, cfg.Synchronous), cfg.BusyTimeout), cfg.CacheSizeKB)
+	var exts *extensionSet
+	if cfg.ExtensionsEnabled {
+		exts = &extensionSet{}
+	}
+	var funcs *functionSet
+	if cfg.FunctionsEnabled {
+		funcs = &functionSet{}
+	}
+	drvName := driverName(cfg, exts, funcs)
+	rwDB, err := sql.Open(drvName, rwDSN)
 	if err != nil {
 		return nil, fmt.Errorf("open: %s", err.Error())
 	}
 
+	// Force creation of database file.
+	if err := rwDB.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping on-disk database: %s", err.Error())
+	}
+
 	// Critical that rqlite has full control over the checkpointing process.
 	if _, err := rwDB.Exec("PRAGMA wal_autocheckpoint=0"); err != nil {
 		return nil, fmt.Errorf("disable autocheckpointing: %s", err.Error())
 	}
 
+	if cfg.SoftHeapLimit > 0 {
+		if _, err := rwDB.Exec(fmt.Sprintf("PRAGMA soft_heap_limit=%d", cfg.SoftHeapLimit)); err != nil {
+			return nil, fmt.Errorf("set soft heap limit: %s", err.Error())
+		}
+	}
+	if cfg.HardHeapLimit > 0 {
+		if _, err := rwDB.Exec(fmt.Sprintf("PRAGMA hard_heap_limit=%d", cfg.HardHeapLimit)); err != nil {
+			return nil, fmt.Errorf("set hard heap limit: %s", err.Error())
+		}
+	}
+
 	/////////////////////////////////////////////////////////////////////////
 	// Read-only connection
-	roDSN := MakeDSN(dbPath, ModeReadOnly, fkEnabled, wal)
-	roDB, err := sql.Open("sqlite3", roDSN)
+	roDSN := withCacheSize(withBusyTimeout(makeDSN(dbPath, ModeReadOnly, fkEnabled, wal, cfg.Synchronous), cfg.BusyTimeout), cfg.CacheSizeKB)
+	roDB, err = sql.Open(drvName, roDSN)
 	if err != nil {
 		return nil, err
 	}
 
-	// Force creation of database file.
-	if err := rwDB.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping on-disk database: %s", err.Error())
-	}
-
 	// Set connection pool behaviour.
 	rwDB.SetConnMaxLifetime(0)
 	rwDB.SetMaxOpenConns(1) // Key to ensure a new connection doesn't enable checkpointing
 	roDB.SetConnMaxIdleTime(30 * time.Second)
 	roDB.SetConnMaxLifetime(0)
 
-	return &DB{
-		path:      dbPath,
-		walPath:   dbPath + "-wal",
-		fkEnabled: fkEnabled,
-		wal:       wal,
-		rwDB:      rwDB,
-		roDB:      roDB,
-		rwDSN:     rwDSN,
-		roDSN:     roDSN,
-		logger:    logger,
-	}, nil
-}
-
-// LastModified returns the last modified time of the database file, or the WAL file,
-// whichever is most recent.
+	// Dedicated read-only connections are only opened on first use.
+	rodDB, err = sql.Open(drvName, roDSN)
+	if err != nil {
+		return nil, err
+	}
+	rodDB.SetConnMaxIdleTime(30 * time.Second)
+	rodDB.SetConnMaxLifetime(0)
+
+	/////////////////////////////////////////////////////////////////////////
+	// Optional dedicated checkpointing connection
+	if wal && cfg.BackgroundCheckpoint {
+		chkDB, err = sql.Open(drvName, rwDSN)
+		if err != nil {
+			return nil, fmt.Errorf("open checkpoint connection: %s", err.Error())
+		}
+		if _, err := chkDB.Exec("PRAGMA wal_autocheckpoint=0"); err != nil {
+			return nil, fmt.Errorf("disable autocheckpointing on checkpoint connection: %s", err.Error())
+		}
+		chkDB.SetConnMaxLifetime(0)
+		chkDB.SetMaxOpenConns(1)
+	}
+
+	var walCheckpointThreshold int64
+	if wal && cfg.WALCheckpointThreshold > 0 {
+		walCheckpointThreshold = cfg.WALCheckpointThreshold
+		if _, err := rwDB.Exec(fmt.Sprintf("PRAGMA journal_size_limit=%d", walCheckpointThreshold)); err != nil {
+			return nil, fmt.Errorf("set journal size limit: %s", err.Error())
+		}
+	}
+
+	db := &DB{
+		path:       dbPath,
+		walPath:    dbPath + "-wal",
+		fkEnabled:  fkEnabled,
+		wal:        wal,
+		rwDB:       rwDB,
+		roDB:       roDB,
+		rodDB:      rodDB,
+		chkDB:      chkDB,
+		rwDSN:      rwDSN,
+		roDSN:      roDSN,
+		slowLogger: newSlowQueryLogger(cfg, logger),
+		logger:     logger,
+
+		walCheckpointThreshold: walCheckpointThreshold,
+		recoverWAL:             cfg.RecoverWAL,
+		exts:                   exts,
+		funcs:                  funcs,
+		writeQueue:             newWriteQueue(cfg.WriteQueueDepth),
+		busyRetrier:            newBusyRetrier(cfg),
+	}
+
+	db.adaptiveCheckpointer, err = newAdaptiveCheckpointer(cfg, db.WALSize, func() error {
+		if db.NumSnapshotReaders() > 0 {
+			return ErrSnapshotReadersOpen
+		}
+		return db.CheckpointWithTimeout(CheckpointTruncate, adaptiveCheckpointTimeout)
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	if db.adaptiveCheckpointer != nil {
+		db.adaptiveCheckpointer.Start()
+	}
+
+	db.walAutoCheckpointer = newWALAutoCheckpointer(cfg, db.WALSize, func() error {
+		if db.NumSnapshotReaders() > 0 {
+			return ErrSnapshotReadersOpen
+		}
+		return db.CheckpointWithTimeout(CheckpointTruncate, walAutoCheckpointTimeout)
+	}, logger)
+	if db.walAutoCheckpointer != nil {
+		db.walAutoCheckpointer.Start()
+	}
+	return db, nil
+}
+
+// CheckpointInterval returns the current interval between adaptive
+// checkpoints, or 0 if adaptive checkpointing is not enabled.
+func (db *DB) CheckpointInterval() time.Duration {
+	if db.adaptiveCheckpointer == nil {
+		return 0
+	}
+	return db.adaptiveCheckpointer.Interval()
+}
+
+// LastModified returns the last modified time of the database file, or the WAL
+// file, whichever is most recent. In WAL mode writes land in the WAL first, so
+// the database file alone lags behind changes until the next checkpoint. The
+// WAL shared-memory file is deliberately not considered, as readers update it
+// too, and so its modification time changes without the data changing.
 func (db *DB) LastModified() (time.Time, error) {
 	dbTime, err := db.DBLastModified()
 	if err != nil {
@@ -238,14 +694,62 @@ func (db *DB) WALSum() (string, error) {
 	return md5sum(db.walPath)
 }
 
-// Close closes the underlying database connection.
+// Close closes the underlying database connection. If any background
+// checkpoints, including adaptive checkpoints, are in progress, Close waits
+// for them to complete. Close also waits, for up to 5 seconds, for queries,
+// executes, and requests in flight to finish, including those made through a
+// Tx or SnapshotTx. If they do not finish in time, ErrBusy is returned and
+// the database is left open. Once Close has been called, new operations fail
+// with ErrClosed. An open Tx or SnapshotTx does not delay Close, but can then
+// only be ended. Calling Close on a closed database does nothing.
 func (db *DB) Close() error {
+	return db.CloseWithTimeout(closeWaitTimeout)
+}
+
+// CloseWithTimeout is like Close, but waits for up to timeout for operations
+// in flight to finish.
+func (db *DB) CloseWithTimeout(timeout time.Duration) error {
+	if closed, err := db.ops.close(timeout); closed || err != nil {
+		return err
+	}
+	db.adaptiveCheckpointer.Stop()
+	db.walAutoCheckpointer.Stop()
+	db.chkWg.Wait()
+	db.resumable.mu.Lock()
+	db.resumable.remove()
+	db.resumable.mu.Unlock()
+	if db.chkDB != nil {
+		if err := db.chkDB.Close(); err != nil {
+			return err
+		}
+	}
 	if err := db.rwDB.Close(); err != nil {
 		return err
 	}
+	if err := db.rodDB.Close(); err != nil {
+		return err
+	}
 	return db.roDB.Close()
 }
 
+// CloseWithCheckpoint checkpoints the WAL in the given mode, and then closes
+// the database. With CheckpointTruncate, this leaves the database file
+// self-contained, with an empty WAL. The checkpoint waits for at most a short
+// bounded time for any active readers or writers, so it may not complete. The
+// database is closed even if the checkpoint fails, and the checkpoint error is
+// returned. If the database is not in WAL mode, or there is no WAL, this is
+// the same as Close.
+func (db *DB) CloseWithCheckpoint(mode CheckpointMode) error {
+	var chkErr error
+	if db.wal && fileExists(db.walPath) {
+		chkErr = db.CheckpointWithTimeout(mode, closeCheckpointTimeout)
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	return chkErr
+}
+
 // Stats returns status and diagnostics for the database.
 func (db *DB) Stats() (map[string]interface{}, error) {
 	copts, err := db.CompileOptions()
@@ -257,8 +761,9 @@ func (db *DB) Stats() (map[string]interface{}, error) {
 		return nil, err
 	}
 	connPoolStats := map[string]interface{}{
-		"ro": db.ConnectionPoolStats(db.roDB),
-		"rw": db.ConnectionPoolStats(db.rwDB),
+		"ro":           db.ConnectionPoolStats(db.roDB),
+		"rw":           db.ConnectionPoolStats(db.rwDB),
+		"ro_dedicated": db.ConnectionPoolStats(db.rodDB),
 	}
 	dbSz, err := db.Size()
 	if err != nil {
@@ -278,6 +783,8 @@ func (db *DB) Stats() (map[string]interface{}, error) {
 		"ro_dsn":           db.roDSN,
 		"conn_pool_stats":  connPoolStats,
 		"pragmas":          pragmas,
+		"active_readers":   db.NumActiveReaders(),
+		"checkpoint_stats": db.CheckpointStats(),
 	}
 
 	lm, err := db.LastModified()
@@ -330,6 +837,47 @@ func (db *DB) WALSize() (int64, error) {
 	return 0, err
 }
 
+// WALFrameCount returns the number of committed frames in the WAL which have
+// not been reset by a checkpoint. The WAL file is parsed directly, so no
+// checkpoint is run and no lock is taken. Frames left over from before the
+// WAL was last reset are not counted, nor are frames of a transaction which
+// has not yet committed. If the database is not in WAL mode, or there is no
+// WAL file, 0 is returned.
+func (db *DB) WALFrameCount() (int, error) {
+	if !db.wal {
+		return 0, nil
+	}
+	fd, err := os.Open(db.walPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer fd.Close()
+
+	r := wal.NewReader(fd)
+	if err := r.ReadHeader(); err == io.EOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	data := make([]byte, r.PageSize())
+	n, committed := 0, 0
+	for {
+		_, commit, err := r.ReadFrame(data)
+		if err == io.EOF {
+			return committed, nil
+		} else if err != nil {
+			return 0, err
+		}
+		n++
+		if commit != 0 {
+			committed = n
+		}
+	}
+}
+
 // SetBusyTimeout sets the busy timeout for the database. If a timeout is
 // is less than zero it is not set.
 func (db *DB) SetBusyTimeout(rwMs, roMs int) (err error) {
@@ -348,6 +896,24 @@ func (db *DB) SetBusyTimeout(rwMs, roMs int) (err error) {
 	return nil
 }
 
+// CacheSizeKB returns the size of the page cache of the read-write
+// connection, in KiB.
+func (db *DB) CacheSizeKB() (int, error) {
+	var n int
+	if err := db.rwDB.QueryRow("PRAGMA cache_size").Scan(&n); err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return -n, nil
+	}
+	// A positive cache size is a number of pages.
+	var pageSize int
+	if err := db.rwDB.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return n * pageSize / 1024, nil
+}
+
 // BusyTimeout returns the current busy timeout value.
 func (db *DB) BusyTimeout() (rwMs, roMs int, err error) {
 	err = db.rwDB.QueryRow("PRAGMA busy_timeout").Scan(&rwMs)
@@ -367,11 +933,130 @@ func (db *DB) Checkpoint(mode CheckpointMode) error {
 	return db.CheckpointWithTimeout(mode, 0)
 }
 
+// CheckpointIfNeeded performs a WAL checkpoint, unless WAL mode is not
+// enabled, or the WAL file does not exist or is empty, in which case it
+// returns immediately without opening a checkpoint transaction. It returns
+// whether a checkpoint was actually performed. This is cheaper than
+// CheckpointWithTimeout when called frequently on a mostly-idle database.
+func (db *DB) CheckpointIfNeeded(mode CheckpointMode, dur time.Duration) (bool, error) {
+	sz, err := db.WALSize()
+	if err != nil {
+		return false, err
+	}
+	if sz == 0 {
+		stats.Add(numCheckpointsSkipped, 1)
+		return false, nil
+	}
+	if err := db.CheckpointWithTimeout(mode, dur); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkpointStats counts the checkpoints of a single DB. Unlike the
+// package-wide expvar stats, it is not shared with any other open DB.
+type checkpointStats struct {
+	checkpoints atomic.Int64
+	timeouts    atomic.Int64
+	byMode      [CheckpointFull + 1]atomic.Int64 // Indexed by CheckpointMode.
+}
+
+// CheckpointStats returns cumulative checkpoint counters for the database:
+// the number of successful checkpoints, in total and by mode, and the number
+// of checkpoints with a timeout which did not complete within it. The counts
+// start from zero when the database is opened.
+func (db *DB) CheckpointStats() map[string]int64 {
+	m := map[string]int64{
+		numCheckpoints:        db.chkStats.checkpoints.Load(),
+		numCheckpointTimeouts: db.chkStats.timeouts.Load(),
+	}
+	for mode, k := range checkpointModeStats {
+		m[k] = db.chkStats.byMode[mode].Load()
+	}
+	return m
+}
+
+// CheckpointResult describes the outcome of a WAL checkpoint.
+type CheckpointResult struct {
+	// LogFrames is the number of frames in the WAL when the checkpoint ran.
+	LogFrames int
+
+	// CheckpointedFrames is the number of frames in the WAL which have been
+	// checkpointed into the database.
+	CheckpointedFrames int
+}
+
 // CheckpointWithTimeout performs a WAL checkpoint. If the checkpoint does not
 // run to completion within the given duration, an error is returned. If the
 // duration is 0, the busy timeout is not modified before executing the
 // checkpoint.
-func (db *DB) CheckpointWithTimeout(mode CheckpointMode, dur time.Duration) (err error) {
+func (db *DB) CheckpointWithTimeout(mode CheckpointMode, dur time.Duration) error {
+	_, err := db.checkpoint(mode, dur)
+	return err
+}
+
+// CheckpointUntil performs WAL checkpoints in the given mode every interval,
+// until one checkpoints every frame in the WAL, or the deadline passes. Each
+// checkpoint is given until the next is due, or the deadline if sooner, to
+// complete. This allows a checkpoint, typically PASSIVE, to make progress as
+// readers come and go, where a single checkpoint would be blocked. nil is
+// returned as soon as a checkpoint completes fully, and an error wrapping
+// ErrCheckpointTimeout if none has by the deadline. Any other error ends the
+// retries, and is returned immediately.
+func (db *DB) CheckpointUntil(mode CheckpointMode, deadline time.Time, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("checkpoint interval must be positive, got %s", interval)
+	}
+	var res CheckpointResult
+	var err error
+	attempts := 0
+	for {
+		start := time.Now()
+		remaining := deadline.Sub(start)
+		if remaining <= 0 {
+			break
+		}
+		dur := interval
+		if remaining < dur {
+			dur = remaining
+		}
+		res, err = db.checkpoint(mode, dur)
+		attempts++
+		if err == nil && res.CheckpointedFrames == res.LogFrames {
+			return nil
+		}
+		if err != nil && !errors.Is(err, ErrCheckpointTimeout) {
+			return err
+		}
+		next := start.Add(interval)
+		if !next.Before(deadline) {
+			break
+		}
+		time.Sleep(time.Until(next))
+	}
+	if attempts == 0 {
+		return fmt.Errorf("%w: deadline passed before checkpointing", ErrCheckpointTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("deadline passed after %d checkpoints: %w", attempts, err)
+	}
+	return fmt.Errorf("%w: deadline passed after %d checkpoints, with %d of %d WAL frames checkpointed",
+		ErrCheckpointTimeout, attempts, res.CheckpointedFrames, res.LogFrames)
+}
+
+// CheckpointWithResult performs a WAL checkpoint, and returns the number of
+// frames in the WAL and the number checkpointed. If the checkpoint could not
+// run to completion, the result is returned along with an error. Comparing
+// the two counts over time shows whether checkpoints are keeping up with
+// writes.
+func (db *DB) CheckpointWithResult(mode CheckpointMode) (CheckpointResult, error) {
+	return db.checkpoint(mode, 0)
+}
+
+func (db *DB) checkpoint(mode CheckpointMode, dur time.Duration) (res CheckpointResult, err error) {
+	if db.memory {
+		return res, nil
+	}
 	start := time.Now()
 	defer func() {
 		if err != nil {
@@ -379,57 +1064,146 @@ func (db *DB) CheckpointWithTimeout(mode CheckpointMode, dur time.Duration) (err
 		} else {
 			stats.Get(checkpointDuration).(*expvar.Int).Set(time.Since(start).Milliseconds())
 			stats.Add(numCheckpoints, 1)
+			stats.Add(checkpointModeStats[mode], 1)
+			db.chkStats.checkpoints.Add(1)
+			db.chkStats.byMode[mode].Add(1)
 		}
 	}()
 
+	chkDB := db.checkpointDB()
 	if dur > 0 {
-		rwBt, _, err := db.BusyTimeout()
-		if err != nil {
-			return fmt.Errorf("failed to get busy_timeout on checkpointing connection: %s", err.Error())
+		var bt int
+		if err := chkDB.QueryRow("PRAGMA busy_timeout").Scan(&bt); err != nil {
+			return res, fmt.Errorf("failed to get busy_timeout on checkpointing connection: %s", err.Error())
 		}
-		if err := db.SetBusyTimeout(int(dur.Milliseconds()), -1); err != nil {
-			return fmt.Errorf("failed to set busy_timeout on checkpointing connection: %s", err.Error())
+		if _, err := chkDB.Exec(fmt.Sprintf("PRAGMA busy_timeout=%d", dur.Milliseconds())); err != nil {
+			return res, fmt.Errorf("failed to set busy_timeout on checkpointing connection: %s", err.Error())
 		}
 		defer func() {
 			// Reset back to default
-			if err := db.SetBusyTimeout(rwBt, -1); err != nil {
+			if _, err := chkDB.Exec(fmt.Sprintf("PRAGMA busy_timeout=%d", bt)); err != nil {
 				db.logger.Printf("failed to reset busy_timeout on checkpointing connection: %s", err.Error())
 			}
 		}()
 	}
 
 	var ok int
-	var nPages int
-	var nMoved int
-	if err := db.rwDB.QueryRow(checkpointPRAGMAs[mode]).Scan(&ok, &nPages, &nMoved); err != nil {
-		return fmt.Errorf("error checkpointing WAL: %s", err.Error())
+	if err := chkDB.QueryRow(checkpointPRAGMAs[mode]).Scan(&ok, &res.LogFrames, &res.CheckpointedFrames); err != nil {
+		if dur > 0 && isBusyError(err) {
+			stats.Add(numCheckpointTimeouts, 1)
+			db.chkStats.timeouts.Add(1)
+			db.logActiveReaders()
+			return res, fmt.Errorf("%w: %s", ErrCheckpointTimeout, err.Error())
+		}
+		return res, fmt.Errorf("error checkpointing WAL: %w", err)
 	}
-	stats.Add(numCheckpointedPages, int64(nPages))
-	stats.Add(numCheckpointedMoves, int64(nMoved))
+	stats.Add(numCheckpointedPages, int64(res.LogFrames))
+	stats.Add(numCheckpointedMoves, int64(res.CheckpointedFrames))
 	if ok != 0 {
-		return fmt.Errorf("failed to completely checkpoint WAL (%d ok, %d pages, %d moved)",
-			ok, nPages, nMoved)
+		if dur > 0 {
+			stats.Add(numCheckpointTimeouts, 1)
+			db.chkStats.timeouts.Add(1)
+			db.logActiveReaders()
+			return res, fmt.Errorf("%w after %s: failed to completely checkpoint WAL (%d ok, %d pages, %d moved)",
+				ErrCheckpointTimeout, dur, ok, res.LogFrames, res.CheckpointedFrames)
+		}
+		return res, fmt.Errorf("failed to completely checkpoint WAL (%d ok, %d pages, %d moved)",
+			ok, res.LogFrames, res.CheckpointedFrames)
+	}
+	return res, nil
+}
+
+// CheckpointInBackground performs a WAL checkpoint on a separate goroutine,
+// and returns a channel on which the result of the checkpoint is sent. If the
+// database was opened with BackgroundCheckpoint set, the checkpoint uses the
+// dedicated checkpointing connection, and will not occupy the write connection
+// while it runs. Close waits for any background checkpoint to complete.
+func (db *DB) CheckpointInBackground(mode CheckpointMode, dur time.Duration) <-chan error {
+	ch := make(chan error, 1)
+	db.chkWg.Add(1)
+	go func() {
+		defer db.chkWg.Done()
+		ch <- db.CheckpointWithTimeout(mode, dur)
+	}()
+	return ch
+}
+
+// checkpointIfWALLarge checkpoints the WAL if it has reached the configured
+// threshold. Failure to checkpoint is logged, but not returned, as the write
+// which triggered the checkpoint has already succeeded. It must not be called
+// while holding a connection to rwDB.
+func (db *DB) checkpointIfWALLarge() {
+	if db.walCheckpointThreshold <= 0 {
+		return
+	}
+	sz, err := db.WALSize()
+	if err != nil || sz < db.walCheckpointThreshold {
+		return
+	}
+	stats.Add(numWALPressureCheckpoints, 1)
+	if db.NumSnapshotReaders() > 0 {
+		// The checkpoint cannot complete, so don't hold up the write.
+		stats.Add(numWALPressureFailures, 1)
+		return
+	}
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, walPressureCheckpointTimeout); err != nil {
+		stats.Add(numWALPressureFailures, 1)
+		db.logger.Printf("failed to checkpoint WAL of %d bytes, exceeding threshold of %d bytes: %s",
+			sz, db.walCheckpointThreshold, err.Error())
 	}
-	return nil
+}
+
+// checkpointDB returns the connection to be used for checkpointing.
+func (db *DB) checkpointDB() *sql.DB {
+	if db.chkDB != nil {
+		return db.chkDB
+	}
+	return db.rwDB
 }
 
 // DisableCheckpointing disables the automatic checkpointing that occurs when
 // the WAL reaches a certain size. This is key for full control of snapshotting.
 // and can be useful for testing.
 func (db *DB) DisableCheckpointing() error {
-	_, err := db.rwDB.Exec("PRAGMA wal_autocheckpoint=0")
-	return err
+	return db.SetAutoCheckpoint(0)
 }
 
 // EnableCheckpointing enables the automatic checkpointing that occurs when
 // the WAL reaches a certain size.
 func (db *DB) EnableCheckpointing() error {
-	_, err := db.rwDB.Exec("PRAGMA wal_autocheckpoint=1000")
-	return err
+	return db.SetAutoCheckpoint(1000)
 }
 
 // GetCheckpointing returns the current checkpointing setting.
 func (db *DB) GetCheckpointing() (int, error) {
+	return db.AutoCheckpoint()
+}
+
+// SetAutoCheckpoint sets SQLite's built-in WAL auto-checkpoint threshold, as
+// with PRAGMA wal_autocheckpoint, in pages. Setting pages to 0 disables it,
+// which is how the database is opened, so that checkpoints only happen when
+// requested. This can be called at any time while the database is open. When
+// enabled, a PASSIVE checkpoint is run by the write which takes the WAL past
+// the threshold, holding up that write. A PASSIVE checkpoint never truncates
+// the WAL, and does not wait for readers.
+//
+// Automatic checkpoints run alongside any checkpoints made explicitly, or by
+// a CheckpointManager, adaptive checkpointing or WAL auto-checkpointing, and
+// change the WAL underneath them. The recommended combination is either to
+// leave this disabled and use one of those to checkpoint in TRUNCATE mode, or
+// to enable this and only run occasional TRUNCATE checkpoints to reclaim the
+// space used by the WAL file.
+func (db *DB) SetAutoCheckpoint(pages int) error {
+	if pages < 0 {
+		return fmt.Errorf("invalid auto-checkpoint threshold %d", pages)
+	}
+	_, err := db.rwDB.Exec(fmt.Sprintf("PRAGMA wal_autocheckpoint=%d", pages))
+	return err
+}
+
+// AutoCheckpoint returns SQLite's built-in WAL auto-checkpoint threshold, in
+// pages. 0 means automatic checkpointing is disabled.
+func (db *DB) AutoCheckpoint() (int, error) {
 	var rwN int
 	err := db.rwDB.QueryRow("PRAGMA wal_autocheckpoint").Scan(&rwN)
 	if err != nil {
@@ -444,9 +1218,97 @@ func (db *DB) Vacuum() error {
 	return err
 }
 
-// VacuumInto VACUUMs the database into the file at path
+// Fragmentation returns the number of free pages in the database, the total
+// number of pages, and the percentage of pages which are free. Free pages are
+// left behind when data is deleted, and are only returned to the filesystem
+// by a VACUUM, so the percentage indicates how much a VACUUM would shrink the
+// database file.
+func (db *DB) Fragmentation() (freePages int, totalPages int, fragPercent float64, err error) {
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer conn.Close()
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA page_count").Scan(&totalPages); err != nil {
+		return 0, 0, 0, err
+	}
+	if totalPages > 0 {
+		fragPercent = 100 * float64(freePages) / float64(totalPages)
+	}
+	return freePages, totalPages, fragPercent, nil
+}
+
+// DBStats summarizes the size of the database, and how much of it is free.
+type DBStats struct {
+	// PageSize is the size of each database page, in bytes.
+	PageSize int64 `json:"page_size"`
+
+	// PageCount is the number of pages in the database.
+	PageCount int64 `json:"page_count"`
+
+	// FreelistCount is the number of unused pages in the database, which a
+	// VACUUM would return to the filesystem.
+	FreelistCount int64 `json:"freelist_count"`
+
+	// WALSize is the size of the WAL file, in bytes. It is 0 if the database
+	// is not in WAL mode, or there is no WAL file.
+	WALSize int64 `json:"wal_size"`
+
+	// FileSize is the size of the database file, in bytes.
+	FileSize int64 `json:"file_size"`
+}
+
+// FileStats returns the page size, page count, and free page count of the
+// database, along with the sizes of the database and WAL files on disk.
+func (db *DB) FileStats() (DBStats, error) {
+	var st DBStats
+	conn, err := db.roDB.Conn(context.Background())
+	if err != nil {
+		return st, err
+	}
+	defer conn.Close()
+	for _, p := range []struct {
+		pragma string
+		v      *int64
+	}{
+		{"PRAGMA page_size", &st.PageSize},
+		{"PRAGMA page_count", &st.PageCount},
+		{"PRAGMA freelist_count", &st.FreelistCount},
+	} {
+		if err := conn.QueryRowContext(context.Background(), p.pragma).Scan(p.v); err != nil {
+			return st, err
+		}
+	}
+	if st.WALSize, err = db.WALSize(); err != nil {
+		return st, err
+	}
+	if st.FileSize, err = db.FileSize(); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// CompactIfFragmented runs a VACUUM on the database if at least minPercent of
+// its pages are free, as reported by Fragmentation. It returns whether a
+// VACUUM was run.
+func (db *DB) CompactIfFragmented(minPercent float64) (bool, error) {
+	_, _, pct, err := db.Fragmentation()
+	if err != nil {
+		return false, err
+	}
+	if pct == 0 || pct < minPercent {
+		return false, nil
+	}
+	return true, db.Vacuum()
+}
+
+// VacuumInto VACUUMs the database into the file at path. The file must not
+// exist, or must be empty.
 func (db *DB) VacuumInto(path string) error {
-	_, err := db.rwDB.Exec(fmt.Sprintf("VACUUM INTO '%s'", path))
+	_, err := db.rwDB.Exec("VACUUM INTO ?", path)
 	return err
 }
 
@@ -479,6 +1341,26 @@ func (db *DB) GetSynchronousMode() (SynchronousMode, error) {
 	return SynchronousModeFromInt(rwN)
 }
 
+// SynchronousMode returns the current synchronous mode of the database, as
+// one of "OFF", "NORMAL", "FULL" or "EXTRA". If the mode cannot be read, an
+// empty string is returned.
+//
+// With OFF SQLite does not sync the database to disk, so writes are fastest,
+// but a power loss or operating system crash may corrupt the database. This
+// is safe for rqlite, since the database can be rebuilt from the Raft log,
+// and may suit embedded users performing bulk imports. In WAL mode NORMAL
+// is also safe from corruption, and only syncs at checkpoints, but the most
+// recent transactions may be lost on power loss. FULL syncs at every commit,
+// making each transaction durable, at a significant cost in throughput, and
+// EXTRA additionally syncs the directory in rollback journal mode.
+func (db *DB) SynchronousMode() string {
+	mode, err := db.GetSynchronousMode()
+	if err != nil {
+		return ""
+	}
+	return mode.String()
+}
+
 // FKEnabled returns whether Foreign Key constraints are enabled.
 func (db *DB) FKEnabled() bool {
 	return db.fkEnabled
@@ -496,7 +1378,7 @@ func (db *DB) Path() string {
 
 // WALPath returns the path to the WAL file for this database.
 func (db *DB) WALPath() string {
-	if !db.wal {
+	if !db.wal || db.memory {
 		return ""
 	}
 	return db.walPath
@@ -571,22 +1453,132 @@ func (db *DB) ExecuteStringStmt(query string) ([]*command.ExecuteQueryResponse,
 	return db.Execute(r, false)
 }
 
+// ExecuteParameterized executes a single query that modifies the database,
+// binding args to its parameters. Arguments are bound through SQLite, so they
+// are never interpreted as SQL. Each argument must be nil, a bool, an integer,
+// a float, a string or a []byte, or a sql.NamedArg holding one of these to
+// bind a named parameter.
+func (db *DB) ExecuteParameterized(query string, args ...interface{}) ([]*command.ExecuteQueryResponse, error) {
+	params, err := makeParameters(args)
+	if err != nil {
+		return nil, err
+	}
+	r := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql:        query,
+				Parameters: params,
+			},
+		},
+	}
+	return db.Execute(r, false)
+}
+
+// StatementError is returned by ExecuteStrings when a statement fails.
+type StatementError struct {
+	// Index is the index of the failed statement.
+	Index int
+
+	// Err is the error reported by the statement.
+	Err string
+}
+
+// Error implements the error interface.
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("statement %d: %s", e.Index, e.Err)
+}
+
+// ExecuteStrings executes the statements, which modify the database, within
+// a single transaction. This is much faster than executing each statement
+// individually when loading many rows. If a statement fails the transaction is
+// rolled back, so none of the statements take effect, and a *StatementError
+// identifying the failed statement is returned. The results align with stmts:
+// the result at index i is that of stmts[i], and is nil if the statement is
+// empty, or was not executed because an earlier statement failed.
+func (db *DB) ExecuteStrings(stmts []string) ([]*command.ExecuteQueryResponse, error) {
+	req := &command.Request{
+		Transaction: true,
+		Statements:  make([]*command.Statement, 0, len(stmts)),
+	}
+	idxs := make([]int, 0, len(stmts))
+	for i, s := range stmts {
+		if s == "" {
+			continue
+		}
+		req.Statements = append(req.Statements, &command.Statement{Sql: s})
+		idxs = append(idxs, i)
+	}
+	res, err := db.Execute(req, false)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*command.ExecuteQueryResponse, len(stmts))
+	for i, r := range res {
+		results[idxs[i]] = r
+		if e := r.GetError(); e != "" {
+			return results, &StatementError{Index: idxs[i], Err: e}
+		}
+	}
+	return results, nil
+}
+
+// ExecuteSummary summarizes the results of executing statements.
+type ExecuteSummary struct {
+	// RowsAffected is the total number of rows affected by the statements.
+	RowsAffected int64
+
+	// LastInsertID is the last insert ID reported by the statements, that is
+	// the rowid of the most recent successful INSERT on the connection.
+	LastInsertID int64
+}
+
+// SummarizeExecute returns a summary of results, as returned by Execute or
+// ExecuteStringStmt, so callers need not inspect each result. If any result
+// is an error, the summary of the results before it is returned, along with
+// a *StatementError identifying it.
+func SummarizeExecute(results []*command.ExecuteQueryResponse) (ExecuteSummary, error) {
+	var sum ExecuteSummary
+	for i, r := range results {
+		if e := r.GetError(); e != "" {
+			return sum, &StatementError{Index: i, Err: e}
+		}
+		er := r.GetE()
+		if er == nil {
+			continue
+		}
+		sum.RowsAffected += er.RowsAffected
+		if er.LastInsertId != 0 {
+			sum.LastInsertID = er.LastInsertId
+		}
+	}
+	return sum, nil
+}
+
 // Execute executes queries that modify the database.
 func (db *DB) Execute(req *command.Request, xTime bool) ([]*command.ExecuteQueryResponse, error) {
+	if err := db.ops.enter(); err != nil {
+		return nil, err
+	}
+	defer db.ops.exit()
 	stats.Add(numExecutions, int64(len(req.Statements)))
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	if err := db.writeQueue.Acquire(ctx, db.ops.closing()); err != nil {
+		return nil, err
+	}
+	defer db.writeQueue.Release()
+	defer db.checkpointIfWALLarge() // Runs after the connection is released.
 	conn, err := db.rwDB.Conn(context.Background())
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
-
-	ctx := context.Background()
-	if req.DbTimeout > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.DbTimeout))
-		defer cancel()
+	for attempt := 0; ; attempt++ {
+		res, err := db.executeWithConn(ctx, req, xTime, conn)
+		if !req.Transaction || !txFailedBusy(res, err) || !db.busyRetrier.Retry(ctx, attempt) {
+			return res, err
+		}
 	}
-	return db.executeWithConn(ctx, req, xTime, conn)
 }
 
 type execerQueryer interface {
@@ -641,6 +1633,9 @@ func (db *DB) executeWithConn(ctx context.Context, req *command.Request, xTime b
 		}
 
 		result, err := db.executeStmtWithConn(ctx, stmt, xTime, eqer, time.Duration(req.DbTimeout))
+		for attempt := 0; tx == nil && isBusyError(err) && db.busyRetrier.Retry(ctx, attempt); attempt++ {
+			result, err = db.executeStmtWithConn(ctx, stmt, xTime, eqer, time.Duration(req.DbTimeout))
+		}
 		if err != nil {
 			if handleError(result, err) {
 				continue
@@ -697,6 +1692,7 @@ func (db *DB) executeStmtWithConn(ctx context.Context, stmt *command.Statement,
 			Q: rows,
 		}
 	} else {
+		defer func() { db.slowLogger.Log(stmt, time.Since(start)) }()
 		result, err := eq.ExecContext(ctx, stmt.Sql, parameters...)
 		if err != nil {
 			response.Result = &command.ExecuteQueryResponse_Error{
@@ -751,6 +1747,25 @@ func (db *DB) QueryStringStmt(query string) ([]*command.QueryRows, error) {
 	return db.Query(r, false)
 }
 
+// QueryParameterized executes a single query that return rows, but don't
+// modify database, binding args to its parameters as ExecuteParameterized
+// does.
+func (db *DB) QueryParameterized(query string, args ...interface{}) ([]*command.QueryRows, error) {
+	params, err := makeParameters(args)
+	if err != nil {
+		return nil, err
+	}
+	r := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql:        query,
+				Parameters: params,
+			},
+		},
+	}
+	return db.Query(r, false)
+}
+
 // QueryStringStmtWithTimeout executes a single query that return rows, but don't modify database.
 // It also sets a timeout for the query.
 func (db *DB) QueryStringStmtWithTimeout(query string, tx bool, timeout time.Duration) ([]*command.QueryRows, error) {
@@ -766,14 +1781,55 @@ func (db *DB) QueryStringStmtWithTimeout(query string, tx bool, timeout time.Dur
 	return db.Query(r, false)
 }
 
+// QueryOptions controls how QueryStringStmtWithOptions runs a query.
+type QueryOptions struct {
+	// ReadOnlyConn, if true, runs the query on a read-only connection from
+	// a pool dedicated to such queries, instead of the pool shared by all
+	// other reads. This suits long-running queries, such as analytical
+	// queries, which would otherwise occupy connections that short queries
+	// wait for. A read-only connection never takes the write lock, so the
+	// query cannot block writers. In WAL mode however the query still reads
+	// from a snapshot of the database, and a RESTART or TRUNCATE checkpoint
+	// cannot complete until the query has finished.
+	ReadOnlyConn bool
+}
+
+// QueryStringStmtWithOptions executes a single query that return rows, but
+// don't modify database, as controlled by opts.
+func (db *DB) QueryStringStmtWithOptions(query string, opts QueryOptions) ([]*command.QueryRows, error) {
+	r := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: query,
+			},
+		},
+	}
+	if opts.ReadOnlyConn {
+		return db.queryOn(db.rodDB, r, false)
+	}
+	return db.Query(r, false)
+}
+
 // Query executes queries that return rows, but don't modify the database.
 func (db *DB) Query(req *command.Request, xTime bool) ([]*command.QueryRows, error) {
+	return db.queryOn(db.roDB, req, xTime)
+}
+
+func (db *DB) queryOn(pool *sql.DB, req *command.Request, xTime bool) ([]*command.QueryRows, error) {
+	if err := db.ops.enter(); err != nil {
+		return nil, err
+	}
+	defer db.ops.exit()
 	stats.Add(numQueries, int64(len(req.Statements)))
-	conn, err := db.roDB.Conn(context.Background())
+	conn, err := pool.Conn(context.Background())
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
+	if err := db.syncAttached(context.Background(), conn); err != nil {
+		return nil, err
+	}
+	defer db.readers.remove(db.readers.add(ReaderQuery, requestSQL(req)))
 
 	ctx := context.Background()
 	if req.DbTimeout > 0 {
@@ -860,6 +1916,7 @@ func (db *DB) queryStmtWithConn(ctx context.Context, stmt *command.Statement, xT
 	}()
 	rows := &command.QueryRows{}
 	start := time.Now()
+	defer func() { db.slowLogger.Log(stmt, time.Since(start)) }()
 
 	parameters, err := parametersToValues(stmt.Parameters)
 	if err != nil {
@@ -958,20 +2015,24 @@ func (db *DB) RequestStringStmtsWithTimeout(stmts []string, timeout time.Duratio
 
 // Request processes a request that can contain both executes and queries.
 func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQueryResponse, error) {
+	if err := db.ops.enter(); err != nil {
+		return nil, err
+	}
+	defer db.ops.exit()
 	stats.Add(numRequests, int64(len(req.Statements)))
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	if err := db.writeQueue.Acquire(ctx, db.ops.closing()); err != nil {
+		return nil, err
+	}
+	defer db.writeQueue.Release()
+	defer db.checkpointIfWALLarge() // Runs after the connection is released.
 	conn, err := db.rwDB.Conn(context.Background())
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
-	ctx := context.Background()
-	if req.DbTimeout > 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.DbTimeout))
-		defer cancel()
-	}
-
 	var eq execerQueryer
 	var tx *sql.Tx
 	if req.Transaction {
@@ -1035,6 +2096,64 @@ func (db *DB) Request(req *command.Request, xTime bool) ([]*command.ExecuteQuery
 	return eqResponse, err
 }
 
+// Intent is a hint as to whether the statements in a request read from, or
+// write to, the database.
+type Intent int
+
+const (
+	// IntentAuto determines the intent of a request from its statements. If
+	// every statement is read-only the request is processed using a read-only
+	// connection, otherwise it is processed using the read-write connection.
+	IntentAuto Intent = iota
+
+	// IntentRead processes the request using a read-only connection. Any
+	// statement which would change the database returns an error.
+	IntentRead
+
+	// IntentWrite processes the request using the read-write connection, even
+	// if every statement is read-only. This is useful for queries which have
+	// side effects SQLite cannot detect.
+	IntentWrite
+)
+
+// RequestWithIntent processes a request that can contain both executes and
+// queries, routing it to either a read-only or the read-write connection as
+// indicated by intent. Routing read-only requests to a read-only connection
+// allows them to run concurrently with other reads and with writes.
+func (db *DB) RequestWithIntent(req *command.Request, xTime bool, intent Intent) ([]*command.ExecuteQueryResponse, error) {
+	if intent == IntentAuto {
+		intent = IntentRead
+		for _, stmt := range req.Statements {
+			if stmt.Sql == "" {
+				continue
+			}
+			ro, err := db.StmtReadOnly(stmt.Sql)
+			if err != nil || !ro {
+				// Let the read-write path report any error.
+				intent = IntentWrite
+				break
+			}
+		}
+	}
+
+	switch intent {
+	case IntentRead:
+		rows, err := db.Query(req, xTime)
+		if err != nil {
+			return nil, err
+		}
+		eqResponse := make([]*command.ExecuteQueryResponse, len(rows))
+		for i := range rows {
+			eqResponse[i] = createEQQueryResponse(rows[i], nil)
+		}
+		return eqResponse, nil
+	case IntentWrite:
+		return db.Request(req, xTime)
+	default:
+		return nil, fmt.Errorf("invalid intent %d", intent)
+	}
+}
+
 // Backup writes a consistent snapshot of the database to the given file.
 // The resultant SQLite database file will be in DELETE mode. This function
 // can be called when changes to the database are in flight.
@@ -1076,6 +2195,22 @@ func (db *DB) Copy(dstDB *DB) error {
 	return err
 }
 
+// CloneInto overwrites the entire contents of dstDB with the contents of this
+// database, using the SQLite Online Backup API. No intermediate file is used.
+// Unlike Copy, dstDB retains its journal mode. This function can be called
+// when changes to the source database are in flight.
+func (db *DB) CloneInto(dstDB *DB) error {
+	if err := copyDatabase(dstDB, db); err != nil {
+		return fmt.Errorf("clone database: %s", err)
+	}
+	mode := "DELETE"
+	if dstDB.wal {
+		mode = "WAL"
+	}
+	_, err := dstDB.rwDB.Exec(fmt.Sprintf("PRAGMA journal_mode=%s", mode))
+	return err
+}
+
 // Serialize returns a byte slice representation of the SQLite database. For
 // an ordinary on-disk database file, the serialization is just a copy of the
 // disk file. If the database is in WAL mode, a temporary on-disk
@@ -1387,6 +2522,55 @@ func parametersToValues(parameters []*command.Parameter) ([]interface{}, error)
 	return values, nil
 }
 
+// makeParameters converts args to parameters. A sql.NamedArg is converted to
+// a named parameter.
+func makeParameters(args []interface{}) ([]*command.Parameter, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	params := make([]*command.Parameter, len(args))
+	for i, arg := range args {
+		p := &command.Parameter{}
+		if na, ok := arg.(sql.NamedArg); ok {
+			p.Name = na.Name
+			arg = na.Value
+		}
+		switch v := arg.(type) {
+		case nil:
+		case bool:
+			p.Value = &command.Parameter_B{B: v}
+		case int:
+			p.Value = &command.Parameter_I{I: int64(v)}
+		case int8:
+			p.Value = &command.Parameter_I{I: int64(v)}
+		case int16:
+			p.Value = &command.Parameter_I{I: int64(v)}
+		case int32:
+			p.Value = &command.Parameter_I{I: int64(v)}
+		case int64:
+			p.Value = &command.Parameter_I{I: v}
+		case uint8:
+			p.Value = &command.Parameter_I{I: int64(v)}
+		case uint16:
+			p.Value = &command.Parameter_I{I: int64(v)}
+		case uint32:
+			p.Value = &command.Parameter_I{I: int64(v)}
+		case float32:
+			p.Value = &command.Parameter_D{D: float64(v)}
+		case float64:
+			p.Value = &command.Parameter_D{D: v}
+		case string:
+			p.Value = &command.Parameter_S{S: v}
+		case []byte:
+			p.Value = &command.Parameter_Y{Y: v}
+		default:
+			return nil, fmt.Errorf("unsupported type %T for parameter %d", arg, i)
+		}
+		params[i] = p
+	}
+	return params, nil
+}
+
 // populateEmptyTypes populates any empty types with the type of the parameter.
 // This is necessary because the SQLite driver doesn't return the type of the
 // column in some cases e.g. it's an expression, so we use the actual types
@@ -2,8 +2,12 @@ package db
 
 import (
 	"bytes"
+	"errors"
+	"expvar"
+	"fmt"
 	"io"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -32,6 +36,447 @@ func Test_WALDatabaseCheckpointOKNoWAL(t *testing.T) {
 	}
 }
 
+// Test_WALDatabaseCheckpointIfNeeded tests that a checkpoint is skipped
+// when the WAL file does not exist or is empty.
+func Test_WALDatabaseCheckpointIfNeeded(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+
+	nSkipped := stats.Get(numCheckpointsSkipped).(*expvar.Int).Value()
+	nChk := stats.Get(numCheckpoints).(*expvar.Int).Value()
+	ok, err := db.CheckpointIfNeeded(CheckpointTruncate, 0)
+	if err != nil {
+		t.Fatalf("failed to checkpoint with nonexistent WAL: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("checkpoint performed with nonexistent WAL")
+	}
+	if exp, got := nSkipped+1, stats.Get(numCheckpointsSkipped).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong skipped checkpoints, exp %d, got %d", exp, got)
+	}
+	if exp, got := nChk, stats.Get(numCheckpoints).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("checkpoint unexpectedly run, exp %d, got %d", exp, got)
+	}
+
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	ok, err = db.CheckpointIfNeeded(CheckpointTruncate, 0)
+	if err != nil {
+		t.Fatalf("failed to checkpoint non-empty WAL: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("checkpoint not performed with non-empty WAL")
+	}
+	if mustFileSize(db.WALPath()) != 0 {
+		t.Fatalf("WAL file not truncated")
+	}
+
+	// The WAL now exists, but is empty.
+	ok, err = db.CheckpointIfNeeded(CheckpointTruncate, 0)
+	if err != nil {
+		t.Fatalf("failed to checkpoint with empty WAL: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("checkpoint performed with empty WAL")
+	}
+	if exp, got := nSkipped+2, stats.Get(numCheckpointsSkipped).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong skipped checkpoints, exp %d, got %d", exp, got)
+	}
+}
+
+// Test_WALDatabaseCheckpointWithResult tests that a checkpoint reports the
+// number of frames in the WAL, and the number checkpointed.
+func Test_WALDatabaseCheckpointWithResult(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 10; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	res, err := db.CheckpointWithResult(CheckpointRestart)
+	if err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	if res.LogFrames == 0 {
+		t.Fatalf("expected non-zero log frames")
+	}
+	if exp, got := res.LogFrames, res.CheckpointedFrames; exp != got {
+		t.Fatalf("not all frames checkpointed, exp %d, got %d", exp, got)
+	}
+
+	// A reader holding a snapshot prevents the WAL being fully checkpointed.
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	mustExecute(db, `INSERT INTO foo(name) VALUES("declan")`)
+	res, err = db.CheckpointWithResult(CheckpointPassive)
+	if err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	if res.CheckpointedFrames >= res.LogFrames {
+		t.Fatalf("expected fewer frames checkpointed than in WAL, got %d of %d",
+			res.CheckpointedFrames, res.LogFrames)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatalf("failed to close snapshot reader: %s", err.Error())
+	}
+}
+
+// Test_WALDatabaseCheckpointFull tests that a FULL checkpoint checkpoints
+// every frame without truncating the WAL, and is not blocked by a reader of
+// the latest snapshot, unlike a RESTART checkpoint.
+func Test_WALDatabaseCheckpointFull(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 10; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	walSz := mustFileSize(db.WALPath())
+	res, err := db.CheckpointWithResult(CheckpointFull)
+	if err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	if res.LogFrames == 0 {
+		t.Fatalf("expected non-zero log frames")
+	}
+	if exp, got := res.LogFrames, res.CheckpointedFrames; exp != got {
+		t.Fatalf("not all frames checkpointed, exp %d, got %d", exp, got)
+	}
+	if exp, got := walSz, mustFileSize(db.WALPath()); exp != got {
+		t.Fatalf("WAL size changed by FULL checkpoint, exp %d, got %d", exp, got)
+	}
+
+	// A reader of the latest snapshot blocks a RESTART checkpoint, but not
+	// a FULL checkpoint.
+	mustExecute(db, `INSERT INTO foo(name) VALUES("declan")`)
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	defer snap.Close()
+	if err := db.CheckpointWithTimeout(CheckpointRestart, 100*time.Millisecond); !errors.Is(err, ErrCheckpointTimeout) {
+		t.Fatalf("expected ErrCheckpointTimeout for RESTART checkpoint, got %v", err)
+	}
+	if err := db.CheckpointWithTimeout(CheckpointFull, 100*time.Millisecond); err != nil {
+		t.Fatalf("failed to FULL checkpoint with reader open: %s", err.Error())
+	}
+}
+
+// Test_WALDatabaseWALFrameCount tests that the WAL frame count is reported
+// without checkpointing, and only counts frames written since the WAL was
+// last reset.
+func Test_WALDatabaseWALFrameCount(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+
+	if err := db.Checkpoint(CheckpointTruncate); err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	if n, err := db.WALFrameCount(); err != nil || n != 0 {
+		t.Fatalf("expected 0 frames in empty WAL, got %d, %v", n, err)
+	}
+
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 10; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	walSize := mustFileSize(db.WALPath())
+	n, err := db.WALFrameCount()
+	if err != nil {
+		t.Fatalf("failed to get WAL frame count: %s", err.Error())
+	}
+	if mustFileSize(db.WALPath()) != walSize {
+		t.Fatalf("WAL changed by getting frame count")
+	}
+	res, err := db.CheckpointWithResult(CheckpointRestart)
+	if err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	if exp, got := res.LogFrames, n; exp != got {
+		t.Fatalf("unexpected WAL frame count, exp %d, got %d", exp, got)
+	}
+
+	// A RESTART checkpoint leaves the WAL file in place, but the next write
+	// resets it, so the old frames must not be counted.
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	n2, err := db.WALFrameCount()
+	if err != nil {
+		t.Fatalf("failed to get WAL frame count: %s", err.Error())
+	}
+	if n2 == 0 || n2 >= n {
+		t.Fatalf("unexpected WAL frame count after restart, got %d, was %d", n2, n)
+	}
+
+	if err := db.Checkpoint(CheckpointTruncate); err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	if n, err := db.WALFrameCount(); err != nil || n != 0 {
+		t.Fatalf("expected 0 frames after TRUNCATE checkpoint, got %d, %v", n, err)
+	}
+
+	dpath := mustTempFile()
+	defer os.Remove(dpath)
+	ddb, err := Open(dpath, false, false)
+	if err != nil {
+		t.Fatalf("failed to open database in DELETE mode: %s", err.Error())
+	}
+	defer ddb.Close()
+	mustExecute(ddb, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if n, err := ddb.WALFrameCount(); err != nil || n != 0 {
+		t.Fatalf("expected 0 frames for DELETE mode database, got %d, %v", n, err)
+	}
+}
+
+// Test_WALDatabaseCloseWithCheckpoint tests that closing with a checkpoint
+// leaves the database file self-contained, and that an active reader causes
+// the checkpoint to fail rather than hang.
+func Test_WALDatabaseCloseWithCheckpoint(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 10; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	if err := db.CloseWithCheckpoint(CheckpointTruncate); err != nil {
+		t.Fatalf("failed to close database with checkpoint: %s", err.Error())
+	}
+	if fileExists(db.WALPath()) && mustFileSize(db.WALPath()) != 0 {
+		t.Fatalf("WAL not empty after close with checkpoint")
+	}
+
+	// The database file alone must contain all the data.
+	rdb, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("failed to open database read-only: %s", err.Error())
+	}
+	rows, err := rdb.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[10]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("expected %s, got %s", exp, got)
+	}
+	rdb.Close()
+
+	// A reader holding a snapshot prevents a TRUNCATE checkpoint.
+	db, err = Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	defer snap.Close()
+	mustExecute(db, `INSERT INTO foo(name) VALUES("declan")`)
+	start := time.Now()
+	if err := db.CloseWithCheckpoint(CheckpointTruncate); err == nil {
+		t.Fatalf("expected error closing with checkpoint while reader active")
+	}
+	if d := time.Since(start); d > 2*closeCheckpointTimeout {
+		t.Fatalf("close with checkpoint took too long: %s", d)
+	}
+	if _, err := db.QueryStringStmt(`SELECT COUNT(*) FROM foo`); err == nil {
+		t.Fatalf("expected error querying closed database")
+	}
+}
+
+// Test_DatabaseCloseWithCheckpoint_NoWAL tests that closing with a checkpoint
+// succeeds when there is no WAL.
+func Test_DatabaseCloseWithCheckpoint_NoWAL(t *testing.T) {
+	for _, wal := range []bool{false, true} {
+		path := mustTempFile()
+		defer os.Remove(path)
+
+		db, err := Open(path, false, wal)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err.Error())
+		}
+		mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+		if wal {
+			if err := db.Checkpoint(CheckpointTruncate); err != nil {
+				t.Fatalf("failed to checkpoint database: %s", err.Error())
+			}
+			os.Remove(db.WALPath())
+		}
+		if err := db.CloseWithCheckpoint(CheckpointTruncate); err != nil {
+			t.Fatalf("failed to close database with checkpoint (WAL %t): %s", wal, err.Error())
+		}
+	}
+}
+
+// Test_WALDatabaseCheckpoint_LastModified tests that the last modified time
+// advances with writes before any checkpoint, and after a TRUNCATE checkpoint.
+func Test_WALDatabaseCheckpoint_LastModified(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	lm, err := db.LastModified()
+	if err != nil {
+		t.Fatalf("failed to get last modified time: %s", err.Error())
+	}
+	lmDB, err := db.DBLastModified()
+	if err != nil {
+		t.Fatalf("failed to get last modified time: %s", err.Error())
+	}
+
+	// On some platforms the time resolution isn't that high, so sleep so
+	// the test won't suffer a false failure.
+	time.Sleep(1 * time.Second)
+	for i := 0; i < 10; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	lm2, err := db.LastModified()
+	if err != nil {
+		t.Fatalf("failed to get last modified time: %s", err.Error())
+	}
+	if !lm2.After(lm) {
+		t.Fatalf("last modified time not advanced by writes before checkpoint")
+	}
+	lmDB2, err := db.DBLastModified()
+	if err != nil {
+		t.Fatalf("failed to get last modified time: %s", err.Error())
+	}
+	if !lmDB2.Equal(lmDB) {
+		t.Fatalf("last modified time changed for DB even though only WAL should have changed")
+	}
+
+	// Reads must not advance the last modified time.
+	time.Sleep(1 * time.Second)
+	for i := 0; i < 5; i++ {
+		if _, err := db.QueryStringStmt("SELECT * FROM foo"); err != nil {
+			t.Fatalf("failed to query table: %s", err.Error())
+		}
+	}
+	lmRead, err := db.LastModified()
+	if err != nil {
+		t.Fatalf("failed to get last modified time: %s", err.Error())
+	}
+	if !lmRead.Equal(lm2) {
+		t.Fatalf("last modified time advanced by reads")
+	}
+
+	time.Sleep(1 * time.Second)
+	if err := db.Checkpoint(CheckpointTruncate); err != nil {
+		t.Fatalf("failed to checkpoint database: %s", err.Error())
+	}
+	if mustFileSize(db.WALPath()) != 0 {
+		t.Fatalf("WAL file not truncated")
+	}
+	lm3, err := db.LastModified()
+	if err != nil {
+		t.Fatalf("failed to get last modified time: %s", err.Error())
+	}
+	if !lm3.After(lm2) {
+		t.Fatalf("last modified time not advanced by TRUNCATE checkpoint")
+	}
+}
+
+// Test_WALDatabaseCheckpointStats tests that checkpoints are counted by mode,
+// and that checkpoints which time out are counted.
+func Test_WALDatabaseCheckpointStats(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	// Another database's checkpoints are not counted.
+	other, otherPath := mustCreateOnDiskDatabaseWAL()
+	defer os.Remove(otherPath)
+	defer other.Close()
+	mustExecute(other, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if err := other.Checkpoint(CheckpointTruncate); err != nil {
+		t.Fatalf("failed to checkpoint other database: %s", err.Error())
+	}
+
+	for _, mode := range []CheckpointMode{CheckpointRestart, CheckpointTruncate, CheckpointTruncate, CheckpointPassive, CheckpointFull} {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+		if err := db.Checkpoint(mode); err != nil {
+			t.Fatalf("failed to checkpoint database: %s", err.Error())
+		}
+	}
+
+	// A reader prevents a TRUNCATE checkpoint from completing.
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	mustExecute(db, `INSERT INTO foo(name) VALUES("declan")`)
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, 100*time.Millisecond); err == nil {
+		t.Fatalf("expected checkpoint to time out")
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatalf("failed to close snapshot reader: %s", err.Error())
+	}
+
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if !reflect.DeepEqual(db.CheckpointStats(), st["checkpoint_stats"]) {
+		t.Fatalf("checkpoint stats not included in database stats")
+	}
+	for k, exp := range map[string]int64{
+		"checkpoints":         5,
+		"checkpoint_restart":  1,
+		"checkpoint_truncate": 2,
+		"checkpoint_passive":  1,
+		"checkpoint_full":     1,
+		"checkpoint_timeouts": 1,
+	} {
+		if got := db.CheckpointStats()[k]; exp != got {
+			t.Fatalf("wrong value for %s, exp %d, got %d", k, exp, got)
+		}
+	}
+}
+
 // Test_WALDatabaseCheckpointOKDelete tests that a checkpoint returns no error
 // even when the database is opened in DELETE mode.
 func Test_WALDatabaseCheckpointOKDelete(t *testing.T) {
@@ -153,8 +598,8 @@ func Test_WALDatabaseCheckpoint_RestartTimeout(t *testing.T) {
 		t.Fatalf("expected %s, got %s", exp, got)
 	}
 
-	if err := db.CheckpointWithTimeout(CheckpointRestart, 250*time.Millisecond); err == nil {
-		t.Fatal("expected error due to failure to checkpoint")
+	if err := db.CheckpointWithTimeout(CheckpointRestart, 250*time.Millisecond); !errors.Is(err, ErrCheckpointTimeout) {
+		t.Fatalf("expected ErrCheckpointTimeout due to failure to checkpoint, got %v", err)
 	}
 
 	// Get some information on the WAL file before the checkpoint. The goal here is
@@ -184,6 +629,28 @@ func Test_WALDatabaseCheckpoint_RestartTimeout(t *testing.T) {
 	}
 }
 
+// Test_WALDatabaseCheckpoint_ErrorNotTimeout tests that a checkpoint which
+// fails for a reason other than a timeout does not return ErrCheckpointTimeout.
+func Test_WALDatabaseCheckpoint_ErrorNotTimeout(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+	err = db.CheckpointWithTimeout(CheckpointTruncate, 250*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected error checkpointing closed database")
+	}
+	if errors.Is(err, ErrCheckpointTimeout) {
+		t.Fatalf("expected non-timeout error checkpointing closed database, got %v", err)
+	}
+}
+
 // Test_WALDatabaseCheckpoint_TruncateTimeout tests that a truncate checkpoint
 // does time out as expected if there is a long running read. It also confirms
 // that the WAL file is not modified as a result of this failure.
@@ -225,8 +692,8 @@ func Test_WALDatabaseCheckpoint_TruncateTimeout(t *testing.T) {
 		t.Fatalf("expected %s, got %s", exp, got)
 	}
 
-	if err := db.CheckpointWithTimeout(CheckpointTruncate, 250*time.Millisecond); err == nil {
-		t.Fatal("expected error due to failure to checkpoint")
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, 250*time.Millisecond); !errors.Is(err, ErrCheckpointTimeout) {
+		t.Fatalf("expected ErrCheckpointTimeout due to failure to checkpoint, got %v", err)
 	}
 	postWALBytes := mustReadBytes(db.WALPath())
 	if !bytes.Equal(preWALBytes, postWALBytes) {
@@ -261,6 +728,88 @@ func Test_WALDatabaseCheckpoint_TruncateTimeout(t *testing.T) {
 	}
 }
 
+// Test_WALDatabaseCheckpoint_Background tests that writes proceed while a
+// checkpoint is running on the dedicated checkpointing connection.
+func Test_WALDatabaseCheckpoint_Background(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.BackgroundCheckpoint = true
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+
+	_, err = db.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	if err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	for i := 0; i < 50; i++ {
+		_, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES(randomblob(4096))`)
+		if err != nil {
+			t.Fatalf("failed to execute INSERT on single node: %s", err.Error())
+		}
+	}
+
+	// Block checkpointing with a long-running read, and then start a checkpoint
+	// which will wait on that reader.
+	blockingDB, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open blocking database in WAL mode: %s", err.Error())
+	}
+	defer blockingDB.Close()
+	_, err = blockingDB.QueryStringStmt(`BEGIN TRANSACTION`)
+	if err != nil {
+		t.Fatalf("failed to execute query on single node: %s", err.Error())
+	}
+	_, err = blockingDB.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to execute query on single node: %s", err.Error())
+	}
+
+	// Writes must proceed while a passive checkpoint runs.
+	chkCh := db.CheckpointInBackground(CheckpointPassive, 0)
+	for i := 0; i < 50; i++ {
+		r, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`)
+		if err != nil {
+			t.Fatalf("failed to execute INSERT during checkpoint: %s", err.Error())
+		}
+		if r[0].GetError() != "" {
+			t.Fatalf("failed to execute INSERT during checkpoint: %s", r[0].GetError())
+		}
+	}
+	if err := <-chkCh; err != nil {
+		t.Fatalf("passive checkpoint failed: %s", err.Error())
+	}
+
+	// A truncate checkpoint, blocked by the reader, must not occupy the write
+	// connection. Writes wait on the SQLite writer lock instead, and so succeed
+	// once the reader goes away and the checkpoint completes.
+	chkCh = db.CheckpointInBackground(CheckpointTruncate, 5*time.Second)
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		time.Sleep(250 * time.Millisecond)
+		blockingDB.Close()
+	}()
+	_, err = db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`)
+	if err != nil {
+		t.Fatalf("failed to execute INSERT during checkpoint: %s", err.Error())
+	}
+	if err := <-chkCh; err != nil {
+		t.Fatalf("truncate checkpoint failed: %s", err.Error())
+	}
+
+	rows, err := db.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to execute query on single node: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[101]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("expected %s, got %s", exp, got)
+	}
+}
+
 func mustReadBytes(path string) []byte {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -282,3 +831,298 @@ func mustGetWALHeader(path string) []byte {
 	}
 	return hdr
 }
+
+// Test_WALDatabaseCheckpoint_Threshold tests that the WAL is checkpointed once
+// it reaches the configured threshold, and that readers blocking the
+// checkpoint do not cause writes to fail.
+func Test_WALDatabaseCheckpoint_Threshold(t *testing.T) {
+	const threshold = 64 * 1024
+	// A single insert of the rows below writes at most a few pages to the WAL.
+	const maxWALSz = threshold + 4*(4096+wal.WALFrameHeaderSize)
+
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.WALCheckpointThreshold = threshold
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+
+	_, err = db.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+	if err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	nChk := stats.Get(numWALPressureCheckpoints).(*expvar.Int).Value()
+	mustInsert := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			_, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES(hex(randomblob(500)))`)
+			if err != nil {
+				t.Fatalf("failed to execute INSERT on single node: %s", err.Error())
+			}
+			if sz := mustFileSize(db.WALPath()); sz > maxWALSz {
+				t.Fatalf("WAL size %d exceeds threshold %d", sz, threshold)
+			}
+		}
+	}
+	for _, n := range []int{10, 100, 1000} {
+		mustInsert(n)
+	}
+	nChks := stats.Get(numWALPressureCheckpoints).(*expvar.Int).Value() - nChk
+	if nChks == 0 {
+		t.Fatalf("expected WAL to be checkpointed")
+	}
+	// The WAL is truncated by each checkpoint, so it must regrow to the
+	// threshold, over many writes, before it is checkpointed again.
+	if nChks > 1110/4 {
+		t.Fatalf("WAL checkpointed too often, %d times for 1110 writes", nChks)
+	}
+
+	// Block checkpointing with a long-running read. Writes must still succeed,
+	// and the checkpoint failures must be recorded.
+	blockingDB, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open blocking database in WAL mode: %s", err.Error())
+	}
+	defer blockingDB.Close()
+	_, err = blockingDB.QueryStringStmt(`BEGIN TRANSACTION`)
+	if err != nil {
+		t.Fatalf("failed to execute query on single node: %s", err.Error())
+	}
+	_, err = blockingDB.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to execute query on single node: %s", err.Error())
+	}
+	nFail := stats.Get(numWALPressureFailures).(*expvar.Int).Value()
+	for i := 0; i < 30; i++ {
+		_, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES(hex(randomblob(500)))`)
+		if err != nil {
+			t.Fatalf("failed to execute INSERT on single node: %s", err.Error())
+		}
+	}
+	if sz := mustFileSize(db.WALPath()); sz <= threshold {
+		t.Fatalf("WAL size %d should exceed threshold while checkpointing is blocked", sz)
+	}
+	if stats.Get(numWALPressureFailures).(*expvar.Int).Value() == nFail {
+		t.Fatalf("expected checkpoint failures while reader blocked checkpointing")
+	}
+
+	// Once the reader is gone, the next write checkpoints and truncates the
+	// WAL.
+	blockingDB.Close()
+	_, err = db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES(hex(randomblob(500)))`)
+	if err != nil {
+		t.Fatalf("failed to execute INSERT on single node: %s", err.Error())
+	}
+	if sz := mustFileSize(db.WALPath()); sz != 0 {
+		t.Fatalf("WAL should be truncated once checkpointing is unblocked, got %d bytes", sz)
+	}
+	mustInsert(100)
+}
+
+// Test_WALDatabaseCheckpointUntil tests that CheckpointUntil retries a
+// checkpoint blocked by an intermittent reader, until the reader goes away.
+func Test_WALDatabaseCheckpointUntil(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+
+	// The reader repeatedly opens a snapshot, after which a write is made
+	// which a PASSIVE checkpoint cannot copy until the snapshot is closed.
+	blocked := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			snap, err := db.SnapshotReader()
+			if err != nil {
+				t.Errorf("failed to create snapshot reader: %s", err.Error())
+				return
+			}
+			if _, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`); err != nil {
+				t.Errorf("failed to execute INSERT: %s", err.Error())
+			}
+			if i == 0 {
+				close(blocked)
+			}
+			time.Sleep(200 * time.Millisecond)
+			snap.Close()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	defer func() { <-done }()
+	<-blocked
+
+	if res, err := db.CheckpointWithResult(CheckpointPassive); err != nil {
+		t.Fatalf("failed to checkpoint: %s", err.Error())
+	} else if res.CheckpointedFrames == res.LogFrames {
+		t.Fatalf("expected checkpoint to be blocked by reader, got %+v", res)
+	}
+
+	start := time.Now()
+	if err := db.CheckpointUntil(CheckpointPassive, time.Now().Add(5*time.Second), 10*time.Millisecond); err != nil {
+		t.Fatalf("failed to checkpoint until deadline: %s", err.Error())
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("checkpoint completed after %s, while the reader was still open", d)
+	}
+}
+
+// Test_WALDatabaseCheckpointUntil_Deadline tests that CheckpointUntil gives
+// up once the deadline passes.
+func Test_WALDatabaseCheckpointUntil_Deadline(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database in WAL mode: %s", err.Error())
+	}
+	defer db.Close()
+	mustExecute(db, `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`)
+
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	defer snap.Close()
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+
+	for _, mode := range []CheckpointMode{CheckpointPassive, CheckpointTruncate} {
+		start := time.Now()
+		err := db.CheckpointUntil(mode, start.Add(200*time.Millisecond), 50*time.Millisecond)
+		if !errors.Is(err, ErrCheckpointTimeout) {
+			t.Fatalf("expected ErrCheckpointTimeout for mode %d, got %v", mode, err)
+		}
+		if d := time.Since(start); d < 150*time.Millisecond || d > 2*time.Second {
+			t.Fatalf("checkpoint for mode %d gave up after %s", mode, d)
+		}
+	}
+
+	if err := db.CheckpointUntil(CheckpointPassive, time.Now(), 50*time.Millisecond); !errors.Is(err, ErrCheckpointTimeout) {
+		t.Fatalf("expected ErrCheckpointTimeout for passed deadline, got %v", err)
+	}
+	if err := db.CheckpointUntil(CheckpointPassive, time.Now().Add(time.Second), 0); err == nil {
+		t.Fatalf("expected error for zero interval")
+	}
+}
+
+// BenchmarkWALDatabaseCheckpoint_WriteLatency measures the latency of a write
+// made while a checkpoint is running, with and without a dedicated
+// checkpointing connection.
+func BenchmarkWALDatabaseCheckpoint_WriteLatency(b *testing.B) {
+	for _, bg := range []bool{false, true} {
+		b.Run(fmt.Sprintf("BackgroundCheckpoint=%t", bg), func(b *testing.B) {
+			path := mustTempFile()
+			defer os.Remove(path)
+			cfg := NewConfig()
+			cfg.WAL = true
+			cfg.BackgroundCheckpoint = bg
+			db, err := OpenWithConfig(path, cfg)
+			if err != nil {
+				b.Fatalf("failed to open database in WAL mode: %s", err.Error())
+			}
+			defer db.Close()
+			if _, err := db.ExecuteStringStmt(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`); err != nil {
+				b.Fatalf("failed to create table: %s", err.Error())
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := 0; j < 1000; j++ {
+					if _, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES(randomblob(4096))`); err != nil {
+						b.Fatalf("failed to execute INSERT: %s", err.Error())
+					}
+				}
+				chkCh := db.CheckpointInBackground(CheckpointPassive, 0)
+				time.Sleep(time.Millisecond) // Let the checkpoint get under way.
+				b.StartTimer()
+				if _, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`); err != nil {
+					b.Fatalf("failed to execute INSERT during checkpoint: %s", err.Error())
+				}
+				b.StopTimer()
+				if err := <-chkCh; err != nil {
+					b.Fatalf("checkpoint failed: %s", err.Error())
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// Test_OpenWithConfig_LateFailureCloses tests that when opening a database
+// fails after its connections have been opened, the connections are closed.
+func Test_OpenWithConfig_LateFailureCloses(t *testing.T) {
+	if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("open file descriptors cannot be counted on this platform")
+	}
+	numFDs := func() int {
+		fds, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Fatalf("failed to read open file descriptors: %s", err.Error())
+		}
+		return len(fds)
+	}
+
+	for _, bg := range []bool{false, true} {
+		t.Run(fmt.Sprintf("BackgroundCheckpoint=%t", bg), func(t *testing.T) {
+			path := mustTempFile()
+			defer os.Remove(path)
+			cfg := NewConfig()
+			cfg.WAL = true
+			cfg.BackgroundCheckpoint = bg
+			// An invalid adaptive checkpoint configuration fails the open at
+			// its last step, once every connection has been opened.
+			cfg.AdaptiveCheckpointMaxInterval = time.Second
+			cfg.AdaptiveCheckpointMinInterval = 2 * time.Second
+
+			n := numFDs()
+			if _, err := OpenWithConfig(path, cfg); !errors.Is(err, ErrInvalidCheckpointInterval) {
+				t.Fatalf("expected ErrInvalidCheckpointInterval, got %v", err)
+			}
+			if exp, got := n, numFDs(); exp != got {
+				t.Fatalf("expected %d open file descriptors after failed open, got %d", exp, got)
+			}
+		})
+	}
+}
+
+// Test_OpenWithConfig_ConflictingCheckpointers tests that a database cannot be
+// opened with more than one automatic checkpointer enabled.
+func Test_OpenWithConfig_ConflictingCheckpointers(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.WALCheckpointThreshold = 1024 * 1024
+	cfg.WALAutoCheckpointBytes = 1024 * 1024
+	if _, err := OpenWithConfig(path, cfg); err != ErrConflictingCheckpointers {
+		t.Fatalf("expected ErrConflictingCheckpointers, got %v", err)
+	}
+
+	cfg.WALAutoCheckpointBytes = 0
+	cfg.AdaptiveCheckpointMinInterval = time.Second
+	cfg.AdaptiveCheckpointMaxInterval = time.Minute
+	if _, err := OpenWithConfig(path, cfg); err != ErrConflictingCheckpointers {
+		t.Fatalf("expected ErrConflictingCheckpointers, got %v", err)
+	}
+
+	// One checkpointer alone is allowed.
+	cfg.WALCheckpointThreshold = 0
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database with one checkpointer: %s", err.Error())
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+}
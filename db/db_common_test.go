@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"errors"
 	"os"
 	"strings"
@@ -1020,6 +1021,46 @@ func testSimpleNilParameterizedStatements(t *testing.T, db *DB) {
 	}
 }
 
+func testExecuteQueryParameterized(t *testing.T, db *DB) {
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, score REAL, data BLOB, note TEXT)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	for _, args := range [][]interface{}{
+		{1, "fiona", 1.5, []byte{0x01, 0x02}, nil},
+		{int64(2), `"declan"; DROP TABLE foo`, float32(2.5), []byte("xyz"), "note"},
+	} {
+		r, err := db.ExecuteParameterized("INSERT INTO foo(id, name, score, data, note) VALUES(?, ?, ?, ?, ?)", args...)
+		if err != nil {
+			t.Fatalf("failed to execute parameterized statement: %s", err.Error())
+		}
+		if e := r[0].GetError(); e != "" {
+			t.Fatalf("parameterized statement failed: %s", e)
+		}
+	}
+
+	rows, err := db.QueryParameterized("SELECT id, name, score, hex(data), note FROM foo WHERE id >= ? ORDER BY id", 1)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name","score","hex(data)","note"],"types":["integer","text","real","text","text"],"values":[[1,"fiona",1.5,"0102",null],[2,"\"declan\"; DROP TABLE foo",2.5,"78797A","note"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	rows, err = db.QueryParameterized("SELECT name FROM foo WHERE id = :id AND note IS :note", sql.Named("id", 1), sql.Named("note", nil))
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["fiona"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	if _, err := db.ExecuteParameterized("INSERT INTO foo(id) VALUES(?)", struct{}{}); err == nil {
+		t.Fatalf("expected error for unsupported parameter type")
+	}
+}
+
 func testSimpleNamedParameterizedStatements(t *testing.T, db *DB) {
 	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, first TEXT, last TEXT)")
 	if err != nil {
@@ -1707,6 +1748,47 @@ func testBackup(t *testing.T, db *DB) {
 	}
 }
 
+func testCloneInto(t *testing.T, db *DB) {
+	_, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for i := 0; i < 100; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+
+	dstDB, dstPath := mustCreateOnDiskDatabaseWAL()
+	defer dstDB.Close()
+	defer os.Remove(dstPath)
+	mustExecute(dstDB, "CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(dstDB, `INSERT INTO bar(name) VALUES("declan")`)
+
+	if err := db.CloneInto(dstDB); err != nil {
+		t.Fatalf("failed to clone database: %s", err.Error())
+	}
+
+	ro, err := dstDB.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[100]]}]`, asJSON(ro); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	ro, err = dstDB.QueryStringStmt(`SELECT name FROM sqlite_master`)
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["foo"]]}]`, asJSON(ro); exp != got {
+		t.Fatalf("destination not overwritten\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Destination should still be usable for writes, and remain in WAL mode.
+	mustExecute(dstDB, `INSERT INTO foo(name) VALUES("fiona")`)
+	if !fileExists(dstDB.WALPath()) {
+		t.Fatalf("destination database not in WAL mode after clone")
+	}
+}
+
 func Test_DatabaseCommonOperations(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -1745,6 +1827,7 @@ func Test_DatabaseCommonOperations(t *testing.T) {
 		{"SimpleTwoParameterizedStatements", testSimpleTwoParameterizedStatements},
 		{"SimpleNilParameterizedStatements", testSimpleNilParameterizedStatements},
 		{"SimpleNamedParameterizedStatements", testSimpleNamedParameterizedStatements},
+		{"ExecuteQueryParameterized", testExecuteQueryParameterized},
 		{"SimpleRequest", testSimpleRequest},
 		{"SimpleRequestTx", testSimpleRequestTx},
 		{"CommonTableExpressions", testCommonTableExpressions},
@@ -1759,6 +1842,7 @@ func Test_DatabaseCommonOperations(t *testing.T) {
 		{"JSON1", testJSON1},
 		{"DBSTAT_table", testDBSTAT_table},
 		{"Copy", testCopy},
+		{"CloneInto", testCloneInto},
 		{"Backup", testBackup},
 	}
 
@@ -1,10 +1,17 @@
 package db
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -325,6 +332,104 @@ func Test_DBVacuum(t *testing.T) {
 	}
 }
 
+func Test_DBFileStats(t *testing.T) {
+	for _, wal := range []bool{false, true} {
+		path := mustTempFile()
+		defer os.Remove(path)
+		db, err := Open(path, false, wal)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err.Error())
+		}
+		defer db.Close()
+		mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+		for i := 0; i < 100; i++ {
+			mustExecute(db, fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, strings.Repeat("x", 500)))
+		}
+		mustExecute(db, "DELETE FROM foo WHERE id > 10")
+
+		st, err := db.FileStats()
+		if err != nil {
+			t.Fatalf("failed to get file stats: %s", err.Error())
+		}
+		if st.PageSize != 4096 {
+			t.Fatalf("unexpected page size: %d", st.PageSize)
+		}
+		if st.PageCount == 0 || st.FreelistCount == 0 || st.FreelistCount >= st.PageCount {
+			t.Fatalf("unexpected page counts: %s", asJSON(st))
+		}
+		if exp, got := mustFileSize(path), st.FileSize; exp != got {
+			t.Fatalf("unexpected file size, exp %d, got %d", exp, got)
+		}
+		if wal {
+			if exp, got := mustFileSize(db.WALPath()), st.WALSize; exp == 0 || exp != got {
+				t.Fatalf("unexpected WAL size, exp %d, got %d", exp, got)
+			}
+			if err := db.Checkpoint(CheckpointTruncate); err != nil {
+				t.Fatalf("failed to checkpoint database: %s", err.Error())
+			}
+			os.Remove(db.WALPath())
+			if st, err = db.FileStats(); err != nil || st.WALSize != 0 {
+				t.Fatalf("expected zero WAL size without WAL file, got %d, %v", st.WALSize, err)
+			}
+		} else if st.WALSize != 0 {
+			t.Fatalf("expected zero WAL size in DELETE mode, got %d", st.WALSize)
+		}
+	}
+}
+
+func Test_DBFragmentation(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 500; i++ {
+		mustExecute(db, fmt.Sprintf(`INSERT INTO foo(name) VALUES("%s")`, strings.Repeat("x", 500)))
+	}
+
+	free, total, pct, err := db.Fragmentation()
+	if err != nil {
+		t.Fatalf("failed to get fragmentation: %s", err.Error())
+	}
+	if free != 0 || pct != 0 {
+		t.Fatalf("expected no free pages, got %d free, %f%%", free, pct)
+	}
+	if total < 50 {
+		t.Fatalf("expected at least 50 pages, got %d", total)
+	}
+	if vacuumed, err := db.CompactIfFragmented(10); err != nil || vacuumed {
+		t.Fatalf("unexpected VACUUM of unfragmented database: %t, %v", vacuumed, err)
+	}
+
+	mustExecute(db, "DELETE FROM foo WHERE id > 50")
+	free, newTotal, pct, err := db.Fragmentation()
+	if err != nil {
+		t.Fatalf("failed to get fragmentation: %s", err.Error())
+	}
+	if newTotal != total {
+		t.Fatalf("page count changed after delete, was %d, now %d", total, newTotal)
+	}
+	if pct < 50 || free == 0 {
+		t.Fatalf("expected free-page ratio to increase, got %d free of %d, %f%%", free, total, pct)
+	}
+	if exp, got := 100*float64(free)/float64(total), pct; exp != got {
+		t.Fatalf("wrong fragmentation percentage, exp %f, got %f", exp, got)
+	}
+
+	if vacuumed, err := db.CompactIfFragmented(pct + 1); err != nil || vacuumed {
+		t.Fatalf("unexpected VACUUM below threshold: %t, %v", vacuumed, err)
+	}
+	if vacuumed, err := db.CompactIfFragmented(10); err != nil || !vacuumed {
+		t.Fatalf("expected VACUUM above threshold: %t, %v", vacuumed, err)
+	}
+	free, newTotal, _, err = db.Fragmentation()
+	if err != nil {
+		t.Fatalf("failed to get fragmentation: %s", err.Error())
+	}
+	if free != 0 || newTotal >= total {
+		t.Fatalf("expected VACUUM to compact database, got %d free of %d", free, newTotal)
+	}
+}
+
 func Test_DBVacuumInto(t *testing.T) {
 	db, path := mustCreateOnDiskDatabaseWAL()
 	defer db.Close()
@@ -399,6 +504,167 @@ func Test_DBVacuumInto(t *testing.T) {
 	}
 }
 
+// Test_DBVacuum_DELETE ensures VACUUM and VACUUM INTO work on a database in
+// DELETE mode, including into paths which need quoting.
+func Test_DBVacuum_DELETE(t *testing.T) {
+	db, path := mustCreateOnDiskDatabase()
+	defer db.Close()
+	defer os.Remove(path)
+
+	if _, err := db.ExecuteStringStmt("CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`); err != nil {
+			t.Fatalf("error executing insertion into table: %s", err.Error())
+		}
+	}
+	if _, err := db.ExecuteStringStmt(`DELETE FROM foo WHERE id > 10`); err != nil {
+		t.Fatalf("error deleting from table: %s", err.Error())
+	}
+	if err := db.Vacuum(); err != nil {
+		t.Fatalf("failed to vacuum database: %s", err.Error())
+	}
+
+	tmpPath := filepath.Join(t.TempDir(), "it's.db")
+	if err := db.VacuumInto(tmpPath); err != nil {
+		t.Fatalf("failed to vacuum database into %s: %s", tmpPath, err.Error())
+	}
+	if !IsValidSQLiteFile(tmpPath) {
+		t.Fatalf("vacuumed file is not a valid SQLite database")
+	}
+	vDB, err := Open(tmpPath, false, false)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer vDB.Close()
+	q, err := vDB.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[10]]}]`, asJSON(q); exp != got {
+		t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+	}
+}
+
+// Test_ExecuteStrings tests that statements executed together form a single
+// transaction, with results aligned to the statements.
+func Test_ExecuteRowsAffectedLastInsertID(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	var lastID int64
+	for i := 0; i < 50; i++ {
+		res, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`)
+		if err != nil {
+			t.Fatalf("failed to insert record: %s", err.Error())
+		}
+		if len(res) != 1 {
+			t.Fatalf("wrong number of results, exp 1, got %d", len(res))
+		}
+		er := res[0].GetE()
+		if er == nil {
+			t.Fatalf("result is not an execute result: %s", asJSON(res))
+		}
+		if exp, got := int64(1), er.RowsAffected; exp != got {
+			t.Fatalf("wrong rows affected, exp %d, got %d", exp, got)
+		}
+		if er.LastInsertId <= lastID {
+			t.Fatalf("last insert ID did not grow, was %d, got %d", lastID, er.LastInsertId)
+		}
+		lastID = er.LastInsertId
+
+		sum, err := SummarizeExecute(res)
+		if err != nil {
+			t.Fatalf("failed to summarize results: %s", err.Error())
+		}
+		if exp, got := (ExecuteSummary{RowsAffected: 1, LastInsertID: lastID}), sum; exp != got {
+			t.Fatalf("wrong summary, exp %+v, got %+v", exp, got)
+		}
+	}
+
+	res, err := db.ExecuteStrings([]string{
+		`INSERT INTO foo(name) VALUES("declan")`,
+		`UPDATE foo SET name = "aoife" WHERE id <= 10`,
+	})
+	if err != nil {
+		t.Fatalf("failed to execute statements: %s", err.Error())
+	}
+	sum, err := SummarizeExecute(res)
+	if err != nil {
+		t.Fatalf("failed to summarize results: %s", err.Error())
+	}
+	if exp, got := (ExecuteSummary{RowsAffected: 11, LastInsertID: 51}), sum; exp != got {
+		t.Fatalf("wrong summary, exp %+v, got %+v", exp, got)
+	}
+
+	res, err = db.ExecuteStringStmt(`INSERT INTO foo(id, name) VALUES(1, "dupe")`)
+	if err != nil {
+		t.Fatalf("failed to execute statement: %s", err.Error())
+	}
+	var stmtErr *StatementError
+	if _, err := SummarizeExecute(res); !errors.As(err, &stmtErr) || stmtErr.Index != 0 {
+		t.Fatalf("expected StatementError for statement 0, got %v", err)
+	}
+}
+
+func Test_ExecuteStrings(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	stmts := []string{
+		"CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)",
+		`INSERT INTO foo(name) VALUES("fiona")`,
+		"",
+		`INSERT INTO foo(name) VALUES("declan")`,
+	}
+	res, err := db.ExecuteStrings(stmts)
+	if err != nil {
+		t.Fatalf("failed to execute statements: %s", err.Error())
+	}
+	if exp, got := len(stmts), len(res); exp != got {
+		t.Fatalf("wrong number of results, exp %d, got %d", exp, got)
+	}
+	if res[2] != nil {
+		t.Fatalf("expected nil result for empty statement, got %s", asJSON(res[2]))
+	}
+	if exp, got := `{"last_insert_id":2,"rows_affected":1}`, asJSON(res[3]); exp != got {
+		t.Fatalf("unexpected result for statement 3, exp %s, got %s", exp, got)
+	}
+
+	// A failure rolls back every statement, and identifies the failed one.
+	stmts = []string{
+		`INSERT INTO foo(name) VALUES("fiona")`,
+		`INSERT INTO foo(name) VALUES("fiona")`,
+		`INSERT INTO bar(name) VALUES("fiona")`,
+		`INSERT INTO foo(name) VALUES("fiona")`,
+	}
+	res, err = db.ExecuteStrings(stmts)
+	var se *StatementError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected StatementError, got %v", err)
+	}
+	if se.Index != 2 {
+		t.Fatalf("wrong index for failed statement, exp 2, got %d", se.Index)
+	}
+	if exp, got := len(stmts), len(res); exp != got {
+		t.Fatalf("wrong number of results, exp %d, got %d", exp, got)
+	}
+	if res[2].GetError() == "" || res[3] != nil {
+		t.Fatalf("unexpected results after failure: %s", asJSON(res))
+	}
+	r, err := db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+	}
+}
+
 // Test_TableCreationFK ensures foreign key constraints work
 func Test_TableCreationFK(t *testing.T) {
 	createTableFoo := "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)"
@@ -507,6 +773,28 @@ func Test_ConcurrentQueries(t *testing.T) {
 	wg.Wait()
 }
 
+func Test_QueryStringStmtWithOptions(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	exp := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`
+	for _, opts := range []QueryOptions{{}, {ReadOnlyConn: true}} {
+		rows, err := db.QueryStringStmtWithOptions("SELECT * FROM foo", opts)
+		if err != nil {
+			t.Fatalf("failed to query table with options %+v: %s", opts, err.Error())
+		}
+		if got := asJSON(rows); exp != got {
+			t.Fatalf("unexpected results for query with options %+v, exp %s, got %s", opts, exp, got)
+		}
+	}
+	if db.rodDB.Stats().Idle != 1 {
+		t.Fatalf("query with ReadOnlyConn did not use dedicated connection")
+	}
+}
+
 func Test_SQLForceQuery(t *testing.T) {
 	db, path := mustCreateOnDiskDatabaseWAL()
 	defer db.Close()
@@ -904,6 +1192,512 @@ func Test_WALDisableCheckpointing(t *testing.T) {
 	}
 }
 
+func Test_WALSetAutoCheckpoint(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	for _, pages := range []int{100, 5000, 0} {
+		if err := db.SetAutoCheckpoint(pages); err != nil {
+			t.Fatalf("failed to set auto-checkpoint threshold to %d: %s", pages, err.Error())
+		}
+		n, err := db.AutoCheckpoint()
+		if err != nil {
+			t.Fatalf("failed to get auto-checkpoint threshold: %s", err.Error())
+		}
+		if exp, got := pages, n; exp != got {
+			t.Fatalf("unexpected auto-checkpoint threshold, expected %d, got %d", exp, got)
+		}
+	}
+
+	if err := db.SetAutoCheckpoint(-1); err == nil {
+		t.Fatalf("expected error setting negative auto-checkpoint threshold")
+	}
+}
+
+func Test_WALAutoCheckpoint_Pragma(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	// Automatic checkpointing is disabled when the database is opened.
+	n, err := db.AutoCheckpoint()
+	if err != nil {
+		t.Fatalf("failed to get WAL auto-checkpoint threshold: %s", err.Error())
+	}
+	if n != 0 {
+		t.Fatalf("expected WAL auto-checkpoint to be disabled, got %d", n)
+	}
+
+	if err := db.SetAutoCheckpoint(2); err != nil {
+		t.Fatalf("failed to set WAL auto-checkpoint threshold: %s", err.Error())
+	}
+	n, err = db.AutoCheckpoint()
+	if err != nil {
+		t.Fatalf("failed to get WAL auto-checkpoint threshold: %s", err.Error())
+	}
+	if exp, got := 2, n; exp != got {
+		t.Fatalf("unexpected WAL auto-checkpoint threshold, expected %d, got %d", exp, got)
+	}
+
+	// Writes past the threshold are checkpointed by SQLite, which resets the
+	// WAL, so it stops growing.
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 10; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	sz := mustFileSize(db.WALPath())
+	for i := 0; i < 100; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	if got := mustFileSize(db.WALPath()); got > sz {
+		t.Fatalf("WAL grew from %d to %d bytes with automatic checkpointing enabled", sz, got)
+	}
+
+	if err := db.SetAutoCheckpoint(0); err != nil {
+		t.Fatalf("failed to disable WAL auto-checkpoint: %s", err.Error())
+	}
+	n, err = db.AutoCheckpoint()
+	if err != nil {
+		t.Fatalf("failed to get WAL auto-checkpoint threshold: %s", err.Error())
+	}
+	if n != 0 {
+		t.Fatalf("expected WAL auto-checkpoint to be disabled, got %d", n)
+	}
+}
+
+func Test_OpenBusyTimeout(t *testing.T) {
+	for _, wal := range []bool{false, true} {
+		path := mustTempFile()
+		defer os.Remove(path)
+
+		cfg := NewConfig()
+		cfg.WAL = wal
+		cfg.BusyTimeout = 1234 * time.Millisecond
+		db, err := OpenWithConfig(path, cfg)
+		if err != nil {
+			t.Fatalf("failed to open database with busy timeout: %s", err.Error())
+		}
+		defer db.Close()
+
+		rwMs, roMs, err := db.BusyTimeout()
+		if err != nil {
+			t.Fatalf("failed to get busy_timeout: %s", err.Error())
+		}
+		if rwMs != 1234 || roMs != 1234 {
+			t.Fatalf("want busy_timeout rw=1234, ro=1234, got rw=%d, ro=%d", rwMs, roMs)
+		}
+
+		// Every connection in the read-only pools must have the timeout set,
+		// not just the first one.
+		for _, pool := range []*sql.DB{db.roDB, db.rodDB} {
+			var conns []*sql.Conn
+			for i := 0; i < 3; i++ {
+				conn, err := pool.Conn(context.Background())
+				if err != nil {
+					t.Fatalf("failed to get read-only connection: %s", err.Error())
+				}
+				conns = append(conns, conn)
+				var ms int
+				if err := conn.QueryRowContext(context.Background(), "PRAGMA busy_timeout").Scan(&ms); err != nil {
+					t.Fatalf("failed to get busy_timeout: %s", err.Error())
+				}
+				if ms != 1234 {
+					t.Fatalf("want busy_timeout 1234 on read-only connection %d, got %d", i, ms)
+				}
+			}
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}
+	}
+}
+
+func Test_OpenCacheSize(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.CacheSizeKB = 8192
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database with cache size: %s", err.Error())
+	}
+	defer db.Close()
+
+	kb, err := db.CacheSizeKB()
+	if err != nil {
+		t.Fatalf("failed to get cache size: %s", err.Error())
+	}
+	if exp, got := 8192, kb; exp != got {
+		t.Fatalf("wrong cache size, exp %d, got %d", exp, got)
+	}
+
+	// Every connection in the read-only pools must have the cache size set.
+	for _, pool := range []*sql.DB{db.roDB, db.rodDB} {
+		var conns []*sql.Conn
+		for i := 0; i < 3; i++ {
+			conn, err := pool.Conn(context.Background())
+			if err != nil {
+				t.Fatalf("failed to get read-only connection: %s", err.Error())
+			}
+			conns = append(conns, conn)
+			var n int
+			if err := conn.QueryRowContext(context.Background(), "PRAGMA cache_size").Scan(&n); err != nil {
+				t.Fatalf("failed to get cache_size: %s", err.Error())
+			}
+			if n != -8192 {
+				t.Fatalf("want cache_size -8192 on read-only connection %d, got %d", i, n)
+			}
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+
+	// Without the option, the SQLite default is used.
+	db2, path2 := mustCreateOnDiskDatabaseWAL()
+	defer db2.Close()
+	defer os.Remove(path2)
+	kb, err = db2.CacheSizeKB()
+	if err != nil {
+		t.Fatalf("failed to get cache size: %s", err.Error())
+	}
+	if exp, got := 2000, kb; exp != got {
+		t.Fatalf("wrong default cache size, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_OpenSynchronous(t *testing.T) {
+	for _, wal := range []bool{false, true} {
+		for _, mode := range []SynchronousMode{SynchronousOff, SynchronousNormal, SynchronousFull} {
+			path := mustTempFile()
+			defer os.Remove(path)
+
+			cfg := NewConfig()
+			cfg.WAL = wal
+			cfg.Synchronous = mode
+			db, err := OpenWithConfig(path, cfg)
+			if err != nil {
+				t.Fatalf("failed to open database with synchronous mode %s: %s", mode, err.Error())
+			}
+			defer db.Close()
+
+			if exp, got := mode.String(), db.SynchronousMode(); exp != got {
+				t.Fatalf("wrong synchronous mode, exp %s, got %s", exp, got)
+			}
+
+			// Every pooled connection must have the mode set.
+			for _, pool := range []*sql.DB{db.roDB, db.rodDB} {
+				var conns []*sql.Conn
+				for i := 0; i < 3; i++ {
+					conn, err := pool.Conn(context.Background())
+					if err != nil {
+						t.Fatalf("failed to get read-only connection: %s", err.Error())
+					}
+					conns = append(conns, conn)
+					var n int
+					if err := conn.QueryRowContext(context.Background(), "PRAGMA synchronous").Scan(&n); err != nil {
+						t.Fatalf("failed to get synchronous mode: %s", err.Error())
+					}
+					if n != int(mode) {
+						t.Fatalf("want synchronous %d on read-only connection %d, got %d", mode, i, n)
+					}
+				}
+				for _, conn := range conns {
+					conn.Close()
+				}
+			}
+
+			if err := db.SetSynchronousMode(SynchronousExtra); err != nil {
+				t.Fatalf("failed to set synchronous mode: %s", err.Error())
+			}
+			if exp, got := "EXTRA", db.SynchronousMode(); exp != got {
+				t.Fatalf("wrong synchronous mode after set, exp %s, got %s", exp, got)
+			}
+		}
+	}
+}
+func Test_OpenInitPragmas(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.InitPragmas = []string{"foreign_keys=ON", "cache_size=-4000"}
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database with init PRAGMAs: %s", err.Error())
+	}
+	defer db.Close()
+
+	// Every connection must have the PRAGMAs applied.
+	for _, pool := range []*sql.DB{db.rwDB, db.roDB, db.rodDB} {
+		var fk, cacheSize int
+		if err := pool.QueryRow("PRAGMA foreign_keys").Scan(&fk); err != nil {
+			t.Fatalf("failed to get foreign_keys: %s", err.Error())
+		}
+		if err := pool.QueryRow("PRAGMA cache_size").Scan(&cacheSize); err != nil {
+			t.Fatalf("failed to get cache_size: %s", err.Error())
+		}
+		if fk != 1 || cacheSize != -4000 {
+			t.Fatalf("init PRAGMAs not applied, got foreign_keys=%d, cache_size=%d", fk, cacheSize)
+		}
+	}
+
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, "CREATE TABLE bar (fooid INTEGER NOT NULL PRIMARY KEY, FOREIGN KEY(fooid) REFERENCES foo(id))")
+	res, err := db.ExecuteStringStmt("INSERT INTO bar(fooid) VALUES(1)")
+	if err != nil {
+		t.Fatalf("failed to execute insertion: %s", err.Error())
+	}
+	if exp, got := `[{"error":"FOREIGN KEY constraint failed"}]`, asJSON(res); exp != got {
+		t.Fatalf("foreign key violation not enforced, exp %s, got %s", exp, got)
+	}
+
+	// A PRAGMA which fails must fail the open, and be identified.
+	badPath := mustTempFile()
+	defer os.Remove(badPath)
+	cfg.InitPragmas = []string{"foreign_keys=ON", "cache_size=)"}
+	_, err = OpenWithConfig(badPath, cfg)
+	if err == nil {
+		t.Fatalf("expected open with bad init PRAGMA to fail")
+	}
+	if !strings.Contains(err.Error(), `"cache_size=)"`) {
+		t.Fatalf("error does not identify bad PRAGMA: %s", err.Error())
+	}
+}
+
+// Test_HeapLimits tests that heap limits are applied. Heap limits are
+// process-wide, and SQLite only allows the hard limit to be lowered, so it
+// cannot be restored once set. The limits are therefore only set in a child
+// process running just this test, so that they cannot leak into any other
+// test. The test must not be made parallel, nor run with other tests in the
+// child process.
+func Test_HeapLimits(t *testing.T) {
+	if os.Getenv("RQLITE_TEST_HEAP_LIMITS") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^Test_HeapLimits$", "-test.count=1", "-test.v")
+		cmd.Env = append(os.Environ(), "RQLITE_TEST_HEAP_LIMITS=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("heap limits test failed in child process: %s\n%s", err.Error(), out)
+		}
+		if !strings.Contains(string(out), "--- PASS: Test_HeapLimits") {
+			t.Fatalf("heap limits test did not run in child process:\n%s", out)
+		}
+		return
+	}
+
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.SoftHeapLimit = 4 * 1024 * 1024
+	cfg.HardHeapLimit = 16 * 1024 * 1024
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database with heap limits: %s", err.Error())
+	}
+	defer db.Close()
+
+	ms, err := db.memStats()
+	if err != nil {
+		t.Fatalf("failed to get memory stats: %s", err.Error())
+	}
+	if exp, got := cfg.SoftHeapLimit, ms["soft_heap_limit"]; exp != got {
+		t.Fatalf("unexpected soft heap limit, exp %d, got %d", exp, got)
+	}
+	if exp, got := cfg.HardHeapLimit, ms["hard_heap_limit"]; exp != got {
+		t.Fatalf("unexpected hard heap limit, exp %d, got %d", exp, got)
+	}
+
+	// An allocation within the limit should succeed.
+	rows, err := db.QueryStringStmt("SELECT length(randomblob(1024*1024))")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["length(randomblob(1024*1024))"],"types":["integer"],"values":[[1048576]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+	}
+
+	// An allocation exceeding the hard limit should fail.
+	rows, err = db.QueryStringStmt("SELECT length(randomblob(64*1024*1024))")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if !strings.Contains(rows[0].Error, "out of memory") {
+		t.Fatalf("expected out of memory error, got %s", asJSON(rows))
+	}
+}
+
+func Test_RequestWithIntent(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	// counts returns the number of statements processed via the read-only
+	// and read-write connections respectively.
+	counts := func() (int64, int64) {
+		return stats.Get(numQueries).(*expvar.Int).Value(), stats.Get(numRequests).(*expvar.Int).Value()
+	}
+	req := func(stmts ...string) *command.Request {
+		r := &command.Request{}
+		for _, s := range stmts {
+			r.Statements = append(r.Statements, &command.Statement{Sql: s})
+		}
+		return r
+	}
+	selectRes := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`
+
+	for _, tc := range []struct {
+		name   string
+		req    *command.Request
+		intent Intent
+		expRO  bool
+		exp    string
+	}{
+		{
+			name:   "AutoRead",
+			req:    req("SELECT * FROM foo"),
+			intent: IntentAuto,
+			expRO:  true,
+			exp:    selectRes,
+		},
+		{
+			name:   "AutoWrite",
+			req:    req("SELECT * FROM foo", `INSERT INTO foo(id, name) VALUES(2, "declan")`),
+			intent: IntentAuto,
+			expRO:  false,
+			exp:    `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]},{"last_insert_id":2,"rows_affected":1}]`,
+		},
+		{
+			name:   "Read",
+			req:    req("SELECT * FROM foo WHERE id = 1"),
+			intent: IntentRead,
+			expRO:  true,
+			exp:    selectRes,
+		},
+		{
+			name:   "ReadRejectsWrite",
+			req:    req(`INSERT INTO foo(id, name) VALUES(3, "aoife")`),
+			intent: IntentRead,
+			expRO:  true,
+			exp:    `[{"error":"attempt to change database via query operation"}]`,
+		},
+		{
+			name:   "Write",
+			req:    req("SELECT * FROM foo WHERE id = 1"),
+			intent: IntentWrite,
+			expRO:  false,
+			exp:    selectRes,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			nQ, nR := counts()
+			res, err := db.RequestWithIntent(tc.req, false, tc.intent)
+			if err != nil {
+				t.Fatalf("failed to process request: %s", err.Error())
+			}
+			if got := asJSON(res); tc.exp != got {
+				t.Fatalf("unexpected results\nexp: %s\ngot: %s", tc.exp, got)
+			}
+			nQ2, nR2 := counts()
+			if tc.expRO && (nQ2 == nQ || nR2 != nR) {
+				t.Fatalf("request not routed to read-only connection")
+			}
+			if !tc.expRO && (nQ2 != nQ || nR2 == nR) {
+				t.Fatalf("request not routed to read-write connection")
+			}
+		})
+	}
+
+	// Confirm a row was not inserted via the read-only connection.
+	rows, err := db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_SlowQueryLogging(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.SlowQueryThreshold = 100 * time.Millisecond
+	cfg.SlowQueryLogSQL = true
+	cfg.SlowQueryRedactParams = true
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	var buf bytes.Buffer
+	db.slowLogger.logger = log.New(&buf, "", 0)
+	db.slowLogger.minInterval = 0
+
+	// A fast query should not be logged.
+	if _, err := db.QueryStringStmt("SELECT 1"); err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("fast query was logged: %s", buf.String())
+	}
+
+	// A slow query should be logged, with its parameters redacted.
+	slowSQL := `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x < ?) SELECT COUNT(*) FROM c`
+	req := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: slowSQL,
+				Parameters: []*command.Parameter{
+					{
+						Value: &command.Parameter_I{I: 2000000},
+					},
+				},
+			},
+		},
+	}
+	if _, err := db.Query(req, false); err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	line := buf.String()
+	if !strings.Contains(line, "slow statement took") {
+		t.Fatalf("slow query was not logged: %s", line)
+	}
+	if !strings.Contains(line, slowSQL) {
+		t.Fatalf("slow query log does not contain SQL: %s", line)
+	}
+	if strings.Contains(line, "2000000") || !strings.Contains(line, slowQueryRedacted) {
+		t.Fatalf("slow query log does not redact parameters: %s", line)
+	}
+
+	// Further slow queries within the rate-limit interval are not logged, but
+	// are counted in the next line logged.
+	buf.Reset()
+	db.slowLogger.minInterval = time.Hour
+	if _, err := db.Query(req, false); err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("rate-limited slow query was logged: %s", buf.String())
+	}
+	db.slowLogger.minInterval = 0
+	if _, err := db.Query(req, false); err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if !strings.Contains(buf.String(), "(1 more slow statements not logged)") {
+		t.Fatalf("slow query log does not report suppressed statements: %s", buf.String())
+	}
+}
+
 func test_FileCreationOnDisk(t *testing.T, db *DB) {
 	defer db.Close()
 	if db.FKEnabled() {
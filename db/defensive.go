@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+const (
+	// defensiveDriverName is the name of the SQLite driver used to open
+	// databases in defensive mode.
+	defensiveDriverName = "rqlite-sqlite3-defensive"
+)
+
+// defensivePragmas are the PRAGMAs which may not be set in defensive mode.
+// They can still be read.
+var defensivePragmas = map[string]bool{
+	"writable_schema":          true,
+	"schema_version":           true,
+	"trusted_schema":           true,
+	"ignore_check_constraints": true,
+	"legacy_alter_table":       true,
+	"locking_mode":             true,
+	"mmap_size":                true,
+	"temp_store_directory":     true,
+	"data_store_directory":     true,
+}
+
+// defensiveTables are the tables which may not be written to in defensive
+// mode. SQLite itself rejects direct writes to sqlite_schema unless
+// writable_schema is set, which is denied.
+var defensiveTables = map[string]bool{
+	"sqlite_dbpage": true,
+}
+
+func init() {
+	sql.Register(defensiveDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: makeDefensive,
+	})
+}
+
+// driverSeq ensures each driver registered by registerDriver has a unique
+// name, as drivers cannot be unregistered.
+var driverSeq atomic.Uint64
+
+// makeDefensive configures the given connection for defensive mode.
+func makeDefensive(c *sqlite3.SQLiteConn) error {
+	if _, err := c.Exec("PRAGMA trusted_schema=OFF", nil); err != nil {
+		return err
+	}
+	c.RegisterAuthorizer(defensiveAuthorizer)
+	return nil
+}
+
+// defensiveAuthorizer is the SQLite authorizer installed on every connection
+// opened in defensive mode. It denies any action which could be used to read
+// or write files other than the database, load code, or corrupt the schema.
+func defensiveAuthorizer(op int, arg1, arg2, arg3 string) int {
+	switch op {
+	case sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+		return sqlite3.SQLITE_DENY
+	case sqlite3.SQLITE_FUNCTION:
+		if strings.EqualFold(arg2, "load_extension") {
+			return sqlite3.SQLITE_DENY
+		}
+	case sqlite3.SQLITE_PRAGMA:
+		name := strings.ToLower(arg1)
+		if arg2 == "" {
+			return sqlite3.SQLITE_OK
+		}
+		if defensivePragmas[name] {
+			return sqlite3.SQLITE_DENY
+		}
+		if name == "journal_mode" {
+			if m := strings.ToLower(arg2); m == "off" || m == "memory" {
+				return sqlite3.SQLITE_DENY
+			}
+		}
+	case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE:
+		if defensiveTables[strings.ToLower(arg1)] {
+			return sqlite3.SQLITE_DENY
+		}
+	}
+	return sqlite3.SQLITE_OK
+}
+
+// driverName returns the name of the SQLite driver to use for the given
+// configuration. If exts or funcs is not nil, or a statement policy or init
+// PRAGMAs are configured, a driver which configures every new connection
+// accordingly is registered.
+func driverName(cfg *Config, exts *extensionSet, funcs *functionSet) string {
+	if exts != nil || funcs != nil || cfg.StatementPolicy != nil || len(cfg.InitPragmas) > 0 {
+		return registerDriver(cfg.Defensive, cfg.StatementPolicy, exts, funcs, cfg.InitPragmas)
+	}
+	if cfg.Defensive {
+		return defensiveDriverName
+	}
+	return "sqlite3"
+}
+
+// registerDriver registers a new SQLite driver which configures every new
+// connection for defensive mode, if defensive is true, installs an
+// authorizer enforcing policy, if it is not nil, loads the extensions in
+// exts, and registers the functions in funcs, if they are not nil, and
+// executes each of pragmas. It returns the name of the driver.
+func registerDriver(defensive bool, policy *StatementPolicy, exts *extensionSet, funcs *functionSet,
+	pragmas []string) string {
+	var auth func(int, string, string, string) int
+	switch {
+	case defensive && policy != nil:
+		auth = func(op int, arg1, arg2, arg3 string) int {
+			if rc := defensiveAuthorizer(op, arg1, arg2, arg3); rc != sqlite3.SQLITE_OK {
+				return rc
+			}
+			return policy.authorize(op, arg1, arg2, arg3)
+		}
+	case defensive:
+		auth = defensiveAuthorizer
+	case policy != nil:
+		auth = policy.authorize
+	}
+
+	name := fmt.Sprintf("rqlite-sqlite3-custom-%d", driverSeq.Add(1))
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(c *sqlite3.SQLiteConn) error {
+			if defensive {
+				if _, err := c.Exec("PRAGMA trusted_schema=OFF", nil); err != nil {
+					return err
+				}
+			}
+			for _, p := range pragmas {
+				if _, err := c.Exec("PRAGMA "+p, nil); err != nil {
+					return fmt.Errorf("init PRAGMA %q: %s", p, err.Error())
+				}
+			}
+			if auth != nil {
+				c.RegisterAuthorizer(auth)
+			}
+			if exts != nil {
+				if err := exts.loadInto(c); err != nil {
+					return err
+				}
+			}
+			if funcs != nil {
+				return funcs.registerWith(c)
+			}
+			return nil
+		},
+	})
+	return name
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Defensive(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.Defensive = true
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database in defensive mode: %s", err.Error())
+	}
+	defer db.Close()
+
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	r, err := db.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	attachPath := mustTempFile()
+	defer os.Remove(attachPath)
+	for _, stmt := range []string{
+		fmt.Sprintf("ATTACH %s AS other", quoteString(attachPath)),
+		"SELECT load_extension('/tmp/evil.so')",
+		"PRAGMA writable_schema=ON",
+		"PRAGMA journal_mode=OFF",
+	} {
+		r, err := db.ExecuteStringStmt(stmt)
+		if err == nil && r[0].GetError() == "" {
+			t.Fatalf("expected error for %q in defensive mode", stmt)
+		}
+		msg := r[0].GetError()
+		if err != nil {
+			msg = err.Error()
+		}
+		if !strings.Contains(msg, "not authorized") {
+			t.Fatalf("expected authorization error for %q, got %s", stmt, msg)
+		}
+	}
+
+	// SQLite rejects direct writes to the schema, since it cannot be made writable.
+	er, err := db.ExecuteStringStmt("UPDATE sqlite_schema SET sql = 'CREATE TABLE foo (x)' WHERE name = 'foo'")
+	if err == nil && er[0].GetError() == "" {
+		t.Fatalf("expected error writing to sqlite_schema in defensive mode")
+	}
+
+	// Extension loading must also be rejected on the read-only connections.
+	r, err = db.QueryStringStmt("SELECT load_extension('/tmp/evil.so')")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if !strings.Contains(r[0].GetError(), "not authorized") {
+		t.Fatalf("expected authorization error for load_extension via query, got %s", asJSON(r))
+	}
+
+	// Reading restricted PRAGMAs is still allowed.
+	r, err = db.QueryStringStmt("PRAGMA writable_schema")
+	if err != nil {
+		t.Fatalf("failed to read PRAGMA: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["writable_schema"],"types":["integer"],"values":[[0]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for PRAGMA\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// ATTACH must still work outside defensive mode.
+	db2, path2 := mustCreateOnDiskDatabaseWAL()
+	defer db2.Close()
+	defer os.Remove(path2)
+	er, err = db2.ExecuteStringStmt(fmt.Sprintf("ATTACH %s AS other", quoteString(attachPath)))
+	if err != nil || er[0].GetError() != "" {
+		t.Fatalf("failed to ATTACH outside defensive mode: %v %s", err, asJSON(er))
+	}
+}
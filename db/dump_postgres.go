@@ -0,0 +1,380 @@
+package db
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// DumpDialect is the SQL dialect of a dump.
+type DumpDialect int
+
+const (
+	// DumpDialectSQLite is the SQLite dialect, as written by Dump.
+	DumpDialectSQLite DumpDialect = iota
+	// DumpDialectPostgres is the PostgreSQL dialect.
+	DumpDialectPostgres
+)
+
+var pgSizedTypeRe = regexp.MustCompile(`^(varchar|character varying|char|character|numeric|decimal)\s*\(\s*\d+(\s*,\s*\d+)?\s*\)$`)
+
+// DumpWithDialect writes a consistent snapshot of the database to w as SQL
+// text in the given dialect. DumpDialectSQLite produces the same output as
+// Dump.
+//
+// The PostgreSQL dialect is intended to ease migration of data to PostgreSQL,
+// and handles common schemas, but the translation is not perfect. Tables are
+// recreated from their column definitions, rather than from the original
+// CREATE TABLE statements, and column types are mapped using SQLite's type
+// affinity rules: integers become BIGINT, floating point numbers DOUBLE
+// PRECISION, text TEXT, and BLOBs BYTEA, while BOOLEAN, DATE, TIMESTAMP, JSON,
+// and sized VARCHAR and NUMERIC types are preserved. A single INTEGER PRIMARY
+// KEY column becomes an identity column, and its sequence is advanced past the
+// existing rows. Primary keys, NOT NULL, simple defaults, UNIQUE constraints,
+// indexes, and foreign keys are carried over, and identifiers are quoted with
+// double quotes. CHECK constraints, collations, partial and expression indexes,
+// views, and triggers are not translated. Views and triggers are listed in
+// comments at the end of the dump.
+func (db *DB) DumpWithDialect(w io.Writer, dialect DumpDialect) error {
+	switch dialect {
+	case DumpDialectSQLite:
+		return db.Dump(w)
+	case DumpDialectPostgres:
+		return db.dumpPostgres(w)
+	default:
+		return fmt.Errorf("unknown dump dialect %d", dialect)
+	}
+}
+
+type pgColumn struct {
+	name     string
+	declType string
+	pgType   string
+	notNull  bool
+	dflt     *string
+	pk       int
+	identity bool
+}
+
+func (db *DB) dumpPostgres(w io.Writer) error {
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	query := func(q string) (*command.QueryRows, error) {
+		rows, err := snap.QueryStringStmt(q)
+		if err != nil {
+			return nil, err
+		}
+		if rows[0].Error != "" {
+			return nil, fmt.Errorf("%s: %s", q, rows[0].Error)
+		}
+		return rows[0], nil
+	}
+
+	if _, err := io.WriteString(w, "BEGIN;\n"); err != nil {
+		return err
+	}
+
+	tables, err := query(`SELECT "name" FROM "sqlite_master"
+		WHERE "type" = 'table' AND "sql" NOT NULL AND "name" NOT LIKE 'sqlite_%' ORDER BY "name"`)
+	if err != nil {
+		return err
+	}
+	var post []string
+	for _, v := range tables.Values {
+		table := v.Parameters[0].GetS()
+		tablePost, err := dumpPostgresTable(w, table, query)
+		if err != nil {
+			return fmt.Errorf("table %s: %s", table, err.Error())
+		}
+		post = append(post, tablePost...)
+	}
+	for _, s := range post {
+		if _, err := io.WriteString(w, s+";\n"); err != nil {
+			return err
+		}
+	}
+
+	others, err := query(`SELECT "type", "name" FROM "sqlite_master"
+		WHERE "sql" NOT NULL AND "type" IN ('view', 'trigger') ORDER BY "type", "name"`)
+	if err != nil {
+		return err
+	}
+	for _, v := range others.Values {
+		if _, err := fmt.Fprintf(w, "-- %s %s not translated\n",
+			v.Parameters[0].GetS(), quoteIdent(v.Parameters[1].GetS())); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "COMMIT;\n")
+	return err
+}
+
+// dumpPostgresTable writes the DDL and data for the given table, and returns
+// the statements which must run once all tables have been loaded.
+func dumpPostgresTable(w io.Writer, table string, query func(string) (*command.QueryRows, error)) ([]string, error) {
+	qTable := quoteIdent(table)
+	info, err := query(fmt.Sprintf("PRAGMA table_info(%s)", qTable))
+	if err != nil {
+		return nil, err
+	}
+	var cols []*pgColumn
+	var pkCols []*pgColumn
+	for _, v := range info.Values {
+		p := v.Parameters
+		c := &pgColumn{
+			name:     p[1].GetS(),
+			declType: strings.ToLower(strings.TrimSpace(p[2].GetS())),
+			notNull:  p[3].GetI() != 0,
+			pk:       int(p[5].GetI()),
+		}
+		if p[4] != nil && p[4].GetValue() != nil {
+			d := p[4].GetS()
+			c.dflt = &d
+		}
+		c.pgType = pgTypeFromDecl(c.declType)
+		cols = append(cols, c)
+		if c.pk > 0 {
+			pkCols = append(pkCols, c)
+		}
+	}
+	if len(pkCols) == 1 && pkCols[0].declType == "integer" {
+		pkCols[0].identity = true
+	}
+	for i := 1; i < len(pkCols); i++ {
+		for j := i; j > 0 && pkCols[j].pk < pkCols[j-1].pk; j-- {
+			pkCols[j], pkCols[j-1] = pkCols[j-1], pkCols[j]
+		}
+	}
+
+	var defs []string
+	for _, c := range cols {
+		def := quoteIdent(c.name) + " " + c.pgType
+		if c.identity {
+			def += " GENERATED BY DEFAULT AS IDENTITY"
+		}
+		if c.notNull || c.pk > 0 {
+			def += " NOT NULL"
+		}
+		if c.dflt != nil && !c.identity {
+			if d, ok := pgDefault(*c.dflt, c.pgType); ok {
+				def += " DEFAULT " + d
+			}
+		}
+		defs = append(defs, def)
+	}
+	if len(pkCols) > 0 {
+		defs = append(defs, "PRIMARY KEY ("+pgIdentList(pkCols)+")")
+	}
+
+	var post []string
+	indexes, err := query(fmt.Sprintf("PRAGMA index_list(%s)", qTable))
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range indexes.Values {
+		p := v.Parameters
+		name, unique, origin, partial := p[1].GetS(), p[2].GetI() != 0, p[3].GetS(), p[4].GetI() != 0
+		if origin == "pk" {
+			continue
+		}
+		if partial {
+			post = append(post, fmt.Sprintf("-- partial index %s not translated", quoteIdent(name)))
+			continue
+		}
+		ii, err := query(fmt.Sprintf("PRAGMA index_info(%s)", quoteIdent(name)))
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, iv := range ii.Values {
+			if iv.Parameters[2] == nil || iv.Parameters[2].GetValue() == nil {
+				names = nil
+				break
+			}
+			names = append(names, quoteIdent(iv.Parameters[2].GetS()))
+		}
+		if names == nil {
+			post = append(post, fmt.Sprintf("-- expression index %s not translated", quoteIdent(name)))
+			continue
+		}
+		if origin == "u" {
+			defs = append(defs, "UNIQUE ("+strings.Join(names, ", ")+")")
+			continue
+		}
+		stmt := "CREATE INDEX "
+		if unique {
+			stmt = "CREATE UNIQUE INDEX "
+		}
+		post = append(post, stmt+quoteIdent(name)+" ON "+qTable+" ("+strings.Join(names, ", ")+")")
+	}
+
+	fks, err := query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", qTable))
+	if err != nil {
+		return nil, err
+	}
+	type fk struct {
+		table, onUpdate, onDelete string
+		from, to                  []string
+	}
+	var fkList []*fk
+	fkByID := make(map[int64]*fk)
+	for _, v := range fks.Values {
+		p := v.Parameters
+		f, ok := fkByID[p[0].GetI()]
+		if !ok {
+			f = &fk{table: p[2].GetS(), onUpdate: p[5].GetS(), onDelete: p[6].GetS()}
+			fkByID[p[0].GetI()] = f
+			fkList = append(fkList, f)
+		}
+		f.from = append(f.from, quoteIdent(p[3].GetS()))
+		if p[4] != nil && p[4].GetValue() != nil {
+			f.to = append(f.to, quoteIdent(p[4].GetS()))
+		}
+	}
+	for _, f := range fkList {
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD FOREIGN KEY (%s) REFERENCES %s",
+			qTable, strings.Join(f.from, ", "), quoteIdent(f.table))
+		if len(f.to) == len(f.from) {
+			stmt += " (" + strings.Join(f.to, ", ") + ")"
+		}
+		for _, a := range []struct{ on, action string }{{"UPDATE", f.onUpdate}, {"DELETE", f.onDelete}} {
+			if a.action != "" && a.action != "NO ACTION" {
+				stmt += " ON " + a.on + " " + a.action
+			}
+		}
+		post = append(post, stmt)
+	}
+
+	if _, err := fmt.Fprintf(w, "CREATE TABLE %s (\n  %s\n);\n", qTable, strings.Join(defs, ",\n  ")); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = quoteIdent(c.name)
+	}
+	rows, err := query(fmt.Sprintf("SELECT %s FROM %s", strings.Join(names, ", "), qTable))
+	if err != nil {
+		return nil, err
+	}
+	colList := strings.Join(names, ", ")
+	for _, v := range rows.Values {
+		vals := make([]string, len(cols))
+		for i, p := range v.Parameters {
+			vals[i] = pgLiteral(p, cols[i].pgType)
+		}
+		if _, err := fmt.Fprintf(w, "INSERT INTO %s (%s) VALUES (%s);\n", qTable, colList, strings.Join(vals, ", ")); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range cols {
+		if c.identity {
+			if _, err := fmt.Fprintf(w, "SELECT setval(pg_get_serial_sequence(%s, %s), COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false);\n",
+				quoteString(qTable), quoteString(c.name), quoteIdent(c.name), qTable); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return post, nil
+}
+
+// pgTypeFromDecl returns the PostgreSQL type for the given lower-cased,
+// declared SQLite column type.
+func pgTypeFromDecl(t string) string {
+	switch {
+	case t == "":
+		return "TEXT"
+	case t == "bool" || t == "boolean":
+		return "BOOLEAN"
+	case t == "date":
+		return "DATE"
+	case t == "datetime" || t == "timestamp":
+		return "TIMESTAMP"
+	case t == "json" || t == "jsonb":
+		return strings.ToUpper(t)
+	case pgSizedTypeRe.MatchString(t):
+		return strings.ToUpper(t)
+	case strings.Contains(t, "int"):
+		return "BIGINT"
+	case strings.Contains(t, "char") || strings.Contains(t, "clob") || strings.Contains(t, "text"):
+		return "TEXT"
+	case strings.Contains(t, "blob"):
+		return "BYTEA"
+	case strings.Contains(t, "real") || strings.Contains(t, "floa") || strings.Contains(t, "doub"):
+		return "DOUBLE PRECISION"
+	default:
+		return "NUMERIC"
+	}
+}
+
+// pgDefault translates a SQLite column default to PostgreSQL. It returns false
+// if the default cannot be translated.
+func pgDefault(d, pgType string) (string, bool) {
+	u := strings.ToUpper(d)
+	switch {
+	case u == "NULL" || u == "CURRENT_TIMESTAMP" || u == "CURRENT_DATE" || u == "CURRENT_TIME":
+		return u, true
+	case pgType == "BOOLEAN" && (u == "0" || u == "FALSE" || u == "'0'"):
+		return "FALSE", true
+	case pgType == "BOOLEAN" && (u == "1" || u == "TRUE" || u == "'1'"):
+		return "TRUE", true
+	case len(d) >= 2 && d[0] == '\'' && d[len(d)-1] == '\'':
+		return d, true
+	case len(d) >= 2 && d[0] == '"' && d[len(d)-1] == '"':
+		return quoteString(strings.ReplaceAll(d[1:len(d)-1], `""`, `"`)), true
+	}
+	if _, err := strconv.ParseFloat(d, 64); err == nil {
+		return d, true
+	}
+	return "", false
+}
+
+// pgLiteral returns the PostgreSQL literal for the given value, stored in a
+// column of the given PostgreSQL type.
+func pgLiteral(p *command.Parameter, pgType string) string {
+	switch v := p.GetValue().(type) {
+	case *command.Parameter_I:
+		if pgType == "BOOLEAN" {
+			return strings.ToUpper(strconv.FormatBool(v.I != 0))
+		}
+		return strconv.FormatInt(v.I, 10)
+	case *command.Parameter_D:
+		switch {
+		case math.IsNaN(v.D):
+			return "'NaN'"
+		case math.IsInf(v.D, 1):
+			return "'Infinity'"
+		case math.IsInf(v.D, -1):
+			return "'-Infinity'"
+		}
+		return strconv.FormatFloat(v.D, 'g', -1, 64)
+	case *command.Parameter_B:
+		return strings.ToUpper(strconv.FormatBool(v.B))
+	case *command.Parameter_Y:
+		return `'\x` + hex.EncodeToString(v.Y) + `'`
+	case *command.Parameter_S:
+		return quoteString(v.S)
+	default:
+		return "NULL"
+	}
+}
+
+func pgIdentList(cols []*pgColumn) string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = quoteIdent(c.name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func Test_DumpWithDialect_Postgres(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	mustExecute(db, `CREATE TABLE authors (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(64) NOT NULL UNIQUE, rating REAL, active BOOLEAN DEFAULT 1, created DATETIME DEFAULT CURRENT_TIMESTAMP)`)
+	mustExecute(db, `CREATE TABLE "book ""list""" (author_id INT NOT NULL REFERENCES authors(id) ON DELETE CASCADE, isbn TEXT DEFAULT 'none', cover BLOB, price DECIMAL(10,2), PRIMARY KEY (isbn, author_id))`)
+	mustExecute(db, `CREATE INDEX authors_rating ON authors(rating)`)
+	mustExecute(db, `CREATE VIEW active_authors AS SELECT * FROM authors WHERE active`)
+	mustExecute(db, `INSERT INTO authors(id, name, rating, active, created) VALUES(1, 'O''Brien', 4.5, 0, '2024-01-02 03:04:05')`)
+	mustExecute(db, `INSERT INTO authors(id, name, rating, active, created) VALUES(7, 'Joyce', NULL, 1, NULL)`)
+	mustExecute(db, `INSERT INTO "book ""list"""(author_id, isbn, cover, price) VALUES(7, '978-0', x'00ff', 9.99)`)
+
+	var buf bytes.Buffer
+	if err := db.DumpWithDialect(&buf, DumpDialectPostgres); err != nil {
+		t.Fatalf("failed to dump: %s", err.Error())
+	}
+	exp := `BEGIN;
+CREATE TABLE "authors" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY NOT NULL,
+  "name" VARCHAR(64) NOT NULL,
+  "rating" DOUBLE PRECISION,
+  "active" BOOLEAN DEFAULT TRUE,
+  "created" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("id"),
+  UNIQUE ("name")
+);
+INSERT INTO "authors" ("id", "name", "rating", "active", "created") VALUES (1, 'O''Brien', 4.5, FALSE, '2024-01-02T03:04:05Z');
+INSERT INTO "authors" ("id", "name", "rating", "active", "created") VALUES (7, 'Joyce', NULL, TRUE, NULL);
+SELECT setval(pg_get_serial_sequence('"authors"', 'id'), COALESCE((SELECT MAX("id") FROM "authors"), 0) + 1, false);
+CREATE TABLE "book ""list""" (
+  "author_id" BIGINT NOT NULL,
+  "isbn" TEXT NOT NULL DEFAULT 'none',
+  "cover" BYTEA,
+  "price" DECIMAL(10,2),
+  PRIMARY KEY ("isbn", "author_id")
+);
+INSERT INTO "book ""list""" ("author_id", "isbn", "cover", "price") VALUES (7, '978-0', '\x00ff', 9.99);
+CREATE INDEX "authors_rating" ON "authors" ("rating");
+ALTER TABLE "book ""list""" ADD FOREIGN KEY ("author_id") REFERENCES "authors" ("id") ON DELETE CASCADE;
+-- view "active_authors" not translated
+COMMIT;
+`
+	if got := buf.String(); exp != got {
+		t.Fatalf("wrong Postgres dump\nexp:\n%s\ngot:\n%s", exp, got)
+	}
+
+	// The SQLite dialect is the same as Dump.
+	var dBuf, sBuf bytes.Buffer
+	if err := db.Dump(&dBuf); err != nil {
+		t.Fatalf("failed to dump: %s", err.Error())
+	}
+	if err := db.DumpWithDialect(&sBuf, DumpDialectSQLite); err != nil {
+		t.Fatalf("failed to dump: %s", err.Error())
+	}
+	if dBuf.String() != sBuf.String() {
+		t.Fatalf("SQLite dialect differs from Dump")
+	}
+}
+
+func Test_PgTypeFromDecl(t *testing.T) {
+	for decl, exp := range map[string]string{
+		"":                 "TEXT",
+		"integer":          "BIGINT",
+		"unsigned big int": "BIGINT",
+		"varchar(255)":     "VARCHAR(255)",
+		"nvarchar(10)":     "TEXT",
+		"clob":             "TEXT",
+		"blob":             "BYTEA",
+		"double":           "DOUBLE PRECISION",
+		"float":            "DOUBLE PRECISION",
+		"boolean":          "BOOLEAN",
+		"date":             "DATE",
+		"timestamp":        "TIMESTAMP",
+		"json":             "JSON",
+		"numeric(10, 2)":   "NUMERIC(10, 2)",
+		"decimal":          "NUMERIC",
+	} {
+		if got := pgTypeFromDecl(decl); exp != got {
+			t.Fatalf("wrong type for %q, exp %s, got %s", decl, exp, got)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// ErrExtensionsDisabled is returned by LoadExtension if the database was not
+// opened with ExtensionsEnabled set.
+var ErrExtensionsDisabled = errors.New("extension loading not enabled")
+
+type extension struct {
+	path  string
+	entry string
+}
+
+// extensionSet is the set of extensions loaded into every connection to a
+// database.
+type extensionSet struct {
+	mu   sync.Mutex
+	exts []extension
+}
+
+// add adds an extension to the set.
+func (e *extensionSet) add(ext extension) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.exts = append(e.exts, ext)
+}
+
+// loadInto loads every extension in the set into the given connection.
+func (e *extensionSet) loadInto(c *sqlite3.SQLiteConn) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ext := range e.exts {
+		if err := c.LoadExtension(ext.path, ext.entry); err != nil {
+			return fmt.Errorf("load extension %s: %s", ext.path, err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadExtension loads the SQLite extension at path into every connection to
+// the database, including connections opened after this call. entrypoint is
+// the name of the extension's initialization function. If it is empty it is
+// derived from the file name, as SQLite does, so the entry point for
+// /path/to/libhalf.so is sqlite3_half_init. ErrExtensionsDisabled is returned
+// if the database was not opened with ExtensionsEnabled set.
+//
+// The extension is loaded immediately into the connection used for writes,
+// so an extension which cannot be loaded is reported by this call. Idle
+// read-only connections are closed, so that the extension is loaded when
+// they are reopened.
+func (db *DB) LoadExtension(path, entrypoint string) error {
+	if db.exts == nil {
+		return ErrExtensionsDisabled
+	}
+	if entrypoint == "" {
+		entrypoint = defaultExtensionEntrypoint(path)
+	}
+
+	var loadErr error
+	if err := db.withRawRWConn(func(c *sqlite3.SQLiteConn) {
+		loadErr = c.LoadExtension(path, entrypoint)
+	}); err != nil {
+		return err
+	}
+	if loadErr != nil {
+		return fmt.Errorf("load extension %s: %s", path, loadErr.Error())
+	}
+	db.exts.add(extension{path: path, entry: entrypoint})
+
+	// Close idle connections, so they load the extension when reopened.
+	for _, d := range []*sql.DB{db.roDB, db.rodDB, db.chkDB} {
+		if d == nil {
+			continue
+		}
+		d.SetMaxIdleConns(0)
+		d.SetMaxIdleConns(2)
+	}
+	return nil
+}
+
+// defaultExtensionEntrypoint returns the entry point SQLite uses for the
+// extension at path when none is given. It is "sqlite3_", followed by the
+// lower-cased letters of the file name up to the first ".", with any leading
+// "lib" removed, followed by "_init".
+func defaultExtensionEntrypoint(path string) string {
+	base := filepath.Base(path)
+	base = strings.TrimPrefix(base, "lib")
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	var b strings.Builder
+	for _, r := range base {
+		if unicode.IsLetter(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return "sqlite3_" + b.String() + "_init"
+}
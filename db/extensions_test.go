@@ -0,0 +1,119 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const halfExtensionSource = `
+#include "sqlite3ext.h"
+SQLITE_EXTENSION_INIT1
+
+static void half(sqlite3_context *ctx, int argc, sqlite3_value **argv) {
+	sqlite3_result_double(ctx, 0.5*sqlite3_value_double(argv[0]));
+}
+
+int sqlite3_half_init(sqlite3 *db, char **pzErrMsg, const sqlite3_api_routines *pApi) {
+	SQLITE_EXTENSION_INIT2(pApi);
+	return sqlite3_create_function(db, "half", 1, SQLITE_UTF8|SQLITE_DETERMINISTIC, 0, half, 0, 0);
+}
+`
+
+func Test_LoadExtension(t *testing.T) {
+	extPath := mustBuildHalfExtension(t)
+
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.ExtensionsEnabled = true
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+
+	// Open a read-only connection before loading, so that the extension must
+	// be loaded into a pooled connection.
+	r, err := db.QueryStringStmt("SELECT half(3)")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if !strings.Contains(r[0].GetError(), "no such function") {
+		t.Fatalf("expected no such function error, got %s", asJSON(r))
+	}
+
+	if err := db.LoadExtension(extPath, ""); err != nil {
+		t.Fatalf("failed to load extension: %s", err.Error())
+	}
+	r, err = db.QueryStringStmt("SELECT half(3)")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["half(3)"],"types":["real"],"values":[[1.5]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, val REAL)")
+	mustExecute(db, "INSERT INTO foo(id, val) VALUES(1, half(5))")
+	r, err = db.QueryStringStmt("SELECT val FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["val"],"types":["real"],"values":[[2.5]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	if err := db.LoadExtension(filepath.Join(t.TempDir(), "nonexistent.so"), ""); err == nil {
+		t.Fatalf("expected error loading nonexistent extension")
+	}
+}
+
+func Test_LoadExtension_Disabled(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	if err := db.LoadExtension("/tmp/half.so", ""); !errors.Is(err, ErrExtensionsDisabled) {
+		t.Fatalf("expected ErrExtensionsDisabled, got %v", err)
+	}
+}
+
+func Test_DefaultExtensionEntrypoint(t *testing.T) {
+	for path, exp := range map[string]string{
+		"/path/to/libhalf.so":      "sqlite3_half_init",
+		"half.so":                  "sqlite3_half_init",
+		"/path/to/Spatia_Lite.dll": "sqlite3_spatialite_init",
+		"/path/to/crypto.1.dylib":  "sqlite3_crypto_init",
+	} {
+		if got := defaultExtensionEntrypoint(path); exp != got {
+			t.Fatalf("wrong entry point for %s, exp %s, got %s", path, exp, got)
+		}
+	}
+}
+
+// mustBuildHalfExtension compiles a SQLite extension providing the function
+// half(), and returns the path to the shared library. The test is skipped if
+// the extension cannot be built.
+func mustBuildHalfExtension(t *testing.T) string {
+	t.Helper()
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "github.com/rqlite/go-sqlite3").Output()
+	if err != nil {
+		t.Skipf("failed to locate SQLite headers: %s", err.Error())
+	}
+	incDir := strings.TrimSpace(string(out))
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "half.c")
+	if err := os.WriteFile(srcPath, []byte(halfExtensionSource), 0644); err != nil {
+		t.Fatalf("failed to write extension source: %s", err.Error())
+	}
+	extPath := filepath.Join(dir, "libhalf.so")
+	cmd := exec.Command("cc", "-shared", "-fPIC", "-I", incDir, "-o", extPath, srcPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("failed to build extension: %s: %s", err.Error(), out)
+	}
+	return extPath
+}
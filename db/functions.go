@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// ErrFunctionsDisabled is returned by RegisterFunc if the database was not
+// opened with FunctionsEnabled set.
+var ErrFunctionsDisabled = errors.New("function registration not enabled")
+
+type function struct {
+	name string
+	impl interface{}
+	pure bool
+}
+
+// functionSet is the set of functions registered with every connection to a
+// database.
+type functionSet struct {
+	mu    sync.Mutex
+	funcs []function
+}
+
+// add adds a function to the set, replacing any function with the same name.
+func (f *functionSet) add(fn function) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.funcs {
+		if f.funcs[i].name == fn.name {
+			f.funcs[i] = fn
+			return
+		}
+	}
+	f.funcs = append(f.funcs, fn)
+}
+
+// registerWith registers every function in the set with the given
+// connection.
+func (f *functionSet) registerWith(c *sqlite3.SQLiteConn) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, fn := range f.funcs {
+		if err := c.RegisterFunc(fn.name, fn.impl, fn.pure); err != nil {
+			return fmt.Errorf("register function %s: %s", fn.name, err.Error())
+		}
+	}
+	return nil
+}
+
+// RegisterFunc registers the Go function fn as the SQL scalar function name,
+// with every connection to the database, including connections opened after
+// this call. fn must be a function whose arguments and results are of types
+// supported by the SQLite driver, optionally returning an error as its last
+// result. If pure is true, fn must always return the same result given the
+// same arguments, which allows SQLite to optimize calls to it, and to use it
+// in indexes. ErrFunctionsDisabled is returned if the database was not
+// opened with FunctionsEnabled set.
+//
+// The function is registered immediately with the connection used for writes,
+// so a function which cannot be registered is reported by this call. Idle
+// read-only connections are closed, so that the function is registered when
+// they are reopened. Read-only connections in use during the call do not see
+// the function until they are reopened, so functions must be registered
+// before any query uses them.
+func (db *DB) RegisterFunc(name string, fn interface{}, pure bool) error {
+	if db.funcs == nil {
+		return ErrFunctionsDisabled
+	}
+
+	var regErr error
+	if err := db.withRawRWConn(func(c *sqlite3.SQLiteConn) {
+		regErr = c.RegisterFunc(name, fn, pure)
+	}); err != nil {
+		return err
+	}
+	if regErr != nil {
+		return fmt.Errorf("register function %s: %s", name, regErr.Error())
+	}
+	db.funcs.add(function{name: name, impl: fn, pure: pure})
+
+	// Close idle connections, so they register the function when reopened.
+	for _, d := range []*sql.DB{db.roDB, db.rodDB, db.chkDB} {
+		if d == nil {
+			continue
+		}
+		d.SetMaxIdleConns(0)
+		d.SetMaxIdleConns(2)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_RegisterFunc(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.FunctionsEnabled = true
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+
+	// Open a read-only connection before registering, so that the function
+	// must be registered with a pooled connection.
+	r, err := db.QueryStringStmt("SELECT my_upper('fiona')")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if !strings.Contains(r[0].GetError(), "no such function") {
+		t.Fatalf("expected no such function error, got %s", asJSON(r))
+	}
+
+	if err := db.RegisterFunc("my_upper", strings.ToUpper, true); err != nil {
+		t.Fatalf("failed to register function: %s", err.Error())
+	}
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, my_upper("declan"))`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(2, "fiona")`)
+	r, err = db.QueryStringStmt("SELECT name, my_upper(name) FROM foo ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name","my_upper(name)"],"types":["text","text"],"values":[["DECLAN","DECLAN"],["fiona","FIONA"]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// A function which cannot be registered is reported.
+	if err := db.RegisterFunc("bad", 42, true); err == nil {
+		t.Fatalf("expected error registering non-function")
+	}
+}
+
+func Test_RegisterFunc_Disabled(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	if err := db.RegisterFunc("my_upper", strings.ToUpper, true); err != ErrFunctionsDisabled {
+		t.Fatalf("expected ErrFunctionsDisabled, got %v", err)
+	}
+}
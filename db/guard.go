@@ -0,0 +1,111 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// closeWaitTimeout is how long Close waits for operations in flight to
+// finish.
+const closeWaitTimeout = 5 * time.Second
+
+var (
+	// ErrClosed is returned by operations started on a database which is
+	// closed, or closing.
+	ErrClosed = errors.New("database closed")
+
+	// ErrBusy is returned by Close when operations in flight did not finish
+	// in time. The database is left open.
+	ErrBusy = errors.New("database busy, operations in flight")
+)
+
+// opGuard counts the operations in flight on a database, so that Close can
+// wait for them to finish before the connections they use are closed.
+type opGuard struct {
+	mu        sync.Mutex
+	closed    bool
+	n         int
+	drained   chan struct{} // Closed when n drops to zero, while closing.
+	closingCh chan struct{} // Closed when closing starts.
+}
+
+// enter records the start of an operation. ErrClosed is returned, and the
+// operation must not start, if the database is closed or closing. Otherwise
+// exit must be called when the operation finishes.
+func (g *opGuard) enter() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosed
+	}
+	g.n++
+	return nil
+}
+
+// exit records the end of an operation.
+func (g *opGuard) exit() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.n--
+	if g.n == 0 && g.drained != nil {
+		close(g.drained)
+		g.drained = nil
+	}
+}
+
+// closing returns a channel which is closed once the guard starts closing,
+// so that an operation in flight which is waiting, rather than running, can
+// give up instead of holding up the close.
+func (g *opGuard) closing() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closingCh == nil {
+		g.closingCh = make(chan struct{})
+		if g.closed {
+			close(g.closingCh)
+		}
+	}
+	return g.closingCh
+}
+
+// close stops new operations from starting, and waits up to timeout for
+// those in flight to finish. If they do not, new operations are allowed
+// again, and ErrBusy is returned. closed is true if the guard was already
+// closed, in which case close returns immediately.
+func (g *opGuard) close(timeout time.Duration) (closed bool, err error) {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return true, nil
+	}
+	g.closed = true
+	if g.closingCh != nil {
+		close(g.closingCh)
+	}
+	if g.n == 0 {
+		g.mu.Unlock()
+		return false, nil
+	}
+	drained := make(chan struct{})
+	g.drained = drained
+	g.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-drained:
+		return false, nil
+	case <-t.C:
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.n == 0 {
+		return false, nil
+	}
+	g.closed = false
+	g.drained = nil
+	g.closingCh = nil
+	return false, ErrBusy
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_CloseWaitsForQueries(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- db.QueryStream("SELECT name FROM foo", func(row []interface{}) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	closeErr := make(chan error, 1)
+	go func() {
+		closeErr <- db.Close()
+	}()
+	select {
+	case err := <-closeErr:
+		t.Fatalf("Close returned while a query was in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// New operations fail fast while the database is closing.
+	if _, err := db.QueryStringStmt("SELECT * FROM foo"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed querying a closing database, got %v", err)
+	}
+	if _, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("declan")`); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed executing on a closing database, got %v", err)
+	}
+
+	close(release)
+	if err := <-streamErr; err != nil {
+		t.Fatalf("in-flight query failed: %s", err.Error())
+	}
+	if err := <-closeErr; err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+
+	if _, err := db.QueryStringStmt("SELECT * FROM foo"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed querying a closed database, got %v", err)
+	}
+	if err := db.QueryStream("SELECT * FROM foo", func(row []interface{}) error { return nil }); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed streaming from a closed database, got %v", err)
+	}
+	if _, err := db.Begin(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed beginning a transaction on a closed database, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("expected closing a closed database to succeed, got %s", err.Error())
+	}
+}
+
+func Test_CloseBusy(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- db.QueryStream("SELECT name FROM foo", func(row []interface{}) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	if err := db.CloseWithTimeout(100 * time.Millisecond); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected ErrBusy closing with a query in flight, got %v", err)
+	}
+
+	// The database is left open, and usable.
+	rows, err := db.QueryStringStmt("SELECT name FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query database after failed close: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["fiona"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results, exp %s, got %s", exp, got)
+	}
+
+	close(release)
+	if err := <-streamErr; err != nil {
+		t.Fatalf("in-flight query failed: %s", err.Error())
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+}
+
+func Test_CloseWithOpenTx(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+
+	// An open Tx or SnapshotTx does not delay Close.
+	if err := db.CloseWithTimeout(time.Second); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+	if _, err := tx.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed executing in a transaction after close, got %v", err)
+	}
+	if _, err := snap.QueryStringStmt("SELECT * FROM foo"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed querying a snapshot after close, got %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back transaction after close: %s", err.Error())
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatalf("failed to close snapshot reader after close: %s", err.Error())
+	}
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// Operations passed to an update hook.
+const (
+	UpdateHookInsert = sqlite3.SQLITE_INSERT
+	UpdateHookUpdate = sqlite3.SQLITE_UPDATE
+	UpdateHookDelete = sqlite3.SQLITE_DELETE
+)
+
+// hookSet holds the hooks registered on the read-write connection. SQLite
+// allows only one hook of each type per connection, so a single dispatcher
+// of each type is registered, which calls the hooks set by the user and
+// notifies any active sessions.
+type hookSet struct {
+	mu         sync.Mutex
+	registered bool
+	update     func(op int, db, table string, rowid int64)
+	commit     func() bool
+	rollback   func()
+	sessions   map[*Session]struct{}
+}
+
+func (h *hookSet) onUpdate(op int, dbName, table string, rowid int64) {
+	h.mu.Lock()
+	fn := h.update
+	for s := range h.sessions {
+		s.record(op, dbName, table, rowid)
+	}
+	h.mu.Unlock()
+	if fn != nil {
+		fn(op, dbName, table, rowid)
+	}
+}
+
+func (h *hookSet) onCommit() int {
+	h.mu.Lock()
+	fn := h.commit
+	h.mu.Unlock()
+	if fn != nil && fn() {
+		return 1
+	}
+	h.mu.Lock()
+	for s := range h.sessions {
+		s.commit()
+	}
+	h.mu.Unlock()
+	return 0
+}
+
+func (h *hookSet) onRollback() {
+	h.mu.Lock()
+	fn := h.rollback
+	for s := range h.sessions {
+		s.rollback()
+	}
+	h.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// SetUpdateHook registers fn to be called whenever a row is inserted, updated,
+// or deleted in a rowid table. fn is passed the operation, one of
+// UpdateHookInsert, UpdateHookUpdate, or UpdateHookDelete, and the database
+// name, table name, and rowid of the changed row. Any existing update hook is
+// replaced. If fn is nil the existing update hook is removed.
+//
+// fn is called synchronously, from within the write transaction, before the
+// change is committed, so the change may later be rolled back. fn must not
+// block, and must not access the database.
+func (db *DB) SetUpdateHook(fn func(op int, db, table string, rowid int64)) error {
+	return db.setHooks(func(h *hookSet) {
+		h.update = fn
+	})
+}
+
+// SetCommitHook registers fn to be called whenever a transaction is about to
+// be committed. If fn returns true the commit is vetoed, and the transaction
+// is rolled back instead. When statements are executed within a transaction,
+// fn is called once for the whole transaction, otherwise it is called once
+// for each statement which changes the database. Any existing commit hook is
+// replaced. If fn is nil the existing commit hook is removed.
+//
+// fn is called synchronously, from within the write transaction. fn must not
+// block, and must not access the database.
+func (db *DB) SetCommitHook(fn func() bool) error {
+	return db.setHooks(func(h *hookSet) {
+		h.commit = fn
+	})
+}
+
+// SetRollbackHook registers fn to be called whenever a transaction is rolled
+// back, including when a commit is vetoed by the commit hook. Any existing
+// rollback hook is replaced. If fn is nil the existing rollback hook is
+// removed.
+//
+// fn is called synchronously, from within the write transaction. fn must not
+// block, and must not access the database.
+func (db *DB) SetRollbackHook(fn func()) error {
+	return db.setHooks(func(h *hookSet) {
+		h.rollback = fn
+	})
+}
+
+// setHooks applies fn to the hook set, registering the dispatchers on the
+// read-write connection if they are not already registered. Acquiring the
+// connection ensures no write is in progress while the hooks change.
+func (db *DB) setHooks(fn func(h *hookSet)) error {
+	return db.withRawRWConn(func(c *sqlite3.SQLiteConn) {
+		db.hooks.mu.Lock()
+		defer db.hooks.mu.Unlock()
+		fn(&db.hooks)
+		if !db.hooks.registered {
+			c.RegisterUpdateHook(db.hooks.onUpdate)
+			c.RegisterCommitHook(db.hooks.onCommit)
+			c.RegisterRollbackHook(db.hooks.onRollback)
+			db.hooks.registered = true
+		}
+	})
+}
+
+// withRawRWConn calls fn with the underlying connection used for writes.
+func (db *DB) withRawRWConn(fn func(c *sqlite3.SQLiteConn)) error {
+	conn, err := db.rwDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		fn(driverConn.(*sqlite3.SQLiteConn))
+		return nil
+	})
+}
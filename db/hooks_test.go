@@ -0,0 +1,120 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_UpdateHook(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	var events []string
+	if err := db.SetUpdateHook(func(op int, dbName, table string, rowid int64) {
+		events = append(events, fmt.Sprintf("%d %s %s %d", op, dbName, table, rowid))
+	}); err != nil {
+		t.Fatalf("failed to set update hook: %s", err.Error())
+	}
+
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(5, "fiona")`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(7, "declan")`)
+	mustExecute(db, `UPDATE foo SET name = "aoife" WHERE id = 7`)
+	mustExecute(db, `DELETE FROM foo WHERE id = 5`)
+	if _, err := db.QueryStringStmt("SELECT * FROM foo"); err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	exp := []string{
+		fmt.Sprintf("%d main foo 5", UpdateHookInsert),
+		fmt.Sprintf("%d main foo 7", UpdateHookInsert),
+		fmt.Sprintf("%d main foo 7", UpdateHookUpdate),
+		fmt.Sprintf("%d main foo 5", UpdateHookDelete),
+	}
+	if !reflect.DeepEqual(exp, events) {
+		t.Fatalf("wrong update hook events\nexp: %v\ngot: %v", exp, events)
+	}
+
+	// Removing the hook should stop events.
+	if err := db.SetUpdateHook(nil); err != nil {
+		t.Fatalf("failed to remove update hook: %s", err.Error())
+	}
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(9, "fiona")`)
+	if len(events) != len(exp) {
+		t.Fatalf("update hook fired after removal")
+	}
+}
+
+func Test_CommitRollbackHooks(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	var nCommits, nRollbacks int
+	veto := false
+	if err := db.SetCommitHook(func() bool {
+		nCommits++
+		return veto
+	}); err != nil {
+		t.Fatalf("failed to set commit hook: %s", err.Error())
+	}
+	if err := db.SetRollbackHook(func() {
+		nRollbacks++
+	}); err != nil {
+		t.Fatalf("failed to set rollback hook: %s", err.Error())
+	}
+
+	// A successful transaction should fire the commit hook exactly once.
+	req := &command.Request{
+		Transaction: true,
+		Statements: []*command.Statement{
+			{Sql: `INSERT INTO foo(id, name) VALUES(1, "fiona")`},
+			{Sql: `INSERT INTO foo(id, name) VALUES(2, "declan")`},
+			{Sql: `INSERT INTO foo(id, name) VALUES(3, "aoife")`},
+		},
+	}
+	if _, err := db.Execute(req, false); err != nil {
+		t.Fatalf("failed to execute transaction: %s", err.Error())
+	}
+	if nCommits != 1 || nRollbacks != 0 {
+		t.Fatalf("wrong hook counts after commit, commits %d, rollbacks %d", nCommits, nRollbacks)
+	}
+
+	// A failing transaction should fire the rollback hook, and not the commit hook.
+	req.Statements = []*command.Statement{
+		{Sql: `INSERT INTO foo(id, name) VALUES(4, "fiona")`},
+		{Sql: `INSERT INTO foo(id, name) VALUES(1, "declan")`},
+	}
+	if _, err := db.Execute(req, false); err != nil {
+		t.Fatalf("failed to execute transaction: %s", err.Error())
+	}
+	if nCommits != 1 || nRollbacks != 1 {
+		t.Fatalf("wrong hook counts after rollback, commits %d, rollbacks %d", nCommits, nRollbacks)
+	}
+
+	// A vetoed commit should be rolled back.
+	veto = true
+	req.Statements = []*command.Statement{
+		{Sql: `INSERT INTO foo(id, name) VALUES(5, "fiona")`},
+	}
+	if _, err := db.Execute(req, false); err == nil {
+		t.Fatalf("expected error for vetoed commit")
+	}
+	if nCommits != 2 || nRollbacks != 2 {
+		t.Fatalf("wrong hook counts after veto, commits %d, rollbacks %d", nCommits, nRollbacks)
+	}
+	veto = false
+
+	r, err := db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[3]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
@@ -0,0 +1,299 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+)
+
+// ErrManifestMismatch is returned by VerifyBackup when a database does not
+// match its manifest.
+var ErrManifestMismatch = errors.New("database does not match manifest")
+
+// BackupManifest records the contents of every table in a database, allowing
+// a backup, once restored, to be checked for data loss or corruption.
+type BackupManifest struct {
+	Tables []TableManifest `json:"tables"`
+}
+
+// TableManifest records the contents of a single table.
+type TableManifest struct {
+	// Name is the name of the table.
+	Name string `json:"name"`
+
+	// Rows is the number of rows in the table.
+	Rows int64 `json:"rows"`
+
+	// Checksum is a hex-encoded checksum of the rows in the table. It does not
+	// depend on the order in which rows are stored.
+	Checksum string `json:"checksum"`
+}
+
+// BackupWithManifest is identical to Backup, but also returns a manifest of
+// the backup. The manifest is computed from the backup file itself, so it
+// describes exactly what was written.
+func (db *DB) BackupWithManifest(path string, vacuum bool) (*BackupManifest, error) {
+	if err := db.Backup(path, vacuum); err != nil {
+		return nil, err
+	}
+	return manifestFromFile(path)
+}
+
+// DumpWithManifest is identical to Dump, but also returns a manifest of the
+// dumped data. The data is first copied to a temporary database, and both the
+// dump and the manifest are generated from that copy, so they are consistent
+// with each other even if changes to the database are in flight.
+func (db *DB) DumpWithManifest(w io.Writer) (*BackupManifest, error) {
+	f, err := os.CreateTemp("", "rqlite-dump")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := db.Backup(f.Name(), false); err != nil {
+		return nil, err
+	}
+	tmpDB, err := Open(f.Name(), false, false)
+	if err != nil {
+		return nil, err
+	}
+	defer tmpDB.Close()
+
+	m, err := tmpDB.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpDB.Dump(w); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Manifest returns a manifest of the database. All tables are read within a
+// single read transaction, so the manifest is consistent.
+func (db *DB) Manifest() (*BackupManifest, error) {
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return manifestWithTx(ctx, tx)
+}
+
+// ContentHash returns a SHA256 hash of the logical contents of the database,
+// that is its schema and the rows in every table. The hash does not depend on
+// the physical layout of the database, such as free pages, the order in which
+// rows are stored, or whether the database has been vacuumed, so databases
+// with identical logical contents always hash equal. All tables are read
+// within a single read transaction, so the hash is consistent.
+func (db *DB) ContentHash() ([]byte, error) {
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	h := sha256.New()
+	rows, err := tx.QueryContext(ctx, `SELECT "type", "name", "tbl_name", "sql" FROM "sqlite_master"
+		WHERE "sql" NOT NULL AND "name" NOT LIKE 'sqlite_%' ORDER BY "type", "name"`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var typ, name, tblName, sql string
+		if err := rows.Scan(&typ, &name, &tblName, &sql); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for _, v := range []string{typ, name, tblName, sql} {
+			hashValue(h, v)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	m, err := manifestWithTx(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range m.Tables {
+		hashValue(h, t.Name)
+		hashValue(h, t.Rows)
+		hashValue(h, t.Checksum)
+	}
+	return h.Sum(nil), nil
+}
+
+// VerifyBackup checks that the SQLite database at dbPath, typically a restored
+// backup, matches the given manifest. If it does not an error wrapping
+// ErrManifestMismatch is returned, describing the first difference found.
+func VerifyBackup(manifest *BackupManifest, dbPath string) error {
+	if !IsValidSQLiteFile(dbPath) {
+		return fmt.Errorf("%s is not a SQLite file", dbPath)
+	}
+	got, err := manifestFromFile(dbPath)
+	if err != nil {
+		return err
+	}
+
+	exp := make(map[string]TableManifest, len(manifest.Tables))
+	for _, t := range manifest.Tables {
+		exp[t.Name] = t
+	}
+	for _, g := range got.Tables {
+		e, ok := exp[g.Name]
+		if !ok {
+			return fmt.Errorf("%w: unexpected table %s", ErrManifestMismatch, g.Name)
+		}
+		if e.Rows != g.Rows {
+			return fmt.Errorf("%w: table %s has %d rows, expected %d", ErrManifestMismatch, g.Name, g.Rows, e.Rows)
+		}
+		if e.Checksum != g.Checksum {
+			return fmt.Errorf("%w: table %s checksum is %s, expected %s", ErrManifestMismatch, g.Name, g.Checksum, e.Checksum)
+		}
+		delete(exp, g.Name)
+	}
+	if len(exp) > 0 {
+		var missing []string
+		for n := range exp {
+			missing = append(missing, n)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("%w: missing tables %v", ErrManifestMismatch, missing)
+	}
+	return nil
+}
+
+// manifestWithTx returns a manifest of every table readable via tx.
+func manifestWithTx(ctx context.Context, tx *sql.Tx) (*BackupManifest, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT "name" FROM "sqlite_master"
+		WHERE "type" = 'table' AND "sql" NOT NULL AND "name" NOT LIKE 'sqlite_%' ORDER BY "name"`)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	m := &BackupManifest{}
+	for _, t := range tables {
+		tm, err := tableManifest(ctx, tx, t)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %s", t, err.Error())
+		}
+		m.Tables = append(m.Tables, *tm)
+	}
+	return m, nil
+}
+
+func manifestFromFile(path string) (*BackupManifest, error) {
+	d, err := Open(path, false, false)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Manifest()
+}
+
+// tableManifest computes the manifest of the given table. Each row is hashed
+// and the row hashes are summed, lane by lane, so the checksum does not depend
+// on row order, and duplicate rows do not cancel each other out.
+func tableManifest(ctx context.Context, tx *sql.Tx, table string) (*TableManifest, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s`, quoteIdent(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	dest := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(dest))
+	for i := range ptrs {
+		ptrs[i] = &dest[i]
+	}
+	var sum [sha256.Size / 8]uint64
+	var n int64
+	h := sha256.New()
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		h.Reset()
+		for _, v := range dest {
+			hashValue(h, v)
+		}
+		rh := h.Sum(nil)
+		for i := range sum {
+			sum[i] += binary.BigEndian.Uint64(rh[i*8:])
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, sha256.Size)
+	for i := range sum {
+		binary.BigEndian.PutUint64(b[i*8:], sum[i])
+	}
+	return &TableManifest{
+		Name:     table,
+		Rows:     n,
+		Checksum: hex.EncodeToString(b),
+	}, nil
+}
+
+// hashValue writes an unambiguous encoding of v, including its type, to h.
+func hashValue(h hash.Hash, v interface{}) {
+	var b []byte
+	switch val := v.(type) {
+	case []byte:
+		b = val
+	default:
+		b = []byte(fmt.Sprintf("%v", val))
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(len(b)))
+	fmt.Fprintf(h, "%T", v)
+	h.Write(hdr[:])
+	h.Write(b)
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func Test_BackupManifest(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, data BLOB)")
+	mustExecute(db, "CREATE TABLE bar (name TEXT)")
+	for i := 0; i < 10; i++ {
+		mustExecute(db, `INSERT INTO foo(name, data) VALUES("fiona", x'0102')`)
+		mustExecute(db, `INSERT INTO bar(name) VALUES("declan")`)
+	}
+
+	bkPath := mustTempFile()
+	defer os.Remove(bkPath)
+	m, err := db.BackupWithManifest(bkPath, false)
+	if err != nil {
+		t.Fatalf("failed to back up database: %s", err.Error())
+	}
+	if exp, got := 2, len(m.Tables); exp != got {
+		t.Fatalf("wrong number of tables in manifest, exp %d, got %d", exp, got)
+	}
+	for _, tm := range m.Tables {
+		if tm.Rows != 10 {
+			t.Fatalf("wrong number of rows for table %s, exp 10, got %d", tm.Name, tm.Rows)
+		}
+	}
+	if err := VerifyBackup(m, bkPath); err != nil {
+		t.Fatalf("failed to verify backup: %s", err.Error())
+	}
+
+	// The manifest of the source database should be identical.
+	srcM, err := db.Manifest()
+	if err != nil {
+		t.Fatalf("failed to get manifest: %s", err.Error())
+	}
+	if exp, got := asJSON(m), asJSON(srcM); exp != got {
+		t.Fatalf("source and backup manifests differ\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// A SQL dump, once restored, should also verify.
+	var buf bytes.Buffer
+	dumpM, err := db.DumpWithManifest(&buf)
+	if err != nil {
+		t.Fatalf("failed to dump database: %s", err.Error())
+	}
+	rDB, rPath := mustCreateOnDiskDatabase()
+	defer os.Remove(rPath)
+	if _, err := rDB.ExecuteStringStmt(buf.String()); err != nil {
+		t.Fatalf("failed to load dump: %s", err.Error())
+	}
+	if err := rDB.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+	if err := VerifyBackup(dumpM, rPath); err != nil {
+		t.Fatalf("failed to verify restored dump: %s", err.Error())
+	}
+}
+
+func Test_BackupManifest_Tampered(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(2, "declan")`)
+
+	for _, tc := range []struct {
+		name   string
+		tamper string
+	}{
+		{name: "Changed", tamper: `UPDATE foo SET name = "aoife" WHERE id = 2`},
+		{name: "Deleted", tamper: `DELETE FROM foo WHERE id = 1`},
+		{name: "Dropped", tamper: `DROP TABLE foo`},
+		{name: "Added", tamper: `CREATE TABLE bar (id INTEGER)`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bkPath := mustTempFile()
+			defer os.Remove(bkPath)
+			m, err := db.BackupWithManifest(bkPath, false)
+			if err != nil {
+				t.Fatalf("failed to back up database: %s", err.Error())
+			}
+
+			bkDB, err := Open(bkPath, false, false)
+			if err != nil {
+				t.Fatalf("failed to open backup: %s", err.Error())
+			}
+			mustExecute(bkDB, tc.tamper)
+			if err := bkDB.Close(); err != nil {
+				t.Fatalf("failed to close backup: %s", err.Error())
+			}
+
+			if err := VerifyBackup(m, bkPath); !errors.Is(err, ErrManifestMismatch) {
+				t.Fatalf("expected manifest mismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_ContentHash(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, "CREATE INDEX foo_name ON foo(name)")
+	for i := 0; i < 1000; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES(hex(randomblob(100)))`)
+	}
+	// Leave free pages behind.
+	mustExecute(db, "DELETE FROM foo WHERE id % 3 = 0")
+
+	pre, err := db.ContentHash()
+	if err != nil {
+		t.Fatalf("failed to hash database: %s", err.Error())
+	}
+	if err := db.Vacuum(); err != nil {
+		t.Fatalf("failed to vacuum database: %s", err.Error())
+	}
+	post, err := db.ContentHash()
+	if err != nil {
+		t.Fatalf("failed to hash database: %s", err.Error())
+	}
+	if !bytes.Equal(pre, post) {
+		t.Fatalf("hash changed after VACUUM")
+	}
+
+	// A database with the same contents, inserted in a different order, must
+	// hash equal.
+	db2, path2 := mustCreateOnDiskDatabaseWAL()
+	defer db2.Close()
+	defer os.Remove(path2)
+	mustExecute(db2, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db2, "CREATE INDEX foo_name ON foo(name)")
+	mustExecute(db2, fmt.Sprintf("ATTACH %s AS src", quoteString(path)))
+	if _, err := db2.ExecuteStringStmt("INSERT INTO foo SELECT * FROM src.foo ORDER BY id DESC"); err != nil {
+		t.Fatalf("failed to copy rows: %s", err.Error())
+	}
+	mustExecute(db2, "DETACH src")
+	h2, err := db2.ContentHash()
+	if err != nil {
+		t.Fatalf("failed to hash database: %s", err.Error())
+	}
+	if !bytes.Equal(pre, h2) {
+		t.Fatalf("hash differs for database with identical contents")
+	}
+
+	// Changing the contents or the schema must change the hash.
+	mustExecute(db2, `UPDATE foo SET name = "fiona" WHERE id = 1`)
+	h3, err := db2.ContentHash()
+	if err != nil {
+		t.Fatalf("failed to hash database: %s", err.Error())
+	}
+	if bytes.Equal(h2, h3) {
+		t.Fatalf("hash unchanged after update")
+	}
+	mustExecute(db, "DROP INDEX foo_name")
+	h4, err := db.ContentHash()
+	if err != nil {
+		t.Fatalf("failed to hash database: %s", err.Error())
+	}
+	if bytes.Equal(pre, h4) {
+		t.Fatalf("hash unchanged after schema change")
+	}
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"sync/atomic"
+)
+
+// memoryDBCounter makes the name of each in-memory database unique.
+var memoryDBCounter atomic.Uint64
+
+// OpenInMemory opens a new, empty, in-memory database. It is intended for
+// tests, which can then avoid creating and removing temporary files. The
+// database is held by SQLite's memdb VFS, so every connection to it sees the
+// same data, and it is freed when it is closed.
+//
+// The database has no files, so no WAL is ever written. The wal flag is only
+// recorded, and reported by WALEnabled, so that code under test which
+// depends on it behaves as it would with an on-disk database. WALPath and
+// Path return the empty string, and checkpoints are no-ops.
+func OpenInMemory(wal bool) (retDB *DB, retErr error) {
+	name := fmt.Sprintf("/rqlite-%d", memoryDBCounter.Add(1))
+	rwDSN := makeMemoryDSN(name, false)
+	roDSN := makeMemoryDSN(name, true)
+
+	var pools []*sql.DB
+	defer func() {
+		if retErr != nil {
+			for _, p := range pools {
+				p.Close()
+			}
+		}
+	}()
+	open := func(dsn string) (*sql.DB, error) {
+		p, err := sql.Open("sqlite3", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("open: %s", err.Error())
+		}
+		pools = append(pools, p)
+		return p, nil
+	}
+	rwDB, err := open(rwDSN)
+	if err != nil {
+		return nil, err
+	}
+	// The database is freed when its last connection closes, so the single
+	// read-write connection must never be closed while the DB is open.
+	rwDB.SetMaxOpenConns(1)
+	rwDB.SetConnMaxLifetime(0)
+	rwDB.SetConnMaxIdleTime(0)
+	if err := rwDB.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping in-memory database: %s", err.Error())
+	}
+	roDB, err := open(roDSN)
+	if err != nil {
+		return nil, err
+	}
+	rodDB, err := open(roDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := NewConfig()
+	logger := log.New(log.Writer(), "[db] ", log.LstdFlags)
+	return &DB{
+		wal:         wal,
+		memory:      true,
+		rwDB:        rwDB,
+		roDB:        roDB,
+		rodDB:       rodDB,
+		rwDSN:       rwDSN,
+		roDSN:       roDSN,
+		slowLogger:  newSlowQueryLogger(cfg, logger),
+		logger:      logger,
+		writeQueue:  newWriteQueue(cfg.WriteQueueDepth),
+		busyRetrier: newBusyRetrier(cfg),
+	}, nil
+}
+
+// makeMemoryDSN returns a DSN for the in-memory database with the given name.
+func makeMemoryDSN(name string, readOnly bool) string {
+	opts := url.Values{}
+	opts.Add("vfs", "memdb")
+	if readOnly {
+		opts.Add("mode", "ro")
+	}
+	opts.Add("_sync", "0")
+	return fmt.Sprintf("file:%s?%s", name, opts.Encode())
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"testing"
+)
+
+func Test_OpenInMemory(t *testing.T) {
+	for _, wal := range []bool{false, true} {
+		db, err := OpenInMemory(wal)
+		if err != nil {
+			t.Fatalf("failed to open in-memory database: %s", err.Error())
+		}
+		defer db.Close()
+		if exp, got := wal, db.WALEnabled(); exp != got {
+			t.Fatalf("unexpected WAL enabled, exp %t, got %t", exp, got)
+		}
+		if db.WALPath() != "" || db.Path() != "" {
+			t.Fatalf("expected empty paths, got %q and %q", db.Path(), db.WALPath())
+		}
+
+		mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+		for i := 0; i < 10; i++ {
+			mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+		}
+		for _, mode := range []CheckpointMode{CheckpointPassive, CheckpointRestart, CheckpointTruncate} {
+			if err := db.Checkpoint(mode); err != nil {
+				t.Fatalf("failed to checkpoint in-memory database: %s", err.Error())
+			}
+		}
+		if n, err := db.WALSize(); err != nil || n != 0 {
+			t.Fatalf("expected zero WAL size, got %d, %v", n, err)
+		}
+
+		// Reads use separate connections, which must see the same data.
+		for _, opts := range []QueryOptions{{}, {ReadOnlyConn: true}} {
+			r, err := db.QueryStringStmtWithOptions("SELECT COUNT(*) FROM foo", opts)
+			if err != nil {
+				t.Fatalf("failed to query in-memory database: %s", err.Error())
+			}
+			if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[10]]}]`, asJSON(r); exp != got {
+				t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+			}
+		}
+	}
+}
+
+func Test_OpenInMemoryIsolated(t *testing.T) {
+	db1, err := OpenInMemory(true)
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %s", err.Error())
+	}
+	defer db1.Close()
+	db2, err := OpenInMemory(true)
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %s", err.Error())
+	}
+	defer db2.Close()
+
+	mustExecute(db1, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	r, err := db2.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query in-memory database: %s", err.Error())
+	}
+	if exp, got := `[{"error":"no such table: foo"}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+	}
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	mergeSchemaName = "rqlite_merge_src"
+)
+
+// MergePolicy determines how rows from a merge source which conflict with
+// existing rows, for example by primary key, are handled.
+type MergePolicy int
+
+const (
+	// MergeIgnore keeps the existing row when a conflict occurs.
+	MergeIgnore MergePolicy = iota
+
+	// MergeReplace replaces the existing row with the incoming row when a
+	// conflict occurs.
+	MergeReplace
+)
+
+// String returns the string representation of the merge policy.
+func (m MergePolicy) String() string {
+	switch m {
+	case MergeIgnore:
+		return "IGNORE"
+	case MergeReplace:
+		return "REPLACE"
+	default:
+		panic("unknown merge policy")
+	}
+}
+
+// MergeSQL merges the contents of the SQL text backup read from r, as
+// generated by Dump, into the database. Unlike loading the backup, existing
+// tables and rows are left in place. Tables, indexes, triggers, and views in
+// the backup which do not exist in the database are created, and rows are
+// inserted using INSERT OR IGNORE, or INSERT OR REPLACE, depending on policy.
+// The merge is performed in a single transaction, so if it fails the database
+// is unchanged.
+func (db *DB) MergeSQL(r io.Reader, policy MergePolicy) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// Load the backup into a scratch database, and merge from that. This way
+	// SQLite itself parses the backup.
+	f, err := os.CreateTemp("", "rqlite-merge")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	srcDB, err := Open(f.Name(), false, false)
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+	if _, err := srcDB.rwDB.Exec(string(b)); err != nil {
+		return fmt.Errorf("load SQL backup: %s", err.Error())
+	}
+	if err := srcDB.Close(); err != nil {
+		return err
+	}
+	return db.MergeFrom(f.Name(), policy)
+}
+
+// MergeFrom merges the contents of the SQLite database at path into the
+// database. See MergeSQL for details of how the merge is performed.
+func (db *DB) MergeFrom(path string, policy MergePolicy) (retErr error) {
+	if policy != MergeIgnore && policy != MergeReplace {
+		return fmt.Errorf("invalid merge policy %d", policy)
+	}
+	if !IsValidSQLiteFile(path) {
+		return fmt.Errorf("invalid SQLite data")
+	}
+
+	ctx := context.Background()
+	conn, err := db.rwDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("ATTACH DATABASE ? AS %s", mergeSchemaName), path); err != nil {
+		return fmt.Errorf("attach merge source: %s", err.Error())
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DETACH DATABASE %s", mergeSchemaName)); err != nil && retErr == nil {
+			retErr = fmt.Errorf("detach merge source: %s", err.Error())
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // Will be ignored if tx is committed
+
+	srcObjs, err := schemaObjects(ctx, tx, mergeSchemaName)
+	if err != nil {
+		return err
+	}
+	dstObjs, err := schemaObjects(ctx, tx, "main")
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(dstObjs))
+	for _, o := range dstObjs {
+		exists[o.typ+":"+o.name] = true
+	}
+
+	// Tables first, then rows, and then everything else, so triggers created
+	// by the merge do not fire on merged rows.
+	for _, o := range srcObjs {
+		if o.typ != "table" || exists[o.typ+":"+o.name] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, o.sql); err != nil {
+			return fmt.Errorf("create table %s: %s", o.name, err.Error())
+		}
+	}
+	for _, o := range srcObjs {
+		if o.typ != "table" {
+			continue
+		}
+		if err := mergeTable(ctx, tx, o.name, policy); err != nil {
+			return fmt.Errorf("merge table %s: %s", o.name, err.Error())
+		}
+	}
+	for _, o := range srcObjs {
+		if o.typ == "table" || exists[o.typ+":"+o.name] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, o.sql); err != nil {
+			return fmt.Errorf("create %s %s: %s", o.typ, o.name, err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+type schemaObject struct {
+	typ  string
+	name string
+	sql  string
+}
+
+// schemaObjects returns the user-created tables, indexes, triggers, and views
+// in the given schema.
+func schemaObjects(ctx context.Context, tx *sql.Tx, schema string) ([]schemaObject, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT "type", "name", "sql" FROM %s.sqlite_master
+		WHERE "sql" NOT NULL AND "name" NOT LIKE 'sqlite_%%' ORDER BY rowid`, schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objs []schemaObject
+	for rows.Next() {
+		var o schemaObject
+		if err := rows.Scan(&o.typ, &o.name, &o.sql); err != nil {
+			return nil, err
+		}
+		objs = append(objs, o)
+	}
+	return objs, rows.Err()
+}
+
+// mergeTable copies all rows in the given table in the merge source into the
+// same table in the main database.
+func mergeTable(ctx context.Context, tx *sql.Tx, table string, policy MergePolicy) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT "name" FROM pragma_table_info(%s, '%s')`,
+		quoteString(table), mergeSchemaName))
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, quoteIdent(c))
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	cols := strings.Join(columns, ",")
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT OR %s INTO main.%s(%s) SELECT %s FROM %s.%s`,
+		policy, quoteIdent(table), cols, cols, mergeSchemaName, quoteIdent(table)))
+	return err
+}
+
+// quoteIdent quotes the given string as a SQLite identifier.
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteString quotes the given string as a SQLite string literal.
+func quoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
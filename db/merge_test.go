@@ -0,0 +1,110 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func Test_MergeSQL(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy MergePolicy
+		exp    string
+	}{
+		{
+			name:   "Ignore",
+			policy: MergeIgnore,
+			exp:    `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"],[2,"declan"],[3,"aoife"]]}]`,
+		},
+		{
+			name:   "Replace",
+			policy: MergeReplace,
+			exp:    `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"],[2,"DECLAN"],[3,"aoife"]]}]`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srcDB, srcPath := mustCreateOnDiskDatabaseWAL()
+			defer srcDB.Close()
+			defer os.Remove(srcPath)
+			mustExecute(srcDB, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+			mustExecute(srcDB, `INSERT INTO foo(id, name) VALUES(2, "DECLAN")`)
+			mustExecute(srcDB, `INSERT INTO foo(id, name) VALUES(3, "aoife")`)
+			mustExecute(srcDB, "CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, age INTEGER)")
+			mustExecute(srcDB, `INSERT INTO bar(id, age) VALUES(1, 20)`)
+			mustExecute(srcDB, "CREATE INDEX bar_age ON bar(age)")
+
+			var buf bytes.Buffer
+			if err := srcDB.Dump(&buf); err != nil {
+				t.Fatalf("failed to dump database: %s", err.Error())
+			}
+
+			db, path := mustCreateOnDiskDatabaseWAL()
+			defer db.Close()
+			defer os.Remove(path)
+			mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+			mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+			mustExecute(db, `INSERT INTO foo(id, name) VALUES(2, "declan")`)
+
+			if err := db.MergeSQL(&buf, tc.policy); err != nil {
+				t.Fatalf("failed to merge SQL backup: %s", err.Error())
+			}
+
+			rows, err := db.QueryStringStmt("SELECT * FROM foo ORDER BY id")
+			if err != nil {
+				t.Fatalf("failed to query table: %s", err.Error())
+			}
+			if got := asJSON(rows); tc.exp != got {
+				t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", tc.exp, got)
+			}
+			rows, err = db.QueryStringStmt("SELECT * FROM bar")
+			if err != nil {
+				t.Fatalf("failed to query table: %s", err.Error())
+			}
+			if exp, got := `[{"columns":["id","age"],"types":["integer","integer"],"values":[[1,20]]}]`, asJSON(rows); exp != got {
+				t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+			}
+			rows, err = db.QueryStringStmt(`SELECT name FROM sqlite_master WHERE type="index"`)
+			if err != nil {
+				t.Fatalf("failed to query table: %s", err.Error())
+			}
+			if exp, got := `[{"columns":["name"],"types":["text"],"values":[["bar_age"]]}]`, asJSON(rows); exp != got {
+				t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+			}
+
+			// The merge source must be detached.
+			rows, err = db.QueryStringStmt("SELECT name FROM pragma_database_list ORDER BY name")
+			if err != nil {
+				t.Fatalf("failed to query database list: %s", err.Error())
+			}
+			if exp, got := `[{"columns":["name"],"types":["text"],"values":[["main"]]}]`, asJSON(rows); exp != got {
+				t.Fatalf("unexpected database list\nexp: %s\ngot: %s", exp, got)
+			}
+		})
+	}
+}
+
+func Test_MergeSQL_Fail(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	// Source table has a column the destination table lacks, so the merge
+	// must fail and leave the destination unchanged.
+	dump := `CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, age INTEGER);
+INSERT INTO foo VALUES(2, 'declan', 20);
+CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY);
+`
+	if err := db.MergeSQL(bytes.NewBufferString(dump), MergeIgnore); err == nil {
+		t.Fatalf("expected error merging incompatible table")
+	}
+	rows, err := db.QueryStringStmt("SELECT name FROM sqlite_master")
+	if err != nil {
+		t.Fatalf("failed to query table: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["foo"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// ErrInvalidKeyColumn is returned by QueryPage when the key column is not one
+// of the columns returned by the base query.
+var ErrInvalidKeyColumn = errors.New("invalid key column")
+
+// QueryPage returns one page of the rows returned by the query baseSQL, using
+// keyset pagination. Rows are ordered by keyColumn, and only rows whose key is
+// greater than afterKey are returned, up to a maximum of limit rows. If
+// afterKey is nil the first page is returned. baseSQL must not contain its own
+// ORDER BY or LIMIT clauses.
+//
+// next is the key of the last row returned, and should be passed as afterKey
+// to retrieve the following page. next is nil if there are no further pages.
+// For pages to be consistent keyColumn should be unique.
+//
+// keyColumn is checked against the columns returned by baseSQL, and
+// ErrInvalidKeyColumn is returned if it is not one of them, so it can never
+// be used to inject SQL.
+func (db *DB) QueryPage(baseSQL string, afterKey interface{}, limit int, keyColumn string) (rows *command.QueryRows, next interface{}, err error) {
+	if limit <= 0 {
+		return nil, nil, fmt.Errorf("invalid limit %d", limit)
+	}
+	columns, err := db.queryColumns(baseSQL)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyIdx := -1
+	for i, c := range columns {
+		if c == keyColumn {
+			keyIdx = i
+			break
+		}
+	}
+	if keyIdx == -1 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidKeyColumn, keyColumn)
+	}
+
+	stmt := &command.Statement{}
+	if afterKey == nil {
+		stmt.Sql = fmt.Sprintf("SELECT * FROM (%s) ORDER BY %s LIMIT ?",
+			baseSQL, quoteIdent(keyColumn))
+	} else {
+		p, err := parameterFromValue(afterKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		stmt.Sql = fmt.Sprintf("SELECT * FROM (%s) WHERE %s > ? ORDER BY %s LIMIT ?",
+			baseSQL, quoteIdent(keyColumn), quoteIdent(keyColumn))
+		stmt.Parameters = append(stmt.Parameters, p)
+	}
+	stmt.Parameters = append(stmt.Parameters, &command.Parameter{
+		Value: &command.Parameter_I{I: int64(limit)},
+	})
+
+	results, err := db.Query(&command.Request{Statements: []*command.Statement{stmt}}, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows = results[0]
+	if rows.Error != "" {
+		return nil, nil, errors.New(rows.Error)
+	}
+	if len(rows.Values) < limit {
+		return rows, nil, nil
+	}
+	return rows, valueFromParameter(rows.Values[len(rows.Values)-1].Parameters[keyIdx]), nil
+}
+
+// queryColumns returns the names of the columns returned by the given query,
+// without reading any rows.
+func (db *DB) queryColumns(query string) ([]string, error) {
+	results, err := db.QueryStringStmt(fmt.Sprintf("SELECT * FROM (%s) LIMIT 0", query))
+	if err != nil {
+		return nil, err
+	}
+	if results[0].Error != "" {
+		return nil, errors.New(results[0].Error)
+	}
+	return results[0].Columns, nil
+}
+
+// parameterFromValue converts a Go value to a Parameter.
+func parameterFromValue(v interface{}) (*command.Parameter, error) {
+	switch val := v.(type) {
+	case int:
+		return &command.Parameter{Value: &command.Parameter_I{I: int64(val)}}, nil
+	case int64:
+		return &command.Parameter{Value: &command.Parameter_I{I: val}}, nil
+	case float64:
+		return &command.Parameter{Value: &command.Parameter_D{D: val}}, nil
+	case bool:
+		return &command.Parameter{Value: &command.Parameter_B{B: val}}, nil
+	case []byte:
+		return &command.Parameter{Value: &command.Parameter_Y{Y: val}}, nil
+	case string:
+		return &command.Parameter{Value: &command.Parameter_S{S: val}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", v)
+	}
+}
+
+// valueFromParameter converts a Parameter to a Go value. It returns nil for
+// a NULL parameter.
+func valueFromParameter(p *command.Parameter) interface{} {
+	switch v := p.GetValue().(type) {
+	case *command.Parameter_I:
+		return v.I
+	case *command.Parameter_D:
+		return v.D
+	case *command.Parameter_B:
+		return v.B
+	case *command.Parameter_Y:
+		return v.Y
+	case *command.Parameter_S:
+		return v.S
+	default:
+		return nil
+	}
+}
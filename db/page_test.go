@@ -0,0 +1,113 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func Test_QueryPage(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, age INTEGER)")
+	// Insert out of key order, to check ordering.
+	for _, i := range []int{5, 3, 9, 1, 7, 2, 8, 4, 6, 10} {
+		mustExecute(db, fmt.Sprintf(`INSERT INTO foo(id, name, age) VALUES(%d, "name%02d", %d)`, i, 11-i, i%2))
+	}
+
+	// Page through the table by id.
+	var ids []int64
+	var after interface{}
+	nPages := 0
+	for {
+		rows, next, err := db.QueryPage("SELECT id, name FROM foo", after, 3, "id")
+		if err != nil {
+			t.Fatalf("failed to query page: %s", err.Error())
+		}
+		nPages++
+		for _, v := range rows.Values {
+			ids = append(ids, v.Parameters[0].GetI())
+		}
+		if next == nil {
+			break
+		}
+		after = next
+	}
+	if exp, got := "[1 2 3 4 5 6 7 8 9 10]", fmt.Sprint(ids); exp != got {
+		t.Fatalf("wrong ids, exp %s, got %s", exp, got)
+	}
+	if exp, got := 4, nPages; exp != got {
+		t.Fatalf("wrong number of pages, exp %d, got %d", exp, got)
+	}
+
+	// Page by a text key, with a filter in the base query.
+	var names []string
+	after = nil
+	for {
+		rows, next, err := db.QueryPage("SELECT name FROM foo WHERE age = 1", after, 2, "name")
+		if err != nil {
+			t.Fatalf("failed to query page: %s", err.Error())
+		}
+		for _, v := range rows.Values {
+			names = append(names, v.Parameters[0].GetS())
+		}
+		if next == nil {
+			break
+		}
+		after = next
+	}
+	if exp, got := "[name02 name04 name06 name08 name10]", fmt.Sprint(names); exp != got {
+		t.Fatalf("wrong names, exp %s, got %s", exp, got)
+	}
+
+	// Exactly one full page leaves an empty last page.
+	rows, next, err := db.QueryPage("SELECT id FROM foo", 8, 2, "id")
+	if err != nil {
+		t.Fatalf("failed to query page: %s", err.Error())
+	}
+	if exp, got := 2, len(rows.Values); exp != got {
+		t.Fatalf("wrong number of rows, exp %d, got %d", exp, got)
+	}
+	rows, next, err = db.QueryPage("SELECT id FROM foo", next, 2, "id")
+	if err != nil {
+		t.Fatalf("failed to query page: %s", err.Error())
+	}
+	if len(rows.Values) != 0 || next != nil {
+		t.Fatalf("expected empty last page, got %d rows, next %v", len(rows.Values), next)
+	}
+}
+
+func Test_QueryPage_InvalidKeyColumn(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	for _, key := range []string{
+		"age",
+		"id; DROP TABLE foo",
+		"1) OR 1=1 --",
+		"",
+	} {
+		if _, _, err := db.QueryPage("SELECT id, name FROM foo", nil, 10, key); !errors.Is(err, ErrInvalidKeyColumn) {
+			t.Fatalf("expected ErrInvalidKeyColumn for key %q, got %v", key, err)
+		}
+	}
+	if _, _, err := db.QueryPage("SELECT id, name FROM foo", nil, 0, "id"); err == nil {
+		t.Fatalf("expected error for zero limit")
+	}
+	if _, _, err := db.QueryPage("SELECT id, name FROM foo", struct{}{}, 10, "id"); err == nil {
+		t.Fatalf("expected error for unsupported key type")
+	}
+
+	// The table must be intact.
+	r, err := db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[0]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
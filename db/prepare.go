@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// opflagP2IsReg is set in P5 of an OpenRead or OpenWrite opcode when P2 is
+// the register holding the root page, rather than the root page itself.
+const opflagP2IsReg = 0x10
+
+// StmtInfo describes a prepared, but not executed, SQL statement.
+type StmtInfo struct {
+	// ReadOnly is whether the statement makes no direct changes to the
+	// database, as reported by sqlite3_stmt_readonly.
+	ReadOnly bool
+
+	// ParamCount is the number of parameters the statement takes.
+	ParamCount int
+
+	// Tables are the tables the statement reads or writes, sorted. Tables
+	// in an attached database are qualified by its name. Statements which
+	// change the schema also write to sqlite_schema. Virtual tables are not
+	// included.
+	Tables []string
+}
+
+// Prepare compiles the first statement in sql, without executing it, and
+// returns a description of it. An error is returned if the statement cannot
+// be compiled, for example because it is not valid SQL, refers to a table or
+// column which does not exist, or is denied by the database's statement
+// policy. This allows statements to be validated, and routed according to
+// whether they are read-only, before they are executed.
+func (db *DB) Prepare(sql string) (StmtInfo, error) {
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		return StmtInfo{}, err
+	}
+	defer conn.Close()
+	if err := db.syncAttached(ctx, conn); err != nil {
+		return StmtInfo{}, err
+	}
+
+	var info StmtInfo
+	if err := conn.Raw(func(driverConn interface{}) error {
+		c := driverConn.(*sqlite3.SQLiteConn)
+		drvStmt, err := c.Prepare(sql)
+		if err != nil {
+			return err
+		}
+		defer drvStmt.Close()
+		sqliteStmt := drvStmt.(*sqlite3.SQLiteStmt)
+		info.ReadOnly = sqliteStmt.Readonly()
+		info.ParamCount = sqliteStmt.NumInput()
+		return nil
+	}); err != nil {
+		return StmtInfo{}, err
+	}
+
+	info.Tables, err = stmtTables(ctx, conn, sql, info.ParamCount)
+	if err != nil {
+		return StmtInfo{}, err
+	}
+	return info, nil
+}
+
+// stmtTables returns the tables accessed by the first statement in query,
+// which takes nParams parameters, by examining the cursors opened by its
+// bytecode.
+func stmtTables(ctx context.Context, conn *sql.Conn, query string, nParams int) ([]string, error) {
+	type rootPage struct {
+		db   int64
+		page int64
+	}
+	pages := make(map[rootPage]bool)
+	// Parameters are not used by EXPLAIN, but must still be supplied.
+	rows, err := conn.QueryContext(ctx, "EXPLAIN "+query, make([]interface{}, nParams)...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var addr, p1, p2, p3, p5 int64
+		var opcode string
+		var p4, comment interface{}
+		if err := rows.Scan(&addr, &opcode, &p1, &p2, &p3, &p4, &p5, &comment); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		switch opcode {
+		case "OpenRead", "OpenWrite":
+			if p5&opflagP2IsReg == 0 {
+				pages[rootPage{db: p3, page: p2}] = true
+			}
+		case "Clear":
+			pages[rootPage{db: p2, page: p1}] = true
+		case "Destroy":
+			pages[rootPage{db: p3, page: p1}] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, nil
+	}
+
+	schemas := make(map[int64]string)
+	rows, err = conn.QueryContext(ctx, "SELECT seq, name FROM pragma_database_list")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var seq int64
+		var name string
+		if err := rows.Scan(&seq, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schemas[seq] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tables := make(map[string]bool)
+	for rp := range pages {
+		schema, ok := schemas[rp.db]
+		if !ok {
+			continue
+		}
+		name := "sqlite_schema"
+		if rp.page != 1 {
+			q := fmt.Sprintf("SELECT tbl_name FROM %s.sqlite_schema WHERE rootpage = ?", quoteIdent(schema))
+			if err := conn.QueryRowContext(ctx, q, rp.page).Scan(&name); err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if schema != "main" {
+			name = schema + "." + name
+		}
+		tables[name] = true
+	}
+
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
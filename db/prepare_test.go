@@ -0,0 +1,94 @@
+package db
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_Prepare(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, "CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, age INTEGER)")
+	mustExecute(db, "CREATE INDEX bar_age ON bar(age)")
+
+	for _, tt := range []struct {
+		name string
+		stmt string
+		exp  string
+	}{
+		{
+			name: "Select",
+			stmt: "SELECT * FROM foo WHERE id = ?",
+			exp:  `{"ReadOnly":true,"ParamCount":1,"Tables":["foo"]}`,
+		},
+		{
+			name: "SelectJoinIndex",
+			stmt: "SELECT foo.name FROM foo JOIN bar ON foo.id = bar.id WHERE bar.age > 10",
+			exp:  `{"ReadOnly":true,"ParamCount":0,"Tables":["bar","foo"]}`,
+		},
+		{
+			name: "Insert",
+			stmt: "INSERT INTO foo(id, name) VALUES(?, ?)",
+			exp:  `{"ReadOnly":false,"ParamCount":2,"Tables":["foo"]}`,
+		},
+		{
+			name: "InsertNamed",
+			stmt: "INSERT INTO bar(id, age) VALUES(:id, :age)",
+			exp:  `{"ReadOnly":false,"ParamCount":2,"Tables":["bar"]}`,
+		},
+		{
+			name: "DeleteAll",
+			stmt: "DELETE FROM foo",
+			exp:  `{"ReadOnly":false,"ParamCount":0,"Tables":["foo"]}`,
+		},
+		{
+			name: "CreateTable",
+			stmt: "CREATE TABLE qux (id INTEGER)",
+			exp:  `{"ReadOnly":false,"ParamCount":0,"Tables":["sqlite_schema"]}`,
+		},
+		{
+			name: "PragmaRead",
+			stmt: "PRAGMA user_version",
+			exp:  `{"ReadOnly":true,"ParamCount":0,"Tables":null}`,
+		},
+		{
+			name: "PragmaWrite",
+			stmt: "PRAGMA user_version=5",
+			exp:  `{"ReadOnly":false,"ParamCount":0,"Tables":null}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := db.Prepare(tt.stmt)
+			if err != nil {
+				t.Fatalf("failed to prepare %s: %s", tt.stmt, err.Error())
+			}
+			if got := asJSON(info); tt.exp != got {
+				t.Fatalf("wrong info for %s, exp %s, got %s", tt.stmt, tt.exp, got)
+			}
+		})
+	}
+
+	// Nothing was executed.
+	r, err := db.QueryStringStmt("SELECT COUNT(*) FROM sqlite_schema WHERE name = 'qux'")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[0]]}]`, asJSON(r); exp != got {
+		t.Fatalf("table created by Prepare, exp %s, got %s", exp, got)
+	}
+	r, err = db.QueryStringStmt("PRAGMA user_version")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["user_version"],"types":["integer"],"values":[[0]]}]`, asJSON(r); exp != got {
+		t.Fatalf("user version set by Prepare, exp %s, got %s", exp, got)
+	}
+
+	for _, stmt := range []string{"SELECT * FROM nonexistent", "SELEC 1"} {
+		if _, err := db.Prepare(stmt); err == nil {
+			t.Fatalf("expected error preparing %s", stmt)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// Kinds of reader reported by ActiveReaders.
+const (
+	// ReaderQuery is a query made with Query or QueryStringStmt.
+	ReaderQuery = "query"
+
+	// ReaderStream is a query made with QueryStream.
+	ReaderStream = "stream"
+
+	// ReaderCSV is a query made with QueryStringStmtCSV.
+	ReaderCSV = "csv"
+
+	// ReaderArrow is a query made with QueryStringStmtArrow.
+	ReaderArrow = "arrow"
+
+	// ReaderSnapshot is an open SnapshotTx.
+	ReaderSnapshot = "snapshot"
+
+	// ReaderTx is an open Tx.
+	ReaderTx = "transaction"
+)
+
+// ReaderInfo describes a reader of the database, as reported by
+// ActiveReaders.
+type ReaderInfo struct {
+	// ID identifies the reader, and is unique for the lifetime of the
+	// database.
+	ID uint64 `json:"id"`
+
+	// Kind is the kind of reader, one of the Reader constants.
+	Kind string `json:"kind"`
+
+	// SQL is the SQL being read, if known. Statements of a multi-statement
+	// query are separated by "; ". SQL is empty for a SnapshotTx or Tx, which
+	// may run many queries.
+	SQL string `json:"sql,omitempty"`
+
+	// Started is when the reader started.
+	Started time.Time `json:"started"`
+
+	// Duration is how long the reader had been open when ActiveReaders was
+	// called.
+	Duration time.Duration `json:"duration"`
+}
+
+// readerSet is the set of readers in progress on a database.
+type readerSet struct {
+	mu     sync.Mutex
+	nextID uint64
+	m      map[uint64]ReaderInfo
+}
+
+// add records that a reader of the given kind has started, and returns its
+// ID, which must be passed to remove when the reader finishes.
+func (r *readerSet) add(kind, sql string) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.m == nil {
+		r.m = make(map[uint64]ReaderInfo)
+	}
+	r.nextID++
+	r.m[r.nextID] = ReaderInfo{
+		ID:      r.nextID,
+		Kind:    kind,
+		SQL:     sql,
+		Started: time.Now(),
+	}
+	return r.nextID
+}
+
+// remove records that the reader with the given ID has finished.
+func (r *readerSet) remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.m, id)
+}
+
+// list returns the readers in progress, oldest first.
+func (r *readerSet) list() []ReaderInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	readers := make([]ReaderInfo, 0, len(r.m))
+	for _, ri := range r.m {
+		ri.Duration = now.Sub(ri.Started)
+		readers = append(readers, ri)
+	}
+	sort.Slice(readers, func(i, j int) bool { return readers[i].ID < readers[j].ID })
+	return readers
+}
+
+// len returns the number of readers in progress.
+func (r *readerSet) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.m)
+}
+
+// ActiveReaders returns the readers of the database in progress, oldest
+// first. In WAL mode a reader holds a read transaction, which prevents RESTART
+// and TRUNCATE checkpoints from completing, so a checkpoint which fails with
+// ErrCheckpointTimeout can be attributed to the long-running readers
+// reported here.
+//
+// SQLite provides no way to list the connections holding read locks, so the
+// database tracks its readers itself. Every query made through the database
+// is reported while it runs, as is every SnapshotTx and Tx while it is open,
+// but readers outside the database's control, such as another process with
+// the database file open, are not.
+func (db *DB) ActiveReaders() []ReaderInfo {
+	return db.readers.list()
+}
+
+// NumActiveReaders returns the number of readers of the database in
+// progress, as reported by ActiveReaders.
+func (db *DB) NumActiveReaders() int {
+	return db.readers.len()
+}
+
+// logActiveReaders logs the number of readers in progress, and the oldest of
+// them, so that a checkpoint timeout can be attributed to it.
+func (db *DB) logActiveReaders() {
+	readers := db.readers.list()
+	if len(readers) == 0 {
+		return
+	}
+	r := readers[0]
+	msg := fmt.Sprintf("checkpoint timed out with %d active readers, oldest is %s reader %d open for %s",
+		len(readers), r.Kind, r.ID, r.Duration)
+	if r.SQL != "" {
+		msg += fmt.Sprintf(": %q", r.SQL)
+	}
+	db.logger.Print(msg)
+}
+
+// requestSQL returns the SQL of the statements in req, separated by "; ".
+func requestSQL(req *command.Request) string {
+	stmts := make([]string, len(req.Statements))
+	for i, s := range req.Statements {
+		stmts[i] = s.Sql
+	}
+	return strings.Join(stmts, "; ")
+}
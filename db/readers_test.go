@@ -0,0 +1,104 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_ActiveReaders(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	if n := db.NumActiveReaders(); n != 0 {
+		t.Fatalf("expected no active readers, got %d", n)
+	}
+
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+
+	var streamed []ReaderInfo
+	if err := db.QueryStream("SELECT name FROM foo", func(row []interface{}) error {
+		streamed = db.ActiveReaders()
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to stream query: %s", err.Error())
+	}
+	if exp, got := 3, len(streamed); exp != got {
+		t.Fatalf("wrong number of active readers while streaming, exp %d, got %d", exp, got)
+	}
+	for i, exp := range []ReaderInfo{
+		{Kind: ReaderSnapshot},
+		{Kind: ReaderTx},
+		{Kind: ReaderStream, SQL: "SELECT name FROM foo"},
+	} {
+		got := streamed[i]
+		if got.Kind != exp.Kind || got.SQL != exp.SQL {
+			t.Fatalf("wrong reader %d, exp %s %q, got %s %q", i, exp.Kind, exp.SQL, got.Kind, got.SQL)
+		}
+		if got.Started.IsZero() || got.Duration < 0 {
+			t.Fatalf("reader %d has bad timing: %+v", i, got)
+		}
+	}
+	if streamed[0].ID >= streamed[1].ID || streamed[1].ID >= streamed[2].ID {
+		t.Fatalf("readers not ordered oldest first: %+v", streamed)
+	}
+
+	// The stream has finished, so is no longer reported.
+	if exp, got := 2, db.NumActiveReaders(); exp != got {
+		t.Fatalf("wrong number of active readers, exp %d, got %d", exp, got)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back transaction: %s", err.Error())
+	}
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if exp, got := 1, stats["active_readers"]; exp != got {
+		t.Fatalf("wrong active readers in stats, exp %d, got %v", exp, got)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatalf("failed to close snapshot reader: %s", err.Error())
+	}
+	if n := db.NumActiveReaders(); n != 0 {
+		t.Fatalf("expected no active readers after closing, got %d", n)
+	}
+}
+
+// Test_ActiveReaders_CheckpointTimeout tests that the reader blocking a
+// checkpoint is reported.
+func Test_ActiveReaders_CheckpointTimeout(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	defer snap.Close()
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, 100*time.Millisecond); !errors.Is(err, ErrCheckpointTimeout) {
+		t.Fatalf("expected ErrCheckpointTimeout, got %v", err)
+	}
+	readers := db.ActiveReaders()
+	if len(readers) != 1 || readers[0].Kind != ReaderSnapshot {
+		t.Fatalf("expected the snapshot reader to be reported, got %+v", readers)
+	}
+	if readers[0].Duration < 100*time.Millisecond {
+		t.Fatalf("expected reader to have been open for the checkpoint timeout, got %s", readers[0].Duration)
+	}
+}
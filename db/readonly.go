@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+)
+
+// OpenReadOnly opens the existing database file at path such that it cannot
+// be changed. Every connection is opened read-only and immutable, so SQLite
+// neither writes to the file nor creates a WAL or shared-memory file beside
+// it, and any attempt to execute a write returns an error. This is intended
+// for inspecting databases which nothing else is accessing, such as backup
+// files. Since the file is assumed not to change, any WAL beside it is
+// ignored, so only the changes checkpointed into the database are visible.
+func OpenReadOnly(path string) (retDB *DB, retErr error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	dsn := makeReadOnlyDSN(path)
+
+	var pools []*sql.DB
+	defer func() {
+		if retErr != nil {
+			for _, p := range pools {
+				p.Close()
+			}
+		}
+	}()
+	open := func() (*sql.DB, error) {
+		p, err := sql.Open("sqlite3", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("open: %s", err.Error())
+		}
+		pools = append(pools, p)
+		return p, nil
+	}
+	rwDB, err := open()
+	if err != nil {
+		return nil, err
+	}
+	roDB, err := open()
+	if err != nil {
+		return nil, err
+	}
+	rodDB, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if err := rwDB.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping on-disk database: %s", err.Error())
+	}
+
+	cfg := NewConfig()
+	logger := log.New(log.Writer(), "[db] ", log.LstdFlags)
+	return &DB{
+		path:        path,
+		walPath:     WALPath(path),
+		rwDB:        rwDB,
+		roDB:        roDB,
+		rodDB:       rodDB,
+		rwDSN:       dsn,
+		roDSN:       dsn,
+		slowLogger:  newSlowQueryLogger(cfg, logger),
+		logger:      logger,
+		writeQueue:  newWriteQueue(cfg.WriteQueueDepth),
+		busyRetrier: newBusyRetrier(cfg),
+	}, nil
+}
+
+// makeReadOnlyDSN returns a DSN which opens the database at path read-only
+// and immutable. No journal mode is set, since setting it would require a
+// write.
+func makeReadOnlyDSN(path string) string {
+	opts := url.Values{}
+	opts.Add("mode", "ro")
+	opts.Add("immutable", "1")
+	opts.Add("_sync", "0")
+	return fmt.Sprintf("file:%s?%s", path, opts.Encode())
+}
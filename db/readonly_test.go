@@ -0,0 +1,75 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_OpenReadOnly(t *testing.T) {
+	for _, wal := range []bool{false, true} {
+		path := mustTempFile()
+		defer os.Remove(path)
+
+		db, err := Open(path, false, wal)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err.Error())
+		}
+		mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close database: %s", err.Error())
+		}
+		if fileExists(WALPath(path)) || fileExists(path+"-shm") {
+			t.Fatalf("WAL or SHM file exists after close")
+		}
+		before, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read database file: %s", err.Error())
+		}
+
+		rdb, err := OpenReadOnly(path)
+		if err != nil {
+			t.Fatalf("failed to open database read-only: %s", err.Error())
+		}
+		q, err := rdb.QueryStringStmt("SELECT * FROM foo")
+		if err != nil {
+			t.Fatalf("failed to query read-only database: %s", err.Error())
+		}
+		if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(q); exp != got {
+			t.Fatalf("unexpected results for query, expected %s, got %s", exp, got)
+		}
+		r, err := rdb.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("declan")`)
+		if err == nil && (len(r) == 0 || r[0].GetError() == "") {
+			t.Fatalf("expected error executing on read-only database")
+		}
+		if err == nil && !strings.Contains(r[0].GetError(), "readonly") {
+			t.Fatalf("unexpected error executing on read-only database: %s", r[0].GetError())
+		}
+
+		if fileExists(WALPath(path)) || fileExists(path+"-shm") {
+			t.Fatalf("WAL or SHM file created by read-only database")
+		}
+		if err := rdb.Close(); err != nil {
+			t.Fatalf("failed to close read-only database: %s", err.Error())
+		}
+		after, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read database file: %s", err.Error())
+		}
+		if !bytes.Equal(before, after) {
+			t.Fatalf("database file changed by read-only database")
+		}
+	}
+}
+
+func Test_OpenReadOnlyNonExistent(t *testing.T) {
+	path := mustTempPath()
+	if _, err := OpenReadOnly(path); err == nil {
+		t.Fatalf("expected error opening non-existent database read-only")
+	}
+	if fileExists(path) {
+		t.Fatalf("database file created by read-only open")
+	}
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrInvalidRestore is returned when data passed to RestoreFromReader is not
+// a valid backup in the given format.
+var ErrInvalidRestore = errors.New("invalid restore data")
+
+// BackupFormat is the format of backup data.
+type BackupFormat int
+
+const (
+	// BackupFormatBinary is a SQLite database file, as written by Backup.
+	BackupFormatBinary BackupFormat = iota
+
+	// BackupFormatSQL is SQL text, as written by Dump.
+	BackupFormatSQL
+)
+
+// String returns the string representation of the backup format.
+func (f BackupFormat) String() string {
+	switch f {
+	case BackupFormatBinary:
+		return "binary"
+	case BackupFormatSQL:
+		return "sql"
+	default:
+		return "unknown"
+	}
+}
+
+// RestoreFromReader replaces the contents of the database with the backup,
+// in the given format, read from r. The backup is first rebuilt into a
+// temporary database, which then overwrites the database using the SQLite
+// Online Backup API, so if the backup cannot be read or loaded the database
+// is left unchanged.
+func (db *DB) RestoreFromReader(r io.Reader, format BackupFormat) error {
+	dir := ""
+	if !db.memory {
+		dir = filepath.Dir(db.path)
+	}
+	tmpFile, err := os.CreateTemp(dir, "rqlite-restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	switch format {
+	case BackupFormatBinary:
+		if _, err := io.Copy(tmpFile, r); err != nil {
+			return err
+		}
+		if err := tmpFile.Close(); err != nil {
+			return err
+		}
+		if !IsValidSQLiteFile(tmpFile.Name()) {
+			return fmt.Errorf("%w: not a SQLite file", ErrInvalidRestore)
+		}
+	case BackupFormatSQL:
+		if err := tmpFile.Close(); err != nil {
+			return err
+		}
+		if err := loadSQL(tmpFile.Name(), r); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unsupported format %s", ErrInvalidRestore, format)
+	}
+
+	if err := db.restoreMain(tmpFile.Name()); err != nil {
+		return fmt.Errorf("restore database: %s", err.Error())
+	}
+	return nil
+}
+
+// loadSQL creates a database at path, by executing the SQL text read from r.
+func loadSQL(path string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	tmpDB, err := Open(path, false, false)
+	if err != nil {
+		return err
+	}
+	defer tmpDB.Close()
+	res, err := tmpDB.ExecuteStringStmt(string(b))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRestore, err.Error())
+	}
+	for _, r := range res {
+		if e := r.GetError(); e != "" {
+			return fmt.Errorf("%w: %s", ErrInvalidRestore, e)
+		}
+	}
+	return tmpDB.Close()
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func Test_RestoreFromReader(t *testing.T) {
+	srcDB, srcPath := mustCreateOnDiskDatabaseWAL()
+	defer srcDB.Close()
+	defer os.Remove(srcPath)
+	mustExecute(srcDB, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 100; i++ {
+		mustExecute(srcDB, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+
+	bakPath := mustTempFile()
+	defer os.Remove(bakPath)
+	if err := srcDB.Backup(bakPath, false); err != nil {
+		t.Fatalf("failed to back up database: %s", err.Error())
+	}
+	var sqlBuf bytes.Buffer
+	if err := srcDB.Dump(&sqlBuf); err != nil {
+		t.Fatalf("failed to dump database: %s", err.Error())
+	}
+
+	for _, tt := range []struct {
+		format BackupFormat
+		data   []byte
+	}{
+		{BackupFormatBinary, mustReadBytes(bakPath)},
+		{BackupFormatSQL, sqlBuf.Bytes()},
+	} {
+		for _, wal := range []bool{false, true} {
+			path := mustTempFile()
+			defer os.Remove(path)
+			dstDB, err := Open(path, false, wal)
+			if err != nil {
+				t.Fatalf("failed to open database: %s", err.Error())
+			}
+			defer dstDB.Close()
+			mustExecute(dstDB, "CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY)")
+
+			if err := dstDB.RestoreFromReader(bytes.NewReader(tt.data), tt.format); err != nil {
+				t.Fatalf("failed to restore %s backup: %s", tt.format, err.Error())
+			}
+			rows, err := dstDB.QueryStringStmt("SELECT COUNT(*) FROM foo")
+			if err != nil {
+				t.Fatalf("failed to query restored database: %s", err.Error())
+			}
+			if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[100]]}]`, asJSON(rows); exp != got {
+				t.Fatalf("wrong rows after %s restore, exp %s, got %s", tt.format, exp, got)
+			}
+
+			// The previous contents are replaced.
+			rows, err = dstDB.QueryStringStmt("SELECT COUNT(*) FROM bar")
+			if err != nil {
+				t.Fatalf("failed to query restored database: %s", err.Error())
+			}
+			if exp, got := `[{"error":"no such table: bar"}]`, asJSON(rows); exp != got {
+				t.Fatalf("wrong rows for replaced table after %s restore, exp %s, got %s", tt.format, exp, got)
+			}
+		}
+	}
+}
+
+func Test_RestoreFromReader_Invalid(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+
+	for _, tt := range []struct {
+		format BackupFormat
+		data   string
+	}{
+		{BackupFormatBinary, "not a database"},
+		{BackupFormatSQL, "CREATE TABLE bar (id INTEGER); INSERT INTO nonexistent VALUES(1);"},
+		{BackupFormat(99), ""},
+	} {
+		err := db.RestoreFromReader(bytes.NewReader([]byte(tt.data)), tt.format)
+		if !errors.Is(err, ErrInvalidRestore) {
+			t.Fatalf("expected ErrInvalidRestore for %s restore, got %v", tt.format, err)
+		}
+	}
+
+	// The database must be unchanged.
+	rows, err := db.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("database changed by failed restore, exp %s, got %s", exp, got)
+	}
+	rows, err = db.QueryStringStmt("SELECT COUNT(*) FROM bar")
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err.Error())
+	}
+	if exp, got := `[{"error":"no such table: bar"}]`, asJSON(rows); exp != got {
+		t.Fatalf("database changed by failed restore, exp %s, got %s", exp, got)
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// TablesOptions controls which tables TablesWithOptions returns.
+type TablesOptions struct {
+	// IncludeInternal, if true, includes the tables SQLite creates for its own
+	// use, whose names begin with "sqlite_", such as sqlite_sequence.
+	IncludeInternal bool
+}
+
+// Tables returns the names of the tables in the database, sorted by name.
+// Internal SQLite tables are not included.
+func (db *DB) Tables() ([]string, error) {
+	return db.TablesWithOptions(TablesOptions{})
+}
+
+// TablesWithOptions returns the names of the tables in the database, sorted
+// by name, as controlled by opts.
+func (db *DB) TablesWithOptions(opts TablesOptions) ([]string, error) {
+	query := `SELECT "name" FROM "sqlite_master" WHERE "type" = 'table'`
+	if !opts.IncludeInternal {
+		query += ` AND "name" NOT LIKE 'sqlite_%'`
+	}
+	query += ` ORDER BY "name"`
+	rows, err := db.schemaQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(rows.Values))
+	for _, v := range rows.Values {
+		tables = append(tables, v.Parameters[0].GetS())
+	}
+	return tables, nil
+}
+
+// Schema returns the SQL which creates the tables, indexes, views, and
+// triggers of the database, in the order they were created, with each
+// statement terminated by a semicolon and a newline. Internal SQLite objects,
+// and indexes created automatically by SQLite, are not included.
+func (db *DB) Schema() (string, error) {
+	rows, err := db.schemaQuery(`SELECT "sql" FROM "sqlite_master"
+WHERE "sql" NOT NULL AND "name" NOT LIKE 'sqlite_%' ORDER BY "rowid"`)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, v := range rows.Values {
+		b.WriteString(v.Parameters[0].GetS())
+		b.WriteString(";\n")
+	}
+	return b.String(), nil
+}
+
+// schemaQuery runs a query against sqlite_master, which returns a single
+// column.
+func (db *DB) schemaQuery(query string) (*command.QueryRows, error) {
+	rows, err := db.QueryStringStmt(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("unexpected number of results querying schema: %d", len(rows))
+	}
+	if rows[0].Error != "" {
+		return nil, fmt.Errorf("querying schema: %s", rows[0].Error)
+	}
+	return rows[0], nil
+}
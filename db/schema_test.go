@@ -0,0 +1,74 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_TablesSchema(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	tables, err := db.Tables()
+	if err != nil {
+		t.Fatalf("failed to get tables: %s", err.Error())
+	}
+	if len(tables) != 0 {
+		t.Fatalf("expected no tables, got %v", tables)
+	}
+	schema, err := db.Schema()
+	if err != nil {
+		t.Fatalf("failed to get schema: %s", err.Error())
+	}
+	if schema != "" {
+		t.Fatalf("expected empty schema, got %s", schema)
+	}
+
+	mustExecute(db, `CREATE TABLE foo (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE)`)
+	mustExecute(db, `CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, age INTEGER)`)
+	mustExecute(db, `CREATE INDEX bar_age ON bar(age)`)
+	mustExecute(db, `CREATE VIEW foobar AS SELECT * FROM foo, bar`)
+	mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+
+	tables, err = db.Tables()
+	if err != nil {
+		t.Fatalf("failed to get tables: %s", err.Error())
+	}
+	if exp := []string{"bar", "foo"}; !reflect.DeepEqual(exp, tables) {
+		t.Fatalf("unexpected tables, exp %v, got %v", exp, tables)
+	}
+	tables, err = db.TablesWithOptions(TablesOptions{IncludeInternal: true})
+	if err != nil {
+		t.Fatalf("failed to get tables: %s", err.Error())
+	}
+	if exp := []string{"bar", "foo", "sqlite_sequence"}; !reflect.DeepEqual(exp, tables) {
+		t.Fatalf("unexpected tables including internal, exp %v, got %v", exp, tables)
+	}
+
+	schema, err = db.Schema()
+	if err != nil {
+		t.Fatalf("failed to get schema: %s", err.Error())
+	}
+	exp := "CREATE TABLE foo (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE);\n" +
+		"CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, age INTEGER);\n" +
+		"CREATE INDEX bar_age ON bar(age);\n" +
+		"CREATE VIEW foobar AS SELECT * FROM foo, bar;\n"
+	if exp != schema {
+		t.Fatalf("unexpected schema, exp:\n%s\ngot:\n%s", exp, schema)
+	}
+
+	// The schema must recreate the same tables in a new database.
+	db2, path2 := mustCreateOnDiskDatabaseWAL()
+	defer db2.Close()
+	defer os.Remove(path2)
+	mustExecute(db2, schema)
+	tables, err = db2.Tables()
+	if err != nil {
+		t.Fatalf("failed to get tables: %s", err.Error())
+	}
+	if exp := []string{"bar", "foo"}; !reflect.DeepEqual(exp, tables) {
+		t.Fatalf("unexpected tables in recreated database, exp %v, got %v", exp, tables)
+	}
+}
@@ -0,0 +1,426 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// The rqlite build of SQLite does not include the session extension, so
+// sessions are implemented using the update, commit, and rollback hooks. Only
+// the rowid of each changed row is recorded as changes are made, and the
+// values of the row are read when the changeset is generated. A changeset
+// therefore records the net effect of the changes, using the latest values of
+// each changed row. Changesets use their own format, and are not compatible
+// with those of the SQLite session extension.
+
+// ChangeOp is the type of change made to a row.
+type ChangeOp int
+
+const (
+	// ChangeInsert is a row insertion.
+	ChangeInsert ChangeOp = iota + 1
+	// ChangeUpdate is a row update.
+	ChangeUpdate
+	// ChangeDelete is a row deletion.
+	ChangeDelete
+)
+
+// Change is a single change in a changeset.
+type Change struct {
+	Table   string        `json:"table"`
+	Op      ChangeOp      `json:"op"`
+	RowID   int64         `json:"rowid"`
+	Columns []string      `json:"columns,omitempty"`
+	Values  []ChangeValue `json:"values,omitempty"`
+}
+
+// ChangeValue is a single value in a Change. Exactly one field is set, or
+// none for NULL.
+type ChangeValue struct {
+	I *int64   `json:"i,omitempty"`
+	D *float64 `json:"d,omitempty"`
+	S *string  `json:"s,omitempty"`
+	Y []byte   `json:"y,omitempty"`
+}
+
+// Value returns the Go value of v.
+func (v ChangeValue) Value() interface{} {
+	switch {
+	case v.I != nil:
+		return *v.I
+	case v.D != nil:
+		return *v.D
+	case v.S != nil:
+		return *v.S
+	case v.Y != nil:
+		return v.Y
+	default:
+		return nil
+	}
+}
+
+// ConflictType is the type of conflict encountered applying a change.
+type ConflictType int
+
+const (
+	// ConflictNotFound means the row to be updated or deleted does not exist.
+	ConflictNotFound ConflictType = iota + 1
+	// ConflictConstraint means applying the change violates a constraint,
+	// for example because the row to be inserted already exists.
+	ConflictConstraint
+)
+
+// ConflictAction is the action to take on a conflict.
+type ConflictAction int
+
+const (
+	// ConflictAbort aborts applying the changeset, and rolls back any changes
+	// already applied.
+	ConflictAbort ConflictAction = iota
+	// ConflictOmit skips the conflicting change.
+	ConflictOmit
+	// ConflictReplace replaces any conflicting row with the change. For a
+	// deletion of a row which does not exist, it is the same as ConflictOmit.
+	ConflictReplace
+)
+
+// ConflictHandler decides the action to take when a change conflicts with
+// the database it is being applied to.
+type ConflictHandler func(ConflictType, *Change) ConflictAction
+
+// ErrChangesetAborted is returned by ApplyChangeset when a conflict handler
+// aborts applying a changeset.
+var ErrChangesetAborted = errors.New("changeset aborted")
+
+type sessionKey struct {
+	table string
+	rowid int64
+}
+
+type sessionChange struct {
+	key sessionKey
+	op  ChangeOp
+}
+
+// Session records the changes made to a database, so they can be applied to
+// another database. A Session must be closed when no longer needed.
+type Session struct {
+	db     *DB
+	tables map[string]bool
+
+	mu      sync.Mutex
+	pending []sessionChange
+	changes map[sessionKey]ChangeOp
+	order   []sessionKey
+}
+
+// StartSession starts recording changes made to the given tables of the
+// main database. If tables is empty, changes to every table are recorded.
+// Only changes to rowid tables are recorded, and rows are identified by their
+// rowid, so tables should have an INTEGER PRIMARY KEY if changesets are to be
+// applied to a database with different contents. Changes which modify the
+// rowid of an existing row are not recorded correctly.
+func (db *DB) StartSession(tables []string) (*Session, error) {
+	s := &Session{
+		db:      db,
+		changes: make(map[sessionKey]ChangeOp),
+	}
+	if len(tables) > 0 {
+		s.tables = make(map[string]bool, len(tables))
+		for _, t := range tables {
+			s.tables[strings.ToLower(t)] = true
+		}
+	}
+	if err := db.setHooks(func(h *hookSet) {
+		if h.sessions == nil {
+			h.sessions = make(map[*Session]struct{})
+		}
+		h.sessions[s] = struct{}{}
+	}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close stops recording changes.
+func (s *Session) Close() error {
+	return s.db.setHooks(func(h *hookSet) {
+		delete(h.sessions, s)
+	})
+}
+
+// record records a change made within the current transaction.
+func (s *Session) record(op int, dbName, table string, rowid int64) {
+	if dbName != "main" || (s.tables != nil && !s.tables[strings.ToLower(table)]) {
+		return
+	}
+	var cop ChangeOp
+	switch op {
+	case UpdateHookInsert:
+		cop = ChangeInsert
+	case UpdateHookUpdate:
+		cop = ChangeUpdate
+	case UpdateHookDelete:
+		cop = ChangeDelete
+	default:
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, sessionChange{key: sessionKey{table, rowid}, op: cop})
+}
+
+// commit merges the changes made within the committed transaction into the
+// session.
+func (s *Session) commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.pending {
+		prev, ok := s.changes[c.key]
+		if !ok {
+			s.changes[c.key] = c.op
+			s.order = append(s.order, c.key)
+			continue
+		}
+		switch {
+		case prev == ChangeInsert && c.op == ChangeDelete:
+			// The row never existed as far as the changeset is concerned.
+			delete(s.changes, c.key)
+		case prev == ChangeInsert:
+			// Still an insert, of the latest values.
+		case prev == ChangeDelete && c.op == ChangeInsert:
+			s.changes[c.key] = ChangeUpdate
+		default:
+			s.changes[c.key] = c.op
+		}
+	}
+	s.pending = nil
+}
+
+// rollback discards the changes made within the rolled-back transaction.
+func (s *Session) rollback() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = nil
+}
+
+// Changeset returns the changes recorded by the session, in the order the
+// rows were first changed. Inserted and updated rows carry their current
+// values, which are read within a single read transaction.
+func (s *Session) Changeset() ([]byte, error) {
+	s.mu.Lock()
+	var changes []sessionChange
+	for _, k := range s.order {
+		if op, ok := s.changes[k]; ok {
+			changes = append(changes, sessionChange{key: k, op: op})
+		}
+	}
+	s.mu.Unlock()
+
+	ctx := context.Background()
+	conn, err := s.db.roDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var cs []*Change
+	for _, sc := range changes {
+		c := &Change{Table: sc.key.table, Op: sc.op, RowID: sc.key.rowid}
+		if sc.op != ChangeDelete {
+			columns, values, err := readRowByRowID(ctx, tx, sc.key.table, sc.key.rowid)
+			if err != nil {
+				return nil, err
+			}
+			if columns == nil {
+				// The table has since been dropped or the row deleted
+				// without the change being recorded.
+				continue
+			}
+			c.Columns, c.Values = columns, values
+		}
+		cs = append(cs, c)
+	}
+	return json.Marshal(cs)
+}
+
+// readRowByRowID reads the row with the given rowid. It returns nil if the
+// row does not exist.
+func readRowByRowID(ctx context.Context, tx *sql.Tx, table string, rowid int64) ([]string, []ChangeValue, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s WHERE rowid = ?", quoteIdent(table)), rowid)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rows.Next() {
+		return nil, nil, rows.Err()
+	}
+	dest := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(dest))
+	for i := range ptrs {
+		ptrs[i] = &dest[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, nil, err
+	}
+	values := make([]ChangeValue, len(dest))
+	for i, v := range dest {
+		switch val := v.(type) {
+		case int64:
+			values[i].I = &val
+		case float64:
+			values[i].D = &val
+		case string:
+			values[i].S = &val
+		case []byte:
+			values[i].Y = val
+		case bool:
+			var n int64
+			if val {
+				n = 1
+			}
+			values[i].I = &n
+		case nil:
+		default:
+			s := fmt.Sprintf("%v", val)
+			values[i].S = &s
+		}
+	}
+	return columns, values, nil
+}
+
+// ApplyChangeset applies a changeset, as returned by Session.Changeset, to the
+// database, within a single transaction. handler is called for any change
+// which conflicts with the database; if handler is nil, applying the
+// changeset is aborted on the first conflict. If applying the changeset is
+// aborted an error wrapping ErrChangesetAborted is returned, and the database
+// is unchanged.
+func (db *DB) ApplyChangeset(changeset []byte, handler ConflictHandler) (retErr error) {
+	var cs []*Change
+	if err := json.Unmarshal(changeset, &cs); err != nil {
+		return fmt.Errorf("invalid changeset: %s", err.Error())
+	}
+	if handler == nil {
+		handler = func(ConflictType, *Change) ConflictAction { return ConflictAbort }
+	}
+
+	ctx := context.Background()
+	conn, err := db.rwDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, c := range cs {
+		if err := applyChange(ctx, tx, c, handler); err != nil {
+			return fmt.Errorf("table %s, rowid %d: %w", c.Table, c.RowID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func applyChange(ctx context.Context, tx *sql.Tx, c *Change, handler ConflictHandler) error {
+	table := quoteIdent(c.Table)
+	args := make([]interface{}, 0, len(c.Values)+1)
+	for _, v := range c.Values {
+		args = append(args, v.Value())
+	}
+
+	insert := func(verb string) error {
+		cols := make([]string, len(c.Columns))
+		for i := range c.Columns {
+			cols[i] = quoteIdent(c.Columns[i])
+		}
+		q := fmt.Sprintf("%s INTO %s(rowid, %s) VALUES(?%s)", verb, table,
+			strings.Join(cols, ", "), strings.Repeat(", ?", len(cols)))
+		_, err := tx.ExecContext(ctx, q, append([]interface{}{c.RowID}, args...)...)
+		return err
+	}
+	resolve := func(ct ConflictType, replace func() error) error {
+		switch handler(ct, c) {
+		case ConflictOmit:
+			return nil
+		case ConflictReplace:
+			if replace == nil {
+				return nil
+			}
+			return replace()
+		default:
+			return ErrChangesetAborted
+		}
+	}
+
+	switch c.Op {
+	case ChangeInsert:
+		if err := insert("INSERT"); err != nil {
+			if !isConstraintError(err) {
+				return err
+			}
+			return resolve(ConflictConstraint, func() error { return insert("INSERT OR REPLACE") })
+		}
+		return nil
+	case ChangeUpdate:
+		sets := make([]string, len(c.Columns))
+		for i := range c.Columns {
+			sets[i] = quoteIdent(c.Columns[i]) + " = ?"
+		}
+		q := fmt.Sprintf("UPDATE %s SET %s WHERE rowid = ?", table, strings.Join(sets, ", "))
+		r, err := tx.ExecContext(ctx, q, append(args, c.RowID)...)
+		if err != nil {
+			if !isConstraintError(err) {
+				return err
+			}
+			return resolve(ConflictConstraint, func() error { return insert("INSERT OR REPLACE") })
+		}
+		if n, err := r.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return resolve(ConflictNotFound, func() error { return insert("INSERT OR REPLACE") })
+		}
+		return nil
+	case ChangeDelete:
+		r, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE rowid = ?", table), c.RowID)
+		if err != nil {
+			return err
+		}
+		if n, err := r.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return resolve(ConflictNotFound, nil)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown change operation %d", c.Op)
+	}
+}
+
+// isConstraintError returns whether err is a SQLite constraint violation.
+func isConstraintError(err error) bool {
+	return strings.Contains(err.Error(), "constraint failed")
+}
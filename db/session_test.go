@@ -0,0 +1,152 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_SessionChangesetRoundTrip(t *testing.T) {
+	src, srcPath := mustCreateOnDiskDatabaseWAL()
+	defer src.Close()
+	defer os.Remove(srcPath)
+	dst, dstPath := mustCreateOnDiskDatabaseWAL()
+	defer dst.Close()
+	defer os.Remove(dstPath)
+
+	for _, d := range []*DB{src, dst} {
+		mustExecute(d, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, age REAL, data BLOB)")
+		mustExecute(d, "CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+		mustExecute(d, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+		mustExecute(d, `INSERT INTO foo(id, name) VALUES(2, "declan")`)
+	}
+
+	sess, err := src.StartSession([]string{"foo"})
+	if err != nil {
+		t.Fatalf("failed to start session: %s", err.Error())
+	}
+	defer sess.Close()
+
+	mustExecute(src, `INSERT INTO foo(id, name, age, data) VALUES(3, "aoife", 20.5, x'0102')`)
+	mustExecute(src, `UPDATE foo SET name = "fiona2" WHERE id = 1`)
+	mustExecute(src, `DELETE FROM foo WHERE id = 2`)
+	mustExecute(src, `INSERT INTO foo(id, name) VALUES(4, "gone")`)
+	mustExecute(src, `DELETE FROM foo WHERE id = 4`)
+	mustExecute(src, `INSERT INTO bar(id, name) VALUES(1, "untracked")`)
+
+	// A rolled-back transaction must not be recorded.
+	r, err := src.Execute(&command.Request{
+		Transaction: true,
+		Statements: []*command.Statement{
+			{Sql: `INSERT INTO foo(id, name) VALUES(5, "rolledback")`},
+			{Sql: `INSERT INTO nonexistent(id) VALUES(1)`},
+		},
+	}, false)
+	if err != nil {
+		t.Fatalf("failed to execute: %s", err.Error())
+	}
+	if r[len(r)-1].GetError() == "" {
+		t.Fatalf("expected transaction to fail")
+	}
+
+	cs, err := sess.Changeset()
+	if err != nil {
+		t.Fatalf("failed to get changeset: %s", err.Error())
+	}
+	if err := dst.ApplyChangeset(cs, nil); err != nil {
+		t.Fatalf("failed to apply changeset: %s", err.Error())
+	}
+
+	for _, q := range []string{"SELECT * FROM foo ORDER BY id", "SELECT * FROM bar"} {
+		exp, err := src.QueryStringStmt(q)
+		if err != nil {
+			t.Fatalf("failed to query source: %s", err.Error())
+		}
+		got, err := dst.QueryStringStmt(q)
+		if err != nil {
+			t.Fatalf("failed to query destination: %s", err.Error())
+		}
+		if q == "SELECT * FROM bar" {
+			if exp, got := `[{"columns":["id","name"],"types":["integer","text"]}]`, asJSON(got); exp != got {
+				t.Fatalf("untracked table changed, exp %s, got %s", exp, got)
+			}
+			continue
+		}
+		if asJSON(exp) != asJSON(got) {
+			t.Fatalf("databases differ for %s\nexp: %s\ngot: %s", q, asJSON(exp), asJSON(got))
+		}
+	}
+}
+
+func Test_SessionApplyChangesetConflicts(t *testing.T) {
+	src, srcPath := mustCreateOnDiskDatabaseWAL()
+	defer src.Close()
+	defer os.Remove(srcPath)
+	mustExecute(src, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(src, `INSERT INTO foo(id, name) VALUES(2, "declan")`)
+
+	sess, err := src.StartSession(nil)
+	if err != nil {
+		t.Fatalf("failed to start session: %s", err.Error())
+	}
+	defer sess.Close()
+	mustExecute(src, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	mustExecute(src, `DELETE FROM foo WHERE id = 2`)
+	cs, err := sess.Changeset()
+	if err != nil {
+		t.Fatalf("failed to get changeset: %s", err.Error())
+	}
+
+	newDst := func() (*DB, string) {
+		d, p := mustCreateOnDiskDatabaseWAL()
+		mustExecute(d, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+		mustExecute(d, `INSERT INTO foo(id, name) VALUES(1, "existing")`)
+		return d, p
+	}
+
+	// No handler aborts, leaving the database unchanged.
+	dst, dstPath := newDst()
+	defer dst.Close()
+	defer os.Remove(dstPath)
+	if err := dst.ApplyChangeset(cs, nil); !errors.Is(err, ErrChangesetAborted) {
+		t.Fatalf("expected ErrChangesetAborted, got %v", err)
+	}
+	rows, err := dst.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"existing"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong rows, exp %s, got %s", exp, got)
+	}
+
+	for _, tt := range []struct {
+		action ConflictAction
+		exp    string
+	}{
+		{ConflictOmit, `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"existing"]]}]`},
+		{ConflictReplace, `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`},
+	} {
+		dst, dstPath := newDst()
+		defer dst.Close()
+		defer os.Remove(dstPath)
+		var conflicts []ConflictType
+		if err := dst.ApplyChangeset(cs, func(ct ConflictType, c *Change) ConflictAction {
+			conflicts = append(conflicts, ct)
+			return tt.action
+		}); err != nil {
+			t.Fatalf("failed to apply changeset: %s", err.Error())
+		}
+		if len(conflicts) != 2 || conflicts[0] != ConflictConstraint || conflicts[1] != ConflictNotFound {
+			t.Fatalf("wrong conflicts: %v", conflicts)
+		}
+		rows, err := dst.QueryStringStmt("SELECT * FROM foo")
+		if err != nil {
+			t.Fatalf("failed to query: %s", err.Error())
+		}
+		if got := asJSON(rows); tt.exp != got {
+			t.Fatalf("wrong rows for action %d, exp %s, got %s", tt.action, tt.exp, got)
+		}
+	}
+}
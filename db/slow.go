@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+const (
+	slowQueryLogInterval = time.Second
+	slowQueryRedacted    = "<redacted>"
+)
+
+// slowQueryLogger logs statements which exceed a duration threshold. Logging
+// is rate-limited, so that a burst of slow statements does not flood the log.
+// Statements which are not logged because of rate-limiting are counted, and
+// the count is included in the next line logged.
+type slowQueryLogger struct {
+	threshold     time.Duration
+	logSQL        bool
+	redactParams  bool
+	minInterval   time.Duration
+	logger        *log.Logger
+	mu            sync.Mutex
+	lastLogged    time.Time
+	numSuppressed int
+}
+
+// newSlowQueryLogger returns a slowQueryLogger configured by cfg, or nil if
+// slow query logging is disabled.
+func newSlowQueryLogger(cfg *Config, logger *log.Logger) *slowQueryLogger {
+	if cfg.SlowQueryThreshold <= 0 {
+		return nil
+	}
+	return &slowQueryLogger{
+		threshold:    cfg.SlowQueryThreshold,
+		logSQL:       cfg.SlowQueryLogSQL,
+		redactParams: cfg.SlowQueryRedactParams,
+		minInterval:  slowQueryLogInterval,
+		logger:       logger,
+	}
+}
+
+// Log logs the given statement if dur is over the threshold. It is safe to
+// call Log on a nil slowQueryLogger.
+func (s *slowQueryLogger) Log(stmt *command.Statement, dur time.Duration) {
+	if s == nil || dur < s.threshold {
+		return
+	}
+	stats.Add(numSlowQueries, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if !s.lastLogged.IsZero() && now.Sub(s.lastLogged) < s.minInterval {
+		s.numSuppressed++
+		return
+	}
+	s.lastLogged = now
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "slow statement took %s", dur)
+	if s.logSQL {
+		fmt.Fprintf(&b, ": %s", stmt.Sql)
+		if len(stmt.Parameters) > 0 {
+			fmt.Fprintf(&b, " %s", s.formatParameters(stmt.Parameters))
+		}
+	}
+	if s.numSuppressed > 0 {
+		fmt.Fprintf(&b, " (%d more slow statements not logged)", s.numSuppressed)
+		s.numSuppressed = 0
+	}
+	s.logger.Print(b.String())
+}
+
+func (s *slowQueryLogger) formatParameters(params []*command.Parameter) string {
+	vals := make([]string, len(params))
+	for i, p := range params {
+		v := slowQueryRedacted
+		if !s.redactParams {
+			v = csvField(p, "NULL")
+		}
+		if p.GetName() != "" {
+			v = p.GetName() + "=" + v
+		}
+		vals[i] = v
+	}
+	return "[" + strings.Join(vals, ", ") + "]"
+}
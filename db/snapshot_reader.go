@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// ErrSnapshotTxClosed is returned when a closed SnapshotTx is used.
+var ErrSnapshotTxClosed = errors.New("snapshot transaction closed")
+
+// ErrSnapshotReadersOpen is returned by checkpoints which the database runs
+// by itself, when they are skipped because snapshot readers are open.
+var ErrSnapshotReadersOpen = errors.New("snapshot readers open")
+
+// SnapshotTx is a read transaction against a stable view of the database, as
+// of the time the SnapshotTx was created. Changes made to the database after
+// that time are not visible to it. A SnapshotTx is not safe for concurrent
+// use, and must be closed when no longer needed.
+type SnapshotTx struct {
+	db       *DB
+	conn     *sql.Conn
+	readerID uint64
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// SnapshotReader begins a read transaction on a dedicated read-only
+// connection, and returns a SnapshotTx through which the database can be
+// queried, as of the time SnapshotReader was called, until the SnapshotTx is
+// closed. Writes are not blocked while the SnapshotTx is open, and are not
+// visible to it, which makes it suitable for long-running scans.
+//
+// In WAL mode an open SnapshotTx prevents the WAL from being reset, so RESTART
+// and TRUNCATE checkpoints cannot complete while it is open, and the WAL
+// grows. Checkpoints the database runs by itself, because of
+// WALCheckpointThreshold or adaptive checkpointing, are skipped while any
+// SnapshotTx is open. Explicit checkpoints are not skipped, but fail once
+// their timeout expires.
+func (db *DB) SnapshotReader() (*SnapshotTx, error) {
+	if err := db.ops.enter(); err != nil {
+		return nil, err
+	}
+	defer db.ops.exit()
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.syncAttached(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// A deferred transaction only takes its snapshot on the first read, so
+	// read immediately to fix the view of the database.
+	if _, err := conn.ExecContext(ctx, "BEGIN DEFERRED"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var n int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&n); err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		conn.Close()
+		return nil, err
+	}
+	db.snapshotReaders.Add(1)
+	return &SnapshotTx{
+		db:       db,
+		conn:     conn,
+		readerID: db.readers.add(ReaderSnapshot, ""),
+	}, nil
+}
+
+// NumSnapshotReaders returns the number of open SnapshotTx.
+func (db *DB) NumSnapshotReaders() int64 {
+	return db.snapshotReaders.Load()
+}
+
+// QueryStringStmt executes a single query against the snapshot.
+func (s *SnapshotTx) QueryStringStmt(query string) ([]*command.QueryRows, error) {
+	r := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: query,
+			},
+		},
+	}
+	return s.Query(r, false)
+}
+
+// Query executes queries against the snapshot. Since every query already
+// runs within the snapshot transaction, the Transaction flag of the request
+// is ignored.
+func (s *SnapshotTx) Query(req *command.Request, xTime bool) ([]*command.QueryRows, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrSnapshotTxClosed
+	}
+	if err := s.db.ops.enter(); err != nil {
+		return nil, err
+	}
+	defer s.db.ops.exit()
+	stats.Add(numQueries, int64(len(req.Statements)))
+
+	ctx := context.Background()
+	if req.DbTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.DbTimeout))
+		defer cancel()
+	}
+	return s.db.queryWithConn(ctx, &command.Request{
+		Statements: req.Statements,
+		DbTimeout:  req.DbTimeout,
+	}, xTime, s.conn)
+}
+
+// Close ends the read transaction, and releases the connection. It is safe to
+// call Close more than once.
+func (s *SnapshotTx) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	defer s.db.snapshotReaders.Add(-1)
+	defer s.db.readers.remove(s.readerID)
+
+	_, err := s.conn.ExecContext(context.Background(), "ROLLBACK")
+	if cErr := s.conn.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_SnapshotReader(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	snap, err := db.SnapshotReader()
+	if err != nil {
+		t.Fatalf("failed to create snapshot reader: %s", err.Error())
+	}
+	defer snap.Close()
+	if exp, got := int64(1), db.NumSnapshotReaders(); exp != got {
+		t.Fatalf("wrong number of snapshot readers, exp %d, got %d", exp, got)
+	}
+
+	// Writes are not blocked by the snapshot, and are not visible to it.
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(2, "declan")`)
+	mustExecute(db, `UPDATE foo SET name = "aoife" WHERE id = 1`)
+
+	for i := 0; i < 2; i++ {
+		rows, err := snap.QueryStringStmt("SELECT * FROM foo")
+		if err != nil {
+			t.Fatalf("failed to query snapshot: %s", err.Error())
+		}
+		if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(rows); exp != got {
+			t.Fatalf("wrong snapshot rows, exp %s, got %s", exp, got)
+		}
+	}
+	rows, err := db.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"aoife"],[2,"declan"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong database rows, exp %s, got %s", exp, got)
+	}
+
+	rows, err = snap.QueryStringStmt(`INSERT INTO foo(id, name) VALUES(3, "nope")`)
+	if err != nil {
+		t.Fatalf("failed to query snapshot: %s", err.Error())
+	}
+	if exp, got := `[{"error":"attempt to change database via query operation"}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong result for write, exp %s, got %s", exp, got)
+	}
+
+	// The snapshot prevents the WAL from being truncated.
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, 100*time.Millisecond); err == nil {
+		t.Fatalf("expected checkpoint to fail while snapshot is open")
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Fatalf("failed to close snapshot reader: %s", err.Error())
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatalf("failed to close snapshot reader twice: %s", err.Error())
+	}
+	if exp, got := int64(0), db.NumSnapshotReaders(); exp != got {
+		t.Fatalf("wrong number of snapshot readers, exp %d, got %d", exp, got)
+	}
+	if _, err := snap.QueryStringStmt("SELECT * FROM foo"); err != ErrSnapshotTxClosed {
+		t.Fatalf("expected ErrSnapshotTxClosed, got %v", err)
+	}
+
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, 100*time.Millisecond); err != nil {
+		t.Fatalf("failed to checkpoint after snapshot closed: %s", err.Error())
+	}
+	if sz := mustFileSize(db.WALPath()); sz != 0 {
+		t.Fatalf("WAL not truncated, size %d", sz)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -78,6 +79,13 @@ func SynchronousModeFromInt(i int) (SynchronousMode, error) {
 
 // MakeDSN returns a SQLite DSN for the given path, with the given options.
 func MakeDSN(path string, readOnly, fkEnabled, walEnabled bool) string {
+	return makeDSN(path, readOnly, fkEnabled, walEnabled, SynchronousOff)
+}
+
+// makeDSN returns a SQLite DSN for the given path, with the given options.
+// The synchronous mode is set through the DSN so that the driver applies it
+// to every connection it opens.
+func makeDSN(path string, readOnly, fkEnabled, walEnabled bool, sync SynchronousMode) string {
 	opts := url.Values{}
 	if readOnly {
 		opts.Add("mode", "ro")
@@ -87,10 +95,29 @@ func MakeDSN(path string, readOnly, fkEnabled, walEnabled bool) string {
 	if !walEnabled {
 		opts.Set("_journal", "DELETE")
 	}
-	opts.Add("_sync", "0")
+	opts.Add("_sync", strconv.Itoa(int(sync)))
 	return fmt.Sprintf("file:%s?%s", path, opts.Encode())
 }
 
+// withBusyTimeout returns the DSN with the busy timeout set to d, so that the
+// driver sets it on each connection it opens. If d is not greater than zero,
+// the DSN is returned unchanged.
+func withBusyTimeout(dsn string, d time.Duration) string {
+	if d <= 0 {
+		return dsn
+	}
+	return fmt.Sprintf("%s&_busy_timeout=%d", dsn, d.Milliseconds())
+}
+
+// withCacheSize returns dsn with the page cache size set to kb KiB, if kb is
+// greater than zero.
+func withCacheSize(dsn string, kb int) string {
+	if kb <= 0 {
+		return dsn
+	}
+	return fmt.Sprintf("%s&_cache_size=-%d", dsn, kb)
+}
+
 // WALPath returns the path to the WAL file for the given database path.
 func WALPath(dbPath string) string {
 	return dbPath + "-wal"
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rqlite/go-sqlite3"
+)
+
+// StatementType is a type of SQL statement governed by a StatementPolicy.
+type StatementType int
+
+const (
+	// StatementSelect is a SELECT, including a subquery within another
+	// statement.
+	StatementSelect StatementType = iota + 1
+	// StatementInsert is an INSERT, or REPLACE, into a table.
+	StatementInsert
+	// StatementUpdate is an UPDATE of a table.
+	StatementUpdate
+	// StatementDelete is a DELETE from a table.
+	StatementDelete
+	// StatementCreate is a CREATE of a table, index, view, trigger, or
+	// virtual table.
+	StatementCreate
+	// StatementDrop is a DROP of a table, index, view, trigger, or virtual
+	// table.
+	StatementDrop
+	// StatementAlter is an ALTER TABLE, REINDEX, or ANALYZE.
+	StatementAlter
+	// StatementAttach is an ATTACH or DETACH.
+	StatementAttach
+)
+
+// String returns the name of the statement type.
+func (t StatementType) String() string {
+	switch t {
+	case StatementSelect:
+		return "SELECT"
+	case StatementInsert:
+		return "INSERT"
+	case StatementUpdate:
+		return "UPDATE"
+	case StatementDelete:
+		return "DELETE"
+	case StatementCreate:
+		return "CREATE"
+	case StatementDrop:
+		return "DROP"
+	case StatementAlter:
+		return "ALTER"
+	case StatementAttach:
+		return "ATTACH"
+	default:
+		return fmt.Sprintf("StatementType(%d)", int(t))
+	}
+}
+
+// StatementPolicy restricts the types of statement which may be executed
+// against a database. It is enforced by a SQLite authorizer, so statements
+// are checked by SQLite itself as they are prepared, rather than by parsing
+// SQL text, and a statement which is not permitted fails with a "not
+// authorized" error.
+//
+// If Allow is not empty, only the statement types it lists are permitted.
+// Any statement type listed in Deny is not permitted, even if it is listed in
+// Allow. Statements which are not covered by a StatementType, such as
+// PRAGMA, BEGIN, COMMIT, and SAVEPOINT, are always permitted, since the
+// database itself relies on them; use Defensive mode to restrict PRAGMAs.
+// Changes SQLite makes to the schema tables on behalf of a CREATE or DROP are
+// governed by StatementCreate and StatementDrop, not by StatementInsert and
+// StatementDelete.
+type StatementPolicy struct {
+	Allow []StatementType
+	Deny  []StatementType
+}
+
+// Permits returns whether the policy permits statements of type t.
+func (p *StatementPolicy) Permits(t StatementType) bool {
+	for _, d := range p.Deny {
+		if d == t {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, a := range p.Allow {
+		if a == t {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize is a SQLite authorizer enforcing the policy.
+func (p *StatementPolicy) authorize(op int, arg1, arg2, arg3 string) int {
+	t, ok := statementTypeFromAuthOp(op, arg1)
+	if !ok || p.Permits(t) {
+		return sqlite3.SQLITE_OK
+	}
+	return sqlite3.SQLITE_DENY
+}
+
+// statementTypeFromAuthOp returns the statement type for the given SQLite
+// authorizer action. It returns false if the action is not governed by a
+// StatementPolicy.
+func statementTypeFromAuthOp(op int, table string) (StatementType, bool) {
+	switch op {
+	case sqlite3.SQLITE_SELECT:
+		return StatementSelect, true
+	case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE:
+		if isSchemaTable(table) {
+			return 0, false
+		}
+		switch op {
+		case sqlite3.SQLITE_INSERT:
+			return StatementInsert, true
+		case sqlite3.SQLITE_UPDATE:
+			return StatementUpdate, true
+		default:
+			return StatementDelete, true
+		}
+	case sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_CREATE_TEMP_INDEX,
+		sqlite3.SQLITE_CREATE_TEMP_TABLE, sqlite3.SQLITE_CREATE_TEMP_TRIGGER, sqlite3.SQLITE_CREATE_TEMP_VIEW,
+		sqlite3.SQLITE_CREATE_TRIGGER, sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_CREATE_VTABLE:
+		return StatementCreate, true
+	case sqlite3.SQLITE_DROP_INDEX, sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_DROP_TEMP_INDEX,
+		sqlite3.SQLITE_DROP_TEMP_TABLE, sqlite3.SQLITE_DROP_TEMP_TRIGGER, sqlite3.SQLITE_DROP_TEMP_VIEW,
+		sqlite3.SQLITE_DROP_TRIGGER, sqlite3.SQLITE_DROP_VIEW, sqlite3.SQLITE_DROP_VTABLE:
+		return StatementDrop, true
+	case sqlite3.SQLITE_ALTER_TABLE, sqlite3.SQLITE_REINDEX, sqlite3.SQLITE_ANALYZE:
+		return StatementAlter, true
+	case sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+		return StatementAttach, true
+	default:
+		return 0, false
+	}
+}
+
+// isSchemaTable returns whether table is one of SQLite's schema tables.
+func isSchemaTable(table string) bool {
+	switch strings.ToLower(table) {
+	case "sqlite_master", "sqlite_temp_master", "sqlite_schema", "sqlite_temp_schema":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_StatementPolicy(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	db, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+
+	mustOpen := func(policy *StatementPolicy, defensive bool) *DB {
+		cfg := NewConfig()
+		cfg.WAL = true
+		cfg.Defensive = defensive
+		cfg.StatementPolicy = policy
+		db, err := OpenWithConfig(path, cfg)
+		if err != nil {
+			t.Fatalf("failed to open database with statement policy: %s", err.Error())
+		}
+		return db
+	}
+	mustDeny := func(db *DB, stmt string) {
+		t.Helper()
+		r, err := db.ExecuteStringStmt(stmt)
+		if err == nil && r[0].GetError() == "" {
+			t.Fatalf("expected %q to be rejected", stmt)
+		}
+		msg := r[0].GetError()
+		if err != nil {
+			msg = err.Error()
+		}
+		if !strings.Contains(msg, "not authorized") {
+			t.Fatalf("expected authorization error for %q, got %s", stmt, msg)
+		}
+	}
+	mustAllow := func(db *DB, stmt string) {
+		t.Helper()
+		r, err := db.ExecuteStringStmt(stmt)
+		if err != nil {
+			t.Fatalf("failed to execute %q: %s", stmt, err.Error())
+		}
+		if r[0].GetError() != "" {
+			t.Fatalf("failed to execute %q: %s", stmt, r[0].GetError())
+		}
+	}
+	mustQuery := func(db *DB, exp string) {
+		t.Helper()
+		r, err := db.QueryStringStmt("SELECT * FROM foo")
+		if err != nil {
+			t.Fatalf("failed to query: %s", err.Error())
+		}
+		if got := asJSON(r); exp != got {
+			t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+		}
+	}
+
+	// SELECT only.
+	db = mustOpen(&StatementPolicy{Allow: []StatementType{StatementSelect}}, false)
+	mustQuery(db, `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`)
+	mustDeny(db, "DROP TABLE foo")
+	mustDeny(db, `INSERT INTO foo(id, name) VALUES(2, "declan")`)
+	mustDeny(db, "CREATE TABLE bar (id INTEGER)")
+	mustQuery(db, `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`)
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+
+	// Deny DDL only, combined with defensive mode.
+	db = mustOpen(&StatementPolicy{Deny: []StatementType{StatementCreate, StatementDrop, StatementAlter}}, true)
+	mustAllow(db, `INSERT INTO foo(id, name) VALUES(2, "declan")`)
+	mustAllow(db, `UPDATE foo SET name = "aoife" WHERE id = 2`)
+	mustDeny(db, "DROP TABLE foo")
+	mustDeny(db, "CREATE INDEX foo_name ON foo(name)")
+	mustDeny(db, "ALTER TABLE foo ADD COLUMN age INTEGER")
+	mustDeny(db, "PRAGMA writable_schema=ON")
+	mustQuery(db, `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"],[2,"aoife"]]}]`)
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+}
+
+func Test_StatementPolicy_Permits(t *testing.T) {
+	p := &StatementPolicy{}
+	if !p.Permits(StatementDrop) {
+		t.Fatalf("empty policy should permit everything")
+	}
+	p = &StatementPolicy{
+		Allow: []StatementType{StatementSelect, StatementInsert},
+		Deny:  []StatementType{StatementInsert},
+	}
+	for st, exp := range map[StatementType]bool{
+		StatementSelect: true,
+		StatementInsert: false,
+		StatementDelete: false,
+	} {
+		if got := p.Permits(st); exp != got {
+			t.Fatalf("wrong result for %s, exp %t, got %t", st, exp, got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// QueryStream executes a single query that returns rows, and calls fn with
+// each row as it is read from the database, so the full result set is never
+// held in memory. Values are int64, float64, string, []byte or nil, with text
+// values returned as strings, except that columns declared as a date, time or
+// boolean type may yield time.Time or bool values. The row slice is reused
+// between calls, so fn must copy it if it needs the row after returning. If fn
+// returns an error, no further rows are read, and that error is returned.
+//
+// The query runs within a read transaction, which is held until the last row
+// has been read, or fn returns an error. In WAL mode an open read transaction
+// prevents the WAL from being reset, so RESTART and TRUNCATE checkpoints
+// cannot complete until QueryStream returns, and the WAL grows. fn should
+// therefore not block for long.
+func (db *DB) QueryStream(query string, fn func(row []interface{}) error) (retErr error) {
+	if err := db.ops.enter(); err != nil {
+		return err
+	}
+	defer db.ops.exit()
+	stats.Add(numQueries, 1)
+	defer func() {
+		if retErr != nil {
+			stats.Add(numQueryErrors, 1)
+		}
+	}()
+
+	ctx := context.Background()
+	conn, err := db.roDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := db.syncAttached(ctx, conn); err != nil {
+		return err
+	}
+	defer db.readers.remove(db.readers.add(ReaderStream, query))
+
+	readOnly, err := db.StmtReadOnlyWithConn(query, conn)
+	if err != nil {
+		return err
+	}
+	if !readOnly {
+		return fmt.Errorf("attempt to change database via query operation")
+	}
+
+	rs, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	types, err := rs.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	text := make([]bool, len(types))
+	for i := range types {
+		text[i] = isTextType(strings.ToLower(types[i].DatabaseTypeName()))
+	}
+
+	row := make([]interface{}, len(types))
+	ptrs := make([]interface{}, len(row))
+	for i := range ptrs {
+		ptrs[i] = &row[i]
+	}
+	for rs.Next() {
+		if err := rs.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range row {
+			switch val := v.(type) {
+			case []byte:
+				if text[i] {
+					row[i] = string(val)
+				}
+			case int:
+				row[i] = int64(val)
+			}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rs.Err()
+}
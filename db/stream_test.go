@@ -0,0 +1,86 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_QueryStream(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, data BLOB, score REAL)")
+	mustExecute(db, `INSERT INTO foo(id, name, data, score) VALUES(1, "fiona", x'00ff', 1.5)`)
+	mustExecute(db, `INSERT INTO foo(id, name, data, score) VALUES(2, "declan", NULL, NULL)`)
+
+	var rows [][]interface{}
+	err := db.QueryStream("SELECT * FROM foo ORDER BY id", func(row []interface{}) error {
+		rows = append(rows, append([]interface{}(nil), row...))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to stream query: %s", err.Error())
+	}
+	exp := [][]interface{}{
+		{int64(1), "fiona", []byte{0x00, 0xff}, 1.5},
+		{int64(2), "declan", nil, nil},
+	}
+	if !reflect.DeepEqual(exp, rows) {
+		t.Fatalf("wrong rows, exp %v, got %v", exp, rows)
+	}
+
+	// Writes are rejected.
+	err = db.QueryStream(`INSERT INTO foo(id, name) VALUES(3, "nope")`, func(row []interface{}) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected error for write")
+	}
+	if err := db.QueryStream("SELECT * FROM nonexistent", func(row []interface{}) error { return nil }); err == nil {
+		t.Fatalf("expected error for nonexistent table")
+	}
+}
+
+func Test_QueryStream_Stop(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 100; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+
+	errStop := errors.New("stop")
+	n := 0
+	err := db.QueryStream("SELECT * FROM foo", func(row []interface{}) error {
+		n++
+		if n == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if n != 10 {
+		t.Fatalf("wrong number of rows streamed, exp 10, got %d", n)
+	}
+
+	// The read transaction blocks a TRUNCATE checkpoint while the query is
+	// being streamed, but is released once QueryStream returns.
+	err = db.QueryStream("SELECT * FROM foo", func(row []interface{}) error {
+		if err := db.CheckpointWithTimeout(CheckpointTruncate, 100*time.Millisecond); !errors.Is(err, ErrCheckpointTimeout) {
+			t.Fatalf("expected ErrCheckpointTimeout during stream, got %v", err)
+		}
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, 100*time.Millisecond); err != nil {
+		t.Fatalf("failed to checkpoint after stream: %s", err.Error())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	command "github.com/rqlite/rqlite/v8/command/proto"
 )
@@ -25,9 +26,18 @@ func OpenSwappable(dbPath string, fkEnabled, wal bool) (*SwappableDB, error) {
 	return &SwappableDB{db: db}, nil
 }
 
+// swapOldSuffix is appended to the path of the database to form the path to
+// which its files are moved while a Swap is in progress.
+const swapOldSuffix = ".swap-old"
+
 // Swap swaps the underlying database with that at the given path. The Swap operation
 // may fail on some platforms if the file at path is open by another process. It is
 // the caller's responsibility to ensure the file at path is not in use.
+//
+// The Swap is atomic from the caller's perspective. The files of the current
+// database are kept until the new database has been opened, and if the Swap
+// fails they are restored, and reopened, so the current database is left
+// intact, and the file at path is left where it was.
 func (s *SwappableDB) Swap(path string, fkConstraints, walEnabled bool) error {
 	if !IsValidSQLiteFile(path) {
 		return fmt.Errorf("invalid SQLite data")
@@ -35,21 +45,87 @@ func (s *SwappableDB) Swap(path string, fkConstraints, walEnabled bool) error {
 
 	s.dbMu.Lock()
 	defer s.dbMu.Unlock()
-	if err := s.db.Close(); err != nil {
-		return fmt.Errorf("failed to close: %s", err)
+	return s.swap(path, fkConstraints, walEnabled)
+}
+
+// SwapFile swaps the underlying database with that at the given path, as Swap
+// does, opening it with the same foreign key and WAL settings as the current
+// database.
+func (s *SwappableDB) SwapFile(path string) error {
+	if !IsValidSQLiteFile(path) {
+		return fmt.Errorf("invalid SQLite data")
 	}
-	if err := RemoveFiles(s.db.Path()); err != nil {
+
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	return s.swap(path, s.db.FKEnabled(), s.db.WALEnabled())
+}
+
+// swap performs a Swap. It must be called with dbMu held.
+func (s *SwappableDB) swap(path string, fkConstraints, walEnabled bool) error {
+	dbPath := s.db.Path()
+	oldPath := dbPath + swapOldSuffix
+	oldFK, oldWAL := s.db.FKEnabled(), s.db.WALEnabled()
+	if err := RemoveFiles(oldPath); err != nil {
 		return fmt.Errorf("failed to remove files: %s", err)
 	}
-	if err := os.Rename(path, s.db.Path()); err != nil {
-		return fmt.Errorf("failed to rename database: %s", err)
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("failed to close: %s", err)
+	}
+
+	// restore puts the original database back in place, and reopens it.
+	restore := func(swapErr error) error {
+		if fileExists(oldPath) {
+			if err := moveFiles(oldPath, dbPath); err != nil {
+				return fmt.Errorf("%s, and failed to restore database: %s", swapErr, err)
+			}
+		}
+		db, err := Open(dbPath, oldFK, oldWAL)
+		if err != nil {
+			return fmt.Errorf("%s, and failed to reopen database: %s", swapErr, err)
+		}
+		s.db = db
+		return swapErr
 	}
 
-	db, err := Open(s.db.Path(), fkConstraints, walEnabled)
+	if err := moveFiles(dbPath, oldPath); err != nil {
+		return restore(fmt.Errorf("failed to move database aside: %s", err))
+	}
+	if err := os.Rename(path, dbPath); err != nil {
+		return restore(fmt.Errorf("failed to rename database: %s", err))
+	}
+	db, err := Open(dbPath, fkConstraints, walEnabled)
 	if err != nil {
-		return fmt.Errorf("open SQLite file failed: %s", err)
+		swapErr := fmt.Errorf("open SQLite file failed: %s", err)
+		// Return the new file to the caller, discarding anything SQLite
+		// created alongside it, before restoring the original database.
+		if err := os.Rename(dbPath, path); err != nil {
+			return fmt.Errorf("%s, and failed to rename database back: %s", swapErr, err)
+		}
+		if err := RemoveFiles(dbPath); err != nil {
+			return fmt.Errorf("%s, and failed to remove files: %s", swapErr, err)
+		}
+		return restore(swapErr)
 	}
 	s.db = db
+	if err := RemoveFiles(oldPath); err != nil {
+		s.db.logger.Printf("failed to remove swapped-out database %s: %s", oldPath, err.Error())
+	}
+	return nil
+}
+
+// moveFiles moves the database at src, and any WAL file, to dst. Any
+// shared-memory file is removed, as SQLite rebuilds it when needed.
+func moveFiles(src, dst string) error {
+	if err := os.Remove(src + "-shm"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	if err := os.Rename(src+"-wal", dst+"-wal"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
 
@@ -151,6 +227,13 @@ func (s *SwappableDB) Dump(w io.Writer) error {
 	return s.db.Dump(w)
 }
 
+// ContentHash calls ContentHash on the underlying database.
+func (s *SwappableDB) ContentHash() ([]byte, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.ContentHash()
+}
+
 // FKEnabled calls FKEnabled on the underlying database.
 func (s *SwappableDB) FKEnabled() bool {
 	s.dbMu.RLock()
@@ -171,3 +254,10 @@ func (s *SwappableDB) FileSize() (int64, error) {
 	defer s.dbMu.RUnlock()
 	return s.db.FileSize()
 }
+
+// LastModified calls LastModified on the underlying database.
+func (s *SwappableDB) LastModified() (time.Time, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.db.LastModified()
+}
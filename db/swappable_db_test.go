@@ -3,6 +3,8 @@ package db
 import (
 	"os"
 	"testing"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
 )
 
 // Test_OpenSwappable_Success tests that OpenSwappable correctly opens a database and returns
@@ -122,3 +124,101 @@ func Test_SwapInvalidSQLiteFile(t *testing.T) {
 		t.Fatalf("expected an error when swapping with an invalid SQLite file, got nil")
 	}
 }
+
+// Test_SwapFile tests that SwapFile swaps the underlying database, keeping the
+// settings of the database it replaces.
+func Test_SwapFile(t *testing.T) {
+	srcPath := mustTempPath()
+	defer os.Remove(srcPath)
+	srcDB, err := Open(srcPath, false, false)
+	if err != nil {
+		t.Fatalf("failed to open source database: %s", err)
+	}
+	mustExecute(srcDB, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(srcDB, `INSERT INTO foo(name) VALUES("test")`)
+	if err := srcDB.Close(); err != nil {
+		t.Fatalf("failed to close source database pre-swap: %s", err)
+	}
+
+	swappablePath := mustTempPath()
+	defer RemoveFiles(swappablePath)
+	swappableDB, err := OpenSwappable(swappablePath, true, true)
+	if err != nil {
+		t.Fatalf("failed to open swappable database: %s", err)
+	}
+	defer swappableDB.Close()
+	if _, err := swappableDB.QueryStringStmt("CREATE TABLE bar (id INTEGER NOT NULL PRIMARY KEY)"); err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+
+	if err := swappableDB.SwapFile(srcPath); err != nil {
+		t.Fatalf("failed to swap database: %s", err)
+	}
+	if !swappableDB.FKEnabled() || !swappableDB.WALEnabled() {
+		t.Fatalf("expected swapped database to keep FK and WAL settings")
+	}
+	if swappableDB.Path() != swappablePath {
+		t.Fatalf("expected swapped database path to be %s, got %s", swappablePath, swappableDB.Path())
+	}
+	rows, err := swappableDB.QueryStringStmt("SELECT * FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query swapped database: %s", err)
+	}
+	if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"test"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results after swap, expected %s, got %s", exp, got)
+	}
+	if fileExists(srcPath) {
+		t.Fatalf("expected swapped-in file to be moved")
+	}
+	if fileExists(swappablePath + swapOldSuffix) {
+		t.Fatalf("expected swapped-out database to be removed")
+	}
+}
+
+// Test_SwapFailureRestores tests that a Swap which fails to open the new
+// database leaves the existing database, and the new file, intact.
+func Test_SwapFailureRestores(t *testing.T) {
+	swappablePath := mustTempPath()
+	defer RemoveFiles(swappablePath)
+	swappableDB, err := OpenSwappable(swappablePath, false, true)
+	if err != nil {
+		t.Fatalf("failed to open swappable database: %s", err)
+	}
+	defer swappableDB.Close()
+	if _, err := swappableDB.Execute(&command.Request{
+		Statements: []*command.Statement{
+			{Sql: "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)"},
+			{Sql: `INSERT INTO foo(name) VALUES("old")`},
+		},
+	}, false); err != nil {
+		t.Fatalf("failed to write to database: %s", err)
+	}
+
+	// A file with a valid SQLite header, but which is otherwise corrupt.
+	badPath := mustTempPath()
+	defer RemoveFiles(badPath)
+	data := append([]byte("SQLite format 3\x00"), make([]byte, 4096)...)
+	for i := 16; i < len(data); i++ {
+		data[i] = 0xff
+	}
+	if err := os.WriteFile(badPath, data, 0644); err != nil {
+		t.Fatalf("failed to write corrupt SQLite file: %s", err)
+	}
+
+	if err := swappableDB.SwapFile(badPath); err == nil {
+		t.Fatalf("expected an error when swapping with a corrupt SQLite file, got nil")
+	}
+	if !fileExists(badPath) {
+		t.Fatalf("expected file at %s to be left in place", badPath)
+	}
+	if swappableDB.Path() != swappablePath || !swappableDB.WALEnabled() {
+		t.Fatalf("expected original database to be reopened with its settings")
+	}
+	rows, err := swappableDB.QueryStringStmt("SELECT name FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query original database: %s", err)
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["old"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results after failed swap, expected %s, got %s", exp, got)
+	}
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+// ErrTxClosed is returned when a committed or rolled back Tx is used.
+var ErrTxClosed = errors.New("transaction closed")
+
+// Tx is an explicit transaction on the database, which is ended by calling
+// Commit or Rollback. A Tx is not safe for concurrent use.
+type Tx struct {
+	db       *DB
+	conn     *sql.Conn
+	readerID uint64
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Begin begins a transaction on the read-write connection, and returns a Tx
+// through which statements are executed and queried atomically. The
+// transaction must be ended with Commit or Rollback.
+//
+// The Tx holds the read-write connection until it ends, so other writes wait
+// for it, as do checkpoints unless BackgroundCheckpoint is enabled. Once it
+// has read from the database, the Tx also holds a read transaction, which in
+// WAL mode prevents the WAL from being reset, exactly like a read transaction
+// on any other connection. RESTART and TRUNCATE checkpoints then cannot
+// complete while it is open, and fail with ErrCheckpointTimeout, so a Tx
+// should be kept short.
+func (db *DB) Begin() (retTx *Tx, retErr error) {
+	if err := db.ops.enter(); err != nil {
+		return nil, err
+	}
+	defer db.ops.exit()
+	if err := db.writeQueue.Acquire(context.Background(), db.ops.closing()); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			db.writeQueue.Release()
+		}
+	}()
+	ctx := context.Background()
+	conn, err := db.rwDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	stats.Add(numETx, 1)
+	db.openTxs.Add(1)
+	return &Tx{
+		db:       db,
+		conn:     conn,
+		readerID: db.readers.add(ReaderTx, ""),
+	}, nil
+}
+
+// ExecuteStringStmt executes a single statement, which modifies the database,
+// within the transaction.
+func (t *Tx) ExecuteStringStmt(query string) ([]*command.ExecuteQueryResponse, error) {
+	r := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: query,
+			},
+		},
+	}
+	return t.Execute(r, false)
+}
+
+// Execute executes statements, which modify the database, within the
+// transaction. Since every statement already runs within the transaction, the
+// Transaction flag of the request is ignored. A failed statement does not end
+// the transaction.
+func (t *Tx) Execute(req *command.Request, xTime bool) ([]*command.ExecuteQueryResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, ErrTxClosed
+	}
+	if err := t.db.ops.enter(); err != nil {
+		return nil, err
+	}
+	defer t.db.ops.exit()
+	stats.Add(numExecutions, int64(len(req.Statements)))
+
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	return t.db.executeWithConn(ctx, &command.Request{
+		Statements: req.Statements,
+		DbTimeout:  req.DbTimeout,
+	}, xTime, t.conn)
+}
+
+// QueryStringStmt executes a single query within the transaction. Changes
+// made earlier in the transaction are visible to it.
+func (t *Tx) QueryStringStmt(query string) ([]*command.QueryRows, error) {
+	r := &command.Request{
+		Statements: []*command.Statement{
+			{
+				Sql: query,
+			},
+		},
+	}
+	return t.Query(r, false)
+}
+
+// Query executes queries within the transaction. Since every query already
+// runs within the transaction, the Transaction flag of the request is ignored.
+func (t *Tx) Query(req *command.Request, xTime bool) ([]*command.QueryRows, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, ErrTxClosed
+	}
+	if err := t.db.ops.enter(); err != nil {
+		return nil, err
+	}
+	defer t.db.ops.exit()
+	stats.Add(numQueries, int64(len(req.Statements)))
+
+	ctx, cancel := requestContext(req)
+	defer cancel()
+	return t.db.queryWithConn(ctx, &command.Request{
+		Statements: req.Statements,
+		DbTimeout:  req.DbTimeout,
+	}, xTime, t.conn)
+}
+
+// Commit commits the transaction. If the commit fails the transaction is
+// rolled back. Either way the Tx is closed, and cannot be used again.
+func (t *Tx) Commit() error {
+	return t.end("COMMIT")
+}
+
+// Rollback rolls back the transaction, and closes the Tx. It is safe to call
+// Rollback after Commit, or more than once, in which case it does nothing.
+func (t *Tx) Rollback() error {
+	if err := t.end("ROLLBACK"); err != ErrTxClosed {
+		return err
+	}
+	return nil
+}
+
+func (t *Tx) end(stmt string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrTxClosed
+	}
+	t.closed = true
+	defer t.db.openTxs.Add(-1)
+	defer t.db.readers.remove(t.readerID)
+	defer t.db.checkpointIfWALLarge() // Runs after the connection is released.
+	defer t.db.writeQueue.Release()
+
+	ctx := context.Background()
+	_, err := t.conn.ExecContext(ctx, stmt)
+	if err != nil && stmt != "ROLLBACK" {
+		t.conn.ExecContext(ctx, "ROLLBACK")
+	}
+	if cErr := t.conn.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// requestContext returns a context bounded by the timeout of the request, if
+// it has one.
+func requestContext(req *command.Request) (context.Context, context.CancelFunc) {
+	if req.DbTimeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(req.DbTimeout))
+	}
+	return context.WithCancel(context.Background())
+}
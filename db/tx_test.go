@@ -0,0 +1,153 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_TxCommit(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+	} {
+		if _, err := tx.ExecuteStringStmt(stmt); err != nil {
+			t.Fatalf("failed to execute statement in transaction: %s", err.Error())
+		}
+	}
+
+	// Changes are visible within the transaction, but not outside it.
+	rows, err := tx.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query in transaction: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong rows in transaction, exp %s, got %s", exp, got)
+	}
+	rows, err = db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[0]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong rows outside transaction, exp %s, got %s", exp, got)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err.Error())
+	}
+	rows, err = db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[2]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong rows after commit, exp %s, got %s", exp, got)
+	}
+
+	if _, err := tx.ExecuteStringStmt(`INSERT INTO foo(id, name) VALUES(3, "nope")`); err != ErrTxClosed {
+		t.Fatalf("expected ErrTxClosed, got %v", err)
+	}
+	if err := tx.Commit(); err != ErrTxClosed {
+		t.Fatalf("expected ErrTxClosed for second commit, got %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback after commit should be a no-op, got %s", err.Error())
+	}
+
+	// The write connection has been released.
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(3, "aoife")`)
+}
+
+func Test_TxRollback(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if _, err := tx.ExecuteStringStmt(`UPDATE foo SET name = "declan" WHERE id = 1`); err != nil {
+		t.Fatalf("failed to execute statement in transaction: %s", err.Error())
+	}
+
+	// A failed statement does not end the transaction.
+	res, err := tx.ExecuteStringStmt(`INSERT INTO foo(id, name) VALUES(1, "dupe")`)
+	if err != nil {
+		t.Fatalf("failed to execute statement in transaction: %s", err.Error())
+	}
+	if exp, got := `[{"error":"UNIQUE constraint failed: foo.id"}]`, asJSON(res); exp != got {
+		t.Fatalf("wrong result for failed statement, exp %s, got %s", exp, got)
+	}
+	rows, err := tx.QueryStringStmt("SELECT name FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query in transaction: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["declan"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong rows in transaction, exp %s, got %s", exp, got)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back transaction: %s", err.Error())
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("failed to roll back transaction twice: %s", err.Error())
+	}
+	if _, err := tx.QueryStringStmt("SELECT name FROM foo"); err != ErrTxClosed {
+		t.Fatalf("expected ErrTxClosed, got %v", err)
+	}
+	rows, err = db.QueryStringStmt("SELECT name FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["fiona"]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("wrong rows after rollback, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_TxBlocksCheckpoint(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	mustExecute(db, `INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	blockingDB, err := Open(path, false, true)
+	if err != nil {
+		t.Fatalf("failed to open blocking database in WAL mode: %s", err.Error())
+	}
+	defer blockingDB.Close()
+	tx, err := blockingDB.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %s", err.Error())
+	}
+	if _, err := tx.QueryStringStmt("SELECT COUNT(*) FROM foo"); err != nil {
+		t.Fatalf("failed to query in transaction: %s", err.Error())
+	}
+
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, 250*time.Millisecond); !errors.Is(err, ErrCheckpointTimeout) {
+		t.Fatalf("expected ErrCheckpointTimeout while transaction is open, got %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %s", err.Error())
+	}
+	if err := db.CheckpointWithTimeout(CheckpointTruncate, 250*time.Millisecond); err != nil {
+		t.Fatalf("failed to checkpoint after transaction ended: %s", err.Error())
+	}
+	if sz := mustFileSize(db.WALPath()); sz != 0 {
+		t.Fatalf("WAL not truncated, size %d", sz)
+	}
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// walAutoCheckpointTimeout is the busy timeout for checkpoints run by
+	// the WAL auto-checkpointer.
+	walAutoCheckpointTimeout = 100 * time.Millisecond
+
+	// defaultWALAutoCheckpointPoll is how often the WAL auto-checkpointer
+	// checks the size of the WAL, if no interval is configured.
+	defaultWALAutoCheckpointPoll = time.Second
+)
+
+// walAutoCheckpointer checks the size of the WAL at a fixed interval, and
+// checkpoints it in TRUNCATE mode once it reaches a threshold. Unlike
+// WALCheckpointThreshold, the checkpoint is never run by a write, so writes
+// are never held up by it.
+type walAutoCheckpointer struct {
+	threshold  int64
+	interval   time.Duration
+	walSize    func() (int64, error)
+	checkpoint func() error
+	logger     *log.Logger
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newWALAutoCheckpointer returns a walAutoCheckpointer configured by cfg, or
+// nil if WAL auto-checkpointing is disabled.
+func newWALAutoCheckpointer(cfg *Config, walSize func() (int64, error), checkpoint func() error,
+	logger *log.Logger) *walAutoCheckpointer {
+	if !cfg.WAL || cfg.WALAutoCheckpointBytes <= 0 {
+		return nil
+	}
+	interval := cfg.WALAutoCheckpointPollInterval
+	if interval <= 0 {
+		interval = defaultWALAutoCheckpointPoll
+	}
+	return &walAutoCheckpointer{
+		threshold:  cfg.WALAutoCheckpointBytes,
+		interval:   interval,
+		walSize:    walSize,
+		checkpoint: checkpoint,
+		logger:     logger,
+	}
+}
+
+// Start starts checking the WAL in the background.
+func (w *walAutoCheckpointer) Start() {
+	w.done = make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				w.step()
+			}
+		}
+	}()
+}
+
+// Stop stops checking the WAL, waiting for any checkpoint in progress to
+// complete. It is safe to call Stop on a nil walAutoCheckpointer.
+func (w *walAutoCheckpointer) Stop() {
+	if w == nil || w.done == nil {
+		return
+	}
+	close(w.done)
+	w.wg.Wait()
+	w.done = nil
+}
+
+// step checkpoints the WAL if it has reached the threshold.
+func (w *walAutoCheckpointer) step() {
+	sz, err := w.walSize()
+	if err != nil {
+		w.logger.Printf("WAL auto-checkpointer failed to get WAL size: %s", err.Error())
+		return
+	}
+	if sz < w.threshold {
+		return
+	}
+	stats.Add(numWALAutoCheckpoints, 1)
+	if err := w.checkpoint(); err != nil {
+		stats.Add(numWALAutoFailures, 1)
+		w.logger.Printf("WAL auto-checkpoint of %d byte WAL failed: %s", sz, err.Error())
+	}
+}
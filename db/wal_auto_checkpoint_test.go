@@ -0,0 +1,68 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_WALAutoCheckpoint(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.WALAutoCheckpointBytes = 64 * 1024
+	cfg.WALAutoCheckpointPollInterval = 10 * time.Millisecond
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	if db.walAutoCheckpointer == nil {
+		t.Fatalf("WAL auto-checkpointer not enabled")
+	}
+
+	// A WAL below the threshold is left alone.
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	time.Sleep(100 * time.Millisecond)
+	if mustFileSize(db.WALPath()) == 0 {
+		t.Fatalf("WAL below threshold was checkpointed")
+	}
+
+	// Once the WAL reaches the threshold it is truncated in the background.
+	for mustFileSize(db.WALPath()) < cfg.WALAutoCheckpointBytes {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mustFileSize(db.WALPath()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("WAL not truncated after reaching threshold")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Close stops the background goroutine.
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %s", err.Error())
+	}
+	if db.walAutoCheckpointer.done != nil {
+		t.Fatalf("WAL auto-checkpointer not stopped")
+	}
+}
+
+func Test_WALAutoCheckpoint_Disabled(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+
+	cfg := NewConfig()
+	cfg.WALAutoCheckpointBytes = 64 * 1024
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	if db.walAutoCheckpointer != nil {
+		t.Fatalf("WAL auto-checkpointer enabled in DELETE mode")
+	}
+}
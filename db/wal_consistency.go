@@ -0,0 +1,178 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrWALInconsistent is returned by CheckWALConsistency when the WAL and the
+// WAL shared-memory file are not in a coherent state.
+var ErrWALInconsistent = errors.New("WAL inconsistent")
+
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+
+	// shmHeaderSize is the size of each of the two copies of the WAL index
+	// header at the start of the shared-memory file.
+	shmHeaderSize = 48
+)
+
+// shmPath returns the path to the WAL shared-memory file.
+func (db *DB) shmPath() string {
+	return db.path + "-shm"
+}
+
+// CheckWALConsistency checks that the WAL and the WAL shared-memory (SHM)
+// file of the database are in a coherent state. An SHM file can be stranded
+// by an unclean shutdown, and may then describe WAL frames which no longer
+// exist, for example if the WAL was since truncated or removed. If the
+// database is not in WAL mode, any SHM file, or non-empty WAL file, is
+// stranded.
+//
+// If an inconsistency is found an error wrapping ErrWALInconsistent is
+// returned, unless the database was opened with RecoverWAL set, in which case
+// recovery is attempted. In WAL mode the WAL index is invalidated, so SQLite
+// rebuilds it from the WAL, and the WAL is then checkpointed. Otherwise a
+// stranded SHM file is removed. A non-empty WAL file next to a database which
+// is not in WAL mode cannot be recovered.
+//
+// Recovery must not be attempted while the database is in use.
+func (db *DB) CheckWALConsistency() error {
+	if db.memory {
+		return nil
+	}
+	if !db.wal {
+		return db.checkStrandedWAL()
+	}
+
+	err := checkWALIndex(db.walPath, db.shmPath())
+	if err == nil || !errors.Is(err, ErrWALInconsistent) || !db.recoverWAL {
+		return err
+	}
+	db.logger.Printf("recovering from %s", err.Error())
+	if err := invalidateWALIndex(db.shmPath()); err != nil {
+		return fmt.Errorf("invalidate WAL index: %s", err.Error())
+	}
+	if err := db.Checkpoint(CheckpointTruncate); err != nil {
+		return fmt.Errorf("checkpoint after recovery: %w", err)
+	}
+	return checkWALIndex(db.walPath, db.shmPath())
+}
+
+// checkStrandedWAL checks for WAL and SHM files next to a database which is
+// not in WAL mode.
+func (db *DB) checkStrandedWAL() error {
+	if sz, err := fileSizeExists(db.walPath); err != nil {
+		return err
+	} else if sz > 0 {
+		return fmt.Errorf("%w: %d bytes in WAL of database not in WAL mode", ErrWALInconsistent, sz)
+	}
+	if !fileExists(db.shmPath()) {
+		return nil
+	}
+	if !db.recoverWAL {
+		return fmt.Errorf("%w: SHM file exists for database not in WAL mode", ErrWALInconsistent)
+	}
+	db.logger.Printf("removing stranded SHM file %s", db.shmPath())
+	return os.Remove(db.shmPath())
+}
+
+// checkWALIndex checks that the WAL index in the SHM file at shmPath is
+// consistent with the WAL at walPath. An absent, or not yet initialized,
+// index is consistent, as SQLite builds it from the WAL when it is next read.
+// So is an index whose two header copies differ, as SQLite then rebuilds it.
+func checkWALIndex(walPath, shmPath string) error {
+	hdr, ok, err := readWALIndexHeader(shmPath)
+	if err != nil || !ok {
+		return err
+	}
+	isInit := hdr[12]
+	mxFrame := binary.NativeEndian.Uint32(hdr[16:20])
+	if isInit == 0 || mxFrame == 0 {
+		return nil
+	}
+	pageSize := int64(binary.NativeEndian.Uint16(hdr[14:16]))
+	pageSize = (pageSize & 0xff00) | ((pageSize & 0x0001) << 16)
+
+	walHdr := make([]byte, walHeaderSize)
+	fd, err := os.Open(walPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: WAL index has %d frames, but WAL does not exist", ErrWALInconsistent, mxFrame)
+	} else if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err := io.ReadFull(fd, walHdr); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: WAL index has %d frames, but WAL has no header", ErrWALInconsistent, mxFrame)
+	} else if err != nil {
+		return err
+	}
+	// The salts are copied byte-for-byte from the WAL header into the index.
+	if !bytes.Equal(walHdr[16:24], hdr[32:40]) {
+		return fmt.Errorf("%w: WAL index salts do not match WAL", ErrWALInconsistent)
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if need := walHeaderSize + int64(mxFrame)*(walFrameHeaderSize+pageSize); fi.Size() < need {
+		return fmt.Errorf("%w: WAL index has %d frames, but WAL is only %d bytes",
+			ErrWALInconsistent, mxFrame, fi.Size())
+	}
+	return nil
+}
+
+// readWALIndexHeader returns the first copy of the WAL index header in the
+// SHM file at path. If the file does not exist, is too short, or the two
+// copies of the header differ, ok is false.
+func readWALIndexHeader(path string) (hdr []byte, ok bool, err error) {
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	defer fd.Close()
+	b := make([]byte, 2*shmHeaderSize)
+	if _, err := io.ReadFull(fd, b); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if !bytes.Equal(b[:shmHeaderSize], b[shmHeaderSize:]) {
+		return nil, false, nil
+	}
+	return b[:shmHeaderSize], true, nil
+}
+
+// invalidateWALIndex zeroes both copies of the WAL index header in the SHM
+// file at path, so SQLite rebuilds the index from the WAL when it is next
+// read.
+func invalidateWALIndex(path string) error {
+	fd, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err := fd.WriteAt(make([]byte, 2*shmHeaderSize), 0); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+// fileSizeExists returns the size of the file at path, or 0 if it does not
+// exist.
+func fileSizeExists(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
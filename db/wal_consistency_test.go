@@ -0,0 +1,135 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func Test_CheckWALConsistency(t *testing.T) {
+	db, path := mustCreateOnDiskDatabaseWAL()
+	defer db.Close()
+	defer os.Remove(path)
+
+	if err := db.CheckWALConsistency(); err != nil {
+		t.Fatalf("new database inconsistent: %s", err.Error())
+	}
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+	for i := 0; i < 10; i++ {
+		mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	if err := db.CheckWALConsistency(); err != nil {
+		t.Fatalf("database with WAL frames inconsistent: %s", err.Error())
+	}
+	if err := db.Checkpoint(CheckpointTruncate); err != nil {
+		t.Fatalf("failed to checkpoint: %s", err.Error())
+	}
+	if err := db.CheckWALConsistency(); err != nil {
+		t.Fatalf("checkpointed database inconsistent: %s", err.Error())
+	}
+}
+
+func Test_CheckWALConsistency_StrandedSHM(t *testing.T) {
+	for _, recoverWAL := range []bool{false, true} {
+		path := mustTempFile()
+		defer os.Remove(path)
+		cfg := NewConfig()
+		cfg.WAL = true
+		cfg.RecoverWAL = recoverWAL
+		db, err := OpenWithConfig(path, cfg)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err.Error())
+		}
+		defer db.Close()
+
+		mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+		for i := 0; i < 10; i++ {
+			mustExecute(db, `INSERT INTO foo(name) VALUES("fiona")`)
+		}
+
+		// Keep the SHM file describing the WAL frames, then checkpoint and
+		// truncate the WAL, and put the stale SHM file back, as if it had been
+		// left behind by a crash.
+		staleSHM := mustReadBytes(db.shmPath())
+		if err := db.Checkpoint(CheckpointTruncate); err != nil {
+			t.Fatalf("failed to checkpoint: %s", err.Error())
+		}
+		if err := os.WriteFile(db.shmPath(), staleSHM, 0644); err != nil {
+			t.Fatalf("failed to write stale SHM file: %s", err.Error())
+		}
+
+		err = db.CheckWALConsistency()
+		if !recoverWAL {
+			if !errors.Is(err, ErrWALInconsistent) {
+				t.Fatalf("expected ErrWALInconsistent, got %v", err)
+			}
+			// Checking must not change anything.
+			if err := db.CheckWALConsistency(); !errors.Is(err, ErrWALInconsistent) {
+				t.Fatalf("expected ErrWALInconsistent on second check, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to recover stranded SHM: %s", err.Error())
+		}
+		if err := db.CheckWALConsistency(); err != nil {
+			t.Fatalf("database inconsistent after recovery: %s", err.Error())
+		}
+		rows, err := db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+		if err != nil {
+			t.Fatalf("failed to query after recovery: %s", err.Error())
+		}
+		if exp, got := `[{"columns":["COUNT(*)"],"types":["integer"],"values":[[10]]}]`, asJSON(rows); exp != got {
+			t.Fatalf("wrong rows after recovery, exp %s, got %s", exp, got)
+		}
+		mustExecute(db, `INSERT INTO foo(name) VALUES("declan")`)
+		if err := db.CheckWALConsistency(); err != nil {
+			t.Fatalf("database inconsistent after write: %s", err.Error())
+		}
+	}
+}
+
+func Test_CheckWALConsistency_NoWAL(t *testing.T) {
+	for _, recoverWAL := range []bool{false, true} {
+		path := mustTempFile()
+		defer os.Remove(path)
+		cfg := NewConfig()
+		cfg.RecoverWAL = recoverWAL
+		db, err := OpenWithConfig(path, cfg)
+		if err != nil {
+			t.Fatalf("failed to open database: %s", err.Error())
+		}
+		defer db.Close()
+		mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+		if err := db.CheckWALConsistency(); err != nil {
+			t.Fatalf("database inconsistent: %s", err.Error())
+		}
+
+		if err := os.WriteFile(db.shmPath(), make([]byte, 32768), 0644); err != nil {
+			t.Fatalf("failed to write stranded SHM file: %s", err.Error())
+		}
+		defer os.Remove(db.shmPath())
+		err = db.CheckWALConsistency()
+		if !recoverWAL {
+			if !errors.Is(err, ErrWALInconsistent) {
+				t.Fatalf("expected ErrWALInconsistent, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to recover stranded SHM: %s", err.Error())
+		}
+		if fileExists(db.shmPath()) {
+			t.Fatalf("stranded SHM file not removed")
+		}
+
+		// A WAL with data cannot be recovered.
+		if err := os.WriteFile(path+"-wal", []byte("data"), 0644); err != nil {
+			t.Fatalf("failed to write stranded WAL file: %s", err.Error())
+		}
+		defer os.Remove(path + "-wal")
+		if err := db.CheckWALConsistency(); !errors.Is(err, ErrWALInconsistent) {
+			t.Fatalf("expected ErrWALInconsistent for stranded WAL, got %v", err)
+		}
+	}
+}
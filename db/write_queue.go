@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrWriteQueueFull is returned when a write cannot be queued because the
+// write queue is full.
+var ErrWriteQueueFull = errors.New("write queue full")
+
+// writeQueue admits writers one at a time, in the order they arrive. A nil
+// writeQueue admits every writer immediately.
+type writeQueue struct {
+	maxDepth int
+
+	mu      sync.Mutex
+	busy    bool
+	waiters []chan struct{}
+}
+
+// newWriteQueue returns a writeQueue which allows up to maxDepth writers to
+// wait. It returns nil if maxDepth is not greater than zero.
+func newWriteQueue(maxDepth int) *writeQueue {
+	if maxDepth <= 0 {
+		return nil
+	}
+	return &writeQueue{maxDepth: maxDepth}
+}
+
+// Acquire blocks until the caller is admitted. It returns ErrWriteQueueFull,
+// without blocking, if the queue is full. If ctx is done before the caller is
+// admitted, the caller leaves the queue and ctx's error is returned. If
+// closing is closed before the caller is admitted, the caller leaves the
+// queue and ErrClosed is returned. Every successful call to Acquire must be
+// followed by a call to Release.
+func (q *writeQueue) Acquire(ctx context.Context, closing <-chan struct{}) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return nil
+	}
+	if len(q.waiters) >= q.maxDepth {
+		q.mu.Unlock()
+		stats.Add(numWriteQueueFull, 1)
+		return ErrWriteQueueFull
+	}
+	ch := make(chan struct{})
+	q.waiters = append(q.waiters, ch)
+	q.mu.Unlock()
+
+	var err error
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-closing:
+		err = ErrClosed
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, w := range q.waiters {
+		if w == ch {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return err
+		}
+	}
+	// The caller was admitted as it gave up, so admit the next writer.
+	q.release()
+	return err
+}
+
+// Release admits the next waiting writer, if any.
+func (q *writeQueue) Release() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.release()
+}
+
+// release admits the next waiting writer, if any. q.mu must be held.
+func (q *writeQueue) release() {
+	if len(q.waiters) == 0 {
+		q.busy = false
+		return
+	}
+	ch := q.waiters[0]
+	q.waiters = q.waiters[1:]
+	close(ch)
+}
+
+// Len returns the number of writers waiting.
+func (q *writeQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters)
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	command "github.com/rqlite/rqlite/v8/command/proto"
+)
+
+func Test_WriteQueue_Concurrent(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.WriteQueueDepth = 200
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	const n = 100
+	var wg sync.WaitGroup
+	errCh := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("fiona")`)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if r[0].GetError() != "" {
+				errCh <- fmt.Errorf("%s", r[0].GetError())
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("concurrent write failed: %s", err.Error())
+	}
+
+	r, err := db.QueryStringStmt("SELECT COUNT(*) FROM foo")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := fmt.Sprintf(`[{"columns":["COUNT(*)"],"types":["integer"],"values":[[%d]]}]`, n), asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func Test_WriteQueue_OrderAndFull(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.WriteQueueDepth = 3
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	// Hold the queue, and then queue writes one after the other.
+	if err := db.writeQueue.Acquire(context.Background(), nil); err != nil {
+		t.Fatalf("failed to acquire write queue: %s", err.Error())
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mustExecute(db, fmt.Sprintf(`INSERT INTO foo(name) VALUES("%d")`, i))
+		}(i)
+		waitForQueueLen(t, db.writeQueue, i+1)
+	}
+
+	// The queue is full, so the next write must fail immediately.
+	if _, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("full")`); err != ErrWriteQueueFull {
+		t.Fatalf("expected ErrWriteQueueFull, got %v", err)
+	}
+
+	db.writeQueue.Release()
+	wg.Wait()
+
+	// Writes must have been admitted in the order they were queued.
+	r, err := db.QueryStringStmt("SELECT name FROM foo ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to query: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["name"],"types":["text"],"values":[["0"],["1"],["2"]]}]`, asJSON(r); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	if exp, got := 0, db.writeQueue.Len(); exp != got {
+		t.Fatalf("wrong queue length, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_WriteQueue_TimeoutAndClose(t *testing.T) {
+	path := mustTempFile()
+	defer os.Remove(path)
+	cfg := NewConfig()
+	cfg.WAL = true
+	cfg.WriteQueueDepth = 3
+	db, err := OpenWithConfig(path, cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	mustExecute(db, "CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)")
+
+	if err := db.writeQueue.Acquire(context.Background(), nil); err != nil {
+		t.Fatalf("failed to acquire write queue: %s", err.Error())
+	}
+
+	// A write which times out while waiting leaves the queue.
+	req := &command.Request{
+		Statements: []*command.Statement{{Sql: `INSERT INTO foo(name) VALUES("fiona")`}},
+		DbTimeout:  int64(50 * time.Millisecond),
+	}
+	if _, err := db.Execute(req, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded waiting in queue, got %v", err)
+	}
+	if exp, got := 0, db.writeQueue.Len(); exp != got {
+		t.Fatalf("wrong queue length after timeout, exp %d, got %d", exp, got)
+	}
+
+	// A write waiting when the database is closed fails, and does not hold
+	// up the close.
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := db.ExecuteStringStmt(`INSERT INTO foo(name) VALUES("declan")`)
+		errCh <- err
+	}()
+	waitForQueueLen(t, db.writeQueue, 1)
+	if err := db.CloseWithTimeout(time.Second); err != nil {
+		t.Fatalf("failed to close database with a write queued: %s", err.Error())
+	}
+	if err := <-errCh; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed for queued write, got %v", err)
+	}
+	if exp, got := 0, db.writeQueue.Len(); exp != got {
+		t.Fatalf("wrong queue length after close, exp %d, got %d", exp, got)
+	}
+}
+
+func waitForQueueLen(t *testing.T, q *writeQueue, n int) {
+	t.Helper()
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if q.Len() == n {
+				return
+			}
+		case <-timer.C:
+			t.Fatalf("timed out waiting for write queue length %d, got %d", n, q.Len())
+		}
+	}
+}
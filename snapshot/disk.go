@@ -0,0 +1,15 @@
+//go:build !windows
+
+package snapshot
+
+import "syscall"
+
+// diskFree returns the number of bytes available to an unprivileged user on
+// the filesystem containing dir.
+func diskFree(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
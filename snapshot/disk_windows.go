@@ -0,0 +1,7 @@
+package snapshot
+
+// diskFree returns the number of bytes available on the filesystem containing
+// dir. It is not supported on Windows, so always returns errDiskFreeUnknown.
+func diskFree(dir string) (int64, error) {
+	return 0, errDiskFreeUnknown
+}
@@ -2,6 +2,7 @@ package snapshot
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -17,22 +18,68 @@ type Sink struct {
 	snapDirPath    string
 	snapTmpDirPath string
 	dataFD         *os.File
+	dataSz         int64
 	opened         bool
+
+	// w and wMeta are set if the Sink writes to a writer instead of the
+	// Store, see NewSinkWriter.
+	w     io.WriteCloser
+	wMeta *Meta
+
+	// FsyncOnClose, if true, makes Close fsync the snapshot data file and
+	// the temporary snapshot directory before the snapshot is moved into
+	// place, and fsync the final SQLite file once it has been created. The
+	// snapshot is then durable once Close returns, even if power is lost
+	// immediately after. It defaults to the FsyncOnClose setting of the
+	// Store.
+	FsyncOnClose bool
 }
 
 // NewSink creates a new Sink object.
 func NewSink(str *Store, meta *raft.SnapshotMeta) *Sink {
 	return &Sink{
-		str:  str,
-		meta: meta,
+		str:          str,
+		meta:         meta,
+		FsyncOnClose: str.FsyncOnClose,
 	}
 }
 
-// Open opens the sink for writing.
+// NewSinkWriter creates a new Sink which streams the snapshot data to w,
+// instead of to a Store. This allows a snapshot to be shipped to a remote
+// store, or captured in tests, without involving the local filesystem. The
+// Sink is used just like one created by NewSink, except that Close does no
+// more than close w, after setting the data size and format version of meta,
+// so that meta describes the data written. Cancel also closes w, so any
+// partial data already written must be discarded by w itself.
+func NewSinkWriter(w io.WriteCloser, meta *Meta) *Sink {
+	return &Sink{
+		meta:  &meta.SnapshotMeta,
+		w:     w,
+		wMeta: meta,
+	}
+}
+
+// Open opens the sink for writing. If the size of the snapshot is declared in
+// its meta, and there is not enough free disk space to hold it, an error
+// wrapping ErrInsufficientDiskSpace is returned. If the size is not declared,
+// or free space cannot be determined, the check is skipped. If any existing
+// snapshot in the Store has meta written in a version this code cannot read,
+// an error wrapping ErrUnsupportedMetaVersion is returned, since the new
+// snapshot may need to build on it.
 func (s *Sink) Open() error {
 	if s.opened {
 		return nil
 	}
+	if s.w != nil {
+		s.opened = true
+		return nil
+	}
+	if _, err := s.str.getSnapshots(); err != nil {
+		return err
+	}
+	if err := s.checkDiskSpace(); err != nil {
+		return err
+	}
 	s.opened = true
 
 	// Make temp snapshot directory
@@ -54,7 +101,13 @@ func (s *Sink) Open() error {
 // Write writes snapshot data to the sink. The snapshot is not in place
 // until Close is called.
 func (s *Sink) Write(p []byte) (n int, err error) {
-	return s.dataFD.Write(p)
+	if s.w != nil {
+		n, err = s.w.Write(p)
+	} else {
+		n, err = s.dataFD.Write(p)
+	}
+	s.dataSz += int64(n)
+	return n, err
 }
 
 // ID returns the ID of the snapshot being written.
@@ -63,17 +116,23 @@ func (s *Sink) ID() string {
 }
 
 // Cancel cancels the snapshot. Cancel must be called if the snapshot is not
-// going to be closed.
+// going to be closed. Any data written so far is removed, and the snapshots
+// already in the Store are left untouched. The data is removed even if closing
+// the data file fails.
 func (s *Sink) Cancel() error {
 	if !s.opened {
 		return nil
 	}
 	s.opened = false
-	if err := s.dataFD.Close(); err != nil {
-		return err
+	if s.w != nil {
+		return s.w.Close()
 	}
+	closeErr := s.dataFD.Close()
 	s.dataFD = nil
-	return RemoveAllTmpSnapshotData(s.str.Dir())
+	if err := RemoveAllTmpSnapshotData(s.str.Dir()); err != nil {
+		return err
+	}
+	return closeErr
 }
 
 // Close closes the sink, and finalizes creation of the snapshot. It is critical
@@ -85,7 +144,18 @@ func (s *Sink) Close() error {
 		return nil
 	}
 	s.opened = false
+	if s.w != nil {
+		s.wMeta.DataSize = s.dataSz
+		s.wMeta.FormatVersion = MetaVersion
+		return s.w.Close()
+	}
 
+	if s.FsyncOnClose {
+		if err := s.dataFD.Sync(); err != nil {
+			s.dataFD.Close()
+			return err
+		}
+	}
 	if err := s.dataFD.Close(); err != nil {
 		return err
 	}
@@ -94,6 +164,11 @@ func (s *Sink) Close() error {
 	if err := s.writeMeta(s.snapTmpDirPath); err != nil {
 		return err
 	}
+	if s.FsyncOnClose {
+		if err := syncDirMaybe(s.snapTmpDirPath); err != nil {
+			return err
+		}
+	}
 
 	if err := s.processSnapshotData(); err != nil {
 		return err
@@ -216,13 +291,42 @@ func (s *Sink) processSnapshotData() (retErr error) {
 			if err := openCloseDB(snapNewDB); err != nil {
 				return err
 			}
+			if s.FsyncOnClose {
+				if err := syncFile(snapNewDB); err != nil {
+					return err
+				}
+			}
 		}
 	}
 	return syncDirMaybe(s.str.Dir())
 }
 
+// checkDiskSpace checks there is enough free disk space for the declared
+// size of the snapshot.
+func (s *Sink) checkDiskSpace() error {
+	if s.meta.Size <= 0 {
+		return nil
+	}
+	free, err := s.str.diskFreeFn(s.str.Dir())
+	if err != nil {
+		if err != errDiskFreeUnknown {
+			s.str.logger.Printf("failed to check free disk space for snapshot %s: %s", s.meta.ID, err.Error())
+		}
+		return nil
+	}
+	if free < s.meta.Size {
+		return fmt.Errorf("%w: snapshot %s needs %d bytes, %d bytes available",
+			ErrInsufficientDiskSpace, s.meta.ID, s.meta.Size, free)
+	}
+	return nil
+}
+
 func (s *Sink) writeMeta(dir string) error {
-	return writeMeta(dir, s.meta)
+	return writeMeta(dir, &Meta{
+		SnapshotMeta:  *s.meta,
+		DataSize:      s.dataSz,
+		FormatVersion: MetaVersion,
+	})
 }
 
 func fileSize(path string) (int64, error) {
@@ -2,10 +2,13 @@ package snapshot
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/raft"
@@ -339,6 +342,269 @@ func Test_SinkCreateFullThenWALSnapshots(t *testing.T) {
 	}
 }
 
+// Test_SinkCancelPartialWrite tests that cancelling a sink after writing
+// partial data removes that data, and leaves the existing snapshot untouched.
+func Test_SinkCancelPartialWrite(t *testing.T) {
+	store := mustStore(t)
+	sink := NewSink(store, makeRaftMeta("snap-1234", 3, 2, 1))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	sqliteFile := mustOpenFile(t, "testdata/db-and-wals/backup.db")
+	defer sqliteFile.Close()
+	if _, err := io.Copy(sink, sqliteFile); err != nil {
+		t.Fatalf("Failed to copy SQLite file: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+
+	snapDir := filepath.Join(store.Dir(), "snap-1234")
+	dbPath := filepath.Join(store.Dir(), "snap-1234.db")
+	metaBefore, err := os.ReadFile(metaPath(snapDir))
+	if err != nil {
+		t.Fatalf("Failed to read meta: %v", err)
+	}
+	dbBefore, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to read database: %v", err)
+	}
+
+	sink = NewSink(store, makeRaftMeta("snap-2345", 4, 3, 2))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	b, err := os.ReadFile("testdata/db-and-wals/wal-00")
+	if err != nil {
+		t.Fatalf("Failed to read WAL file: %v", err)
+	}
+	if _, err := sink.Write(b[:len(b)/2]); err != nil {
+		t.Fatalf("Failed to write to sink: %v", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink: %v", err)
+	}
+
+	entries, err := os.ReadDir(store.Dir())
+	if err != nil {
+		t.Fatalf("Failed to read store directory: %v", err)
+	}
+	for _, e := range entries {
+		if isTmpName(e.Name()) || strings.HasPrefix(e.Name(), "snap-2345") {
+			t.Fatalf("Cancelled snapshot data remains: %s", e.Name())
+		}
+	}
+	snaps, err := store.List()
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snaps) != 1 || snaps[0].ID != "snap-1234" {
+		t.Fatalf("Unexpected snapshots after cancel: %s", asJSON(snaps))
+	}
+	if metaAfter, err := os.ReadFile(metaPath(snapDir)); err != nil || !bytes.Equal(metaBefore, metaAfter) {
+		t.Fatalf("Existing snapshot meta modified by cancel")
+	}
+	if dbAfter, err := os.ReadFile(dbPath); err != nil || !bytes.Equal(dbBefore, dbAfter) {
+		t.Fatalf("Existing snapshot database modified by cancel")
+	}
+
+	// A new snapshot can still be built on the existing one.
+	sink = NewSink(store, makeRaftMeta("snap-2345", 4, 3, 2))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	if _, err := sink.Write(b); err != nil {
+		t.Fatalf("Failed to write to sink: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+}
+
+func Test_SinkFsyncOnClose(t *testing.T) {
+	store := mustStore(t)
+	store.FsyncOnClose = true
+	createSnapshot := func(id string, index, term, cfgIndex uint64, file string) {
+		sink := NewSink(store, makeRaftMeta(id, index, term, cfgIndex))
+		if !sink.FsyncOnClose {
+			t.Fatalf("Sink did not inherit FsyncOnClose from Store")
+		}
+		if err := sink.Open(); err != nil {
+			t.Fatalf("Failed to open sink: %v", err)
+		}
+		fd := mustOpenFile(t, file)
+		defer fd.Close()
+		if _, err := io.Copy(sink, fd); err != nil {
+			t.Fatalf("Failed to copy file: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Failed to close sink: %v", err)
+		}
+	}
+
+	mustNonEmpty := func(path string) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		if fi.Size() == 0 {
+			t.Fatalf("File %s is empty", path)
+		}
+	}
+
+	createSnapshot("snap-1234", 3, 2, 1, "testdata/db-and-wals/backup.db")
+	mustNonEmpty(filepath.Join(store.Dir(), "snap-1234.db"))
+	mustNonEmpty(metaPath(filepath.Join(store.Dir(), "snap-1234")))
+
+	createSnapshot("snap-2345", 4, 3, 2, "testdata/db-and-wals/wal-00")
+	mustNonEmpty(filepath.Join(store.Dir(), "snap-2345.db"))
+	mustNonEmpty(metaPath(filepath.Join(store.Dir(), "snap-2345")))
+	if fileExists(filepath.Join(store.Dir(), "snap-2345.db-wal")) {
+		t.Fatalf("WAL file not replayed into snapshot database")
+	}
+	if !db.IsValidSQLiteFile(filepath.Join(store.Dir(), "snap-2345.db")) {
+		t.Fatalf("snapshot database is not a valid SQLite file")
+	}
+}
+
+func Test_SinkDataSize(t *testing.T) {
+	store := mustStore(t)
+	sink := NewSink(store, makeRaftMeta("snap-1234", 3, 2, 1))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	sqliteFile := mustOpenFile(t, "testdata/db-and-wals/backup.db")
+	defer sqliteFile.Close()
+	if _, err := io.Copy(sink, sqliteFile); err != nil {
+		t.Fatalf("Failed to copy SQLite file: %v", err)
+	}
+	sqliteFile.Close()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+
+	sink = NewSink(store, makeRaftMeta("snap-2345", 4, 3, 2))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	wal := mustOpenFile(t, "testdata/db-and-wals/wal-00")
+	defer wal.Close()
+	if _, err := io.Copy(sink, wal); err != nil {
+		t.Fatalf("Failed to copy WAL file: %v", err)
+	}
+	wal.Close()
+	store.reapDisabled = true
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+
+	metas, err := store.ListMeta()
+	if err != nil {
+		t.Fatalf("Failed to list snapshot meta: %v", err)
+	}
+	if len(metas) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(metas))
+	}
+	if exp, got := mustGetFileSize(t, "testdata/db-and-wals/backup.db"), metas[0].DataSize; exp != got {
+		t.Fatalf("Unexpected data size for full snapshot, exp %d, got %d", exp, got)
+	}
+	if exp, got := mustGetFileSize(t, "testdata/db-and-wals/wal-00"), metas[1].DataSize; exp != got {
+		t.Fatalf("Unexpected data size for WAL snapshot, exp %d, got %d", exp, got)
+	}
+
+	// The full snapshot data is moved into place as is, so the recorded size
+	// must match the size of the snapshot's database file on disk.
+	sink = NewSink(store, makeRaftMeta("snap-3456", 5, 4, 3))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	sqliteFile2 := mustOpenFile(t, "testdata/db-and-wals/full2.db")
+	defer sqliteFile2.Close()
+	if _, err := io.Copy(sink, sqliteFile2); err != nil {
+		t.Fatalf("Failed to copy SQLite file: %v", err)
+	}
+	sqliteFile2.Close()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+	metas, err = store.ListMeta()
+	if err != nil {
+		t.Fatalf("Failed to list snapshot meta: %v", err)
+	}
+	if exp, got := mustGetFileSize(t, filepath.Join(store.Dir(), "snap-3456.db")), metas[len(metas)-1].DataSize; exp != got {
+		t.Fatalf("Unexpected data size for snapshot, exp %d, got %d", exp, got)
+	}
+}
+
+// Test_SinkMetaVersion tests that a Sink writes the current meta version,
+// that unversioned meta is upgraded when read, and that a Sink refuses to open
+// when an existing snapshot's meta version is too new.
+func Test_SinkMetaVersion(t *testing.T) {
+	store := mustStore(t)
+	sink := NewSink(store, makeRaftMeta("snap-1234", 3, 2, 1))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	sqliteFile := mustOpenFile(t, "testdata/db-and-wals/backup.db")
+	defer sqliteFile.Close()
+	if _, err := io.Copy(sink, sqliteFile); err != nil {
+		t.Fatalf("Failed to copy SQLite file: %v", err)
+	}
+	sqliteFile.Close()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+	metas, err := store.ListMeta()
+	if err != nil {
+		t.Fatalf("Failed to list snapshot meta: %v", err)
+	}
+	if exp, got := MetaVersion, metas[0].FormatVersion; exp != got {
+		t.Fatalf("Unexpected meta version, exp %d, got %d", exp, got)
+	}
+
+	// Meta written before versioning has no version, and is upgraded.
+	snapDir := filepath.Join(store.Dir(), "snap-1234")
+	legacy := struct {
+		raft.SnapshotMeta
+		DataSize int64
+	}{metas[0].SnapshotMeta, metas[0].DataSize}
+	b, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatalf("Failed to marshal legacy meta: %v", err)
+	}
+	if err := os.WriteFile(metaPath(snapDir), b, 0644); err != nil {
+		t.Fatalf("Failed to write legacy meta: %v", err)
+	}
+	metas, err = store.ListMeta()
+	if err != nil {
+		t.Fatalf("Failed to list legacy snapshot meta: %v", err)
+	}
+	if exp, got := MetaVersion, metas[0].FormatVersion; exp != got {
+		t.Fatalf("Unexpected upgraded meta version, exp %d, got %d", exp, got)
+	}
+	sink = NewSink(store, makeRaftMeta("snap-2345", 4, 3, 2))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink with legacy snapshot: %v", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink: %v", err)
+	}
+
+	// Meta written by newer code must be rejected.
+	m := metas[0]
+	m.FormatVersion = MetaVersion + 1
+	if err := writeMeta(snapDir, m); err != nil {
+		t.Fatalf("Failed to write meta: %v", err)
+	}
+	sink = NewSink(store, makeRaftMeta("snap-2345", 4, 3, 2))
+	if err := sink.Open(); !errors.Is(err, ErrUnsupportedMetaVersion) {
+		t.Fatalf("Expected ErrUnsupportedMetaVersion, got %v", err)
+	}
+	if _, err := store.ListMeta(); !errors.Is(err, ErrUnsupportedMetaVersion) {
+		t.Fatalf("Expected ErrUnsupportedMetaVersion listing meta, got %v", err)
+	}
+}
+
 func compareMetas(t *testing.T, m1, m2 *raft.SnapshotMeta) {
 	t.Helper()
 	if m1.ID != m2.ID {
@@ -378,6 +644,89 @@ func compareReaderToReader(t *testing.T, r1, r2 io.Reader) bool {
 	return bytes.Equal(buf1, buf2)
 }
 
+func Test_SinkInsufficientDiskSpace(t *testing.T) {
+	str := mustStore(t)
+	str.diskFreeFn = func(dir string) (int64, error) {
+		return 1000, nil
+	}
+
+	meta := makeRaftMeta("snap-1234", 3, 2, 1)
+	meta.Size = 1001
+	sink := NewSink(str, meta)
+	if err := sink.Open(); !errors.Is(err, ErrInsufficientDiskSpace) {
+		t.Fatalf("Expected ErrInsufficientDiskSpace, got %v", err)
+	}
+	if _, err := sink.Write([]byte("data")); err == nil {
+		t.Fatalf("Expected error writing to sink which failed to open")
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink which failed to open: %v", err)
+	}
+	if entries, err := os.ReadDir(str.Dir()); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected no snapshot data in store, got %v, %v", entries, err)
+	}
+
+	// Exactly enough space is sufficient.
+	meta.Size = 1000
+	sink = NewSink(str, meta)
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink: %v", err)
+	}
+
+	// The check is skipped if the size is unknown, or if free space cannot
+	// be determined.
+	meta.Size = 0
+	sink = NewSink(str, meta)
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink of unknown size: %v", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink: %v", err)
+	}
+	str.diskFreeFn = func(dir string) (int64, error) {
+		return 0, errDiskFreeUnknown
+	}
+	meta.Size = 1 << 40
+	sink = NewSink(str, meta)
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink when free space unknown: %v", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink: %v", err)
+	}
+
+	// Store.CreateWithSize performs the same check.
+	str.diskFreeFn = func(dir string) (int64, error) {
+		return 1000, nil
+	}
+	if _, err := str.CreateWithSize(1, 3, 2, makeTestConfiguration("1", "localhost:1"), 1, 2000, nil); !errors.Is(err, ErrInsufficientDiskSpace) {
+		t.Fatalf("Expected ErrInsufficientDiskSpace from CreateWithSize, got %v", err)
+	}
+	sink2, err := str.Create(1, 3, 2, makeTestConfiguration("1", "localhost:1"), 1, nil)
+	if err != nil {
+		t.Fatalf("Failed to create sink after failed create: %v", err)
+	}
+	if err := sink2.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink: %v", err)
+	}
+}
+
+func Test_DiskFree(t *testing.T) {
+	free, err := diskFree(t.TempDir())
+	if err == errDiskFreeUnknown {
+		t.Skip("free disk space not supported on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Failed to get free disk space: %v", err)
+	}
+	if free <= 0 {
+		t.Fatalf("Expected positive free disk space, got %d", free)
+	}
+}
+
 func mustStore(t *testing.T) *Store {
 	t.Helper()
 	str, err := NewStore(t.TempDir())
@@ -423,3 +772,77 @@ func asJSON(v interface{}) string {
 	}
 	return string(b)
 }
+
+// bufferCloser is an io.WriteCloser which records the data written to it,
+// and whether it has been closed.
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func Test_SinkWriter(t *testing.T) {
+	meta := &Meta{SnapshotMeta: *makeRaftMeta("snap-1234", 3, 2, 1)}
+	w := &bufferCloser{}
+	sink := NewSinkWriter(w, meta)
+	if sink.ID() != "snap-1234" {
+		t.Fatalf("Unexpected ID: %s", sink.ID())
+	}
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	b, err := os.ReadFile("testdata/db-and-wals/backup.db")
+	if err != nil {
+		t.Fatalf("Failed to read SQLite file: %v", err)
+	}
+	if _, err := io.Copy(sink, bytes.NewReader(b)); err != nil {
+		t.Fatalf("Failed to copy SQLite file: %v", err)
+	}
+	if w.closed {
+		t.Fatalf("Writer closed before sink closed")
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+	if !w.closed {
+		t.Fatalf("Writer not closed by sink")
+	}
+	if !bytes.Equal(b, w.Bytes()) {
+		t.Fatalf("Writer does not contain snapshot data")
+	}
+	if exp, got := int64(len(b)), meta.DataSize; exp != got {
+		t.Fatalf("Unexpected data size, exp %d, got %d", exp, got)
+	}
+	if exp, got := MetaVersion, meta.FormatVersion; exp != got {
+		t.Fatalf("Unexpected format version, exp %d, got %d", exp, got)
+	}
+
+	// Closing again is a no-op.
+	w.closed = false
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink twice: %v", err)
+	}
+	if w.closed {
+		t.Fatalf("Writer closed by second close")
+	}
+
+	// Cancelling closes the writer.
+	w = &bufferCloser{}
+	sink = NewSinkWriter(w, &Meta{SnapshotMeta: *makeRaftMeta("snap-2345", 4, 3, 2)})
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	if _, err := sink.Write(b[:100]); err != nil {
+		t.Fatalf("Failed to write to sink: %v", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink: %v", err)
+	}
+	if !w.closed {
+		t.Fatalf("Writer not closed by cancel")
+	}
+}
@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rqlite/rqlite/v8/db"
+)
+
+// ErrIncompleteSnapshot is returned when a Source is opened on a snapshot
+// which has not been completely written.
+var ErrIncompleteSnapshot = errors.New("snapshot incomplete")
+
+// Source is the counterpart of Sink, and reads a snapshot directly from its
+// directory in a Store, without opening the Store. This allows a snapshot to
+// be restored from, or copied to another node, by a process which does not
+// own the Store. Since a Source does not take the Store's lock, the Store
+// must not be reaping or pruning the snapshot while it is read. A process
+// which owns the Store should use Store.Open instead.
+type Source struct {
+	dir string
+}
+
+// NewSource returns a Source for the snapshot in the directory dir, which is
+// the directory holding the snapshot's meta, named after its ID.
+func NewSource(dir string) *Source {
+	return &Source{
+		dir: filepath.Clean(dir),
+	}
+}
+
+// Open checks that the snapshot is complete, and returns its meta, and a
+// reader of the snapshot data, which is a SQLite file. Close must be called
+// on the reader when finished with it. An error wrapping
+// ErrIncompleteSnapshot is returned if the snapshot has not been completely
+// written, for example because its directory is still a temporary working
+// directory, its meta is missing, or its data has not been written, or not
+// yet combined with any earlier snapshot.
+func (s *Source) Open() (*Meta, io.ReadCloser, error) {
+	id := filepath.Base(s.dir)
+	if isTmpName(id) {
+		return nil, nil, fmt.Errorf("%w: %s is a temporary snapshot directory", ErrIncompleteSnapshot, s.dir)
+	}
+	if !dirExists(s.dir) {
+		return nil, nil, fmt.Errorf("snapshot directory %s does not exist", s.dir)
+	}
+	if !fileExists(metaPath(s.dir)) {
+		return nil, nil, fmt.Errorf("%w: snapshot %s has no meta", ErrIncompleteSnapshot, id)
+	}
+	meta, err := readMeta(s.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.ID != id {
+		return nil, nil, fmt.Errorf("%w: snapshot %s has meta for snapshot %s", ErrIncompleteSnapshot, id, meta.ID)
+	}
+
+	dataPath := s.dir + ".db"
+	if fileExists(s.dir + ".db-wal") {
+		return nil, nil, fmt.Errorf("%w: snapshot %s has WAL data which has not been replayed", ErrIncompleteSnapshot, id)
+	}
+	if !db.IsValidSQLiteFile(dataPath) {
+		return nil, nil, fmt.Errorf("%w: snapshot %s has no valid SQLite data", ErrIncompleteSnapshot, id)
+	}
+	fd, err := os.Open(dataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, nil, err
+	}
+	// The size in the meta is only updated once the snapshot is complete.
+	if fi.Size() != meta.Size {
+		fd.Close()
+		return nil, nil, fmt.Errorf("%w: snapshot %s data is %d bytes, meta records %d bytes",
+			ErrIncompleteSnapshot, id, fi.Size(), meta.Size)
+	}
+	return meta, fd, nil
+}
@@ -0,0 +1,91 @@
+package snapshot
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Source(t *testing.T) {
+	store := mustStore(t)
+	sink := NewSink(store, makeRaftMeta("snap-1234", 3, 2, 1))
+	if err := sink.Open(); err != nil {
+		t.Fatalf("Failed to open sink: %v", err)
+	}
+	sqliteFile := mustOpenFile(t, "testdata/db-and-wals/backup.db")
+	if _, err := io.Copy(sink, sqliteFile); err != nil {
+		t.Fatalf("Failed to copy SQLite file: %v", err)
+	}
+	sqliteFile.Close()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+
+	dir := filepath.Join(store.Dir(), "snap-1234")
+	meta, rc, err := NewSource(dir).Open()
+	if err != nil {
+		t.Fatalf("Failed to open source: %v", err)
+	}
+	compareMetas(t, makeRaftMeta("snap-1234", 3, 2, 1), &meta.SnapshotMeta)
+	if exp, got := mustGetFileSize(t, "testdata/db-and-wals/backup.db"), meta.DataSize; exp != got {
+		t.Fatalf("Unexpected data size, exp %d, got %d", exp, got)
+	}
+	if !compareReaderToFile(t, rc, "testdata/db-and-wals/backup.db") {
+		t.Fatalf("Snapshot data does not match")
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatalf("Failed to close source reader: %v", err)
+	}
+
+	// Unreplayed WAL data means the snapshot is incomplete.
+	mustTouchFile(t, dir+".db-wal")
+	if _, _, err := NewSource(dir).Open(); !errors.Is(err, ErrIncompleteSnapshot) {
+		t.Fatalf("Expected ErrIncompleteSnapshot with WAL present, got %v", err)
+	}
+	if err := os.Remove(dir + ".db-wal"); err != nil {
+		t.Fatalf("Failed to remove WAL file: %v", err)
+	}
+
+	// So does a size which does not match the meta.
+	meta.Size++
+	if err := writeMeta(dir, meta); err != nil {
+		t.Fatalf("Failed to write meta: %v", err)
+	}
+	if _, _, err := NewSource(dir).Open(); !errors.Is(err, ErrIncompleteSnapshot) {
+		t.Fatalf("Expected ErrIncompleteSnapshot with size mismatch, got %v", err)
+	}
+}
+
+func Test_SourceIncomplete(t *testing.T) {
+	dir := t.TempDir()
+
+	// A temporary working directory.
+	tmpDir := filepath.Join(dir, "snap-1234"+tmpSuffix)
+	mustTouchDir(t, tmpDir)
+	if _, _, err := NewSource(tmpDir).Open(); !errors.Is(err, ErrIncompleteSnapshot) {
+		t.Fatalf("Expected ErrIncompleteSnapshot for temporary directory, got %v", err)
+	}
+
+	// A directory without meta.
+	snapDir := filepath.Join(dir, "snap-1234")
+	mustTouchDir(t, snapDir)
+	if _, _, err := NewSource(snapDir).Open(); !errors.Is(err, ErrIncompleteSnapshot) {
+		t.Fatalf("Expected ErrIncompleteSnapshot without meta, got %v", err)
+	}
+
+	// Meta, but no data.
+	if err := writeMeta(snapDir, &Meta{SnapshotMeta: *makeRaftMeta("snap-1234", 3, 2, 1)}); err != nil {
+		t.Fatalf("Failed to write meta: %v", err)
+	}
+	if _, _, err := NewSource(snapDir).Open(); !errors.Is(err, ErrIncompleteSnapshot) {
+		t.Fatalf("Expected ErrIncompleteSnapshot without data, got %v", err)
+	}
+
+	// A directory which does not exist is not a snapshot at all.
+	_, _, err := NewSource(filepath.Join(dir, "snap-5678")).Open()
+	if err == nil || errors.Is(err, ErrIncompleteSnapshot) {
+		t.Fatalf("Expected non-incomplete error for missing directory, got %v", err)
+	}
+}
@@ -81,11 +81,11 @@ func getSnapshots(dir string) ([]*raft.SnapshotMeta, error) {
 		// Try to read the meta data
 		meta, err := readMeta(filepath.Join(dir, dirName))
 		if err != nil {
-			return nil, fmt.Errorf("failed to read meta for snapshot %s: %s", dirName, err)
+			return nil, fmt.Errorf("failed to read meta for snapshot %s: %w", dirName, err)
 		}
 
 		// Append, but only return up to the retain count
-		snapMeta = append(snapMeta, meta)
+		snapMeta = append(snapMeta, &meta.SnapshotMeta)
 	}
 
 	sort.Sort(snapMetaSlice(snapMeta))
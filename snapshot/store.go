@@ -2,9 +2,11 @@ package snapshot
 
 import (
 	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
@@ -56,6 +58,45 @@ func ResetStats() {
 	stats.Add(snapshotOpenMRSWFail, 0)
 }
 
+var (
+	// ErrInsufficientDiskSpace is returned when a Sink is opened for a
+	// snapshot of known size, and there is not enough free disk space to
+	// hold it.
+	ErrInsufficientDiskSpace = errors.New("insufficient disk space")
+
+	// ErrUnsupportedMetaVersion is returned when a snapshot's meta was written
+	// in a format version newer than this code supports.
+	ErrUnsupportedMetaVersion = errors.New("unsupported snapshot meta version")
+
+	// ErrInvalidPruneKeep is returned by Prune when asked to keep fewer than
+	// one snapshot.
+	ErrInvalidPruneKeep = errors.New("at least one snapshot must be kept")
+
+	// errDiskFreeUnknown is returned when free disk space cannot be
+	// determined on this platform.
+	errDiskFreeUnknown = errors.New("free disk space unknown")
+)
+
+// MetaVersion is the version of the snapshot meta format written by this
+// code. Meta written before versioning was introduced has no version, and is
+// read as version 0.
+const MetaVersion = 1
+
+// Meta is the meta data stored with each snapshot.
+type Meta struct {
+	raft.SnapshotMeta
+
+	// DataSize is the number of bytes written to the Sink when the snapshot
+	// was created. For a full snapshot this is the size of the SQLite file, and
+	// for an incremental snapshot it is the size of the WAL data.
+	DataSize int64
+
+	// FormatVersion is the version of the meta format the snapshot was
+	// written in. It is distinct from the embedded Raft snapshot Version,
+	// which describes the Raft snapshot protocol.
+	FormatVersion int
+}
+
 // LockingSink is a wrapper around a SnapshotSink holds the CAS lock
 // while the Sink is in use.
 type LockingSink struct {
@@ -135,8 +176,17 @@ type Store struct {
 
 	mrsw *rsync.MultiRSW
 
+	// diskFreeFn returns the free space on the filesystem containing a
+	// directory. For testing purposes.
+	diskFreeFn func(dir string) (int64, error)
+
 	LogReaping   bool
 	reapDisabled bool // For testing purposes
+
+	// FsyncOnClose, if true, makes every Sink created by the Store fsync
+	// the snapshot data and directories when it is closed. See
+	// Sink.FsyncOnClose.
+	FsyncOnClose bool
 }
 
 // NewStore returns a new Snapshot Store.
@@ -150,6 +200,7 @@ func NewStore(dir string) (*Store, error) {
 		fullNeededPath: filepath.Join(dir, fullNeededFile),
 		logger:         log.New(os.Stderr, "[snapshot-store] ", log.LstdFlags),
 		mrsw:           rsync.NewMultiRSW(),
+		diskFreeFn:     diskFree,
 	}
 	str.logger.Printf("store initialized using %s", dir)
 
@@ -163,8 +214,25 @@ func NewStore(dir string) (*Store, error) {
 // the state of the store, and if those assumptions were changed by another Sink writing to the store
 // it could cause failures. Therefore we only allow 1 Sink to be in existence at a time. This shouldn't
 // be a problem, since snapshots are taken infrequently in one at a time.
+//
+// Create does not know the size of the snapshot, so no free disk space check
+// is made. This includes every snapshot created, or installed, by Raft, which
+// always calls Create. Use CreateWithSize to have the check made.
 func (s *Store) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration,
 	configurationIndex uint64, trans raft.Transport) (retSink raft.SnapshotSink, retErr error) {
+	return s.CreateWithSize(version, index, term, configuration, configurationIndex, 0, trans)
+}
+
+// CreateWithSize is identical to Create, but also takes the size of the
+// snapshot data which will be written to the Sink, if known. If size is
+// greater than zero, and there is not enough free disk space to hold the
+// data, an error wrapping ErrInsufficientDiskSpace is returned, so that
+// installing the snapshot fails immediately, rather than after filling the
+// disk. Raft does not report the size of a snapshot when calling Create, so
+// the check only applies to snapshots created through CreateWithSize, by
+// callers which know the size.
+func (s *Store) CreateWithSize(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration,
+	configurationIndex uint64, size int64, trans raft.Transport) (retSink raft.SnapshotSink, retErr error) {
 	if err := s.mrsw.BeginWrite(); err != nil {
 		stats.Add(snapshotCreateMRSWFail, 1)
 		return nil, err
@@ -182,6 +250,7 @@ func (s *Store) Create(version raft.SnapshotVersion, index, term uint64, configu
 		Configuration:      configuration,
 		ConfigurationIndex: configurationIndex,
 		Version:            version,
+		Size:               size,
 	}
 	sink := NewSink(s, meta)
 	if err := sink.Open(); err != nil {
@@ -205,7 +274,7 @@ func (s *Store) List() ([]*raft.SnapshotMeta, error) {
 		if err != nil {
 			return nil, err
 		}
-		snapMeta = append(snapMeta, meta) // Insert it.
+		snapMeta = append(snapMeta, &meta.SnapshotMeta) // Insert it.
 	}
 	return snapMeta, nil
 }
@@ -230,7 +299,27 @@ func (s *Store) Open(id string) (_ *raft.SnapshotMeta, _ io.ReadCloser, retErr e
 	if err != nil {
 		return nil, nil, err
 	}
-	return meta, NewLockingSnapshot(fd, s), nil
+	return &meta.SnapshotMeta, NewLockingSnapshot(fd, s), nil
+}
+
+// ListMeta returns the full meta data of every snapshot in the Store, sorted
+// from oldest to newest. Unlike List, the returned meta data includes the
+// number of bytes written for each snapshot, so callers do not need to stat
+// the snapshot data.
+func (s *Store) ListMeta() ([]*Meta, error) {
+	snapshots, err := s.getSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	metas := make([]*Meta, len(snapshots))
+	for i, snap := range snapshots {
+		meta, err := readMeta(filepath.Join(s.dir, snap.ID))
+		if err != nil {
+			return nil, err
+		}
+		metas[i] = meta
+	}
+	return metas, nil
 }
 
 // FullNeeded returns true if a full snapshot is needed.
@@ -271,9 +360,16 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	sizes := make(map[string]int64, len(snapshots))
+	for _, snap := range snapshots {
+		if sizes[snap.ID], err = SnapshotSize(filepath.Join(s.dir, snap.ID)); err != nil {
+			return nil, err
+		}
+	}
 	return map[string]interface{}{
 		"dir":       s.dir,
 		"snapshots": snapsAsIDs,
+		"sizes":     sizes,
 		"db_path":   dbPath,
 	}, nil
 }
@@ -315,6 +411,84 @@ func (s *Store) Reap() (retN int, retErr error) {
 	return n, nil
 }
 
+// Prune removes all snapshots, and all associated data, except the most
+// recent keep snapshots, and returns the paths removed. The most recent
+// snapshot holds the SQLite database, so keep must be at least one. Pruning
+// requires exclusive access to the Store, so if a Sink is open, or a
+// snapshot is being read, an error is returned and nothing is removed.
+func (s *Store) Prune(keep int) (removed []string, retErr error) {
+	if keep < 1 {
+		return nil, ErrInvalidPruneKeep
+	}
+	if err := s.mrsw.BeginWrite(); err != nil {
+		return nil, err
+	}
+	defer s.mrsw.EndWrite()
+	defer func() {
+		if retErr != nil {
+			stats.Add(snapshotsReapedFail, 1)
+		}
+	}()
+
+	snapshots, err := s.getSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) <= keep {
+		return nil, nil
+	}
+	n := 0
+	for _, snap := range snapshots[:len(snapshots)-keep] {
+		paths, err := removeAllPrefixPaths(s.dir, snap.ID)
+		removed = append(removed, paths...)
+		if err != nil {
+			return removed, err
+		}
+		if s.LogReaping {
+			s.logger.Printf("pruned snapshot %s", snap.ID)
+		}
+		n++
+	}
+	stats.Add(snapshotsReaped, int64(n))
+	return removed, syncDirMaybe(s.dir)
+}
+
+// SnapshotSize returns the number of bytes on disk used by the snapshot in
+// the directory dir, which is the directory holding the snapshot's meta,
+// named after its ID. This includes any data stored alongside the directory,
+// such as the SQLite file held by the most recent snapshot, so it is the
+// space which pruning the snapshot would free.
+func SnapshotSize(dir string) (int64, error) {
+	dir = filepath.Clean(dir)
+	if !dirExists(dir) {
+		return 0, fmt.Errorf("snapshot directory %s does not exist", dir)
+	}
+	paths, err := filepath.Glob(dir + "*")
+	if err != nil {
+		return 0, err
+	}
+	var sz int64
+	for _, p := range paths {
+		if err := filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			sz += fi.Size()
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	return sz, nil
+}
+
 // Dir returns the directory where the snapshots are stored.
 func (s *Store) Dir() string {
 	return s.dir
@@ -463,6 +637,15 @@ func syncDir(dir string) error {
 	return fh.Sync()
 }
 
+func syncFile(path string) error {
+	fh, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return fh.Sync()
+}
+
 func removeDirSync(dir string) error {
 	if err := os.RemoveAll(dir); err != nil {
 		return err
@@ -489,16 +672,25 @@ func syncDirMaybe(dir string) error {
 
 // removeAllPrefix removes all files in the given directory that have the given prefix.
 func removeAllPrefix(path, prefix string) error {
+	_, err := removeAllPrefixPaths(path, prefix)
+	return err
+}
+
+// removeAllPrefixPaths is like removeAllPrefix, but also returns the paths
+// removed.
+func removeAllPrefixPaths(path, prefix string) ([]string, error) {
 	files, err := filepath.Glob(filepath.Join(path, prefix) + "*")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	var removed []string
 	for _, f := range files {
 		if err := os.RemoveAll(f); err != nil {
-			return err
+			return removed, err
 		}
+		removed = append(removed, f)
 	}
-	return nil
+	return removed, nil
 }
 
 // metaPath returns the path to the meta file in the given directory.
@@ -507,23 +699,41 @@ func metaPath(dir string) string {
 }
 
 // readMeta is used to read the meta data in a given snapshot directory.
-func readMeta(dir string) (*raft.SnapshotMeta, error) {
+func readMeta(dir string) (*Meta, error) {
 	fh, err := os.Open(metaPath(dir))
 	if err != nil {
 		return nil, err
 	}
 	defer fh.Close()
 
-	meta := &raft.SnapshotMeta{}
+	meta := &Meta{}
 	dec := json.NewDecoder(fh)
 	if err := dec.Decode(meta); err != nil {
 		return nil, err
 	}
+	if err := upgradeMeta(meta); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", filepath.Base(dir), err)
+	}
 	return meta, nil
 }
 
+// upgradeMeta upgrades meta, as read from disk, to the current MetaVersion.
+// An error wrapping ErrUnsupportedMetaVersion is returned if the meta was
+// written by newer code, in a version this code cannot read.
+func upgradeMeta(meta *Meta) error {
+	switch {
+	case meta.FormatVersion > MetaVersion:
+		return fmt.Errorf("%w: meta version is %d, newest supported version is %d",
+			ErrUnsupportedMetaVersion, meta.FormatVersion, MetaVersion)
+	case meta.FormatVersion == 0:
+		// Unversioned meta has the same layout as version 1.
+		meta.FormatVersion = 1
+	}
+	return nil
+}
+
 // writeMeta is used to write the meta data in a given snapshot directory.
-func writeMeta(dir string, meta *raft.SnapshotMeta) error {
+func writeMeta(dir string, meta *Meta) error {
 	fh, err := os.Create(metaPath(dir))
 	if err != nil {
 		return fmt.Errorf("error creating meta file: %v", err)
@@ -3,6 +3,8 @@ package snapshot
 import (
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
 	"testing"
 
@@ -234,6 +236,164 @@ func Test_StoreList(t *testing.T) {
 	}
 }
 
+func Test_StorePrune(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create new store: %v", err)
+	}
+	store.reapDisabled = true
+
+	createSnapshot := func(id string, index, term, cfgIndex uint64, file string) {
+		sink := NewSink(store, makeRaftMeta(id, index, term, cfgIndex))
+		if err := sink.Open(); err != nil {
+			t.Fatalf("Failed to open sink: %v", err)
+		}
+		fd := mustOpenFile(t, file)
+		defer fd.Close()
+		if _, err := io.Copy(sink, fd); err != nil {
+			t.Fatalf("Failed to copy file: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Failed to close sink: %v", err)
+		}
+	}
+	createSnapshot("2-1017-1704807719996", 1017, 2, 1, "testdata/db-and-wals/backup.db")
+	createSnapshot("2-1131-1704807720976", 1131, 2, 1, "testdata/db-and-wals/wal-00")
+	createSnapshot("2-1200-1704807721976", 1200, 2, 1, "testdata/db-and-wals/wal-01")
+	createSnapshot("2-1300-1704807722976", 1300, 2, 1, "testdata/db-and-wals/wal-02")
+
+	if _, err := store.Prune(0); err != ErrInvalidPruneKeep {
+		t.Fatalf("Expected ErrInvalidPruneKeep, got %v", err)
+	}
+
+	// Nothing is pruned while a Sink is open.
+	sink, err := store.Create(1, 2, 3, makeTestConfiguration("1", "localhost:1"), 1, nil)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	if _, err := store.Prune(1); err != rsync.ErrMRSWConflict {
+		t.Fatalf("Expected MRSW conflict, got %v", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("Failed to cancel sink: %v", err)
+	}
+
+	removed, err := store.Prune(2)
+	if err != nil {
+		t.Fatalf("Failed to prune snapshots: %v", err)
+	}
+	exp := []string{
+		filepath.Join(dir, "2-1017-1704807719996"),
+		filepath.Join(dir, "2-1131-1704807720976"),
+	}
+	if !reflect.DeepEqual(exp, removed) {
+		t.Fatalf("Unexpected paths removed, exp %v, got %v", exp, removed)
+	}
+	for _, p := range removed {
+		if pathExists(p) {
+			t.Fatalf("Pruned path %s still exists", p)
+		}
+	}
+	snaps, err := store.getSnapshots()
+	if err != nil {
+		t.Fatalf("Failed to get snapshots: %v", err)
+	}
+	if exp, got := 2, len(snaps); exp != got {
+		t.Fatalf("Wrong number of snapshots after prune, exp %d, got %d", exp, got)
+	}
+	if !pathExists(filepath.Join(dir, "2-1300-1704807722976.db")) {
+		t.Fatalf("Database of most recent snapshot removed")
+	}
+
+	removed, err = store.Prune(2)
+	if err != nil {
+		t.Fatalf("Failed to prune snapshots: %v", err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("Expected nothing to be removed, got %v", removed)
+	}
+}
+
+func Test_SnapshotSize(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create new store: %v", err)
+	}
+	store.reapDisabled = true
+
+	createSnapshot := func(id string, index, term, cfgIndex uint64, file string) {
+		sink := NewSink(store, makeRaftMeta(id, index, term, cfgIndex))
+		if err := sink.Open(); err != nil {
+			t.Fatalf("Failed to open sink: %v", err)
+		}
+		fd := mustOpenFile(t, file)
+		defer fd.Close()
+		if _, err := io.Copy(sink, fd); err != nil {
+			t.Fatalf("Failed to copy file: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Failed to close sink: %v", err)
+		}
+	}
+
+	// A full snapshot holds the SQLite file written to it.
+	fullID := "2-1017-1704807719996"
+	fullDir := filepath.Join(dir, fullID)
+	createSnapshot(fullID, 1017, 2, 1, "testdata/db-and-wals/backup.db")
+	sz, err := SnapshotSize(fullDir)
+	if err != nil {
+		t.Fatalf("Failed to get snapshot size: %v", err)
+	}
+	dataSz := mustGetFileSize(t, "testdata/db-and-wals/backup.db")
+	if exp, got := dataSz+mustGetFileSize(t, metaPath(fullDir)), sz; exp != got {
+		t.Fatalf("Wrong size for full snapshot, exp %d, got %d", exp, got)
+	}
+	meta, err := readMeta(fullDir)
+	if err != nil {
+		t.Fatalf("Failed to read meta: %v", err)
+	}
+	if exp, got := dataSz, meta.DataSize; exp != got {
+		t.Fatalf("Wrong data size in meta, exp %d, got %d", exp, got)
+	}
+
+	// Once an incremental snapshot is taken, the SQLite file moves to it,
+	// leaving only the meta of the full snapshot.
+	incID := "2-1131-1704807720976"
+	incDir := filepath.Join(dir, incID)
+	createSnapshot(incID, 1131, 2, 1, "testdata/db-and-wals/wal-00")
+	if exp, got := mustGetFileSize(t, metaPath(fullDir)), mustSnapshotSize(t, fullDir); exp != got {
+		t.Fatalf("Wrong size for full snapshot after incremental, exp %d, got %d", exp, got)
+	}
+	if exp, got := mustGetFileSize(t, filepath.Join(dir, incID+".db"))+mustGetFileSize(t, metaPath(incDir)),
+		mustSnapshotSize(t, incDir); exp != got {
+		t.Fatalf("Wrong size for incremental snapshot, exp %d, got %d", exp, got)
+	}
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	sizes := stats["sizes"].(map[string]int64)
+	if exp, got := mustSnapshotSize(t, incDir), sizes[incID]; exp != got {
+		t.Fatalf("Wrong size in stats, exp %d, got %d", exp, got)
+	}
+
+	if _, err := SnapshotSize(filepath.Join(dir, "2-9999-1704807729999")); err == nil {
+		t.Fatalf("Expected error for nonexistent snapshot")
+	}
+}
+
+func mustSnapshotSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	sz, err := SnapshotSize(dir)
+	if err != nil {
+		t.Fatalf("Failed to get snapshot size: %v", err)
+	}
+	return sz
+}
+
 func mustTouchFile(t *testing.T, path string) {
 	t.Helper()
 	fd, err := os.Create(path)
@@ -85,7 +85,7 @@ func Upgrade7To8(old, new string, logger *log.Logger) (retErr error) {
 	if err := os.MkdirAll(newSnapshotPath, 0755); err != nil {
 		return fmt.Errorf("failed to create new snapshot directory %s: %s", newSnapshotPath, err)
 	}
-	if err := writeMeta(newSnapshotPath, oldMeta); err != nil {
+	if err := writeMeta(newSnapshotPath, &Meta{SnapshotMeta: *oldMeta}); err != nil {
 		return fmt.Errorf("failed to write new snapshot meta file to %s: %s", newSnapshotPath, err)
 	}
 
@@ -0,0 +1,380 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/command/proto"
+)
+
+var (
+	// ErrInvalidDelta is returned when delta backup data cannot be decoded.
+	ErrInvalidDelta = errors.New("invalid delta backup")
+
+	// ErrDeltaBaseMismatch is returned when a delta backup is applied to a
+	// database other than the one it was taken against.
+	ErrDeltaBaseMismatch = errors.New("delta backup base mismatch")
+)
+
+// deltaMagic identifies delta backup data.
+var deltaMagic = []byte("rqdelta1")
+
+// BackupRef describes a binary backup, as a checksum of each of its pages,
+// so that a delta backup can be taken against it.
+type BackupRef struct {
+	// PageSize is the page size of the backed-up database.
+	PageSize int
+
+	// Checksums is the SHA256 checksum of each page of the backup.
+	Checksums [][sha256.Size]byte
+}
+
+// NewBackupRef returns a BackupRef describing the binary backup read from r.
+func NewBackupRef(r io.Reader) (*BackupRef, error) {
+	hdr := make([]byte, 100)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("%w: reading SQLite header: %s", ErrInvalidDelta, err.Error())
+	}
+	pageSize := int(binary.BigEndian.Uint16(hdr[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("%w: bad page size %d", ErrInvalidDelta, pageSize)
+	}
+
+	ref := &BackupRef{PageSize: pageSize}
+	page := make([]byte, pageSize)
+	copy(page, hdr)
+	n := len(hdr)
+	for {
+		m, err := io.ReadFull(r, page[n:])
+		if err == io.EOF && n == 0 {
+			return ref, nil
+		}
+		if err != nil && err != io.EOF {
+			if err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("%w: partial page at end of backup", ErrInvalidDelta)
+			}
+			return nil, err
+		}
+		if n+m != pageSize {
+			return nil, fmt.Errorf("%w: partial page at end of backup", ErrInvalidDelta)
+		}
+		ref.Checksums = append(ref.Checksums, sha256.Sum256(page))
+		n = 0
+	}
+}
+
+// Hash returns a SHA256 hash identifying the backup described by the ref.
+func (r *BackupRef) Hash() [sha256.Size]byte {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, uint32(r.PageSize))
+	for _, c := range r.Checksums {
+		h.Write(c[:])
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// deltaHeader is the header of delta backup data. It is followed by the
+// page number and contents of each changed page.
+type deltaHeader struct {
+	BaseHash   [sha256.Size]byte // Hash of the BackupRef the delta is against.
+	ResultHash [sha256.Size]byte // Hash of the BackupRef after applying the delta.
+	PageSize   uint32
+	PageCount  uint32 // Number of pages in the database after applying the delta.
+	NumChanged uint32
+}
+
+// DeltaProvider implements the uploader Provider interface, providing a
+// delta backup of the Store's database at each call to Provide. A delta
+// holds only the pages which have changed since the last delta committed, or
+// since the base backup for the first delta, so a chain of deltas applied to
+// the base with RestoreDeltas reconstructs the database.
+//
+// A delta is only added to the chain once Commit is called, which the
+// Uploader does once the delta has been uploaded. Until then the next
+// Provide is taken against the same backup, so a delta which fails to upload
+// does not leave a gap in the chain. Each delta in the chain has a distinct
+// Name, which sorts in chain order, so that each can be stored separately.
+type DeltaProvider struct {
+	str *Store
+
+	// For testing purposes.
+	backupFn func(*proto.BackupRequest, io.Writer) error
+	lmFn     func() (time.Time, error)
+
+	mu        sync.Mutex
+	chain     string     // Identifies the chain, from the hash of the base.
+	seq       int        // Position in the chain of the next delta.
+	ref       *BackupRef // Backup reconstructed by the committed deltas.
+	lm        time.Time  // Last modified time of the database at the last commit.
+	pending   *BackupRef // Backup reconstructed by the delta last provided.
+	pendingLM time.Time  // Last modified time of the database at the last Provide.
+}
+
+// NewDeltaProvider returns a new DeltaProvider, which provides deltas
+// against the backup described by base.
+func NewDeltaProvider(s *Store, base BackupRef) *DeltaProvider {
+	h := base.Hash()
+	return &DeltaProvider{
+		str:      s,
+		backupFn: s.Backup,
+		lmFn:     s.dbLastModified,
+		chain:    fmt.Sprintf("%x", h[:8]),
+		ref:      &base,
+	}
+}
+
+// LastIndex returns the cluster-wide index the data managed by the DataProvider was
+// last modified by.
+func (p *DeltaProvider) LastIndex() (uint64, error) {
+	stats.Add(numProviderChecks, 1)
+	return p.str.DBAppliedIndex(), nil
+}
+
+// ShouldProvide returns whether the database has been modified since the
+// last delta was committed, as reported by its last modified time, and so
+// whether a new delta is needed. idx is not used.
+func (p *DeltaProvider) ShouldProvide(idx uint64) (bool, error) {
+	lm, err := p.lmFn()
+	if err != nil {
+		return false, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return lm.After(p.lm), nil
+}
+
+// Ref returns a BackupRef describing the backup reconstructed by applying
+// every delta committed so far to the base.
+func (p *DeltaProvider) Ref() *BackupRef {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ref
+}
+
+// Name returns the name of the delta written by the most recent Provide,
+// which is distinct for each delta in the chain. Names sort in chain order.
+func (p *DeltaProvider) Name() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Sprintf("delta-%s-%08d", p.chain, p.seq)
+}
+
+// Provide writes a delta backup of the database to w. The next delta is
+// taken against it only once Commit is called.
+func (p *DeltaProvider) Provide(w io.Writer) (retErr error) {
+	stats.Add(numProviderProvides, 1)
+	defer func() {
+		if retErr != nil {
+			stats.Add(numProviderProvidesFail, 1)
+		}
+	}()
+
+	lm, err := p.lmFn()
+	if err != nil {
+		return err
+	}
+	fd, err := createTemp(p.str.dbDir, backupScatchPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+	br := &proto.BackupRequest{
+		Format: proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY,
+	}
+	if err := p.backupFn(br, fd); err != nil {
+		return err
+	}
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ref, err := NewBackupRef(fd)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	base := p.ref
+	p.mu.Unlock()
+	if err := writeDelta(w, base, ref, fd); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending, p.pendingLM = ref, lm
+	return nil
+}
+
+// Commit adds the delta written by the most recent Provide to the chain, so
+// that the next delta is taken against it. It must only be called once the
+// delta has been stored. Calling Commit when there is no delta to commit is
+// a no-op.
+func (p *DeltaProvider) Commit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending == nil {
+		return
+	}
+	p.ref, p.lm = p.pending, p.pendingLM
+	p.pending = nil
+	p.seq++
+}
+
+// writeDelta writes to w a delta which transforms the backup described by
+// base into the backup described by ref, whose data is read from src.
+func writeDelta(w io.Writer, base, ref *BackupRef, src io.ReaderAt) error {
+	var changed []uint32
+	for i, c := range ref.Checksums {
+		if base.PageSize != ref.PageSize || i >= len(base.Checksums) || base.Checksums[i] != c {
+			changed = append(changed, uint32(i))
+		}
+	}
+	hdr := deltaHeader{
+		BaseHash:   base.Hash(),
+		ResultHash: ref.Hash(),
+		PageSize:   uint32(ref.PageSize),
+		PageCount:  uint32(len(ref.Checksums)),
+		NumChanged: uint32(len(changed)),
+	}
+	if _, err := w.Write(deltaMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, &hdr); err != nil {
+		return err
+	}
+	page := make([]byte, ref.PageSize)
+	for _, pgno := range changed {
+		if _, err := src.ReadAt(page, int64(pgno)*int64(ref.PageSize)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, pgno); err != nil {
+			return err
+		}
+		if _, err := w.Write(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyDelta applies the delta backup read from r to the database file at
+// path, which must be the backup the delta was taken against, or the result
+// of applying the preceding delta in the chain. If it is not, an error
+// wrapping ErrDeltaBaseMismatch is returned. The delta is applied to a copy
+// of the file, which then replaces it, so the file is not modified unless the
+// delta is applied in full.
+func ApplyDelta(path string, r io.Reader) (retErr error) {
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, deltaMagic) {
+		return fmt.Errorf("%w: bad magic", ErrInvalidDelta)
+	}
+	var hdr deltaHeader
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return fmt.Errorf("%w: reading header: %s", ErrInvalidDelta, err.Error())
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	ref, err := NewBackupRef(src)
+	if err != nil {
+		return err
+	}
+	if ref.Hash() != hdr.BaseHash {
+		return fmt.Errorf("%w: %s", ErrDeltaBaseMismatch, path)
+	}
+
+	fd, err := createTemp(filepath.Dir(path), filepath.Base(path)+".delta-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fd.Close()
+		if retErr != nil {
+			os.Remove(fd.Name())
+		}
+	}()
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(fd, src); err != nil {
+		return err
+	}
+
+	page := make([]byte, hdr.PageSize)
+	for i := uint32(0); i < hdr.NumChanged; i++ {
+		var pgno uint32
+		if err := binary.Read(r, binary.BigEndian, &pgno); err != nil {
+			return fmt.Errorf("%w: reading page number: %s", ErrInvalidDelta, err.Error())
+		}
+		if pgno >= hdr.PageCount {
+			return fmt.Errorf("%w: page %d out of range", ErrInvalidDelta, pgno)
+		}
+		if _, err := io.ReadFull(r, page); err != nil {
+			return fmt.Errorf("%w: reading page %d: %s", ErrInvalidDelta, pgno, err.Error())
+		}
+		if _, err := fd.WriteAt(page, int64(pgno)*int64(hdr.PageSize)); err != nil {
+			return err
+		}
+	}
+	if err := fd.Truncate(int64(hdr.PageCount) * int64(hdr.PageSize)); err != nil {
+		return err
+	}
+
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ref, err = NewBackupRef(fd)
+	if err != nil {
+		return err
+	}
+	if ref.Hash() != hdr.ResultHash {
+		return fmt.Errorf("%w: result of applying delta to %s does not match", ErrInvalidDelta, path)
+	}
+	if err := fd.Sync(); err != nil {
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// RestoreDeltas reconstructs a database at path, by writing the binary
+// backup read from base to it, and then applying each delta in turn. The
+// deltas must be in the order in which they were provided.
+func RestoreDeltas(path string, base io.Reader, deltas ...io.Reader) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fd, base); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	for i, d := range deltas {
+		if err := ApplyDelta(path, d); err != nil {
+			return fmt.Errorf("delta %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite/v8/db"
+)
+
+func Test_SingleNodeProvideDelta(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	mustInsert := func(from, to int) {
+		stmts := []string{`CREATE TABLE IF NOT EXISTS foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`}
+		for i := from; i < to; i++ {
+			stmts = append(stmts, fmt.Sprintf(`INSERT INTO foo(id, name) VALUES(%d, "fiona")`, i))
+		}
+		if _, err := s.Execute(executeRequestFromStrings(stmts, false, false)); err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+	}
+	mustInsert(0, 500)
+
+	// Take a full backup as the base.
+	var full bytes.Buffer
+	if err := NewProvider(s, false, false, false).Provide(&full); err != nil {
+		t.Fatalf("failed to provide full backup: %s", err.Error())
+	}
+	base, err := NewBackupRef(bytes.NewReader(full.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to create backup ref: %s", err.Error())
+	}
+	if len(base.Checksums) == 0 {
+		t.Fatalf("backup ref has no pages")
+	}
+
+	dp := NewDeltaProvider(s, *base)
+	lii, err := dp.LastIndex()
+	if err != nil {
+		t.Fatalf("failed to get last index: %s", err.Error())
+	}
+
+	mustInsert(500, 510)
+	newLii, err := dp.LastIndex()
+	if err != nil {
+		t.Fatalf("failed to get last index: %s", err.Error())
+	}
+	if newLii <= lii {
+		t.Fatalf("last index should have increased after write, was %d, now %d", lii, newLii)
+	}
+	if should, err := dp.ShouldProvide(newLii); err != nil || !should {
+		t.Fatalf("expected delta to be needed after write, got %v, err %v", should, err)
+	}
+	var delta1 bytes.Buffer
+	if err := dp.Provide(&delta1); err != nil {
+		t.Fatalf("failed to provide first delta: %s", err.Error())
+	}
+
+	// Until the delta is committed, the chain does not advance.
+	if dp.Ref().Hash() != base.Hash() {
+		t.Fatalf("delta provider ref advanced before commit")
+	}
+	name1 := dp.Name()
+	dp.Commit()
+	if dp.Ref().Hash() == base.Hash() {
+		t.Fatalf("delta provider ref not advanced by commit")
+	}
+
+	mustInsert(510, 520)
+	lii, err = dp.LastIndex()
+	if err != nil {
+		t.Fatalf("failed to get last index: %s", err.Error())
+	}
+	if should, err := dp.ShouldProvide(lii); err != nil || !should {
+		t.Fatalf("expected delta to be needed after write, got %v, err %v", should, err)
+	}
+	var delta2 bytes.Buffer
+	if err := dp.Provide(&delta2); err != nil {
+		t.Fatalf("failed to provide second delta: %s", err.Error())
+	}
+	if name2 := dp.Name(); name2 <= name1 {
+		t.Fatalf("delta names not distinct and in chain order, got %s then %s", name1, name2)
+	}
+	dp.Commit()
+	for i, d := range []*bytes.Buffer{&delta1, &delta2} {
+		if d.Len() >= full.Len() {
+			t.Fatalf("delta %d is not smaller than full backup, delta %d bytes, full %d bytes", i, d.Len(), full.Len())
+		}
+	}
+
+	// Reconstruct the database from the base and both deltas, and check it.
+	path := filepath.Join(t.TempDir(), "restored.db")
+	if err := RestoreDeltas(path, bytes.NewReader(full.Bytes()),
+		bytes.NewReader(delta1.Bytes()), bytes.NewReader(delta2.Bytes())); err != nil {
+		t.Fatalf("failed to restore deltas: %s", err.Error())
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open restored database: %s", err.Error())
+	}
+	ref, err := NewBackupRef(fd)
+	fd.Close()
+	if err != nil {
+		t.Fatalf("failed to create backup ref for restored database: %s", err.Error())
+	}
+	if ref.Hash() != dp.Ref().Hash() {
+		t.Fatalf("restored database does not match delta provider ref")
+	}
+
+	rdb, err := db.Open(path, false, false)
+	if err != nil {
+		t.Fatalf("failed to open restored database: %s", err.Error())
+	}
+	defer rdb.Close()
+	rows, err := rdb.QueryStringStmt(`SELECT COUNT(*) FROM foo`)
+	if err != nil {
+		t.Fatalf("failed to query restored database: %s", err.Error())
+	}
+	if exp, got := `[[520]]`, asJSON(rows[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+
+	// Applying a delta out of order must fail, leaving the database untouched.
+	path = filepath.Join(t.TempDir(), "outoforder.db")
+	err = RestoreDeltas(path, bytes.NewReader(full.Bytes()), bytes.NewReader(delta2.Bytes()))
+	if !errors.Is(err, ErrDeltaBaseMismatch) {
+		t.Fatalf("expected ErrDeltaBaseMismatch, got %v", err)
+	}
+	if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, full.Bytes()) {
+		t.Fatalf("database modified by failed delta application")
+	}
+
+	// A delta which is cut short must fail, leaving the database untouched,
+	// and no temporary copy behind.
+	dir := t.TempDir()
+	path = filepath.Join(dir, "short.db")
+	d1 := delta1.Bytes()
+	err = RestoreDeltas(path, bytes.NewReader(full.Bytes()), bytes.NewReader(d1[:len(d1)-100]))
+	if !errors.Is(err, ErrInvalidDelta) {
+		t.Fatalf("expected ErrInvalidDelta, got %v", err)
+	}
+	if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, full.Bytes()) {
+		t.Fatalf("database modified by failed delta application")
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("expected only the database in %s, got %v, err %v", dir, entries, err)
+	}
+}
+
+func Test_ApplyDeltaInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+	if err := ApplyDelta(path, bytes.NewReader([]byte("not a delta"))); !errors.Is(err, ErrInvalidDelta) {
+		t.Fatalf("expected ErrInvalidDelta, got %v", err)
+	}
+}
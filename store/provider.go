@@ -1,46 +1,508 @@
 package store
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash"
 	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rqlite/rqlite/v8/command/proto"
+	sql "github.com/rqlite/rqlite/v8/db"
 )
 
+var (
+	// ErrDryRun is returned by Provide when the Provider is in dry-run mode
+	// and the dry run completed successfully. Nothing is written to the
+	// destination during a dry run.
+	ErrDryRun error = &skipError{msg: "provider dry run", dryRun: true}
+
+	// ErrPaused is returned by Provide when the Provider is paused. Nothing
+	// is read from the database or written to the destination while paused.
+	ErrPaused error = &skipError{msg: "provider paused, skipped"}
+
+	// ErrBackupVerifyFailed is returned by Provide when verification is
+	// enabled and the backup fails its integrity check.
+	ErrBackupVerifyFailed = errors.New("backup failed integrity check")
+
+	// ErrChecksumMismatch is returned when a backup does not match the
+	// checksum recorded in its checksum file.
+	ErrChecksumMismatch = errors.New("backup checksum mismatch")
+)
+
+// skipError is the type of the errors returned by Provide when it
+// deliberately writes nothing to the destination. It implements the
+// auto-backup Uploader's SkipError, so the Uploader skips the upload.
+type skipError struct {
+	msg    string
+	dryRun bool
+}
+
+func (e *skipError) Error() string {
+	return e.msg
+}
+
+// DryRun returns whether the backup was produced, but not written.
+func (e *skipError) DryRun() bool {
+	return e.dryRun
+}
+
+// checksumFileSuffix is appended to the path of a backup to form the path of
+// its checksum file.
+const checksumFileSuffix = ".sha256"
+
+// DryRunResult describes the outcome of a dry-run Provide.
+type DryRunResult struct {
+	// Size is the number of bytes the Provider would have written.
+	Size int64
+
+	// Checksum is the hex-encoded SHA256 checksum of the bytes the
+	// Provider would have written.
+	Checksum string
+}
+
+// ProvideResult describes a successful Provide.
+type ProvideResult struct {
+	// Time is the time at which the Provide started.
+	Time time.Time
+
+	// Size is the number of bytes written.
+	Size int64
+
+	// Checksum is the hex-encoded SHA256 checksum of the bytes written.
+	Checksum string
+
+	// Pinned is whether the pin policy selected this Provide for pinning.
+	// Data from a pinned Provide should be retained, and not pruned.
+	Pinned bool
+}
+
+// PinPolicy decides whether a Provide starting at now should be pinned. last
+// is the time of the most recent pinned Provide, and is the zero time if no
+// Provide has been pinned.
+type PinPolicy func(now, last time.Time) bool
+
+// PinFirstOfMonth is a PinPolicy which pins the first Provide of each
+// calendar month, in UTC.
+func PinFirstOfMonth(now, last time.Time) bool {
+	if last.IsZero() {
+		return true
+	}
+	ny, nm, _ := now.UTC().Date()
+	ly, lm, _ := last.UTC().Date()
+	return ny != ly || nm != lm
+}
+
+// RetryPolicy controls how a Provider retries a backup which fails.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a failed backup is retried, before
+	// Provide gives up.
+	MaxRetries int
+
+	// BaseInterval is the wait before the first retry.
+	BaseInterval time.Duration
+
+	// MaxInterval is the longest wait between retries.
+	MaxInterval time.Duration
+
+	// Multiplier is the factor by which the wait grows after each failure.
+	// If less than or equal to 1, 2 is used.
+	Multiplier float64
+
+	// Jitter is the fraction, between 0 and 1, by which each wait may be
+	// randomly shortened, so that many Providers retrying against the same
+	// storage do not do so in lockstep. If 0, waits are not jittered.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the RetryPolicy used by a Provider, unless
+// another is set.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:   10,
+		BaseInterval: 500 * time.Millisecond,
+		MaxInterval:  10 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.5,
+	}
+}
+
 // Provider implements the uploader Provider interface, allowing the
 // Store to be used as a DataProvider for an uploader.
 type Provider struct {
 	str      *Store
 	vacuum   bool
 	compress bool
+	checksum bool
+
+	// For testing purposes.
+	backupFn func(*proto.BackupRequest, io.Writer) error
+	sleepFn  func(context.Context, time.Duration) error
+	nowFn    func() time.Time
+	hashFn   func() ([]byte, error)
+	indexFn  func() uint64
+	lmFn     func() (time.Time, error)
+
+	mu         sync.Mutex
+	format     proto.BackupRequest_Format
+	retry      RetryPolicy
+	backoff    time.Duration
+	paused     bool
+	dryRun     bool
+	lastDryRun *DryRunResult
+	pinPolicy  PinPolicy
+	lastPinned time.Time
+	lastResult *ProvideResult
+	tracer     Tracer
+	verify     bool
+	fastCopy   bool
 
-	nRetries      int
-	retryInterval time.Duration
+	lastDuration time.Duration // Duration of the last successful backup.
+
+	minInterval time.Duration
+	checkedIdx  uint64 // Index returned by the most recent LastIndex.
+	providedIdx uint64 // Index returned by LastIndex before the last Provide.
+
+	hashCheck   bool
+	lastHash    []byte // Content hash of the data last provided.
+	lastHashIdx uint64 // Index returned by LastIndex for lastHash.
+	nextHash    []byte // Content hash seen by the most recent LastIndex.
+	nextHashIdx uint64 // Index returned by the most recent LastIndex.
 }
 
 // NewProvider returns a new instance of Provider. If v is true, the
 // SQLite database will be VACUUMed before being provided. If c is
 // true, the SQLite database will be compressed before being provided.
-func NewProvider(s *Store, v, c bool) *Provider {
+// If cs is true, the checksum of the data last provided is made
+// available through Checksum, so it can be stored alongside the data.
+func NewProvider(s *Store, v, c, cs bool) *Provider {
 	return &Provider{
-		str:           s,
-		vacuum:        v,
-		compress:      c,
-		nRetries:      10,
-		retryInterval: 500 * time.Millisecond,
+		str:      s,
+		vacuum:   v,
+		compress: c,
+		checksum: cs,
+		format:   proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY,
+		retry:    DefaultRetryPolicy(),
+		backupFn: s.Backup,
+		sleepFn:  sleepContext,
+		nowFn:    time.Now,
+		hashFn:   s.contentHash,
+		indexFn:  s.DBAppliedIndex,
+		lmFn:     s.dbLastModified,
+	}
+}
+
+// SetFormat sets the format of the data provided, which is binary by
+// default. In SQL format the data is a text dump of the database, which can
+// be restored by executing it. If the Provider compresses, SQL-format data is
+// gzip-compressed just like binary data. A SQL-format backup cannot be
+// VACUUMed, so ErrInvalidBackupFormat is returned if the Provider was created
+// to VACUUM, or if the format is not recognized.
+func (p *Provider) SetFormat(f proto.BackupRequest_Format) error {
+	if f != proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY &&
+		f != proto.BackupRequest_BACKUP_REQUEST_FORMAT_SQL {
+		return ErrInvalidBackupFormat
+	}
+	if f == proto.BackupRequest_BACKUP_REQUEST_FORMAT_SQL && p.vacuum {
+		return ErrInvalidBackupFormat
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.format = f
+	return nil
+}
+
+// SetVerify sets whether the Provider verifies each binary backup before
+// providing it. When enabled the backup is first written to a temporary file,
+// which is opened read-only and checked with PRAGMA integrity_check. Only if
+// the check passes is the backup written to the destination, so a corrupt
+// backup is never provided. A backup which fails the check is retried like
+// any other failed backup. Verification needs space for a full uncompressed
+// copy of the database, and reads it in full. SQL-format backups are not
+// verified.
+func (p *Provider) SetVerify(b bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.verify = b
+}
+
+// SetFastCopy sets whether the Provider requests a fast copy when it
+// VACUUMs. When enabled, the database file is copied directly and the copy
+// vacuumed, rather than the database being copied page by page, if the
+// Store can do so safely. See Store.Backup for when that is. It has no effect
+// unless the Provider was created to VACUUM.
+func (p *Provider) SetFastCopy(b bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fastCopy = b
+}
+
+// SetMinInterval sets the minimum interval between successful Provides, as
+// enforced by ShouldProvide. If zero, there is no minimum.
+func (p *Provider) SetMinInterval(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.minInterval = d
+}
+
+// ShouldProvide returns whether a new Provide is worthwhile. idx must be the
+// index just returned by LastIndex, so that content hash checking, if enabled,
+// is taken into account without hashing the database again. A Provide is not
+// worthwhile if idx has not advanced since the last successful Provide, or if
+// less than the minimum interval has passed since that Provide started. If no
+// Provide has succeeded, it returns true.
+func (p *Provider) ShouldProvide(idx uint64) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastResult == nil {
+		return true, nil
+	}
+	if idx <= p.providedIdx {
+		return false, nil
+	}
+	if p.minInterval > 0 && p.nowFn().Sub(p.lastResult.Time) < p.minInterval {
+		stats.Add(numProviderDebounced, 1)
+		return false, nil
+	}
+	return true, nil
+}
+
+// SetRetryPolicy sets the policy for retrying failed backups. Any backoff
+// state built up by earlier failures is reset.
+func (p *Provider) SetRetryPolicy(policy RetryPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retry = policy
+	p.backoff = 0
+}
+
+// Pause pauses the Provider. While paused, Provide returns ErrPaused without
+// accessing the database or the destination. Pausing an already-paused
+// Provider is a no-op.
+func (p *Provider) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+}
+
+// Resume resumes a paused Provider. Resuming a Provider which is not paused
+// is a no-op.
+func (p *Provider) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+}
+
+// Paused returns whether the Provider is paused.
+func (p *Provider) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// SetDryRun sets whether the Provider runs in dry-run mode. In dry-run mode
+// Provide performs the complete backup, but discards the data instead of
+// writing it to the destination, recording only its size and checksum. This
+// allows configuration to be validated without producing any output.
+func (p *Provider) SetDryRun(b bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dryRun = b
+}
+
+// LastDryRun returns the result of the most recent successful dry run. If
+// no dry run has completed, ok is false.
+func (p *Provider) LastDryRun() (r DryRunResult, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastDryRun == nil {
+		return DryRunResult{}, false
+	}
+	return *p.lastDryRun, true
+}
+
+// SetPinPolicy sets the policy used to decide whether a Provide is pinned.
+// The Provider only records whether a Provide was pinned, retention of the
+// provided data is the responsibility of the caller. If policy is nil no
+// Provide is pinned.
+func (p *Provider) SetPinPolicy(policy PinPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pinPolicy = policy
+}
+
+// LastProvide returns the result of the most recent successful Provide,
+// including whether it was pinned. Dry runs are not included. If no Provide
+// has succeeded, ok is false.
+func (p *Provider) LastProvide() (r ProvideResult, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastResult == nil {
+		return ProvideResult{}, false
+	}
+	return *p.lastResult, true
+}
+
+// Checksum returns the hex-encoded SHA256 checksum of the data written by the
+// most recent successful Provide, which is computed as the data is written, so
+// the data is not read again. If the Provider was not created to checksum, or
+// no Provide has succeeded, ok is false.
+func (p *Provider) Checksum() (sum string, ok bool) {
+	if !p.checksum {
+		return "", false
 	}
+	r, ok := p.LastProvide()
+	return r.Checksum, ok
+}
+
+// LastDuration returns how long the most recent successful backup took,
+// including a dry-run backup. Only the successful attempt is timed, so
+// earlier failed attempts and waits between retries are not included. If no
+// backup has succeeded, 0 is returned.
+func (p *Provider) LastDuration() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastDuration
+}
+
+// SetContentHashCheck sets whether the Provider detects changes by comparing
+// the content hash of the database with that of the data last provided. When
+// enabled, LastIndex returns the same index as it did before the last Provide
+// if the logical contents of the database have not changed since, even if
+// the database has been written to, so no new upload is made. Hashing reads
+// the whole database, so this is more expensive than relying on the index
+// alone, and should only be enabled if redundant uploads are costly.
+func (p *Provider) SetContentHashCheck(b bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hashCheck = b
+	p.lastHash, p.nextHash = nil, nil
 }
 
 // LastIndex returns the cluster-wide index the data managed by the DataProvider was
 // last modified by.
 func (p *Provider) LastIndex() (uint64, error) {
 	stats.Add(numProviderChecks, 1)
-	return p.str.DBAppliedIndex(), nil
+	idx := p.indexFn()
+
+	p.mu.Lock()
+	hashCheck := p.hashCheck
+	if !hashCheck {
+		p.checkedIdx = idx
+	}
+	p.mu.Unlock()
+	if !hashCheck {
+		return idx, nil
+	}
+	h, err := p.hashFn()
+	if err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastHash != nil && bytes.Equal(h, p.lastHash) {
+		stats.Add(numProviderUnchanged, 1)
+		p.checkedIdx = p.lastHashIdx
+		return p.lastHashIdx, nil
+	}
+	p.nextHash, p.nextHashIdx = h, idx
+	p.checkedIdx = idx
+	return idx, nil
+}
+
+// LastModified returns the time the SQLite database was last modified. In
+// WAL mode the WAL is taken into account, so writes which have not yet been
+// checkpointed into the database file are not missed.
+func (p *Provider) LastModified() (time.Time, error) {
+	return p.lmFn()
+}
+
+// ProvideIfNewerThan provides the database to w, as Provide does, but only if
+// the database has been modified after since. It returns the last modified
+// time of the database, and whether a Provide was made. If the database has
+// not been modified nothing is read from the database or written to w. The
+// caller should pass the returned time as since to the next call.
+//
+// Providing a binary backup may itself checkpoint the database, modifying
+// its files, so after a Provide the time is read again, and returned, if the
+// database has not been written to in the meantime. Otherwise the time read
+// before the Provide is returned, so that such writes are not missed by the
+// next call.
+func (p *Provider) ProvideIfNewerThan(w io.Writer, since time.Time) (time.Time, bool, error) {
+	idx := p.indexFn()
+	lm, err := p.LastModified()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if !lm.After(since) {
+		return lm, false, nil
+	}
+	if err := p.Provide(w); err != nil {
+		return lm, false, err
+	}
+	if p.indexFn() != idx {
+		return lm, true, nil
+	}
+	if lmPost, err := p.LastModified(); err == nil && lmPost.After(lm) {
+		lm = lmPost
+	}
+	return lm, true, nil
 }
 
 // Provider writes the SQLite database to the given path. If path exists,
-// it will be overwritten.
-func (p *Provider) Provide(w io.Writer) (retErr error) {
+// it will be overwritten. If the Provider is paused ErrPaused is returned.
+// If the Provider is in dry-run mode nothing is written to w, and ErrDryRun
+// is returned if the dry run succeeded.
+func (p *Provider) Provide(w io.Writer) error {
+	return p.ProvideWithContext(context.Background(), w)
+}
+
+// ProvideWithContext is like Provide, but can be cancelled through ctx. Once
+// ctx is done, any wait to retry a failed backup is abandoned, the backup in
+// progress fails at its next write to w, and ctx's error is returned. Work
+// done by the backup before it first writes to w, such as a VACUUM, runs to
+// completion. If a Tracer is set, the span recording the Provide is started
+// as a child of any span carried by ctx.
+func (p *Provider) ProvideWithContext(ctx context.Context, w io.Writer) (retErr error) {
+	ctx, span := p.startSpan(ctx, spanProvide)
+	defer func() {
+		if retErr != nil && retErr != ErrDryRun && retErr != ErrPaused {
+			span.RecordError(retErr)
+		}
+		span.End()
+	}()
+
+	p.mu.Lock()
+	paused, dryRun, format := p.paused, p.dryRun, p.format
+	p.mu.Unlock()
+	if paused {
+		span.SetAttribute(attrProviderPaused, true)
+		stats.Add(numProviderPausedSkips, 1)
+		return ErrPaused
+	}
+	if format == proto.BackupRequest_BACKUP_REQUEST_FORMAT_SQL {
+		span.SetAttribute(attrBackupFormat, backupFormatSQL)
+	} else {
+		span.SetAttribute(attrBackupFormat, backupFormatBinary)
+	}
+	span.SetAttribute(attrBackupVacuum, p.vacuum)
+	span.SetAttribute(attrBackupCompress, p.compress)
+	if dryRun {
+		span.SetAttribute(attrProviderDryRun, true)
+		return p.provideDryRun(ctx, span)
+	}
+
 	stats.Add(numProviderProvides, 1)
 	defer func() {
 		if retErr != nil {
@@ -48,22 +510,307 @@ func (p *Provider) Provide(w io.Writer) (retErr error) {
 		}
 	}()
 
+	now := p.nowFn()
+	hw := &hashingWriter{h: sha256.New()}
+	if err := p.provide(ctx, io.MultiWriter(w, hw)); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The data provided is at least as recent as the index returned by the
+	// last call to LastIndex.
+	p.providedIdx = p.checkedIdx
+	if p.hashCheck && p.nextHash != nil {
+		// The data provided is at least as recent as that hashed by the last
+		// call to LastIndex, so if the hash is seen again nothing has changed.
+		p.lastHash, p.lastHashIdx = p.nextHash, p.nextHashIdx
+		p.nextHash = nil
+	}
+	r := &ProvideResult{
+		Time:     now,
+		Size:     hw.n,
+		Checksum: hex.EncodeToString(hw.h.Sum(nil)),
+	}
+	if p.pinPolicy != nil && p.pinPolicy(now, p.lastPinned) {
+		r.Pinned = true
+		p.lastPinned = now
+		stats.Add(numProviderPinned, 1)
+	}
+	span.SetAttribute(attrBackupSize, r.Size)
+	span.SetAttribute(attrBackupChecksum, r.Checksum)
+	span.SetAttribute(attrProviderPinned, r.Pinned)
+	p.lastResult = r
+	return nil
+}
+
+func (p *Provider) provideDryRun(ctx context.Context, span Span) (retErr error) {
+	stats.Add(numProviderDryRuns, 1)
+	defer func() {
+		if retErr != ErrDryRun {
+			stats.Add(numProviderDryRunsFail, 1)
+		}
+	}()
+
+	hw := &hashingWriter{h: sha256.New()}
+	if err := p.provide(ctx, hw); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastDryRun = &DryRunResult{
+		Size:     hw.n,
+		Checksum: hex.EncodeToString(hw.h.Sum(nil)),
+	}
+	span.SetAttribute(attrBackupSize, p.lastDryRun.Size)
+	span.SetAttribute(attrBackupChecksum, p.lastDryRun.Checksum)
+	return ErrDryRun
+}
+
+func (p *Provider) provide(ctx context.Context, w io.Writer) error {
+	p.mu.Lock()
+	format, fastCopy := p.format, p.fastCopy
+	p.mu.Unlock()
 	br := &proto.BackupRequest{
-		Format:   proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY,
+		Format:   format,
 		Vacuum:   p.vacuum,
 		Compress: p.compress,
+		FastCopy: fastCopy,
 	}
+	cw := &contextWriter{ctx: ctx, w: w}
+	// Backoff only grows across the retries within this call.
+	p.resetBackoff()
 	nRetries := 0
 	for {
-		err := p.str.Backup(br, w)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := p.nowFn()
+		err := p.backupAttempt(ctx, br, cw, nRetries+1)
 		if err == nil {
+			p.recordDuration(p.nowFn().Sub(start))
+			p.resetBackoff()
 			break
 		}
-		time.Sleep(p.retryInterval)
-		nRetries++
-		if nRetries > p.nRetries {
+		if cErr := ctx.Err(); cErr != nil {
+			return cErr
+		}
+		d, maxRetries := p.nextBackoff()
+		if nRetries >= maxRetries {
 			return err
 		}
+		if sErr := p.sleepFn(ctx, d); sErr != nil {
+			return sErr
+		}
+		nRetries++
 	}
 	return nil
 }
+
+// backupAttempt makes a single attempt to back up the database to w,
+// recording it as a child span of any span carried by ctx.
+func (p *Provider) backupAttempt(ctx context.Context, br *proto.BackupRequest, w io.Writer, attempt int) error {
+	_, span := p.startSpan(ctx, spanBackupAttempt)
+	defer span.End()
+	span.SetAttribute(attrBackupAttempt, attempt)
+	err := p.backup(br, w)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// backup backs up the database to w, as described by br.
+func (p *Provider) backup(br *proto.BackupRequest, w io.Writer) error {
+	p.mu.Lock()
+	verify := p.verify
+	p.mu.Unlock()
+	if verify && br.Format == proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY {
+		return p.backupVerified(br, w)
+	}
+	if br.Format != proto.BackupRequest_BACKUP_REQUEST_FORMAT_SQL || !br.Compress {
+		return p.backupFn(br, w)
+	}
+	// The Store only compresses binary backups, so compress SQL here.
+	gw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	if err := p.backupFn(br, gw); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// backupVerified backs up the database to a temporary file, checks the
+// integrity of the file, and only then copies it to w, compressing it if
+// requested by br.
+func (p *Provider) backupVerified(br *proto.BackupRequest, w io.Writer) error {
+	fd, err := createTemp(p.str.dbDir, backupScatchPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	vbr := &proto.BackupRequest{
+		Format:   br.Format,
+		Vacuum:   br.Vacuum,
+		FastCopy: br.FastCopy,
+	}
+	if err := p.backupFn(vbr, fd); err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		return err
+	}
+	if err := verifyBackup(fd.Name()); err != nil {
+		stats.Add(numProviderVerifyFail, 1)
+		return err
+	}
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if !br.Compress {
+		_, err = io.Copy(w, fd)
+		return err
+	}
+	gw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(gw, fd); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// verifyBackup opens the SQLite database at path read-only, and runs a full
+// integrity check on it.
+func verifyBackup(path string) error {
+	d, err := sql.OpenReadOnly(path)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBackupVerifyFailed, err.Error())
+	}
+	defer d.Close()
+	rows, err := d.IntegrityCheck(true)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBackupVerifyFailed, err.Error())
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("%w: unexpected result", ErrBackupVerifyFailed)
+	}
+	if e := rows[0].GetError(); e != "" {
+		return fmt.Errorf("%w: %s", ErrBackupVerifyFailed, e)
+	}
+	vals := rows[0].GetValues()
+	if len(vals) != 1 || len(vals[0].GetParameters()) != 1 || vals[0].GetParameters()[0].GetS() != "ok" {
+		return fmt.Errorf("%w: %v", ErrBackupVerifyFailed, vals)
+	}
+	return nil
+}
+
+// readChecksumFile returns the checksum stored in the checksum file of the
+// backup at path. If there is no checksum file, ok is false.
+func readChecksumFile(path string) (checksum string, ok bool, err error) {
+	b, err := os.ReadFile(path + checksumFileSuffix)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", false, fmt.Errorf("checksum file %s is empty", path+checksumFileSuffix)
+	}
+	return strings.ToLower(fields[0]), true, nil
+}
+
+// nextBackoff returns the interval to wait before the next retry, and the
+// number of retries allowed by the retry policy. The interval starts at the
+// policy's BaseInterval, and grows by its Multiplier after each failure, up
+// to its MaxInterval, before jitter is applied. The backoff state is reset
+// at the start of each Provide, and when a backup succeeds.
+func (p *Provider) nextBackoff() (time.Duration, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.backoff == 0 {
+		p.backoff = p.retry.BaseInterval
+	} else {
+		m := p.retry.Multiplier
+		if m <= 1 {
+			m = 2
+		}
+		p.backoff = time.Duration(float64(p.backoff) * m)
+	}
+	if p.backoff > p.retry.MaxInterval || p.backoff < 0 {
+		p.backoff = p.retry.MaxInterval
+	}
+	d := p.backoff
+	if j := p.retry.Jitter; j > 0 && d > 0 {
+		if j > 1 {
+			j = 1
+		}
+		d -= time.Duration(rand.Float64() * j * float64(d))
+	}
+	return d, p.retry.MaxRetries
+}
+
+// recordDuration records d as the duration of the last successful backup.
+func (p *Provider) recordDuration(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastDuration = d
+	stats.Get(providerLastDurationMs).(*expvar.Int).Set(d.Milliseconds())
+}
+
+// resetBackoff resets the backoff state, so the next failure waits for the
+// minimum interval.
+func (p *Provider) resetBackoff() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backoff = 0
+}
+
+// sleepContext waits for d, returning early with ctx's error if ctx is done
+// first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// contextWriter is an io.Writer which writes to w, until ctx is done.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+// Write implements io.Writer.
+func (c *contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// hashingWriter is an io.Writer which discards all data written to it,
+// recording only the number of bytes written and their hash.
+type hashingWriter struct {
+	h hash.Hash
+	n int64
+}
+
+// Write implements io.Writer.
+func (hw *hashingWriter) Write(p []byte) (int, error) {
+	n, err := hw.h.Write(p)
+	hw.n += int64(n)
+	return n, err
+}
@@ -1,13 +1,24 @@
 package store
 
 import (
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	command "github.com/rqlite/rqlite/v8/command/proto"
+	"github.com/rqlite/rqlite/v8/db"
 )
 
 func test_SingleNodeProvide(t *testing.T, vacuum, compress bool) {
@@ -49,7 +60,7 @@ func test_SingleNodeProvide(t *testing.T, vacuum, compress bool) {
 	tmpFd := mustCreateTempFD()
 	defer os.Remove(tmpFd.Name())
 	defer tmpFd.Close()
-	provider := NewProvider(s0, vacuum, compress)
+	provider := NewProvider(s0, vacuum, compress, false)
 	if err := provider.Provide(tmpFd); err != nil {
 		t.Fatalf("failed to provide SQLite data: %s", err.Error())
 	}
@@ -113,6 +124,171 @@ func Test_SingleNodeProvide(t *testing.T) {
 	})
 }
 
+func test_SingleNodeProvideSQL(t *testing.T, compress bool) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT, data BLOB)`,
+		`INSERT INTO foo(id, name, data) VALUES(1, "fiona", x'00ff10')`,
+		`INSERT INTO foo(id, name, data) VALUES(2, "it's", NULL)`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	tmpFd := mustCreateTempFD()
+	defer os.Remove(tmpFd.Name())
+	defer tmpFd.Close()
+	provider := NewProvider(s, false, compress, false)
+	if err := provider.SetFormat(command.BackupRequest_BACKUP_REQUEST_FORMAT_SQL); err != nil {
+		t.Fatalf("failed to set SQL format: %s", err.Error())
+	}
+	if err := provider.Provide(tmpFd); err != nil {
+		t.Fatalf("failed to provide SQL data: %s", err.Error())
+	}
+
+	dumpFile := tmpFd.Name()
+	if compress {
+		f, err := gunzip(tmpFd.Name())
+		if err != nil {
+			t.Fatalf("failed to gunzip provided SQL data: %s", err.Error())
+		}
+		defer os.Remove(f)
+		dumpFile = f
+	}
+	dump, err := os.ReadFile(dumpFile)
+	if err != nil {
+		t.Fatalf("failed to read provided SQL data: %s", err.Error())
+	}
+	if !strings.Contains(string(dump), "X'00FF10'") {
+		t.Fatalf("BLOB not hex-encoded in dump: %s", dump)
+	}
+
+	// Restore the dump into a new database and check it.
+	dbPath := filepath.Join(t.TempDir(), "restored.db")
+	rDB, err := db.Open(dbPath, false, false)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer rDB.Close()
+	if _, err := rDB.ExecuteStringStmt(string(dump)); err != nil {
+		t.Fatalf("failed to execute dump: %s", err.Error())
+	}
+	rows, err := rDB.QueryStringStmt("SELECT id, name, hex(data) FROM foo ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to query restored database: %s", err.Error())
+	}
+	if exp, got := `[{"columns":["id","name","hex(data)"],"types":["integer","text","text"],"values":[[1,"fiona","00FF10"],[2,"it's",""]]}]`, asJSON(rows); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+// Test_SingleNodeProvideSQL tests that the Provider provides a SQL dump
+// which can be restored by executing it.
+func Test_SingleNodeProvideSQL(t *testing.T) {
+	t.Run("NoCompress", func(t *testing.T) {
+		test_SingleNodeProvideSQL(t, false)
+	})
+	t.Run("Compress", func(t *testing.T) {
+		test_SingleNodeProvideSQL(t, true)
+	})
+}
+
+func Test_ProviderSetFormat(t *testing.T) {
+	if err := NewProvider(nil, true, false, false).SetFormat(command.BackupRequest_BACKUP_REQUEST_FORMAT_SQL); err != ErrInvalidBackupFormat {
+		t.Fatalf("expected ErrInvalidBackupFormat for SQL with VACUUM, got %v", err)
+	}
+	if err := NewProvider(nil, false, false, false).SetFormat(command.BackupRequest_BACKUP_REQUEST_FORMAT_NONE); err != ErrInvalidBackupFormat {
+		t.Fatalf("expected ErrInvalidBackupFormat for unknown format, got %v", err)
+	}
+	if err := NewProvider(nil, true, false, false).SetFormat(command.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY); err != nil {
+		t.Fatalf("failed to set binary format: %s", err.Error())
+	}
+}
+
+func Test_SingleNodeProvideVerify(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	stmts := []string{`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`}
+	for i := 0; i < 200; i++ {
+		stmts = append(stmts, `INSERT INTO foo(name) VALUES("fiona")`)
+	}
+	if _, err := s.Execute(executeRequestFromStrings(stmts, false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	// A good backup is verified and provided, compressed as requested.
+	provider := NewProvider(s, false, true, false)
+	provider.SetVerify(true)
+	var buf bytes.Buffer
+	if err := provider.Provide(&buf); err != nil {
+		t.Fatalf("failed to provide verified backup: %s", err.Error())
+	}
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to create gzip reader: %s", err.Error())
+	}
+	path := filepath.Join(t.TempDir(), "backup.db")
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create file: %s", err.Error())
+	}
+	if _, err := io.Copy(fd, gr); err != nil {
+		t.Fatalf("failed to decompress backup: %s", err.Error())
+	}
+	fd.Close()
+	if err := verifyBackup(path); err != nil {
+		t.Fatalf("provided backup failed verification: %s", err.Error())
+	}
+
+	// A corrupt backup must fail, with nothing provided.
+	provider.SetRetryPolicy(RetryPolicy{})
+	provider.sleepFn = func(context.Context, time.Duration) error { return nil }
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		var b bytes.Buffer
+		if err := s.Backup(br, &b); err != nil {
+			return err
+		}
+		_, err := w.Write(b.Bytes()[:b.Len()/2])
+		return err
+	}
+	buf.Reset()
+	nFail := stats.Get(numProviderVerifyFail).(*expvar.Int).Value()
+	if err := provider.Provide(&buf); !errors.Is(err, ErrBackupVerifyFailed) {
+		t.Fatalf("expected ErrBackupVerifyFailed, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("corrupt backup was provided")
+	}
+	if exp, got := nFail+1, stats.Get(numProviderVerifyFail).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong number of verify failures, exp %d, got %d", exp, got)
+	}
+}
+
 func Test_SingleNodeProvideLastIndex(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()
@@ -130,7 +306,7 @@ func Test_SingleNodeProvideLastIndex(t *testing.T) {
 
 	tmpFile := mustCreateTempFile()
 	defer os.Remove(tmpFile)
-	provider := NewProvider(s, false, false)
+	provider := NewProvider(s, false, false, false)
 
 	lm, err := provider.LastIndex()
 	if err != nil {
@@ -227,6 +403,572 @@ func Test_SingleNodeProvideLastIndex(t *testing.T) {
 	}
 }
 
+// Test_SingleNodeProvideDryRun tests that a dry-run Provide does not write
+// any data, but reports what would have been written.
+func Test_SingleNodeProvideDryRun(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	_, err := s.Execute(er)
+	if err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	provider := NewProvider(s, false, false, false)
+	if _, ok := provider.LastDryRun(); ok {
+		t.Fatalf("dry run result available before any dry run")
+	}
+
+	nDryRuns := stats.Get(numProviderDryRuns).(*expvar.Int).Value()
+	nProvides := stats.Get(numProviderProvides).(*expvar.Int).Value()
+	provider.SetDryRun(true)
+	dryFd := mustCreateTempFD()
+	defer os.Remove(dryFd.Name())
+	defer dryFd.Close()
+	if err := provider.Provide(dryFd); err != ErrDryRun {
+		t.Fatalf("expected ErrDryRun from dry-run provide, got %v", err)
+	}
+	if sz := mustFileSize(dryFd.Name()); sz != 0 {
+		t.Fatalf("dry-run provide wrote %d bytes to destination", sz)
+	}
+	res, ok := provider.LastDryRun()
+	if !ok {
+		t.Fatalf("no dry run result available after dry run")
+	}
+	if stats.Get(numProviderDryRuns).(*expvar.Int).Value() != nDryRuns+1 {
+		t.Fatalf("dry run not counted")
+	}
+	if stats.Get(numProviderProvides).(*expvar.Int).Value() != nProvides {
+		t.Fatalf("dry run counted as a real provide")
+	}
+
+	// Now do a real provide, and check the dry run reported the same data.
+	provider.SetDryRun(false)
+	tmpFd := mustCreateTempFD()
+	defer os.Remove(tmpFd.Name())
+	defer tmpFd.Close()
+	if err := provider.Provide(tmpFd); err != nil {
+		t.Fatalf("failed to provide SQLite data: %s", err.Error())
+	}
+	b := mustReadFile(tmpFd.Name())
+	if exp, got := int64(len(b)), res.Size; exp != got {
+		t.Fatalf("dry run reported wrong size, exp %d, got %d", exp, got)
+	}
+	sum := sha256.Sum256(b)
+	if exp, got := hex.EncodeToString(sum[:]), res.Checksum; exp != got {
+		t.Fatalf("dry run reported wrong checksum, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_SingleNodeProvidePaused(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	_, err := s.Execute(er)
+	if err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	provider := NewProvider(s, false, false, false)
+	if provider.Paused() {
+		t.Fatalf("new provider is paused")
+	}
+
+	// Pause and resume concurrently, to check for races.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			provider.Pause()
+			provider.Resume()
+		}()
+	}
+	wg.Wait()
+
+	provider.Pause()
+	if !provider.Paused() {
+		t.Fatalf("provider is not paused")
+	}
+	nProvides := stats.Get(numProviderProvides).(*expvar.Int).Value()
+	nSkips := stats.Get(numProviderPausedSkips).(*expvar.Int).Value()
+	pausedFd := mustCreateTempFD()
+	defer os.Remove(pausedFd.Name())
+	defer pausedFd.Close()
+	for i := 0; i < 2; i++ {
+		if err := provider.Provide(pausedFd); err != ErrPaused {
+			t.Fatalf("expected ErrPaused from paused provide, got %v", err)
+		}
+	}
+	if sz := mustFileSize(pausedFd.Name()); sz != 0 {
+		t.Fatalf("paused provide wrote %d bytes to destination", sz)
+	}
+	if stats.Get(numProviderProvides).(*expvar.Int).Value() != nProvides {
+		t.Fatalf("paused provide counted as a provide")
+	}
+	if exp, got := nSkips+2, stats.Get(numProviderPausedSkips).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong number of paused skips, exp %d, got %d", exp, got)
+	}
+
+	provider.Resume()
+	tmpFd := mustCreateTempFD()
+	defer os.Remove(tmpFd.Name())
+	defer tmpFd.Close()
+	if err := provider.Provide(tmpFd); err != nil {
+		t.Fatalf("failed to provide SQLite data after resume: %s", err.Error())
+	}
+	if sz := mustFileSize(tmpFd.Name()); sz == 0 {
+		t.Fatalf("resumed provide wrote no data")
+	}
+}
+
+func Test_ProviderBackoffReset(t *testing.T) {
+	provider := NewProvider(nil, false, false, false)
+	provider.SetRetryPolicy(RetryPolicy{
+		MaxRetries:   3,
+		BaseInterval: 100 * time.Millisecond,
+		MaxInterval:  300 * time.Millisecond,
+	})
+
+	// Each call to backupFn pops the next result.
+	var results []error
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		err := results[0]
+		results = results[1:]
+		return err
+	}
+	var intervals []time.Duration
+	provider.sleepFn = func(_ context.Context, d time.Duration) error {
+		intervals = append(intervals, d)
+		return nil
+	}
+	errBackup := errors.New("backup failed")
+
+	// Failure, then success. The interval should grow during the provide.
+	results = []error{errBackup, errBackup, errBackup, nil}
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	exp := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	if !reflect.DeepEqual(exp, intervals) {
+		t.Fatalf("wrong intervals, exp %v, got %v", exp, intervals)
+	}
+
+	// Success resets the backoff, so a later failure starts at the minimum.
+	intervals = nil
+	results = []error{errBackup, nil}
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	exp = []time.Duration{100 * time.Millisecond}
+	if !reflect.DeepEqual(exp, intervals) {
+		t.Fatalf("wrong intervals after success, exp %v, got %v", exp, intervals)
+	}
+
+	// A provide which fails outright does not wait after its final attempt,
+	// and does not carry its backoff over to the next provide.
+	intervals = nil
+	results = []error{errBackup, errBackup, errBackup, errBackup}
+	if err := provider.Provide(io.Discard); err != errBackup {
+		t.Fatalf("expected backup error, got %v", err)
+	}
+	exp = []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	if !reflect.DeepEqual(exp, intervals) {
+		t.Fatalf("wrong intervals during failed provide, exp %v, got %v", exp, intervals)
+	}
+	intervals = nil
+	results = []error{errBackup, nil}
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	exp = []time.Duration{100 * time.Millisecond}
+	if !reflect.DeepEqual(exp, intervals) {
+		t.Fatalf("wrong intervals after failed provide, exp %v, got %v", exp, intervals)
+	}
+}
+
+func Test_ProviderShouldProvide(t *testing.T) {
+	provider := NewProvider(nil, false, false, false)
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		_, err := w.Write([]byte("data"))
+		return err
+	}
+	var idx uint64 = 10
+	provider.indexFn = func() uint64 { return idx }
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider.nowFn = func() time.Time { return now }
+	provider.SetMinInterval(time.Minute)
+
+	mustShould := func(exp bool) {
+		t.Helper()
+		li, err := provider.LastIndex()
+		if err != nil {
+			t.Fatalf("failed to get last index: %s", err.Error())
+		}
+		got, err := provider.ShouldProvide(li)
+		if err != nil {
+			t.Fatalf("failed to check whether to provide: %s", err.Error())
+		}
+		if exp != got {
+			t.Fatalf("wrong ShouldProvide result, exp %t, got %t", exp, got)
+		}
+	}
+
+	// Nothing provided yet.
+	mustShould(true)
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+
+	// Index has not advanced.
+	now = now.Add(time.Hour)
+	mustShould(false)
+
+	// Index has advanced, but not enough time has passed.
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	idx++
+	now = now.Add(30 * time.Second)
+	nDebounced := stats.Get(numProviderDebounced).(*expvar.Int).Value()
+	mustShould(false)
+	if exp, got := nDebounced+1, stats.Get(numProviderDebounced).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong number of debounced checks, exp %d, got %d", exp, got)
+	}
+
+	// Index has advanced, and enough time has passed.
+	now = now.Add(30 * time.Second)
+	mustShould(true)
+
+	// Without a minimum interval only the index matters.
+	provider.SetMinInterval(0)
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	mustShould(false)
+	idx++
+	mustShould(true)
+}
+
+func Test_ProviderLastDuration(t *testing.T) {
+	provider := NewProvider(nil, false, false, false)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider.nowFn = func() time.Time { return now }
+	nAttempts := 0
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		nAttempts++
+		if nAttempts == 1 {
+			now = now.Add(5 * time.Second)
+			return errors.New("backup failed")
+		}
+		now = now.Add(3 * time.Second)
+		_, err := w.Write([]byte("data"))
+		return err
+	}
+	provider.sleepFn = func(_ context.Context, d time.Duration) error {
+		now = now.Add(time.Minute)
+		return nil
+	}
+
+	if exp, got := time.Duration(0), provider.LastDuration(); exp != got {
+		t.Fatalf("wrong last duration before any provide, exp %s, got %s", exp, got)
+	}
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+
+	// Only the successful attempt is timed.
+	if exp, got := 3*time.Second, provider.LastDuration(); exp != got {
+		t.Fatalf("wrong last duration, exp %s, got %s", exp, got)
+	}
+	if exp, got := int64(3000), stats.Get(providerLastDurationMs).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong last duration stat, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_SingleNodeProvideIfNewerThan(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	provider := NewProvider(s, false, false, false)
+	var buf bytes.Buffer
+	lm, ok, err := provider.ProvideIfNewerThan(&buf, time.Time{})
+	if err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	if !ok || buf.Len() == 0 {
+		t.Fatalf("expected data to be provided")
+	}
+
+	// Nothing has changed, so nothing is provided.
+	buf.Reset()
+	lm2, ok, err := provider.ProvideIfNewerThan(&buf, lm)
+	if err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	if ok || buf.Len() != 0 {
+		t.Fatalf("expected nothing to be provided")
+	}
+	if !lm2.Equal(lm) {
+		t.Fatalf("last modified time changed, exp %s, got %s", lm, lm2)
+	}
+
+	// A write which has only reached the WAL must be detected.
+	time.Sleep(10 * time.Millisecond)
+	er = executeRequestFromString(`INSERT INTO foo(id, name) VALUES(2, "declan")`, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	lm3, ok, err := provider.ProvideIfNewerThan(&buf, lm)
+	if err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	if !ok || buf.Len() == 0 {
+		t.Fatalf("expected data to be provided after write")
+	}
+	if !lm3.After(lm) {
+		t.Fatalf("last modified time did not advance, was %s, got %s", lm, lm3)
+	}
+}
+
+func Test_ProviderRetryPolicy(t *testing.T) {
+	provider := NewProvider(nil, false, false, false)
+	provider.SetRetryPolicy(RetryPolicy{
+		MaxRetries:   4,
+		BaseInterval: 100 * time.Millisecond,
+		MaxInterval:  time.Second,
+		Multiplier:   3,
+	})
+	errBackup := errors.New("backup failed")
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		return errBackup
+	}
+	var intervals []time.Duration
+	provider.sleepFn = func(_ context.Context, d time.Duration) error {
+		intervals = append(intervals, d)
+		return nil
+	}
+
+	if err := provider.Provide(io.Discard); err != errBackup {
+		t.Fatalf("expected backup error, got %v", err)
+	}
+	// No wait follows the final attempt.
+	exp := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second}
+	if !reflect.DeepEqual(exp, intervals) {
+		t.Fatalf("wrong intervals, exp %v, got %v", exp, intervals)
+	}
+
+	// With jitter, each wait is shortened by up to the jitter fraction.
+	provider.SetRetryPolicy(RetryPolicy{
+		MaxRetries:   4,
+		BaseInterval: 100 * time.Millisecond,
+		MaxInterval:  time.Second,
+		Multiplier:   3,
+		Jitter:       0.5,
+	})
+	intervals = nil
+	if err := provider.Provide(io.Discard); err != errBackup {
+		t.Fatalf("expected backup error, got %v", err)
+	}
+	for i, d := range intervals {
+		if d < exp[i]/2 || d > exp[i] {
+			t.Fatalf("jittered interval %d out of range, exp between %s and %s, got %s", i, exp[i]/2, exp[i], d)
+		}
+	}
+}
+
+func Test_ProviderRetryCancel(t *testing.T) {
+	provider := NewProvider(nil, false, false, false)
+	provider.SetRetryPolicy(RetryPolicy{
+		MaxRetries:   3,
+		BaseInterval: time.Hour,
+		MaxInterval:  time.Hour,
+	})
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		return errors.New("backup failed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	ch := make(chan error, 1)
+	go func() {
+		ch <- provider.ProvideWithContext(ctx, io.Discard)
+	}()
+	select {
+	case err := <-ch:
+		if err != context.Canceled {
+			t.Fatalf("expected context canceled error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Provide not cancelled while waiting to retry")
+	}
+}
+
+func Test_ProviderCancelBackup(t *testing.T) {
+	provider := NewProvider(nil, false, false, false)
+	provider.sleepFn = func(context.Context, time.Duration) error { return nil }
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// A backup in progress fails at its next write once ctx is cancelled.
+	nCalls := 0
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		nCalls++
+		for i := 0; ; i++ {
+			if i == 10 {
+				cancel()
+			}
+			if _, err := w.Write([]byte("data")); err != nil {
+				return err
+			}
+		}
+	}
+	if err := provider.ProvideWithContext(ctx, io.Discard); err != context.Canceled {
+		t.Fatalf("expected context canceled error, got %v", err)
+	}
+	if exp, got := 1, nCalls; exp != got {
+		t.Fatalf("wrong number of backup attempts, exp %d, got %d", exp, got)
+	}
+
+	// No backup is attempted if ctx is already done.
+	nCalls = 0
+	if err := provider.ProvideWithContext(ctx, io.Discard); err != context.Canceled {
+		t.Fatalf("expected context canceled error, got %v", err)
+	}
+	if nCalls != 0 {
+		t.Fatalf("backup attempted with cancelled context")
+	}
+}
+
+func Test_ProviderPinned(t *testing.T) {
+	provider := NewProvider(nil, false, false, false)
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		_, err := w.Write([]byte("data"))
+		return err
+	}
+	var now time.Time
+	provider.nowFn = func() time.Time { return now }
+
+	if _, ok := provider.LastProvide(); ok {
+		t.Fatalf("expected no last provide")
+	}
+
+	// No pin policy, nothing pinned.
+	now = time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	r, ok := provider.LastProvide()
+	if !ok {
+		t.Fatalf("expected last provide")
+	}
+	if r.Pinned {
+		t.Fatalf("provide pinned without pin policy")
+	}
+	sum := sha256.Sum256([]byte("data"))
+	if exp, got := hex.EncodeToString(sum[:]), r.Checksum; exp != got {
+		t.Fatalf("wrong checksum, exp %s, got %s", exp, got)
+	}
+	if exp, got := int64(4), r.Size; exp != got {
+		t.Fatalf("wrong size, exp %d, got %d", exp, got)
+	}
+	if !r.Time.Equal(now) {
+		t.Fatalf("wrong time, exp %s, got %s", now, r.Time)
+	}
+
+	// The first provide of each month is pinned.
+	provider.SetPinPolicy(PinFirstOfMonth)
+	for _, tc := range []struct {
+		now    time.Time
+		pinned bool
+	}{
+		{time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), true},
+	} {
+		now = tc.now
+		if err := provider.Provide(io.Discard); err != nil {
+			t.Fatalf("failed to provide: %s", err.Error())
+		}
+		r, _ := provider.LastProvide()
+		if exp, got := tc.pinned, r.Pinned; exp != got {
+			t.Fatalf("wrong pinned for provide at %s, exp %t, got %t", now, exp, got)
+		}
+	}
+
+	// A failed provide is not pinned, and does not consume the pin.
+	provider.SetRetryPolicy(RetryPolicy{})
+	provider.sleepFn = func(context.Context, time.Duration) error { return nil }
+	errBackup := errors.New("backup failed")
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		return errBackup
+	}
+	now = time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := provider.Provide(io.Discard); err != errBackup {
+		t.Fatalf("expected backup error, got %v", err)
+	}
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		return nil
+	}
+	now = time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	if r, _ := provider.LastProvide(); !r.Pinned {
+		t.Fatalf("expected provide after failed provide to be pinned")
+	}
+}
+
 func gunzip(file string) (string, error) {
 	f, err := os.Open(file)
 	if err != nil {
@@ -250,3 +992,231 @@ func gunzip(file string) (string, error) {
 
 	return tmpFd.Name(), nil
 }
+
+// Test_SingleNodeProvideContentHash tests that, with content hash checking
+// enabled, writes which leave the contents of the database unchanged do not
+// change the last index, even if the database file is modified.
+func Test_SingleNodeProvideContentHash(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	execute := func(stmts ...string) {
+		t.Helper()
+		if _, err := s.Execute(executeRequestFromStrings(stmts, false, false)); err != nil {
+			t.Fatalf("failed to execute on single node: %s", err.Error())
+		}
+		if _, err := s.WaitForAppliedFSM(2 * time.Second); err != nil {
+			t.Fatalf("failed to wait for FSM to apply")
+		}
+	}
+	execute(`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`)
+
+	provider := NewProvider(s, false, false, false)
+	provider.SetContentHashCheck(true)
+	li, err := provider.LastIndex()
+	if err != nil {
+		t.Fatalf("failed to get last index: %s", err.Error())
+	}
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+
+	// Change the database, and its modification time, but not its contents.
+	execute(`INSERT INTO foo(id, name) VALUES(2, "declan")`, `DELETE FROM foo WHERE id = 2`)
+	execute(`UPDATE foo SET name = "fiona" WHERE id = 1`)
+	mtime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(s.dbPath, mtime, mtime); err != nil {
+		t.Fatalf("failed to change database modification time: %s", err.Error())
+	}
+	if s.DBAppliedIndex() <= li {
+		t.Fatalf("applied index should have changed")
+	}
+
+	stats.Get(numProviderUnchanged).(*expvar.Int).Set(0)
+	newLI, err := provider.LastIndex()
+	if err != nil {
+		t.Fatalf("failed to get last index: %s", err.Error())
+	}
+	if exp, got := li, newLI; exp != got {
+		t.Fatalf("last index changed though contents did not, exp %d, got %d", exp, got)
+	}
+	if exp, got := int64(1), stats.Get(numProviderUnchanged).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong unchanged count, exp %d, got %d", exp, got)
+	}
+
+	// Without content hash checking the index is used.
+	provider.SetContentHashCheck(false)
+	if newLI, err := provider.LastIndex(); err != nil || newLI <= li {
+		t.Fatalf("last index should have changed without content hash check")
+	}
+
+	// A change to the contents changes the last index.
+	provider.SetContentHashCheck(true)
+	if _, err := provider.LastIndex(); err != nil {
+		t.Fatalf("failed to get last index: %s", err.Error())
+	}
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	li, err = provider.LastIndex()
+	if err != nil {
+		t.Fatalf("failed to get last index: %s", err.Error())
+	}
+	execute(`INSERT INTO foo(id, name) VALUES(3, "aoife")`)
+	newLI, err = provider.LastIndex()
+	if err != nil {
+		t.Fatalf("failed to get last index: %s", err.Error())
+	}
+	if newLI <= li {
+		t.Fatalf("last index should have changed, was %d, now %d", li, newLI)
+	}
+}
+
+func Test_ProviderTracing(t *testing.T) {
+	provider := NewProvider(nil, true, false, false)
+	provider.SetRetryPolicy(RetryPolicy{MaxRetries: 3})
+	provider.sleepFn = func(context.Context, time.Duration) error { return nil }
+	errBackup := errors.New("backup failed")
+	results := []error{errBackup, errBackup, nil}
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		err := results[0]
+		results = results[1:]
+		if err == nil {
+			_, err = w.Write([]byte("data"))
+		}
+		return err
+	}
+
+	// No tracer set, Provide should work as normal.
+	if err := provider.Provide(io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+
+	tr := &recordingTracer{}
+	provider.SetTracer(tr)
+	ctx, parent := tr.Start(context.Background(), "caller")
+	results = []error{errBackup, errBackup, nil}
+	if err := provider.ProvideWithContext(ctx, io.Discard); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	parent.End()
+
+	if exp, got := 5, len(tr.spans); exp != got {
+		t.Fatalf("wrong number of spans, exp %d, got %d", exp, got)
+	}
+	provide := tr.spans[1]
+	if provide.name != spanProvide || provide.parent != parent {
+		t.Fatalf("provide span not recorded correctly: %+v", provide)
+	}
+	if exp, got := int64(4), provide.attrs[attrBackupSize]; exp != got {
+		t.Fatalf("wrong size attribute, exp %v, got %v", exp, got)
+	}
+	if exp, got := backupFormatBinary, provide.attrs[attrBackupFormat]; exp != got {
+		t.Fatalf("wrong format attribute, exp %v, got %v", exp, got)
+	}
+	if exp, got := true, provide.attrs[attrBackupVacuum]; exp != got {
+		t.Fatalf("wrong vacuum attribute, exp %v, got %v", exp, got)
+	}
+	for i, s := range tr.spans[2:] {
+		if s.name != spanBackupAttempt || s.parent != provide {
+			t.Fatalf("attempt span %d not recorded correctly: %+v", i, s)
+		}
+		if exp, got := i+1, s.attrs[attrBackupAttempt]; exp != got {
+			t.Fatalf("wrong attempt attribute, exp %v, got %v", exp, got)
+		}
+		if exp, got := i < 2, s.err != nil; exp != got {
+			t.Fatalf("attempt span %d error recorded wrongly, exp %v, got %v", i, exp, got)
+		}
+	}
+	for _, s := range tr.spans {
+		if !s.ended {
+			t.Fatalf("span %s not ended", s.name)
+		}
+	}
+
+	// A failed Provide records the error on the provide span.
+	tr.spans = nil
+	results = []error{errBackup, errBackup, errBackup, errBackup}
+	if err := provider.Provide(io.Discard); err != errBackup {
+		t.Fatalf("expected backup error, got %v", err)
+	}
+	if exp, got := 5, len(tr.spans); exp != got {
+		t.Fatalf("wrong number of spans, exp %d, got %d", exp, got)
+	}
+	if tr.spans[0].parent != nil || tr.spans[0].err != errBackup {
+		t.Fatalf("failed provide span not recorded correctly: %+v", tr.spans[0])
+	}
+}
+
+type recordingSpanKey struct{}
+
+// recordingSpan is a Span which records everything done to it.
+type recordingSpan struct {
+	name   string
+	parent *recordingSpan
+	attrs  map[string]any
+	err    error
+	ended  bool
+}
+
+func (s *recordingSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *recordingSpan) RecordError(err error)              { s.err = err }
+func (s *recordingSpan) End()                               { s.ended = true }
+
+// recordingTracer is a Tracer which records every span started.
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordingSpanKey{}).(*recordingSpan)
+	s := &recordingSpan{name: name, parent: parent, attrs: make(map[string]any)}
+	r.spans = append(r.spans, s)
+	return context.WithValue(ctx, recordingSpanKey{}, s), s
+}
+
+func Test_ProviderChecksum(t *testing.T) {
+	provider := NewProvider(nil, false, false, true)
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		_, err := w.Write([]byte("data"))
+		return err
+	}
+	if _, ok := provider.Checksum(); ok {
+		t.Fatalf("expected no checksum before first provide")
+	}
+
+	var buf bytes.Buffer
+	if err := provider.Provide(&buf); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	sum := sha256.Sum256([]byte("data"))
+	exp := hex.EncodeToString(sum[:])
+	got, ok := provider.Checksum()
+	if !ok || got != exp {
+		t.Fatalf("wrong checksum, exp %s, got %s", exp, got)
+	}
+
+	// Without the option, no checksum is made available.
+	provider = NewProvider(nil, false, false, false)
+	provider.backupFn = func(br *command.BackupRequest, w io.Writer) error {
+		_, err := w.Write([]byte("data"))
+		return err
+	}
+	if err := provider.Provide(&buf); err != nil {
+		t.Fatalf("failed to provide: %s", err.Error())
+	}
+	if _, ok := provider.Checksum(); ok {
+		t.Fatalf("expected no checksum when not enabled")
+	}
+}
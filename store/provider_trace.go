@@ -0,0 +1,71 @@
+package store
+
+import "context"
+
+// Tracer starts trace spans. It is a small subset of the OpenTelemetry
+// tracing API, so that a Provider can be traced without the store package
+// depending on any particular tracing implementation. A thin adapter around
+// an OpenTelemetry trace.Tracer satisfies it.
+type Tracer interface {
+	// Start starts a span called name, as a child of any span carried by
+	// ctx. It returns the span, and a context carrying it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation, started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute on the span.
+	SetAttribute(key string, value any)
+
+	// RecordError records that the operation failed with err.
+	RecordError(err error)
+
+	// End completes the span. No calls should be made on the span after End.
+	End()
+}
+
+// Span names and attributes recorded by the Provider.
+const (
+	spanProvide        = "provider.provide"
+	spanBackupAttempt  = "provider.backup_attempt"
+	attrBackupFormat   = "backup.format"
+	attrBackupVacuum   = "backup.vacuum"
+	attrBackupCompress = "backup.compress"
+	attrBackupSize     = "backup.size"
+	attrBackupChecksum = "backup.checksum"
+	attrBackupAttempt  = "backup.attempt"
+	attrProviderPaused = "provider.paused"
+	attrProviderDryRun = "provider.dry_run"
+	attrProviderPinned = "provider.pinned"
+	backupFormatBinary = "binary"
+	backupFormatSQL    = "sql"
+)
+
+// noopSpan is used when no Tracer is set. It holds no state, so using it
+// does not allocate.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+func (noopSpan) RecordError(error)        {}
+func (noopSpan) End()                     {}
+
+// SetTracer sets the Tracer used to trace each Provide. Each Provide is
+// recorded as a span, with each attempt to back up the database recorded as
+// a child span. If t is nil, which is the default, tracing is disabled.
+func (p *Provider) SetTracer(t Tracer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tracer = t
+}
+
+// startSpan starts a span using the Provider's Tracer. If no Tracer is set
+// ctx is returned unchanged, along with a span which does nothing.
+func (p *Provider) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	p.mu.Lock()
+	t := p.tracer
+	p.mu.Unlock()
+	if t == nil {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name)
+}
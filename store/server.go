@@ -1,10 +1,27 @@
 package store
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidServers is returned when a set of servers is not valid.
+var ErrInvalidServers = errors.New("invalid servers")
+
 // Server represents another node in the cluster.
 type Server struct {
-	ID       string `json:"id,omitempty"`
-	Addr     string `json:"addr,omitempty"`
-	Suffrage string `json:"suffrage,omitempty"`
+	ID       string            `json:"id,omitempty"`
+	Addr     string            `json:"addr,omitempty"`
+	Suffrage string            `json:"suffrage,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
 }
 
 // NewServer returns an initialized Server.
@@ -20,12 +37,26 @@ func NewServer(id, addr string, voter bool) *Server {
 	}
 }
 
+// String returns a compact representation of the server, of the form
+// ID@Addr/Suffrage, for logging. A nil server is rendered as "<nil>".
+func (s *Server) String() string {
+	if s == nil {
+		return "<nil>"
+	}
+	suffrage := s.Suffrage
+	if strings.EqualFold(suffrage, "Voter") {
+		suffrage = "Voter"
+	}
+	return fmt.Sprintf("%s@%s/%s", s.ID, s.Addr, suffrage)
+}
+
 // Servers is a set of Servers.
 type Servers []*Server
 
 // IsReadOnly returns whether the given node, as specified by its Raft ID,
-// is a read-only (non-voting) node. If no node is found with the given ID
-// then found will be false.
+// is a read-only node. Non-voting and staging nodes are both read-only, since
+// a staging node does not vote until it has been promoted. If no node is found
+// with the given ID then found will be false.
 func (s Servers) IsReadOnly(id string) (readOnly bool, found bool) {
 	readOnly = false
 	found = false
@@ -36,7 +67,7 @@ func (s Servers) IsReadOnly(id string) (readOnly bool, found bool) {
 
 	for _, n := range s {
 		if n != nil && n.ID == id {
-			readOnly = n.Suffrage == "Nonvoter"
+			readOnly = n.Suffrage == "Nonvoter" || n.Suffrage == "Staging"
 			found = true
 			return
 		}
@@ -47,16 +78,314 @@ func (s Servers) IsReadOnly(id string) (readOnly bool, found bool) {
 // Contains returns whether the given node, as specified by its Raft ID,
 // is a member of the set of servers.
 func (s Servers) Contains(id string) bool {
+	_, found := s.Get(id)
+	return found
+}
+
+// Get returns the given node, as specified by its Raft ID, such as that of
+// the leader, so that its address can be found. If no node is found with the
+// given ID then found will be false.
+func (s Servers) Get(id string) (server *Server, found bool) {
 	if s == nil || id == "" {
-		return false
+		return nil, false
 	}
 
 	for _, n := range s {
 		if n != nil && n.ID == id {
-			return true
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// Voters returns the servers which are voters, sorted by ID. Staging servers
+// are not included, as they do not vote until promoted.
+func (s Servers) Voters() Servers {
+	var ss Servers
+	for _, n := range s {
+		if n != nil && strings.EqualFold(n.Suffrage, "Voter") {
+			ss = append(ss, n)
+		}
+	}
+	sort.Sort(ss)
+	return ss
+}
+
+// Nonvoters returns the servers which are read-only, sorted by ID. As with
+// IsReadOnly, both non-voting and staging servers are included. Servers with
+// any other suffrage are not.
+func (s Servers) Nonvoters() Servers {
+	var ss Servers
+	for _, n := range s {
+		if n != nil && (strings.EqualFold(n.Suffrage, "Nonvoter") || strings.EqualFold(n.Suffrage, "Staging")) {
+			ss = append(ss, n)
+		}
+	}
+	sort.Sort(ss)
+	return ss
+}
+
+// QuorumSize returns the number of voters which must agree for the cluster
+// to make progress, that is a majority of the voters. Non-voting and staging
+// servers are not counted. If there are no voters, 0 is returned.
+func (s Servers) QuorumSize() int {
+	n := len(s.Voters())
+	if n == 0 {
+		return 0
+	}
+	return n/2 + 1
+}
+
+// StagingServers returns the servers which are staging, and will become
+// voters once they have caught up with the leader. Comparing the result over
+// time allows promotions to be observed.
+func (s Servers) StagingServers() Servers {
+	var ss Servers
+	for _, n := range s {
+		if n != nil && n.Suffrage == "Staging" {
+			ss = append(ss, n)
+		}
+	}
+	return ss
+}
+
+// WithLabel returns the servers which have the label k set to the value v.
+func (s Servers) WithLabel(k, v string) Servers {
+	var ss Servers
+	for _, n := range s {
+		if n == nil {
+			continue
+		}
+		if lv, ok := n.Labels[k]; ok && lv == v {
+			ss = append(ss, n)
+		}
+	}
+	return ss
+}
+
+// ByLabel groups the servers by the value of the label k. Servers which do not
+// have the label set are not included in any group.
+func (s Servers) ByLabel(k string) map[string]Servers {
+	m := make(map[string]Servers)
+	for _, n := range s {
+		if n == nil {
+			continue
+		}
+		if lv, ok := n.Labels[k]; ok {
+			m[lv] = append(m[lv], n)
+		}
+	}
+	return m
+}
+
+// Validate checks that every server is non-nil, has a non-empty ID and
+// address, has a recognized suffrage, and that no ID appears more than once.
+// If the servers are not valid an error wrapping ErrInvalidServers is returned.
+func (s Servers) Validate() error {
+	ids := make(map[string]bool, len(s))
+	for i, n := range s {
+		if n == nil {
+			return fmt.Errorf("%w: server %d is nil", ErrInvalidServers, i)
+		}
+		if n.ID == "" {
+			return fmt.Errorf("%w: server %d has no ID", ErrInvalidServers, i)
+		}
+		if n.Addr == "" {
+			return fmt.Errorf("%w: server %s has no address", ErrInvalidServers, n.ID)
+		}
+		if !strings.EqualFold(n.Suffrage, "Voter") && n.Suffrage != "Nonvoter" && n.Suffrage != "Staging" {
+			return fmt.Errorf("%w: server %s has unknown suffrage %q", ErrInvalidServers, n.ID, n.Suffrage)
+		}
+		if ids[n.ID] {
+			return fmt.Errorf("%w: duplicate server ID %s", ErrInvalidServers, n.ID)
+		}
+		ids[n.ID] = true
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. The servers are encoded as an array
+// sorted by ID, so the encoding of a set of servers does not depend on their
+// order. Nil servers are omitted.
+func (s Servers) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	ss := make(Servers, 0, len(s))
+	for _, n := range s {
+		if n != nil {
+			ss = append(ss, n)
+		}
+	}
+	sort.Sort(ss)
+	return json.Marshal([]*Server(ss))
+}
+
+// WriteFile validates the servers and writes them, sorted by ID, to the file
+// at path as JSON. The file is written atomically, by first writing to a
+// temporary file in the same directory and then renaming it, so a crash
+// during the write never leaves a partially-written file at path.
+func (s Servers) WriteFile(path string) (retErr error) {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	fd, err := os.CreateTemp(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			fd.Close()
+			os.Remove(fd.Name())
+		}
+	}()
+	if _, err := fd.Write(b); err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(fd.Name(), path); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dh.Close()
+	return dh.Sync()
+}
+
+// ReadServersFile reads servers, as written by WriteFile, from the file at
+// path. An error is returned if the file cannot be decoded, or if the servers
+// it contains are not valid.
+func ReadServersFile(path string) (Servers, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Servers
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidServers, err.Error())
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Hash returns a hex-encoded SHA256 hash of the membership described by the
+// servers, that is the ID, address, and suffrage of each server. The servers
+// are sorted by ID before hashing, and suffrages are normalized, so two sets
+// of servers describing the same membership hash equal regardless of order.
+// Labels are not part of membership, and nil servers are ignored.
+func (s Servers) Hash() string {
+	ss := make(Servers, 0, len(s))
+	for _, n := range s {
+		if n != nil {
+			ss = append(ss, n)
+		}
+	}
+	sort.Sort(ss)
+
+	h := sha256.New()
+	for _, n := range ss {
+		suffrage := n.Suffrage
+		if strings.EqualFold(suffrage, "Voter") {
+			suffrage = "Voter"
+		}
+		for _, v := range []string{n.ID, n.Addr, suffrage} {
+			fmt.Fprintf(h, "%d:%s", len(v), v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Equal returns whether the servers describe the same membership as other,
+// that is the same ID, address, and suffrage for each server, regardless of
+// order. As with Hash, suffrages are normalized, labels are not compared, and
+// nil servers are ignored, so a nil or empty set of servers is equal to
+// another nil or empty set. Equal can be used to avoid issuing a
+// configuration change which would not change the membership.
+func (s Servers) Equal(other Servers) bool {
+	a, b := s.members(), other.members()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// member is the part of a Server which describes its membership.
+type member struct {
+	id, addr, suffrage string
+}
+
+// members returns the membership of the non-nil servers, sorted by ID.
+func (s Servers) members() []member {
+	m := make([]member, 0, len(s))
+	for _, n := range s {
+		if n == nil {
+			continue
 		}
+		suffrage := n.Suffrage
+		if strings.EqualFold(suffrage, "Voter") {
+			suffrage = "Voter"
+		}
+		m = append(m, member{id: n.ID, addr: n.Addr, suffrage: suffrage})
+	}
+	sort.Slice(m, func(i, j int) bool {
+		if m[i].id != m[j].id {
+			return m[i].id < m[j].id
+		}
+		if m[i].addr != m[j].addr {
+			return m[i].addr < m[j].addr
+		}
+		return m[i].suffrage < m[j].suffrage
+	})
+	return m
+}
+
+// String returns a compact representation of the servers, sorted by ID, such
+// as "[1@localhost:4001/Voter 2@localhost:4002/Nonvoter]", so that the same set
+// of servers is always rendered identically. Nil servers are rendered, last,
+// as "<nil>".
+func (s Servers) String() string {
+	ss := make(Servers, 0, len(s))
+	nils := 0
+	for _, n := range s {
+		if n == nil {
+			nils++
+			continue
+		}
+		ss = append(ss, n)
+	}
+	sort.Sort(ss)
+
+	strs := make([]string, 0, len(s))
+	for _, n := range ss {
+		strs = append(strs, n.String())
+	}
+	for i := 0; i < nils; i++ {
+		strs = append(strs, "<nil>")
 	}
-	return false
+	return "[" + strings.Join(strs, " ") + "]"
 }
 
 func (s Servers) Less(i, j int) bool { return s[i].ID < s[j].ID }
@@ -1,6 +1,12 @@
 package store
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -53,6 +59,16 @@ func Test_IsReadOnly(t *testing.T) {
 			expectedRO:    true,
 			expectedFound: true,
 		},
+		{
+			name: "ExistingStagingNode",
+			servers: Servers([]*Server{
+				{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"},
+				{ID: "node2", Addr: "localhost:4004", Suffrage: "Staging"},
+			}),
+			nodeID:        "node2",
+			expectedRO:    true,
+			expectedFound: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -65,6 +81,180 @@ func Test_IsReadOnly(t *testing.T) {
 	}
 }
 
+func Test_VotersStaging(t *testing.T) {
+	testCases := []struct {
+		name         string
+		servers      Servers
+		expVoters    []string
+		expNonvoters []string
+		expStaging   []string
+	}{
+		{
+			name:         "EmptyServers",
+			servers:      nil,
+			expVoters:    nil,
+			expNonvoters: nil,
+			expStaging:   nil,
+		},
+		{
+			name: "NilServer",
+			servers: Servers([]*Server{
+				nil,
+				{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"},
+			}),
+			expVoters:    []string{"node1"},
+			expNonvoters: nil,
+			expStaging:   nil,
+		},
+		{
+			name: "NoStaging",
+			servers: Servers([]*Server{
+				{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"},
+				{ID: "node2", Addr: "localhost:4004", Suffrage: "Nonvoter"},
+				{ID: "node3", Addr: "localhost:4006", Suffrage: "voter"},
+			}),
+			expVoters:    []string{"node1", "node3"},
+			expNonvoters: []string{"node2"},
+			expStaging:   nil,
+		},
+		{
+			name: "Staging",
+			servers: Servers([]*Server{
+				{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"},
+				{ID: "node2", Addr: "localhost:4004", Suffrage: "Staging"},
+				{ID: "node3", Addr: "localhost:4006", Suffrage: "Nonvoter"},
+				{ID: "node4", Addr: "localhost:4008", Suffrage: "Staging"},
+			}),
+			expVoters:    []string{"node1"},
+			expNonvoters: []string{"node2", "node3", "node4"},
+			expStaging:   []string{"node2", "node4"},
+		},
+		{
+			name: "Unsorted",
+			servers: Servers([]*Server{
+				{ID: "node4", Addr: "localhost:4008", Suffrage: "Nonvoter"},
+				{ID: "node3", Addr: "localhost:4006", Suffrage: "Voter"},
+				nil,
+				{ID: "node2", Addr: "localhost:4004", Suffrage: "Nonvoter"},
+				{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"},
+			}),
+			expVoters:    []string{"node1", "node3"},
+			expNonvoters: []string{"node2", "node4"},
+			expStaging:   nil,
+		},
+		{
+			name: "UnknownSuffrage",
+			servers: Servers([]*Server{
+				{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"},
+				{ID: "node2", Addr: "localhost:4004", Suffrage: ""},
+				{ID: "node3", Addr: "localhost:4006", Suffrage: "nonvoter"},
+				{ID: "node4", Addr: "localhost:4008", Suffrage: "Observer"},
+			}),
+			expVoters:    []string{"node1"},
+			expNonvoters: []string{"node3"},
+			expStaging:   nil,
+		},
+	}
+
+	ids := func(ss Servers) []string {
+		var ids []string
+		for _, s := range ss {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if exp, got := tc.expVoters, ids(tc.servers.Voters()); !reflect.DeepEqual(exp, got) {
+				t.Fatalf("Voters for %s returned %v, expected %v", tc.name, got, exp)
+			}
+			if exp, got := tc.expNonvoters, ids(tc.servers.Nonvoters()); !reflect.DeepEqual(exp, got) {
+				t.Fatalf("Nonvoters for %s returned %v, expected %v", tc.name, got, exp)
+			}
+			if exp, got := tc.expStaging, ids(tc.servers.StagingServers()); !reflect.DeepEqual(exp, got) {
+				t.Fatalf("StagingServers for %s returned %v, expected %v", tc.name, got, exp)
+			}
+		})
+	}
+}
+
+func Test_QuorumSize(t *testing.T) {
+	voters := func(n int) Servers {
+		var ss Servers
+		for i := 0; i < n; i++ {
+			ss = append(ss, &Server{ID: fmt.Sprintf("voter%d", i), Addr: fmt.Sprintf("localhost:%d", 4002+i), Suffrage: "Voter"})
+		}
+		return ss
+	}
+	withNonvoters := func(ss Servers) Servers {
+		return append(ss,
+			nil,
+			&Server{ID: "nonvoter", Addr: "localhost:5002", Suffrage: "Nonvoter"},
+			&Server{ID: "staging", Addr: "localhost:5004", Suffrage: "Staging"},
+		)
+	}
+
+	testCases := []struct {
+		name    string
+		servers Servers
+		exp     int
+	}{
+		{
+			name:    "EmptyServers",
+			servers: nil,
+			exp:     0,
+		},
+		{
+			name:    "OnlyNonvoters",
+			servers: withNonvoters(nil),
+			exp:     0,
+		},
+		{
+			name:    "SingleVoter",
+			servers: voters(1),
+			exp:     1,
+		},
+		{
+			name:    "ThreeVoters",
+			servers: voters(3),
+			exp:     2,
+		},
+		{
+			name:    "FourVoters",
+			servers: voters(4),
+			exp:     3,
+		},
+		{
+			name:    "FiveVoters",
+			servers: voters(5),
+			exp:     3,
+		},
+		{
+			name:    "SingleVoterWithNonvoters",
+			servers: withNonvoters(voters(1)),
+			exp:     1,
+		},
+		{
+			name:    "ThreeVotersWithNonvoters",
+			servers: withNonvoters(voters(3)),
+			exp:     2,
+		},
+		{
+			name:    "FiveVotersWithNonvoters",
+			servers: withNonvoters(voters(5)),
+			exp:     3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.servers.QuorumSize(); tc.exp != got {
+				t.Fatalf("QuorumSize for %s returned %d, expected %d", tc.name, got, tc.exp)
+			}
+		})
+	}
+}
+
 func Test_Contains(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -111,3 +301,374 @@ func Test_Contains(t *testing.T) {
 		})
 	}
 }
+
+func Test_Get(t *testing.T) {
+	node1 := &Server{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter"}
+	testCases := []struct {
+		name     string
+		servers  Servers
+		nodeID   string
+		expected *Server
+	}{
+		{
+			name:     "EmptyServers",
+			servers:  nil,
+			nodeID:   "1",
+			expected: nil,
+		},
+		{
+			name:     "EmptyNodeID",
+			servers:  Servers([]*Server{nil, {ID: "", Addr: "localhost:4003"}}),
+			nodeID:   "",
+			expected: nil,
+		},
+		{
+			name: "NonExistentNode",
+			servers: Servers([]*Server{
+				node1,
+			}),
+			nodeID:   "node2",
+			expected: nil,
+		},
+		{
+			name: "ExistingNode",
+			servers: Servers([]*Server{
+				nil,
+				{ID: "node0", Addr: "localhost:4001", Suffrage: "Nonvoter"},
+				node1,
+			}),
+			nodeID:   "node1",
+			expected: node1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, found := tc.servers.Get(tc.nodeID)
+			if actual != tc.expected {
+				t.Fatalf("Get for %s returned %v, expected %v", tc.name, actual, tc.expected)
+			}
+			if found != (tc.expected != nil) {
+				t.Fatalf("Get for %s returned found %t, expected %t", tc.name, found, tc.expected != nil)
+			}
+		})
+	}
+}
+
+func Test_Labels(t *testing.T) {
+	servers := Servers([]*Server{
+		{ID: "node1", Addr: "localhost:4002", Suffrage: "Voter", Labels: map[string]string{"region": "us-east", "zone": "a"}},
+		{ID: "node2", Addr: "localhost:4004", Suffrage: "Voter", Labels: map[string]string{"region": "us-west", "zone": "a"}},
+		{ID: "node3", Addr: "localhost:4006", Suffrage: "Nonvoter", Labels: map[string]string{"region": "us-east", "zone": "b"}},
+		{ID: "node4", Addr: "localhost:4008", Suffrage: "Voter"},
+		nil,
+	})
+
+	t.Run("WithLabel", func(t *testing.T) {
+		ss := servers.WithLabel("region", "us-east")
+		if len(ss) != 2 || ss[0].ID != "node1" || ss[1].ID != "node3" {
+			t.Fatalf("unexpected servers for region us-east: %v", ss)
+		}
+		if ss := servers.WithLabel("region", "eu-west"); len(ss) != 0 {
+			t.Fatalf("expected no servers for region eu-west, got %d", len(ss))
+		}
+		if ss := Servers(nil).WithLabel("region", "us-east"); len(ss) != 0 {
+			t.Fatalf("expected no servers from nil Servers, got %d", len(ss))
+		}
+	})
+
+	t.Run("ByLabel", func(t *testing.T) {
+		groups := servers.ByLabel("zone")
+		if len(groups) != 2 {
+			t.Fatalf("expected 2 zone groups, got %d", len(groups))
+		}
+		if len(groups["a"]) != 2 || groups["a"][0].ID != "node1" || groups["a"][1].ID != "node2" {
+			t.Fatalf("unexpected servers for zone a: %v", groups["a"])
+		}
+		if len(groups["b"]) != 1 || groups["b"][0].ID != "node3" {
+			t.Fatalf("unexpected servers for zone b: %v", groups["b"])
+		}
+		if groups := servers.ByLabel("rack"); len(groups) != 0 {
+			t.Fatalf("expected no rack groups, got %d", len(groups))
+		}
+	})
+
+	t.Run("Marshal", func(t *testing.T) {
+		b, err := json.Marshal(servers[0])
+		if err != nil {
+			t.Fatalf("failed to marshal server: %s", err.Error())
+		}
+		if exp, got := `{"id":"node1","addr":"localhost:4002","suffrage":"Voter","labels":{"region":"us-east","zone":"a"}}`, string(b); exp != got {
+			t.Fatalf("unexpected JSON for server\nexp: %s\ngot: %s", exp, got)
+		}
+		b, err = json.Marshal(servers[3])
+		if err != nil {
+			t.Fatalf("failed to marshal server: %s", err.Error())
+		}
+		if exp, got := `{"id":"node4","addr":"localhost:4008","suffrage":"Voter"}`, string(b); exp != got {
+			t.Fatalf("unexpected JSON for server\nexp: %s\ngot: %s", exp, got)
+		}
+	})
+}
+
+func Test_ServersValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		servers Servers
+		valid   bool
+	}{
+		{
+			name:    "Empty",
+			servers: nil,
+			valid:   true,
+		},
+		{
+			name: "Valid",
+			servers: Servers{
+				{ID: "1", Addr: "localhost:4002", Suffrage: "Voter"},
+				{ID: "2", Addr: "localhost:4004", Suffrage: "Nonvoter"},
+				{ID: "3", Addr: "localhost:4006", Suffrage: "Staging"},
+				NewServer("4", "localhost:4008", true),
+			},
+			valid: true,
+		},
+		{
+			name:    "Nil",
+			servers: Servers{nil},
+			valid:   false,
+		},
+		{
+			name:    "NoID",
+			servers: Servers{{Addr: "localhost:4002", Suffrage: "Voter"}},
+			valid:   false,
+		},
+		{
+			name:    "NoAddr",
+			servers: Servers{{ID: "1", Suffrage: "Voter"}},
+			valid:   false,
+		},
+		{
+			name:    "BadSuffrage",
+			servers: Servers{{ID: "1", Addr: "localhost:4002", Suffrage: "Observer"}},
+			valid:   false,
+		},
+		{
+			name: "DuplicateID",
+			servers: Servers{
+				{ID: "1", Addr: "localhost:4002", Suffrage: "Voter"},
+				{ID: "1", Addr: "localhost:4004", Suffrage: "Voter"},
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.servers.Validate()
+			if tc.valid && err != nil {
+				t.Fatalf("expected valid, got %s", err.Error())
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidServers) {
+				t.Fatalf("expected ErrInvalidServers, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_ServersFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	servers := Servers{
+		{ID: "2", Addr: "localhost:4004", Suffrage: "Nonvoter"},
+		{ID: "1", Addr: "localhost:4002", Suffrage: "Voter", Labels: map[string]string{"zone": "a"}},
+	}
+	if err := servers.WriteFile(path); err != nil {
+		t.Fatalf("failed to write servers file: %s", err.Error())
+	}
+	got, err := ReadServersFile(path)
+	if err != nil {
+		t.Fatalf("failed to read servers file: %s", err.Error())
+	}
+	exp := Servers{servers[1], servers[0]}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong servers read, exp %v, got %v", exp, got)
+	}
+
+	// Writing invalid servers should fail, and leave the existing file intact.
+	if err := (Servers{{ID: "3"}}).WriteFile(path); !errors.Is(err, ErrInvalidServers) {
+		t.Fatalf("expected ErrInvalidServers, got %v", err)
+	}
+
+	// Simulate a crash part way through a write, leaving a partially-written
+	// temporary file. The last complete write should still be read.
+	b, err := json.Marshal(Servers{{ID: "3", Addr: "localhost:4006", Suffrage: "Voter"}})
+	if err != nil {
+		t.Fatalf("failed to marshal servers: %s", err.Error())
+	}
+	if err := os.WriteFile(path+".tmp123", b[:len(b)/2], 0644); err != nil {
+		t.Fatalf("failed to write partial file: %s", err.Error())
+	}
+	got, err = ReadServersFile(path)
+	if err != nil {
+		t.Fatalf("failed to read servers file: %s", err.Error())
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong servers read after partial write, exp %v, got %v", exp, got)
+	}
+
+	// A corrupt file should be rejected.
+	if err := os.WriteFile(path, b[:len(b)/2], 0644); err != nil {
+		t.Fatalf("failed to write corrupt file: %s", err.Error())
+	}
+	if _, err := ReadServersFile(path); !errors.Is(err, ErrInvalidServers) {
+		t.Fatalf("expected ErrInvalidServers for corrupt file, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte(`[{"id":"1"}]`), 0644); err != nil {
+		t.Fatalf("failed to write invalid file: %s", err.Error())
+	}
+	if _, err := ReadServersFile(path); !errors.Is(err, ErrInvalidServers) {
+		t.Fatalf("expected ErrInvalidServers for invalid file, got %v", err)
+	}
+}
+
+func Test_ServersHash(t *testing.T) {
+	a := Servers{
+		NewServer("1", "localhost:4002", true),
+		NewServer("2", "localhost:4004", false),
+		{ID: "3", Addr: "localhost:4006", Suffrage: "Voter", Labels: map[string]string{"zone": "a"}},
+	}
+	b := Servers{
+		{ID: "3", Addr: "localhost:4006", Suffrage: "voter"},
+		NewServer("1", "localhost:4002", true),
+		nil,
+		NewServer("2", "localhost:4004", false),
+	}
+	if a.Hash() != b.Hash() {
+		t.Fatalf("permuted servers hash differently, %s != %s", a.Hash(), b.Hash())
+	}
+	if len(a.Hash()) != 64 {
+		t.Fatalf("hash has wrong length: %s", a.Hash())
+	}
+
+	for _, c := range []Servers{
+		{NewServer("1", "localhost:4002", true), NewServer("2", "localhost:4004", false)},
+		{NewServer("1", "localhost:4002", true), NewServer("2", "localhost:4004", true), NewServer("3", "localhost:4006", true)},
+		{NewServer("1", "localhost:4002", true), NewServer("2", "localhost:4005", false), NewServer("3", "localhost:4006", true)},
+	} {
+		if a.Hash() == c.Hash() {
+			t.Fatalf("different servers hash equally: %v", c)
+		}
+	}
+	if (Servers{NewServer("1", "a", true)}).Hash() == (Servers{NewServer("1a", "", true)}).Hash() {
+		t.Fatalf("ambiguous fields hash equally")
+	}
+}
+
+func Test_ServersEqual(t *testing.T) {
+	a := Servers{
+		NewServer("1", "localhost:4002", true),
+		NewServer("2", "localhost:4004", false),
+		{ID: "3", Addr: "localhost:4006", Suffrage: "Voter", Labels: map[string]string{"zone": "a"}},
+	}
+	b := Servers{
+		{ID: "3", Addr: "localhost:4006", Suffrage: "voter"},
+		NewServer("1", "localhost:4002", true),
+		nil,
+		NewServer("2", "localhost:4004", false),
+	}
+	if !a.Equal(b) || !b.Equal(a) {
+		t.Fatalf("reordered servers are not equal")
+	}
+	if !a.Equal(a) {
+		t.Fatalf("servers are not equal to themselves")
+	}
+	if b[0].ID != "3" {
+		t.Fatalf("Equal reordered the servers")
+	}
+
+	for _, c := range []Servers{
+		nil,
+		{NewServer("1", "localhost:4002", true), NewServer("2", "localhost:4004", false)},
+		{NewServer("1", "localhost:4002", true), NewServer("2", "localhost:4004", true), NewServer("3", "localhost:4006", true)},
+		{NewServer("1", "localhost:4002", true), NewServer("2", "localhost:4005", false), NewServer("3", "localhost:4006", true)},
+		{NewServer("1", "localhost:4002", true), NewServer("2", "localhost:4004", false), NewServer("4", "localhost:4006", true)},
+		{NewServer("1", "localhost:4002", true), {ID: "2", Addr: "localhost:4004", Suffrage: "Staging"}, NewServer("3", "localhost:4006", true)},
+	} {
+		if a.Equal(c) || c.Equal(a) {
+			t.Fatalf("different servers are equal: %v", c)
+		}
+		if a.Hash() == c.Hash() {
+			t.Fatalf("Equal is inconsistent with Hash for %v", c)
+		}
+	}
+
+	if !Servers(nil).Equal(nil) || !Servers(nil).Equal(Servers{}) || !(Servers{nil}).Equal(nil) {
+		t.Fatalf("empty servers are not equal")
+	}
+}
+
+func Test_ServersJSON(t *testing.T) {
+	servers := Servers{
+		{ID: "3", Addr: "localhost:4006", Suffrage: "Staging"},
+		{ID: "1", Addr: "localhost:4002", Suffrage: "Voter"},
+		nil,
+		{ID: "2", Addr: "localhost:4004", Suffrage: "Nonvoter"},
+	}
+	b, err := json.Marshal(servers)
+	if err != nil {
+		t.Fatalf("failed to marshal servers: %s", err.Error())
+	}
+	exp := `[{"id":"1","addr":"localhost:4002","suffrage":"Voter"},` +
+		`{"id":"2","addr":"localhost:4004","suffrage":"Nonvoter"},` +
+		`{"id":"3","addr":"localhost:4006","suffrage":"Staging"}]`
+	if got := string(b); exp != got {
+		t.Fatalf("wrong JSON for servers, exp %s, got %s", exp, got)
+	}
+	if servers[0].ID != "3" {
+		t.Fatalf("marshaling reordered the servers")
+	}
+
+	var got Servers
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("failed to unmarshal servers: %s", err.Error())
+	}
+	if expServers := (Servers{servers[1], servers[3], servers[0]}); !reflect.DeepEqual(expServers, got) {
+		t.Fatalf("wrong servers after round trip, exp %v, got %v", expServers, got)
+	}
+
+	// Marshaling within another value must also be sorted.
+	b, err = json.Marshal(map[string]Servers{"nodes": {servers[3], servers[1]}})
+	if err != nil {
+		t.Fatalf("failed to marshal servers: %s", err.Error())
+	}
+	if exp, got := `{"nodes":[{"id":"1","addr":"localhost:4002","suffrage":"Voter"},{"id":"2","addr":"localhost:4004","suffrage":"Nonvoter"}]}`, string(b); exp != got {
+		t.Fatalf("wrong JSON for nested servers, exp %s, got %s", exp, got)
+	}
+
+	b, err = json.Marshal(Servers(nil))
+	if err != nil {
+		t.Fatalf("failed to marshal nil servers: %s", err.Error())
+	}
+	if exp, got := "null", string(b); exp != got {
+		t.Fatalf("wrong JSON for nil servers, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_ServersString(t *testing.T) {
+	servers := Servers{
+		{ID: "2", Addr: "localhost:4002", Suffrage: "Nonvoter"},
+		nil,
+		NewServer("1", "localhost:4001", true),
+	}
+	if exp, got := "[1@localhost:4001/Voter 2@localhost:4002/Nonvoter <nil>]", servers.String(); exp != got {
+		t.Fatalf("wrong string for servers, exp %s, got %s", exp, got)
+	}
+	if servers[0].ID != "2" {
+		t.Fatalf("String reordered the servers")
+	}
+	if exp, got := "[1@localhost:4001/Voter 2@localhost:4002/Nonvoter <nil>]", fmt.Sprintf("%v", servers); exp != got {
+		t.Fatalf("wrong formatted servers, exp %s, got %s", exp, got)
+	}
+	if exp, got := "[]", Servers(nil).String(); exp != got {
+		t.Fatalf("wrong string for nil servers, exp %s, got %s", exp, got)
+	}
+}
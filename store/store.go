@@ -4,9 +4,12 @@
 package store
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
@@ -127,6 +130,8 @@ const (
 	autoVacuumDuration                = "auto_vacuum_duration"
 	numBoots                          = "num_boots"
 	numBackups                        = "num_backups"
+	numBackupsFastCopy                = "num_backups_fast_copy"
+	numBackupsFastCopyFallback        = "num_backups_fast_copy_fallback"
 	numLoads                          = "num_loads"
 	numRestores                       = "num_restores"
 	numRestoresFailed                 = "num_restores_failed"
@@ -137,6 +142,14 @@ const (
 	numProviderChecks                 = "num_provider_checks"
 	numProviderProvides               = "num_provider_provides"
 	numProviderProvidesFail           = "num_provider_provides_fail"
+	numProviderDryRuns                = "num_provider_dry_runs"
+	numProviderDryRunsFail            = "num_provider_dry_runs_fail"
+	numProviderPausedSkips            = "num_provider_paused_skips"
+	numProviderPinned                 = "num_provider_pinned"
+	numProviderUnchanged              = "num_provider_unchanged"
+	numProviderVerifyFail             = "num_provider_verify_fail"
+	numProviderDebounced              = "num_provider_debounced"
+	providerLastDurationMs            = "provider_last_duration_ms"
 	numUncompressedCommands           = "num_uncompressed_commands"
 	numCompressedCommands             = "num_compressed_commands"
 	numJoins                          = "num_joins"
@@ -182,6 +195,8 @@ func ResetStats() {
 	stats.Add(autoVacuumDuration, 0)
 	stats.Add(numBoots, 0)
 	stats.Add(numBackups, 0)
+	stats.Add(numBackupsFastCopy, 0)
+	stats.Add(numBackupsFastCopyFallback, 0)
 	stats.Add(numLoads, 0)
 	stats.Add(numRestores, 0)
 	stats.Add(numRestoresFailed, 0)
@@ -189,6 +204,14 @@ func ResetStats() {
 	stats.Add(numProviderChecks, 0)
 	stats.Add(numProviderProvides, 0)
 	stats.Add(numProviderProvidesFail, 0)
+	stats.Add(numProviderDryRuns, 0)
+	stats.Add(numProviderDryRunsFail, 0)
+	stats.Add(numProviderPausedSkips, 0)
+	stats.Add(numProviderPinned, 0)
+	stats.Add(numProviderUnchanged, 0)
+	stats.Add(numProviderVerifyFail, 0)
+	stats.Add(numProviderDebounced, 0)
+	stats.Add(providerLastDurationMs, 0)
 	stats.Add(numAutoRestores, 0)
 	stats.Add(numAutoRestoresSkipped, 0)
 	stats.Add(numAutoRestoresFailed, 0)
@@ -287,6 +310,12 @@ type Store struct {
 	// Latest log entry index which actually changed the database.
 	dbAppliedIdx *atomic.Uint64
 
+	// Raft log position reflected by the main database file, excluding the
+	// WAL. Updated whenever the WAL is checkpointed into the main file, or the
+	// main file is replaced.
+	dbFileMarkerMu sync.Mutex
+	dbFileMarker   BackupMarker
+
 	reqMarshaller *command.RequestMarshaler // Request marshaler for writing to log.
 	raftLog       raft.LogStore             // Persistent log store.
 	raftStable    raft.StableStore          // Persistent k-v store.
@@ -388,8 +417,12 @@ func New(ly Layer, c *Config) *Store {
 
 // SetRestorePath sets the path to a file containing a copy of a
 // SQLite database. This database will be loaded if and when the
-// node becomes the Leader for the first time only. The Store will
-// also delete the file when it's finished with it.
+// node becomes the Leader for the first time only. If a checksum
+// file, in the format used by sha256sum, exists at the path with
+// ".sha256" appended, the file is only loaded if it matches the
+// checksum. The Store will
+// also delete the file, and any checksum file, when it's finished
+// with it.
 //
 // This function should only be called before the Store is opened
 // and setting the restore path means the Store will not report
@@ -748,6 +781,17 @@ func (s *Store) DBAppliedIndex() uint64 {
 	return s.dbAppliedIdx.Load()
 }
 
+// contentHash returns the content hash of the database.
+func (s *Store) contentHash() ([]byte, error) {
+	return s.db.ContentHash()
+}
+
+// dbLastModified returns the last modified time of the SQLite database,
+// taking its WAL into account.
+func (s *Store) dbLastModified() (time.Time, error) {
+	return s.db.LastModified()
+}
+
 // IsLeader is used to determine if the current node is cluster leader
 func (s *Store) IsLeader() bool {
 	if !s.open.Is() {
@@ -1299,6 +1343,13 @@ func (s *Store) Request(eqr *proto.ExecuteQueryRequest) ([]*proto.ExecuteQueryRe
 // is made. If compression false, and dst is an os.File, then the vacuumed copy
 // will be written directly to that file. Otherwise a temporary file will be created,
 // and that temporary file copied to dst.
+//
+// If vacuum and FastCopy are both true, the database file is copied directly,
+// rather than page by page using the SQLite Online Backup API, and the copy is
+// vacuumed, which is faster for large databases. This is only done when it is
+// safe, that is when no writes have been made since the pre-backup snapshot,
+// otherwise the Online Backup API is used as usual. FastCopy has no effect on
+// a backup which is not vacuumed, as that is always copied directly.
 func (s *Store) Backup(br *proto.BackupRequest, dst io.Writer) (retErr error) {
 	if !s.open.Is() {
 		return ErrNotOpen
@@ -1328,6 +1379,17 @@ func (s *Store) Backup(br *proto.BackupRequest, dst io.Writer) (retErr error) {
 		var srcFD *os.File
 		var err error
 		if br.Vacuum {
+			if br.FastCopy {
+				fd, ok, err := s.fastCopyVacuumed()
+				if err != nil {
+					return err
+				}
+				if ok {
+					defer os.Remove(fd.Name())
+					defer fd.Close()
+					return copyMaybeCompressed(dst, fd, br.Compress)
+				}
+			}
 			if !br.Compress {
 				if f, ok := dst.(*os.File); ok {
 					// Fast path, just vacuum directly to the destination.
@@ -1386,6 +1448,268 @@ func (s *Store) Backup(br *proto.BackupRequest, dst io.Writer) (retErr error) {
 	return ErrInvalidBackupFormat
 }
 
+// fastCopyVacuumed makes a vacuumed copy of the database by copying the main
+// database file directly, rather than page by page through the SQLite Online
+// Backup API, and vacuuming the copy. The copy is returned as a temporary
+// file, open for reading, which the caller must close and remove.
+//
+// Copying the main file is only correct if it holds every committed change,
+// and does not change while it is read. So the database is first snapshotted,
+// which checkpoints the WAL into the main file and truncates it, and
+// snapshotting, and with it checkpointing, is then blocked while the file is
+// copied. Writes made during the copy go only to the WAL, and are not
+// included, just as if the backup had been made before them. If snapshotting
+// cannot be blocked, or the WAL is not empty once it is, because the snapshot
+// failed or writes were made after it, ok is false and nothing is copied, and
+// the caller should fall back to the Online Backup API.
+func (s *Store) fastCopyVacuumed() (fd *os.File, ok bool, retErr error) {
+	// Whether the copy is safe depends only on the WAL being empty once
+	// snapshotting is blocked, so a failed snapshot needs no handling here.
+	s.Snapshot(0)
+	if err := s.snapshotCAS.Begin("backup"); err != nil {
+		stats.Add(numBackupsFastCopyFallback, 1)
+		return nil, false, nil
+	}
+	if pathExistsWithData(s.walPath) {
+		s.snapshotCAS.End()
+		stats.Add(numBackupsFastCopyFallback, 1)
+		return nil, false, nil
+	}
+
+	fd, err := createTemp(s.dbDir, backupScatchPattern)
+	if err != nil {
+		s.snapshotCAS.End()
+		return nil, false, err
+	}
+	defer func() {
+		if retErr != nil {
+			fd.Close()
+			os.Remove(fd.Name())
+		}
+	}()
+	err = func() error {
+		defer s.snapshotCAS.End()
+		srcFD, err := os.Open(s.dbPath)
+		if err != nil {
+			return fmt.Errorf("failed to open database file: %s", err.Error())
+		}
+		defer srcFD.Close()
+		_, err = io.Copy(fd, srcFD)
+		return err
+	}()
+	if err != nil {
+		return nil, false, err
+	}
+
+	cpDB, err := sql.Open(fd.Name(), false, false)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := cpDB.ExecuteStringStmt("PRAGMA journal_mode=DELETE"); err != nil {
+		cpDB.Close()
+		return nil, false, err
+	}
+	if err := cpDB.Vacuum(); err != nil {
+		cpDB.Close()
+		return nil, false, err
+	}
+	if err := cpDB.Close(); err != nil {
+		return nil, false, err
+	}
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	stats.Add(numBackupsFastCopy, 1)
+	return fd, true, nil
+}
+
+// BackupMarker identifies the Raft log position reflected by a backup.
+type BackupMarker struct {
+	// Index is the index of the last Raft log entry reflected by the backup.
+	Index uint64 `json:"index"`
+
+	// Term is the term of the last Raft log entry reflected by the backup.
+	Term uint64 `json:"term"`
+}
+
+// BackupWithMarker is like Backup, but also returns the Raft log index and term
+// reflected by the backup, allowing the backup to be aligned with the Raft log.
+// The marker is captured atomically with the backup, as both are taken from
+// the main database file while snapshotting is blocked.
+//
+// If header is true, and the backup is in SQL format, the marker is also
+// written to dst as a leading SQL comment. header is ignored for binary
+// backups, as the SQLite file format has no room for it.
+//
+// Unlike Backup, a vacuumed or SQL-format backup is made from a temporary
+// copy of the main database file, so writes not yet snapshotted are not
+// included.
+func (s *Store) BackupWithMarker(br *proto.BackupRequest, dst io.Writer, header bool) (_ *BackupMarker, retErr error) {
+	if !s.open.Is() {
+		return nil, ErrNotOpen
+	}
+	if br.Vacuum && br.Format != proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY {
+		return nil, ErrInvalidBackupFormat
+	}
+	if br.Format != proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY &&
+		br.Format != proto.BackupRequest_BACKUP_REQUEST_FORMAT_SQL {
+		return nil, ErrInvalidBackupFormat
+	}
+	defer func() {
+		if retErr == nil {
+			stats.Add(numBackups, 1)
+		}
+	}()
+	if br.Leader && s.raft.State() != raft.Leader {
+		return nil, ErrNotLeader
+	}
+
+	// Snapshot to ensure the main SQLite file has all the latest data.
+	if err := s.Snapshot(0); err != nil {
+		if err != raft.ErrNothingNewToSnapshot &&
+			!strings.Contains(err.Error(), "wait until the configuration entry at") {
+			return nil, fmt.Errorf("pre-backup snapshot failed: %s", err.Error())
+		}
+	}
+
+	// Block snapshotting, so the main SQLite file, and the marker, do not
+	// change while the file is read.
+	if err := s.snapshotCAS.Begin("backup"); err != nil {
+		return nil, err
+	}
+	marker, err := s.dbFileBackupMarker()
+	if err != nil {
+		s.snapshotCAS.End()
+		return nil, err
+	}
+
+	if br.Format == proto.BackupRequest_BACKUP_REQUEST_FORMAT_BINARY && !br.Vacuum {
+		defer s.snapshotCAS.End()
+		srcFD, err := os.Open(s.dbPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database file: %s", err.Error())
+		}
+		defer srcFD.Close()
+		if err := copyMaybeCompressed(dst, srcFD, br.Compress); err != nil {
+			return nil, err
+		}
+		return marker, nil
+	}
+
+	// Copy the main file, so it can be vacuumed or dumped without blocking
+	// snapshotting.
+	cpFD, err := createTemp(s.dbDir, backupScatchPattern)
+	if err != nil {
+		s.snapshotCAS.End()
+		return nil, err
+	}
+	defer os.Remove(cpFD.Name())
+	defer cpFD.Close()
+	err = func() error {
+		defer s.snapshotCAS.End()
+		srcFD, err := os.Open(s.dbPath)
+		if err != nil {
+			return fmt.Errorf("failed to open database file: %s", err.Error())
+		}
+		defer srcFD.Close()
+		if _, err := io.Copy(cpFD, srcFD); err != nil {
+			return err
+		}
+		return cpFD.Close()
+	}()
+	if err != nil {
+		return nil, err
+	}
+	cpDB, err := sql.Open(cpFD.Name(), false, false)
+	if err != nil {
+		return nil, err
+	}
+	defer cpDB.Close()
+
+	if br.Format == proto.BackupRequest_BACKUP_REQUEST_FORMAT_SQL {
+		if header {
+			if _, err := fmt.Fprintf(dst, "-- rqlite backup index=%d term=%d\n", marker.Index, marker.Term); err != nil {
+				return nil, err
+			}
+		}
+		if err := cpDB.Dump(dst); err != nil {
+			return nil, err
+		}
+		return marker, nil
+	}
+
+	vacFD, err := createTemp(s.dbDir, backupScatchPattern)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(vacFD.Name())
+	defer vacFD.Close()
+	if err := cpDB.Backup(vacFD.Name(), true); err != nil {
+		return nil, err
+	}
+	if err := copyMaybeCompressed(dst, vacFD, br.Compress); err != nil {
+		return nil, err
+	}
+	return marker, nil
+}
+
+// dbFileBackupMarker returns the Raft log position reflected by the main
+// database file. If the main file has not been updated since this node
+// started, that is the position of the latest snapshot. The caller must
+// ensure snapshotting is blocked.
+func (s *Store) dbFileBackupMarker() (*BackupMarker, error) {
+	s.dbFileMarkerMu.Lock()
+	m := s.dbFileMarker
+	s.dbFileMarkerMu.Unlock()
+	if m.Index != 0 {
+		return &m, nil
+	}
+	li, tm, err := snapshot.LatestIndexTerm(s.snapshotDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest snapshot index: %s", err.Error())
+	}
+	return &BackupMarker{Index: li, Term: tm}, nil
+}
+
+// setDBFileMarker records the Raft log position reflected by the main
+// database file.
+func (s *Store) setDBFileMarker(idx, term uint64) {
+	s.dbFileMarkerMu.Lock()
+	defer s.dbFileMarkerMu.Unlock()
+	s.dbFileMarker = BackupMarker{Index: idx, Term: term}
+}
+
+// maybeDecompress returns a reader of the data read from r, decompressing it
+// if it is gzip-compressed.
+func maybeDecompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// copyMaybeCompressed copies src to dst, gzip-compressing it if compress is
+// true.
+func copyMaybeCompressed(dst io.Writer, src io.Reader, compress bool) error {
+	if !compress {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	gw, err := gzip.NewWriterLevel(dst, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(gw, src); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
 // Loads an entire SQLite file into the database, sending the request
 // through the Raft log.
 func (s *Store) Load(lr *proto.LoadRequest) error {
@@ -1439,7 +1763,8 @@ func (s *Store) load(lr *proto.LoadRequest) error {
 
 // ReadFrom reads data from r, and loads it into the database, bypassing Raft consensus.
 // Once the data is loaded, a snapshot is triggered, which then results in a system as
-// if the data had been loaded through Raft consensus.
+// if the data had been loaded through Raft consensus. The data may be gzip-compressed,
+// as written by Backup when compression is requested.
 func (s *Store) ReadFrom(r io.Reader) (int64, error) {
 	// Check the constraints.
 	if s.raft.State() != raft.Leader {
@@ -1461,15 +1786,24 @@ func (s *Store) ReadFrom(r io.Reader) (int64, error) {
 	defer os.Remove(f.Name())
 	defer f.Close()
 
+	// The data may be gzip-compressed, as written by a compressed Backup.
+	cr := progress.NewCountingReader(r)
+	src, err := maybeDecompress(cr)
+	if err != nil {
+		return cr.Count(), err
+	}
+
 	cw := progress.NewCountingWriter(f)
 	cm := progress.StartCountingMonitor(func(n int64) {
 		s.logger.Printf("boot process installed %d bytes", n)
 	}, cw)
-	n, err := func() (int64, error) {
+	err = func() error {
 		defer cm.StopAndWait()
 		defer f.Close()
-		return io.Copy(cw, r)
+		_, err := io.Copy(cw, src)
+		return err
 	}()
+	n := cr.Count()
 	if err != nil {
 		return n, err
 	}
@@ -1588,7 +1922,7 @@ func (s *Store) Notify(nr *proto.NotifyRequest) error {
 		return fmt.Errorf("failed to resolve %s: %w", addr, err)
 	}
 
-	s.notifyingNodes[nr.Id] = &Server{nr.Id, nr.Address, "voter"}
+	s.notifyingNodes[nr.Id] = &Server{ID: nr.Id, Addr: nr.Address, Suffrage: "voter"}
 	if len(s.notifyingNodes) < s.BootstrapExpect {
 		return nil
 	}
@@ -1871,6 +2205,7 @@ func (s *Store) fsmApply(l *raft.Log) (e interface{}) {
 	if cmd.Type == proto.Command_COMMAND_TYPE_NOOP {
 		s.numNoops.Add(1)
 	} else if cmd.Type == proto.Command_COMMAND_TYPE_LOAD {
+		s.setDBFileMarker(l.Index, l.Term)
 		// Swapping in a new database invalidates any existing snapshot.
 		err := s.snapshotStore.SetFullNeeded()
 		if err != nil {
@@ -1993,6 +2328,10 @@ func (s *Store) fsmSnapshot() (fSnap raft.FSMSnapshot, retErr error) {
 		stats.Add(numSnapshotsIncremental, 1)
 	}
 
+	// The WAL has been checkpointed, if necessary, so the main database file
+	// now reflects every log entry applied.
+	s.setDBFileMarker(s.fsmIdx.Load(), s.fsmTerm.Load())
+
 	stats.Add(numSnapshots, 1)
 	dur := time.Since(startT)
 	stats.Get(snapshotCreateDuration).(*expvar.Int).Set(dur.Milliseconds())
@@ -2052,6 +2391,7 @@ func (s *Store) fsmRestore(rc io.ReadCloser) (retErr error) {
 	s.fsmIdx.Store(li)
 	s.fsmTerm.Store(tm)
 	s.dbAppliedIdx.Store(li)
+	s.setDBFileMarker(li, tm)
 
 	stats.Add(numRestores, 1)
 	s.logger.Printf("node restored in %s", time.Since(startT))
@@ -2233,6 +2573,10 @@ func (s *Store) selfLeaderChange(leader bool) {
 				s.logger.Printf("failed to remove restore path after restore %s: %s",
 					s.restorePath, err.Error())
 			}
+			if err := os.Remove(s.restorePath + checksumFileSuffix); err != nil && !os.IsNotExist(err) {
+				s.logger.Printf("failed to remove checksum file after restore %s: %s",
+					s.restorePath, err.Error())
+			}
 			s.restorePath = ""
 			close(s.restoreDoneCh)
 		}()
@@ -2259,10 +2603,18 @@ func (s *Store) installRestore() error {
 		return err
 	}
 	defer f.Close()
-	b, err := io.ReadAll(f)
+	h := sha256.New()
+	b, err := io.ReadAll(io.TeeReader(f, h))
 	if err != nil {
 		return err
 	}
+	exp, ok, err := readChecksumFile(s.restorePath)
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); ok && exp != got {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, exp, got)
+	}
 	lr := &proto.LoadRequest{
 		Data: b,
 	}
@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"crypto/rand"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"net"
@@ -317,6 +318,86 @@ COMMIT;
 	}
 }
 
+// Test_SingleNodeBackupWithMarker tests that a backup returns the Raft log
+// position it reflects.
+func Test_SingleNodeBackupWithMarker(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	appliedIdx := s.DBAppliedIndex()
+
+	f := mustCreateTempFD()
+	defer os.Remove(f.Name())
+	defer f.Close()
+	m, err := s.BackupWithMarker(backupRequestBinary(true, false, false), f, false)
+	if err != nil {
+		t.Fatalf("backup failed: %s", err.Error())
+	}
+	if m.Index == 0 || m.Term == 0 {
+		t.Fatalf("expected non-zero marker, got %+v", m)
+	}
+	if m.Index < appliedIdx {
+		t.Fatalf("marker index %d is behind applied index %d", m.Index, appliedIdx)
+	}
+	if !filesIdentical(f.Name(), s.dbPath) {
+		t.Fatalf("backup file not identical to database file")
+	}
+
+	// Further writes must advance the marker.
+	if _, err := s.Execute(executeRequestFromString(`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+		false, false)); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+	var buf bytes.Buffer
+	m2, err := s.BackupWithMarker(backupRequestSQL(true), &buf, true)
+	if err != nil {
+		t.Fatalf("backup failed: %s", err.Error())
+	}
+	if m2.Index <= m.Index {
+		t.Fatalf("marker index did not advance, was %d, now %d", m.Index, m2.Index)
+	}
+	exp := fmt.Sprintf("-- rqlite backup index=%d term=%d\n", m2.Index, m2.Term)
+	if !strings.HasPrefix(buf.String(), exp) {
+		t.Fatalf("SQL backup missing marker header, got %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `INSERT INTO "foo" VALUES(2,'declan');`) {
+		t.Fatalf("SQL backup missing latest write, got %s", buf.String())
+	}
+
+	// A vacuumed backup reflects the same position.
+	vf := mustCreateTempFD()
+	defer os.Remove(vf.Name())
+	defer vf.Close()
+	m3, err := s.BackupWithMarker(backupRequestBinary(true, true, false), vf, false)
+	if err != nil {
+		t.Fatalf("backup failed: %s", err.Error())
+	}
+	if *m3 != *m2 {
+		t.Fatalf("wrong marker for vacuumed backup, exp %+v, got %+v", m2, m3)
+	}
+	if !db.IsValidSQLiteFile(vf.Name()) {
+		t.Fatalf("vacuumed backup is not a valid SQLite file")
+	}
+}
+
 // Test_SingleNodeBackupBinary tests that requesting a binary-formatted
 // backup works as expected.
 func Test_SingleNodeBackupBinaryVacuum(t *testing.T) {
@@ -403,6 +484,101 @@ COMMIT;
 	checkDB(guzf.Name())
 }
 
+// Test_SingleNodeBackupBinaryVacuumFastCopy tests that a vacuumed backup made
+// by copying the database file is correct, and that the Store falls back to
+// the Online Backup API when a fast copy is not possible.
+func Test_SingleNodeBackupBinaryVacuumFastCopy(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	er := executeRequestFromStrings([]string{
+		`CREATE TABLE foo (id INTEGER NOT NULL PRIMARY KEY, name TEXT)`,
+		`INSERT INTO foo(id, name) VALUES(1, "fiona")`,
+		`INSERT INTO foo(id, name) VALUES(2, "declan")`,
+		`DELETE FROM foo WHERE id = 2`,
+	}, false, false)
+	if _, err := s.Execute(er); err != nil {
+		t.Fatalf("failed to execute on single node: %s", err.Error())
+	}
+
+	checkDB := func(path string) {
+		t.Helper()
+		dstDB, err := db.Open(path, false, false)
+		if err != nil {
+			t.Fatalf("unable to open backup database, %s", err.Error())
+		}
+		defer dstDB.Close()
+		r, err := dstDB.QueryStringStmt("SELECT * FROM foo")
+		if err != nil {
+			t.Fatalf("failed to query backup database: %s", err.Error())
+		}
+		if exp, got := `[{"columns":["id","name"],"types":["integer","text"],"values":[[1,"fiona"]]}]`, asJSON(r); exp != got {
+			t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+		}
+		if dstDB.WALEnabled() {
+			t.Fatalf("backup database is in WAL mode")
+		}
+	}
+	backup := func(compress bool) string {
+		t.Helper()
+		f, err := os.CreateTemp(t.TempDir(), "rqlite-baktest-")
+		if err != nil {
+			t.Fatalf("Backup Failed: unable to create temp file, %s", err.Error())
+		}
+		defer f.Close()
+		br := backupRequestBinary(true, true, compress)
+		br.FastCopy = true
+		if err := s.Backup(br, f); err != nil {
+			t.Fatalf("Backup failed %s", err.Error())
+		}
+		if !compress {
+			return f.Name()
+		}
+		guzf, err := os.CreateTemp(t.TempDir(), "rqlite-baktest-")
+		if err != nil {
+			t.Fatalf("Backup Failed: unable to create temp file, %s", err.Error())
+		}
+		defer guzf.Close()
+		if err := gunzipFile(guzf, f); err != nil {
+			t.Fatalf("Failed to gunzip backup file %s", err.Error())
+		}
+		return guzf.Name()
+	}
+
+	nFast := stats.Get(numBackupsFastCopy).(*expvar.Int).Value()
+	checkDB(backup(false))
+	checkDB(backup(true))
+	if exp, got := nFast+2, stats.Get(numBackupsFastCopy).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong number of fast copy backups, exp %d, got %d", exp, got)
+	}
+
+	// With snapshotting blocked the file cannot be copied safely, so the
+	// backup must fall back.
+	if err := s.snapshotCAS.Begin("test"); err != nil {
+		t.Fatalf("failed to block snapshotting: %s", err.Error())
+	}
+	nFallback := stats.Get(numBackupsFastCopyFallback).(*expvar.Int).Value()
+	checkDB(backup(false))
+	s.snapshotCAS.End()
+	if exp, got := nFallback+1, stats.Get(numBackupsFastCopyFallback).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("wrong number of fast copy fallbacks, exp %d, got %d", exp, got)
+	}
+	if exp, got := nFast+2, stats.Get(numBackupsFastCopy).(*expvar.Int).Value(); exp != got {
+		t.Fatalf("fast copy made while snapshotting blocked")
+	}
+}
+
 // Test_SingleNodeSnapshot tests that the Store correctly takes a snapshot
 // and recovers from it.
 func Test_SingleNodeSnapshot(t *testing.T) {
@@ -1656,6 +1832,41 @@ func Test_SingleNodeAutoRestore(t *testing.T) {
 	}
 }
 
+func Test_SingleNodeAutoRestoreChecksumMismatch(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	path := mustCopyFileToTempFile(filepath.Join("testdata", "load.sqlite"))
+	mustWriteFile(path+checksumFileSuffix, strings.Repeat("0", 64)+"  load.sqlite\n")
+	if err := s.SetRestorePath(path); err != nil {
+		t.Fatalf("failed to set restore path: %s", err.Error())
+	}
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	testPoll(t, s.Ready, 100*time.Millisecond, 2*time.Second)
+	qr := queryRequestFromString("SELECT * FROM foo WHERE id=2", false, true)
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `{"error":"no such table: foo"}`, asJSON(r[0]); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+	if pathExists(path + checksumFileSuffix) {
+		t.Fatalf("checksum file not removed after restore")
+	}
+}
+
 func Test_SingleNodeSetRestoreFailStoreOpen(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()
@@ -1812,6 +2023,54 @@ COMMIT;
 
 }
 
+func Test_SingleNodeBoot_Gzip(t *testing.T) {
+	s, ln := mustNewStore(t)
+	defer ln.Close()
+
+	if err := s.Open(); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	if err := s.Bootstrap(NewServer(s.ID(), s.Addr(), true)); err != nil {
+		t.Fatalf("failed to bootstrap single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("Error waiting for leader: %s", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join("testdata", "load.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to read SQLite file: %s", err.Error())
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(b); err != nil {
+		t.Fatalf("failed to compress SQLite file: %s", err.Error())
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %s", err.Error())
+	}
+	sz := int64(buf.Len())
+
+	n, err := s.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("failed to load compressed SQLite file via Reader: %s", err.Error())
+	}
+	if n != sz {
+		t.Fatalf("expected %d bytes to be read, got %d", sz, n)
+	}
+
+	qr := queryRequestFromString("SELECT count(*) FROM foo", false, true)
+	qr.Level = proto.QueryRequest_QUERY_REQUEST_LEVEL_STRONG
+	r, err := s.Query(qr)
+	if err != nil {
+		t.Fatalf("failed to query single node: %s", err.Error())
+	}
+	if exp, got := `[[3]]`, asJSON(r[0].Values); exp != got {
+		t.Fatalf("unexpected results for query\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
 func Test_SingleNodeBoot_InvalidFail_WALOK(t *testing.T) {
 	s, ln := mustNewStore(t)
 	defer ln.Close()